@@ -22,27 +22,39 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
+	"google.golang.org/grpc"
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
+	storageinformersv1beta1 "k8s.io/client-go/informers/storage/v1beta1"
 	"k8s.io/client-go/kubernetes"
 	listersv1 "k8s.io/client-go/listers/core/v1"
 	storagelistersv1 "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/client-go/util/workqueue"
 	utilflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/metrics/legacyregistry"
@@ -54,26 +66,55 @@ import (
 
 	"github.com/kubernetes-csi/csi-lib-utils/leaderelection"
 	"github.com/kubernetes-csi/csi-lib-utils/metrics"
+	"github.com/kubernetes-csi/csi-lib-utils/rpc"
+	"github.com/kubernetes-csi/external-provisioner/pkg/adaptiveqps"
+	"github.com/kubernetes-csi/external-provisioner/pkg/canary"
 	"github.com/kubernetes-csi/external-provisioner/pkg/capacity"
 	"github.com/kubernetes-csi/external-provisioner/pkg/capacity/topology"
+	"github.com/kubernetes-csi/external-provisioner/pkg/capture"
+	provisionerconfig "github.com/kubernetes-csi/external-provisioner/pkg/config"
 	ctrl "github.com/kubernetes-csi/external-provisioner/pkg/controller"
+	"github.com/kubernetes-csi/external-provisioner/pkg/csimetrics"
+	"github.com/kubernetes-csi/external-provisioner/pkg/debugstate"
+	"github.com/kubernetes-csi/external-provisioner/pkg/eventlimit"
+	"github.com/kubernetes-csi/external-provisioner/pkg/faultinject"
+	"github.com/kubernetes-csi/external-provisioner/pkg/features"
+	"github.com/kubernetes-csi/external-provisioner/pkg/jsonlog"
+	kubeconfigreloader "github.com/kubernetes-csi/external-provisioner/pkg/kubeconfig"
+	"github.com/kubernetes-csi/external-provisioner/pkg/otlp"
 	"github.com/kubernetes-csi/external-provisioner/pkg/owner"
+	"github.com/kubernetes-csi/external-provisioner/pkg/resourceqps"
+	"github.com/kubernetes-csi/external-provisioner/pkg/shadow"
 	snapclientset "github.com/kubernetes-csi/external-snapshotter/client/v3/clientset/versioned"
 )
 
 var (
 	master               = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
 	kubeconfig           = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
-	csiEndpoint          = flag.String("csi-address", "/run/csi/socket", "The gRPC endpoint for Target CSI Volume.")
+	csiEndpoint          = flag.String("csi-address", "/run/csi/socket", "The gRPC endpoint for Target CSI Volume. A Unix domain socket path or a tcp://host:port address. A comma-separated list of endpoints of the same driver enables failover between them.")
+	csiTLSCAFile         = flag.String("csi-tls-ca-file", "", "File with CA certificates which are used to verify the driver at tcp:// endpoints. Enables TLS for those endpoints. The system certificates are used when only other --csi-tls flags are set.")
+	csiTLSCertFile       = flag.String("csi-tls-cert-file", "", "File with the client certificate which is presented to the driver at tcp:// endpoints for mutual TLS. Requires --csi-tls-key-file.")
+	csiTLSKeyFile        = flag.String("csi-tls-key-file", "", "File with the key for --csi-tls-cert-file.")
+	csiTLSServerName     = flag.String("csi-tls-server-name", "", "Overrides the name that is expected in the certificate of the driver at tcp:// endpoints. Defaults to the host in the endpoint.")
 	volumeNamePrefix     = flag.String("volume-name-prefix", "pvc", "Prefix to apply to the name of a created volume.")
 	volumeNameUUIDLength = flag.Int("volume-name-uuid-length", -1, "Truncates generated UUID of a created volume to this length. Defaults behavior is to NOT truncate.")
+	volumeNameTemplate   = flag.String("volume-name-template", "", "If set, a Go template for the names of created volumes instead of --volume-name-prefix and a UUID. It can use .PVCName, .PVCNamespace, .PVCUID and .StorageClassName, for example \"{{.PVCNamespace}}-{{.PVCName}}\".")
 	showVersion          = flag.Bool("version", false, "Show version.")
-	retryIntervalStart   = flag.Duration("retry-interval-start", time.Second, "Initial retry interval of failed provisioning or deletion. It doubles with each failure, up to retry-interval-max.")
-	retryIntervalMax     = flag.Duration("retry-interval-max", 5*time.Minute, "Maximum retry interval of failed provisioning or deletion.")
+	retryIntervalStart   = flag.Duration("retry-interval-start", time.Second, "Initial retry interval of failed provisioning or deletion. It doubles with each failure, up to retry-interval-max. Deprecated: use initialDelay of the rateLimiters in --config instead, this is only the default for it.")
+	retryIntervalMax     = flag.Duration("retry-interval-max", 5*time.Minute, "Maximum retry interval of failed provisioning or deletion. Deprecated: use maxDelay of the rateLimiters in --config instead, this is only the default for it.")
+	configFile           = flag.String("config", "", "Path to a YAML file with values for command line flags and further settings, like the rate limiters of the work queues.")
+	configReloadInterval = flag.Duration("config-reload-interval", time.Minute, "How often the file specified with --config is checked for changes. Zero disables reloading.")
+	retryBudget          = flag.Int("retry-budget", 0, "Maximum number of retries of failed provisioning or deletion per minute, across all volumes. Zero disables the limit.")
 	workerThreads        = flag.Uint("worker-threads", 100, "Number of provisioner worker threads, in other words nr. of simultaneous CSI calls.")
+	workerRampUp         = flag.Duration("worker-ramp-up", 0, "If non-zero, the number of simultaneous CSI calls starts at one and grows to --worker-threads within this period after the provisioner starts working, for example after becoming the leader.")
 	finalizerThreads     = flag.Uint("cloning-protection-threads", 1, "Number of simultaneously running threads, handling cloning finalizer removal")
 	capacityThreads      = flag.Uint("capacity-threads", 1, "Number of simultaneously running threads, handling CSIStorageCapacity objects")
-	operationTimeout     = flag.Duration("timeout", 10*time.Second, "Timeout for waiting for creation or deletion of a volume")
+	operationTimeout     = flag.Duration("timeout", 10*time.Second, "Timeout for waiting for creation or deletion of a volume and for other CSI calls")
+	createVolumeTimeout  = flag.Duration("create-volume-timeout", 0, "Timeout for CreateVolume calls. Zero uses --timeout.")
+	deleteVolumeTimeout  = flag.Duration("delete-volume-timeout", 0, "Timeout for DeleteVolume calls. Zero uses --timeout.")
+	restoreTimeout       = flag.Duration("snapshot-restore-timeout", 0, "Timeout for CreateVolume calls with a snapshot as data source. Zero uses --create-volume-timeout.")
+	inFlightTimeout      = flag.Duration("create-volume-in-flight-timeout", 0, "If non-zero, a CreateVolume call which exceeds its timeout continues in the background for up to this long in total, and retries for the same volume wait for it instead of calling CreateVolume again. Should be larger than the CreateVolume timeouts.")
+	cacheSyncTimeout     = flag.Duration("cache-sync-timeout", 0, "Maximum time to wait for informer caches to sync during startup. Once it expires, provisioning starts if PVCs and storage classes are synced while the other informers catch up in the background. Zero waits for all informers without a timeout.")
 
 	enableLeaderElection = flag.Bool("leader-election", false, "Enables leader election. If leader election is enabled, additional RBAC rules are required. Please refer to the Kubernetes CSI documentation for instructions on setting up these RBAC rules.")
 
@@ -81,30 +122,145 @@ var (
 	strictTopology          = flag.Bool("strict-topology", false, "Late binding: pass only selected node topology to CreateVolume Request, unlike default behavior of passing aggregated cluster topologies that match with topology keys of the selected node.")
 	immediateTopology       = flag.Bool("immediate-topology", true, "Immediate binding: pass aggregated cluster topologies for all nodes where the CSI driver is available (enabled, the default) or no topology requirements (if disabled).")
 	extraCreateMetadata     = flag.Bool("extra-create-metadata", false, "If set, add pv/pvc metadata to plugin create requests as parameters.")
-	metricsAddress          = flag.String("metrics-address", "", "(deprecated) The TCP network address where the prometheus metrics endpoint will listen (example: `:8080`). The default is empty string, which means metrics endpoint is disabled. Only one of `--metrics-address` and `--http-endpoint` can be set.")
+	metricsAddress          = flag.String("metrics-address", "", "(deprecated) The TCP network address where the prometheus metrics endpoint and leader election health check will listen (example: `:8080`). The default is empty string, which means metrics endpoint is disabled. Only one of `--metrics-address` and `--http-endpoint` can be set.")
 	httpEndpoint            = flag.String("http-endpoint", "", "The TCP network address where the HTTP server for diagnostics, including metrics and leader election health check, will listen (example: `:8080`). The default is empty string, which means the server is disabled. Only one of `--metrics-address` and `--http-endpoint` can be set.")
 	metricsPath             = flag.String("metrics-path", "/metrics", "The HTTP path where prometheus metrics will be exposed. Default is `/metrics`.")
+	operationLatencyBuckets = flag.String("csi-operation-latency-buckets", "", "Comma-separated upper bounds in seconds of the histogram buckets for the csi_sidecar_operations_seconds metric (example: `1,10,60,300,900,1800,3600`). The default is empty string, which means the buckets of csi-lib-utils are used.")
+
+	loggingFormat = flag.String("logging-format", "text", "Sets the log format. Permitted formats: \"text\", \"json\". With \"json\", each log entry is written to stderr as a single line with a JSON object and the klog flags for log files and headers are ignored.")
+
+	metricsExportEndpoint = flag.String("metrics-export-endpoint", "", "If set, metrics are also pushed periodically to this OTLP/HTTP metrics endpoint of an OpenTelemetry collector (example: `http://otel-collector:4318/v1/metrics`), using the JSON encoding.")
+	metricsExportInterval = flag.Duration("metrics-export-interval", time.Minute, "How often metrics are pushed to --metrics-export-endpoint.")
+
+	tracingEndpoint       = flag.String("tracing-endpoint", "", "If set, provisioning and deletion get traced and the spans are pushed to this OTLP/HTTP traces endpoint of an OpenTelemetry collector (example: `http://otel-collector:4318/v1/traces`), using the JSON encoding.")
+	tracingExportInterval = flag.Duration("tracing-export-interval", 5*time.Second, "How often spans are pushed to --tracing-endpoint.")
+	tracingSamplingRatio  = flag.Float64("tracing-sampling-ratio", 1, "The fraction of provisioning and deletion operations which get traced, between 0 and 1.")
 
 	defaultFSType = flag.String("default-fstype", "", "The default filesystem type of the volume to provision when fstype is unspecified in the StorageClass. If the default is not set and fstype is unset in the StorageClass, then no fstype will be set")
 
+	latencyAnnotations = flag.Bool("latency-annotations", false, "If set, annotate new PVs with the time spent on waiting, topology computation and CreateVolume during provisioning.")
+
+	fairSchedulingSlots = flag.Uint("fair-scheduling-slots", 0, "If non-zero, at most this many provisioning operations run concurrently and free slots are handed out round-robin across storage classes, with PVCs that have a higher provisioner.storage.k8s.io/priority annotation first. Must be smaller than --worker-threads. Zero disables fair scheduling.")
+
+	reservedWorkerThreads = flag.Uint("reserved-worker-threads", 0, "Number of provisioning worker threads that are reserved for claims in the namespaces listed with --reserved-namespaces. Claims in other namespaces use at most the remaining worker threads. Must be smaller than --worker-threads.")
+	reservedNamespaces    = flag.StringSlice("reserved-namespaces", nil, "Namespaces whose claims may use the worker threads reserved with --reserved-worker-threads, for example kube-system.")
+
+	maxRequisiteTopologies         = flag.Int("max-requisite-topologies", 0, "Maximum number of requisite topology entries passed to CreateVolume. Zero means that the limit from the max-topology-entries key in the GetPluginInfo manifest of the driver is used, if there is one, otherwise no limit.")
+	requisiteTopologyLimitStrategy = flag.String("requisite-topology-limit-strategy", string(ctrl.TopologyLimitTruncate), "What to do when --max-requisite-topologies is exceeded: \"truncate\" keeps the preferred entries and logs a warning, \"sample\" keeps the first preferred entry and an evenly spread sample of the other entries, chosen based on the PVC name, \"error\" fails provisioning.")
+	topologyMode                   = flag.String("topology-mode", string(ctrl.TopologyModeRequisite), "Which topology is passed to CreateVolume: \"requisite\" passes requisite and preferred topology, \"preferred-only\" only the preferred topology, for drivers which ignore the requisite topology. Can be overridden per storage class with the csi.storage.k8s.io/topology-mode parameter.")
+
 	kubeAPIQPS   = flag.Float32("kube-api-qps", 5, "QPS to use while communicating with the kubernetes apiserver. Defaults to 5.0.")
 	kubeAPIBurst = flag.Int("kube-api-burst", 10, "Burst to use while communicating with the kubernetes apiserver. Defaults to 10.")
 
+	kubeAPIAdaptiveQPS      = flag.Bool("kube-api-adaptive-qps", false, "Lower the QPS for the kubernetes apiserver automatically when it rejects requests with 429 Too Many Requests or responds slowly, and raise it again up to --kube-api-qps when it recovers.")
+	kubeAPIMinQPS           = flag.Float32("kube-api-min-qps", 1, "The lowest QPS used with --kube-api-adaptive-qps.")
+	kubeAPIResourceQPS      = flag.StringToString("kube-api-resource-qps", nil, "Comma-separated list of <resource>=<qps>[:<burst>] entries, for example nodes=2,persistentvolumes=20:40. Requests for these resources get rate limited separately instead of being subject to --kube-api-qps. The burst defaults to twice the QPS.")
+	kubeAPILatencyThreshold = flag.Duration("kube-api-latency-threshold", 2*time.Second, "Responses that take longer than this count as a sign of apiserver overload for --kube-api-adaptive-qps. Zero disables the check.")
+
+	kubeconfigReloadInterval = flag.Duration("kubeconfig-reload-interval", time.Minute, "How often the file specified with --kubeconfig is checked for new credentials. Zero disables reloading.")
+
 	enableCapacity           = flag.Bool("enable-capacity", false, "This enables producing CSIStorageCapacity objects with capacity information from the driver's GetCapacity call.")
 	capacityImmediateBinding = flag.Bool("capacity-for-immediate-binding", false, "Enables producing capacity information for storage classes with immediate binding. Not needed for the Kubernetes scheduler, maybe useful for other consumers or for debugging.")
+	capacityDryRun           = flag.Bool("capacity-dry-run", false, "Log which CSIStorageCapacity objects would be created, updated or deleted instead of changing them.")
+	capacityWriteQPS         = flag.Float32("capacity-write-qps", 0, "If non-zero, creating, updating and deleting CSIStorageCapacity objects is limited to this many requests per second, independently of --kube-api-qps. Zero disables the limit.")
+	capacityWriteBurst       = flag.Int("capacity-write-burst", 10, "Maximum number of CSIStorageCapacity objects that get written in a burst when --capacity-write-qps is set.")
 	capacityPollInterval     = flag.Duration("capacity-poll-interval", time.Minute, "How long the external-provisioner waits before checking for storage capacity changes.")
+	capacityCacheTTL         = flag.Duration("capacity-cache-ttl", 0, "If non-zero, GetCapacity responses are reused for identical requests during this period, for example for storage classes which share the same storage pool. Provisioning and deleting volumes invalidates the cached responses for the affected storage class or topology segment.")
 	capacityOwnerrefLevel    = flag.Int("capacity-ownerref-level", 1, "The level indicates the number of objects that need to be traversed starting from the pod identified by the POD_NAME and NAMESPACE environment variables to reach the owning object for CSIStorageCapacity objects: -1 for no owner, 0 for the pod itself, 1 for a StatefulSet or DaemonSet, 2 for a Deployment, etc.")
+	capacityOwnerrefRefresh  = flag.Duration("capacity-ownerref-refresh-interval", 10*time.Minute, "How often the owner of CSIStorageCapacity objects is looked up again. When it was re-created with a different UID, all objects get updated. Zero disables it.")
+	capacityOwnerrefGVK      = flag.String("capacity-ownerref-gvk", "", "If set, the owner of CSIStorageCapacity objects is the first object of this <group>/<version>/<kind> in the ownership chain of the pod, regardless of --capacity-ownerref-level (example: `example.com/v1/StorageCluster`).")
+	capacityOwnerrefName     = flag.String("capacity-ownerref-name", "", "The name of the object in the NAMESPACE that owns CSIStorageCapacity objects. Requires --capacity-ownerref-gvk and skips walking the ownership chain of the pod.")
+	capacityRefreshEndpoint  = flag.Bool("enable-capacity-refresh-endpoint", false, "Serves POST requests at /capacity/refresh on the HTTP endpoint which trigger an update of CSIStorageCapacity objects. Only has an effect together with --enable-capacity and --http-endpoint.")
+	capacityReadyzIntervals  = flag.Uint("capacity-readyz-poll-intervals", 0, "If non-zero, /readyz on the HTTP endpoint fails when no CSIStorageCapacity object was refreshed successfully for this many capacity poll intervals. Only has an effect together with --enable-capacity and --http-endpoint.")
+	capacityDeleteOnShutdown = flag.Bool("capacity-delete-on-shutdown", false, "Delete all CSIStorageCapacity objects managed by this instance when receiving SIGTERM or SIGINT. Only has an effect together with --enable-capacity.")
+	capacityShutdownTimeout  = flag.Duration("capacity-delete-on-shutdown-timeout", 30*time.Second, "How long the external-provisioner tries to delete CSIStorageCapacity objects during shutdown before giving up.")
+	capacityAggregation      = flag.Bool("enable-capacity-aggregation", false, "Report the sum of the capacity in all CSIStorageCapacity objects of the driver in the NAMESPACE as metrics, by storage class and by the values of --capacity-aggregation-keys. Meant for a central instance when node-local instances publish the objects.")
+	capacityAggregationKeys  = flag.StringSlice("capacity-aggregation-keys", nil, "Topology keys by which --enable-capacity-aggregation groups CSIStorageCapacity objects, for example topology.kubernetes.io/zone. Without keys, the capacity of each storage class is summed up across all objects.")
 
 	enableNodeDeployment           = flag.Bool("node-deployment", false, "Enables deploying the external-provisioner together with a CSI driver on nodes to manage node-local volumes.")
 	nodeDeploymentImmediateBinding = flag.Bool("node-deployment-immediate-binding", true, "Determines whether immediate binding is supported when deployed on each node.")
 	nodeDeploymentBaseDelay        = flag.Duration("node-deployment-base-delay", 20*time.Second, "Determines how long the external-provisioner sleeps initially before trying to own a PVC with immediate binding.")
 	nodeDeploymentMaxDelay         = flag.Duration("node-deployment-max-delay", 60*time.Second, "Determines how long the external-provisioner sleeps at most before trying to own a PVC with immediate binding.")
+	nodeDeploymentOrphanedVolumes  = flag.String("node-deployment-orphaned-volumes", string(ctrl.OrphanedVolumesIgnore), "Determines how volumes get deleted which are not accessible by any existing node: \"ignore\" leaves them alone, \"distribute\" spreads them across all nodes with the CSI driver, \"fallback\" deletes all of them in this instance.")
+	nodeDeploymentPreferRegistered = flag.Bool("node-deployment-prefer-registered-topology", false, "Use the node ID and topology from the CSINode and Node objects registered by kubelet instead of the NodeGetInfo response when they differ. Differences are logged as warnings either way.")
+	additionalCSIEndpoints         = flag.StringSlice("additional-csi-address", nil, "The gRPC endpoints of further node-local CSI drivers that are handled in addition to the driver at --csi-address. Only supported together with --node-deployment.")
+
+	watchVolumeAttachments          = flag.Bool("watch-volumeattachments", true, "Watch VolumeAttachments to postpone deleting volumes that are still attached. Can be disabled to save memory and API server load when detaching is known to complete before PVs get deleted.")
+	deleteWaitForAttachments        = flag.Bool("delete-wait-for-volume-attachments", false, "Postpone deleting a volume while a VolumeAttachment exists for its PV also for CSI drivers without PUBLISH_UNPUBLISH_VOLUME capability. Such drivers normally have no VolumeAttachments, but they may be left behind when detaching went wrong.")
+	leakedVolumesLogInterval        = flag.Duration("leaked-volumes-log-interval", 0, "If non-zero, PVs which get removed without a successful DeleteVolume call are counted by a metric and logged at this interval. Not supported together with --node-deployment.")
+	useDeletionController           = flag.Bool("deletion-controller", false, "Delete the volumes of released PVs with a separate controller that has its own work queue, rate limiter and metrics, instead of sharing the work queue handling with provisioning.")
+	strayVolumeCleanupAge           = flag.Duration("stray-volume-cleanup-age", 0, "If non-zero, PVs with reclaim policy Delete which were provisioned by the driver, were never bound and whose PVC no longer exists get deleted together with their volume once they are older than this. The check runs at the same interval.")
+	faultInjectionCSILatency        = flag.Duration("fault-injection-csi-latency", 0, "For resilience testing only: delay each CSI call made by the controllers by this duration.")
+	faultInjectionCSIErrorRate      = flag.Float64("fault-injection-csi-error-rate", 0, "For resilience testing only: fraction of CSI calls made by the controllers, between 0 and 1, which fail with an Unavailable error instead of reaching the driver.")
+	faultInjectionAPILatency        = flag.Duration("fault-injection-api-latency", 0, "For resilience testing only: delay each Kubernetes API request that modifies objects by this duration.")
+	faultInjectionAPIErrorRate      = flag.Float64("fault-injection-api-error-rate", 0, "For resilience testing only: fraction of Kubernetes API requests that modify objects, between 0 and 1, which fail instead of reaching the API server.")
+	secretInformer                  = flag.Bool("secret-informer", false, "Look up the secrets referenced by storage classes and PVs in an informer cache instead of getting them from the API server for each provisioning and deletion. Secrets which are not in the cache are still fetched from the API server. Requires permission to list and watch secrets.")
+	secretInformerNamespace         = flag.String("secret-informer-namespace", "", "Restricts the informer enabled with --secret-informer to secrets in this namespace. By default, secrets in all namespaces are cached.")
+	secretInformerLabelSelector     = flag.String("secret-informer-label-selector", "", "Restricts the informer enabled with --secret-informer to secrets with these labels, for example \"provisioner-secret=true\". By default, all secrets are cached.")
+	randomSeed                      = flag.Int64("random-seed", 0, "If non-zero, seeds all random decisions, like the suffix of the provisioner identity, the jitter of retry delays and of the node deployment delays and the topology for PVCs without a name, so that tests and incidents can be reproduced. Zero uses a different seed for each run.")
+	canaryStorageClass              = flag.String("canary-storage-class", "", "If set, a volume gets provisioned with this storage class and deleted again when becoming the leader and on demand through POST requests to /canary at the HTTP endpoint, to check that provisioning works. The result is reported with metrics and events for the pod identified by the POD_NAME and NAMESPACE environment variables.")
+	canarySize                      = flag.String("canary-size", "1Mi", "The size of the volume provisioned by the canary check.")
+	maxVolumeSize                   = flag.String("max-volume-size", "", "If set, PVCs which request more than this are rejected with a VolumeTooLarge event instead of calling CreateVolume. By default, the maximum volume size that the CSI driver reports in a GetCapacity call without parameters is used, if it supports GET_CAPACITY.")
+	canaryInterval                  = flag.Duration("canary-interval", 0, "If non-zero, the canary check enabled with --canary-storage-class gets repeated at this interval.")
+	shadowMode                      = flag.Bool("shadow-mode", false, "Process PVCs and PVs without changing anything: CSI calls which modify volumes and Kubernetes API writes are only logged and counted by the shadow_mode_suppressed_calls_total metric. Meant for comparing a new version against the active instance. Not supported together with --leader-election.")
+	csiCaptureFile                  = flag.String("csi-capture-file", "", "For debugging only: append all CreateVolume and DeleteVolume calls made by the controllers, with their results, to this file. Values of secrets are not recorded. The calls can be sent again to a driver with csi-rpc-replay.")
+	capabilityRefreshInterval       = flag.Duration("capability-refresh-interval", 0, "If non-zero, the plugin and controller capabilities of the CSI driver get retrieved again at this interval, so that changed support for snapshots and cloning takes effect without a restart.")
+	volumeAttributesRefreshInterval = flag.Duration("volume-attributes-refresh-interval", 0, "If non-zero, the volume attributes of PVs get compared with the volume context reported by ControllerGetVolume at this interval and updated when they differ. Requires a driver with GET_VOLUME capability.")
+	claimEventLimit                 = flag.Int("claim-event-limit", 0, "If non-zero, at most this many events get written for each PVC during --claim-event-window. Further events are dropped, the next written event with the same reason and message mentions how often it was repeated.")
+	claimEventWindow                = flag.Duration("claim-event-window", 10*time.Minute, "The time window for --claim-event-limit.")
+	provisioningFinalizer           = flag.Bool("provisioning-finalizer", false, "Add a finalizer to PVCs while CreateVolume is in progress, so that the PVC cannot be removed before the outcome is known and the volume deleted again if necessary.")
+	workqueueItemAgeWarning         = flag.Duration("workqueue-item-age-warning", 0, "If non-zero, a warning event is emitted for the pod identified by the POD_NAME and NAMESPACE environment variables when an item in one of the work queues has not been processed successfully for this long, for example because it keeps failing. For the claims and volumes queues, the time is measured from the first failed attempt.")
+
+	debugStateEndpoint = flag.Bool("enable-debug-state-endpoint", false, "Serves GET requests at /debug/state on the HTTP endpoint with a JSON dump of pending PVCs, operations in progress, topology segments and capacity work items. Requests must have a bearer token of a user who may get that non-resource URL.")
+	enableProfile      = flag.Bool("enable-pprof", false, "Enable pprof profiling on the TCP network address specified by --http-endpoint. The HTTP path is `/debug/pprof/`.")
+
+	claimShards             = flag.Int("claim-shards", 1, "Number of external-provisioner instances which share the work by handling only some of the PVCs, as determined by --claim-shard-key.")
+	claimShardIndex         = flag.Int("claim-shard-index", 0, "The shard handled by this instance when --claim-shards is larger than one, in the range from 0 to --claim-shards minus one.")
+	claimShardIndexFromName = flag.Bool("claim-shard-index-from-pod-name", false, "Use the StatefulSet ordinal at the end of the POD_NAME environment variable as --claim-shard-index, so that all replicas of a StatefulSet can use the same command line.")
+	claimShardKey           = flag.String("claim-shard-key", string(ctrl.ClaimShardByNamespace), "How PVCs are assigned to shards: \"namespace\" by a hash of the namespace name, \"uid\" by a consistent hash of the PVC UID, which spreads PVCs evenly also when most of them are in a few namespaces.")
+
+	provisionerInstanceID = flag.String("provisioner-instance-id", "", "If set, new PVs are annotated with this ID and only PVs with this ID, without an ID or with one of the --adopt-provisioner-instance-ids get deleted. Allows several deployments for the same driver, for example during a blue/green rollout.")
+	adoptedInstanceIDs    = flag.StringSlice("adopt-provisioner-instance-ids", nil, "Instance IDs of other deployments whose PVs are also deleted by this one, for example the ID of the previous deployment after a blue/green rollout. Requires --provisioner-instance-id.")
+
+	provisionerIdentity        = flag.String("provisioner-identity", "", "If set, this identity gets recorded in the volume attributes of new PVs instead of one which is generated from the start time and a random number on each start. Must be different for all instances which run at the same time, except that --node-deployment appends the node name.")
+	provisionerIdentityFromPod = flag.Bool("provisioner-identity-from-pod-name", false, "Derive the identity from the NAMESPACE and POD_NAME environment variables, so that it stays the same when the pod gets restarted, for example in a StatefulSet.")
+
+	controllers = flag.StringSlice("controllers", allControllers.List(), "The controllers that run in this instance: \"provision\" for provisioning volumes, \"delete\" for deleting them, \"cloning-protection\" for removing the finalizer of clone sources, \"capacity\" for producing CSIStorageCapacity objects when enabled with --enable-capacity. \"provisioning\" is a shorthand for the first three.")
 
 	featureGates        map[string]bool
 	provisionController *controller.ProvisionController
+	vaIndexedLister     storagelistersv1.VolumeAttachmentLister
+	volumeNameTmpl      *template.Template
+	maxVolumeSizeBytes  int64
 	version             = "unknown"
 )
 
+const (
+	controllerProvision         = "provision"
+	controllerDelete            = "delete"
+	controllerCloningProtection = "cloning-protection"
+	controllerCapacity          = "capacity"
+
+	// controllerProvisioning enables provision, delete and cloning-protection.
+	controllerProvisioning = "provisioning"
+)
+
+var allControllers = sets.NewString(controllerProvision, controllerDelete, controllerCloningProtection, controllerCapacity)
+
+// criticalInformers must be synced before provisioning can start, even
+// when --cache-sync-timeout is used. All other informers are optional.
+var criticalInformers = map[reflect.Type]bool{
+	reflect.TypeOf(&v1.PersistentVolumeClaim{}): true,
+	reflect.TypeOf(&storagev1.StorageClass{}):   true,
+}
+
+// topologyInformers are also critical when provisioning reads the
+// topology of the nodes from them.
+var topologyInformers = map[reflect.Type]bool{
+	reflect.TypeOf(&v1.Node{}):           true,
+	reflect.TypeOf(&storagev1.CSINode{}): true,
+}
+
 type leaderElection interface {
 	Run() error
 	WithNamespace(namespace string)
@@ -122,6 +278,26 @@ func main() {
 	flag.Set("logtostderr", "true")
 	flag.Parse()
 
+	if *configFile != "" {
+		// Flags from the file must be set before anything else
+		// looks at them, including the rate limiter defaults below.
+		fileConfig, err := provisionerconfig.Load(*configFile, provisionerconfig.NewRateLimiterDefaults(*retryIntervalStart, *retryIntervalMax))
+		if err != nil {
+			klog.Fatalf("--config: %v", err)
+		}
+		if err := fileConfig.ApplyFlags(flag.CommandLine); err != nil {
+			klog.Fatalf("--config: %s: %v", *configFile, err)
+		}
+	}
+
+	switch *loggingFormat {
+	case "text":
+	case "json":
+		klog.SetLogger(jsonlog.NewLogger(os.Stderr))
+	default:
+		klog.Fatalf("Unsupported --logging-format %q, must be \"text\" or \"json\".", *loggingFormat)
+	}
+
 	if err := utilfeature.DefaultMutableFeatureGate.SetFromMap(featureGates); err != nil {
 		klog.Fatal(err)
 	}
@@ -130,6 +306,184 @@ func main() {
 	if *enableNodeDeployment && node == "" {
 		klog.Fatal("The NODE_NAME environment variable must be set when using --enable-node-deployment.")
 	}
+	switch ctrl.TopologyLimitStrategy(*requisiteTopologyLimitStrategy) {
+	case ctrl.TopologyLimitTruncate, ctrl.TopologyLimitError, ctrl.TopologyLimitSample:
+	default:
+		klog.Fatalf("Invalid --requisite-topology-limit-strategy %q, must be %q, %q or %q.", *requisiteTopologyLimitStrategy, ctrl.TopologyLimitTruncate, ctrl.TopologyLimitError, ctrl.TopologyLimitSample)
+	}
+	if _, err := ctrl.ParseTopologyMode(*topologyMode); err != nil {
+		klog.Fatalf("--topology-mode: %v", err)
+	}
+	if len(*additionalCSIEndpoints) > 0 && !*enableNodeDeployment {
+		klog.Fatal("--additional-csi-address is only supported together with --node-deployment.")
+	}
+	if csiTLSConfig().Enabled() {
+		networkEndpoint := false
+		for _, endpoint := range append(ctrl.SplitEndpoints(*csiEndpoint), *additionalCSIEndpoints...) {
+			networkEndpoint = networkEndpoint || ctrl.IsNetworkEndpoint(endpoint)
+		}
+		if !networkEndpoint {
+			klog.Fatal("--csi-tls-ca-file, --csi-tls-cert-file, --csi-tls-key-file and --csi-tls-server-name are only supported together with tcp:// endpoints.")
+		}
+	}
+	if *metricsExportEndpoint != "" && *metricsExportInterval <= 0 {
+		klog.Fatal("--metrics-export-interval must be positive.")
+	}
+	if *tracingEndpoint != "" && *tracingExportInterval <= 0 {
+		klog.Fatal("--tracing-export-interval must be positive.")
+	}
+	if *tracingSamplingRatio < 0 || *tracingSamplingRatio > 1 {
+		klog.Fatal("--tracing-sampling-ratio must be between 0 and 1.")
+	}
+	if *fairSchedulingSlots > 0 && *fairSchedulingSlots >= *workerThreads {
+		klog.Fatal("--fair-scheduling-slots must be smaller than --worker-threads.")
+	}
+	if *reservedWorkerThreads > 0 {
+		if *reservedWorkerThreads >= *workerThreads {
+			klog.Fatal("--reserved-worker-threads must be smaller than --worker-threads.")
+		}
+		if len(*reservedNamespaces) == 0 {
+			klog.Fatal("--reserved-worker-threads requires --reserved-namespaces.")
+		}
+	}
+	if len(*adoptedInstanceIDs) > 0 && *provisionerInstanceID == "" {
+		klog.Fatal("--adopt-provisioner-instance-ids requires --provisioner-instance-id.")
+	}
+	rateLimiterDefaults := provisionerconfig.NewRateLimiterDefaults(*retryIntervalStart, *retryIntervalMax)
+	cfg := provisionerconfig.Default(rateLimiterDefaults)
+	if *configFile != "" {
+		var err error
+		cfg, err = provisionerconfig.Load(*configFile, rateLimiterDefaults)
+		if err != nil {
+			klog.Fatalf("--config: %v", err)
+		}
+	}
+	orphanedVolumes, err := ctrl.ParseOrphanedVolumePolicy(*nodeDeploymentOrphanedVolumes)
+	if err != nil {
+		klog.Fatalf("--node-deployment-orphaned-volumes: %v", err)
+	}
+	var latencyBuckets []float64
+	if *operationLatencyBuckets != "" {
+		latencyBuckets, err = csimetrics.ParseBuckets(*operationLatencyBuckets)
+		if err != nil {
+			klog.Fatalf("--csi-operation-latency-buckets: %v", err)
+		}
+	}
+	var ownerGVK *schema.GroupVersionKind
+	if *capacityOwnerrefGVK != "" {
+		gvk, err := owner.ParseGroupVersionKind(*capacityOwnerrefGVK)
+		if err != nil {
+			klog.Fatalf("--capacity-ownerref-gvk: %v", err)
+		}
+		ownerGVK = &gvk
+	} else if *capacityOwnerrefName != "" {
+		klog.Fatal("--capacity-ownerref-name requires --capacity-ownerref-gvk.")
+	}
+	if *volumeNameTemplate != "" {
+		if flag.CommandLine.Changed("volume-name-prefix") || flag.CommandLine.Changed("volume-name-uuid-length") {
+			klog.Fatal("--volume-name-template cannot be combined with --volume-name-prefix or --volume-name-uuid-length.")
+		}
+		tmpl, err := ctrl.ParseVolumeNameTemplate(*volumeNameTemplate)
+		if err != nil {
+			klog.Fatalf("Invalid --volume-name-template: %v", err)
+		}
+		volumeNameTmpl = tmpl
+	}
+	if *capacityCacheTTL < 0 {
+		klog.Fatal("--capacity-cache-ttl must not be negative.")
+	}
+	if *capacityWriteQPS > 0 && *capacityWriteBurst < 1 {
+		klog.Fatal("--capacity-write-burst must be at least one when --capacity-write-qps is set.")
+	}
+	if *leakedVolumesLogInterval > 0 && *enableNodeDeployment {
+		klog.Fatal("--leaked-volumes-log-interval is not supported together with --node-deployment.")
+	}
+	if !*watchVolumeAttachments && *deleteWaitForAttachments {
+		klog.Fatal("--delete-wait-for-volume-attachments cannot be used together with --watch-volumeattachments=false.")
+	}
+	if !utilfeature.DefaultFeatureGate.Enabled(features.DeleteWaitForDetach) && *deleteWaitForAttachments {
+		klog.Fatal("--delete-wait-for-volume-attachments cannot be used together with --feature-gates=DeleteWaitForDetach=false.")
+	}
+	if *createVolumeTimeout < 0 || *deleteVolumeTimeout < 0 || *restoreTimeout < 0 {
+		klog.Fatal("--create-volume-timeout, --delete-volume-timeout and --snapshot-restore-timeout must not be negative.")
+	}
+	if *claimShardIndexFromName {
+		ordinal, err := ctrl.ParseStatefulSetOrdinal(os.Getenv("POD_NAME"))
+		if err != nil {
+			klog.Fatalf("--claim-shard-index-from-pod-name: %v", err)
+		}
+		*claimShardIndex = ordinal
+	}
+	if *provisionerIdentity != "" && *provisionerIdentityFromPod {
+		klog.Fatal("--provisioner-identity and --provisioner-identity-from-pod-name cannot be used together.")
+	}
+	if *provisionerIdentityFromPod && (os.Getenv("NAMESPACE") == "" || os.Getenv("POD_NAME") == "") {
+		klog.Fatal("--provisioner-identity-from-pod-name needs the NAMESPACE and POD_NAME env variables.")
+	}
+	if *claimShards < 1 || *claimShardIndex < 0 || *claimShardIndex >= *claimShards {
+		klog.Fatal("--claim-shard-index must be at least zero and smaller than --claim-shards, which must be at least one.")
+	}
+	switch ctrl.ClaimShardKey(*claimShardKey) {
+	case ctrl.ClaimShardByNamespace, ctrl.ClaimShardByUID:
+	default:
+		klog.Fatalf("Invalid --claim-shard-key %q, must be %q or %q.", *claimShardKey, ctrl.ClaimShardByNamespace, ctrl.ClaimShardByUID)
+	}
+	claimShard := ctrl.ClaimShard{Index: *claimShardIndex, Count: *claimShards, Key: ctrl.ClaimShardKey(*claimShardKey)}
+	if claimShard.Count > 1 && *leakedVolumesLogInterval > 0 {
+		klog.Fatal("--leaked-volumes-log-interval is not supported together with --claim-shards.")
+	}
+	enabledControllers := sets.NewString(*controllers...)
+	if enabledControllers.Has(controllerProvisioning) {
+		enabledControllers.Delete(controllerProvisioning)
+		enabledControllers.Insert(controllerProvision, controllerDelete, controllerCloningProtection)
+	}
+	if unknown := enabledControllers.Difference(allControllers); unknown.Len() > 0 {
+		klog.Fatalf("Invalid --controllers %v, supported are %v and %q.", unknown.List(), allControllers.List(), controllerProvisioning)
+	}
+	runProvision := enabledControllers.Has(controllerProvision)
+	runDelete := enabledControllers.Has(controllerDelete)
+	runCloningProtection := enabledControllers.Has(controllerCloningProtection)
+	runCapacity := enabledControllers.Has(controllerCapacity) && *enableCapacity
+	// The provisioner library handles both provisioning and deleting.
+	runProvisionController := runProvision || runDelete
+	watchClaims := runProvisionController || runCloningProtection
+	if !watchClaims && !runCapacity && !*capacityAggregation {
+		klog.Fatal("No controller enabled, check --controllers, --enable-capacity and --enable-capacity-aggregation.")
+	}
+	if claimShard.Count > 1 && runCapacity {
+		klog.Fatal("--claim-shards cannot be used in an instance which runs the capacity controller, run it in a separate instance with --controllers=capacity.")
+	}
+	csiFaults := faultinject.Faults{Latency: *faultInjectionCSILatency, ErrorRate: *faultInjectionCSIErrorRate}
+	if err := csiFaults.Validate(); err != nil {
+		klog.Fatalf("Invalid --fault-injection-csi-*: %v", err)
+	}
+	apiFaults := faultinject.Faults{Latency: *faultInjectionAPILatency, ErrorRate: *faultInjectionAPIErrorRate}
+	if err := apiFaults.Validate(); err != nil {
+		klog.Fatalf("Invalid --fault-injection-api-*: %v", err)
+	}
+	if *shadowMode && *enableLeaderElection {
+		klog.Fatal("--shadow-mode is not supported together with --leader-election.")
+	}
+	if *claimEventLimit < 0 || *claimEventLimit > 0 && *claimEventWindow <= 0 {
+		klog.Fatal("--claim-event-limit must not be negative and --claim-event-window must be positive.")
+	}
+	canaryVolumeSize, err := resource.ParseQuantity(*canarySize)
+	if err != nil {
+		klog.Fatalf("Invalid --canary-size: %v", err)
+	}
+	if *maxVolumeSize != "" {
+		size, err := resource.ParseQuantity(*maxVolumeSize)
+		if err != nil || size.Sign() <= 0 {
+			klog.Fatalf("Invalid --max-volume-size %q, must be a positive quantity.", *maxVolumeSize)
+		}
+		maxVolumeSizeBytes = size.Value()
+	}
+	if _, err := labels.Parse(*secretInformerLabelSelector); err != nil {
+		klog.Fatalf("Invalid --secret-informer-label-selector: %v", err)
+	}
+	if *canaryStorageClass != "" && (*enableNodeDeployment || !runProvision || !runDelete) {
+		klog.Fatal("--canary-storage-class requires the provision and delete controllers and is not supported together with --node-deployment.")
+	}
 
 	if *showVersion {
 		fmt.Println(os.Args[0], version)
@@ -137,6 +491,13 @@ func main() {
 	}
 	klog.Infof("Version: %s", version)
 
+	if *randomSeed != 0 {
+		klog.Infof("Using random seed %d", *randomSeed)
+		rand.Seed(*randomSeed)
+	} else {
+		rand.Seed(time.Now().UnixNano())
+	}
+
 	if *metricsAddress != "" && *httpEndpoint != "" {
 		klog.Error("only one of `--metrics-address` and `--http-endpoint` can be set.")
 		os.Exit(1)
@@ -145,6 +506,9 @@ func main() {
 	if addr == "" {
 		addr = *httpEndpoint
 	}
+	if *enableProfile && addr == "" {
+		klog.Fatal("--enable-pprof is only supported together with --http-endpoint.")
+	}
 
 	// get the KUBECONFIG from env if specified (useful for local/debug cluster)
 	kubeconfigEnv := os.Getenv("KUBECONFIG")
@@ -154,7 +518,14 @@ func main() {
 		kubeconfig = &kubeconfigEnv
 	}
 
-	if *master != "" || *kubeconfig != "" {
+	if *kubeconfig != "" && *kubeconfigReloadInterval > 0 {
+		klog.Infof("Kubeconfig specified. building kube config from that and reloading credentials when it changes..")
+		var reloader *kubeconfigreloader.Reloader
+		config, reloader, err = kubeconfigreloader.NewConfig(*master, *kubeconfig)
+		if err == nil {
+			go reloader.Run(context.Background(), *kubeconfigReloadInterval)
+		}
+	} else if *master != "" || *kubeconfig != "" {
 		klog.Infof("Either master or kubeconfig specified. building kube config from that..")
 		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
 	} else {
@@ -167,11 +538,54 @@ func main() {
 
 	config.QPS = *kubeAPIQPS
 	config.Burst = *kubeAPIBurst
+	// Leader election gets a copy without the rate limiters, fault
+	// injection and tracing added below. Renewing the lease must
+	// neither get delayed nor fail because of the other clients.
+	leConfig := rest.CopyConfig(config)
+	if *kubeAPIAdaptiveQPS {
+		if *kubeAPIMinQPS <= 0 || *kubeAPIMinQPS > *kubeAPIQPS {
+			klog.Fatal("--kube-api-min-qps must be positive and not larger than --kube-api-qps.")
+		}
+		limiter := adaptiveqps.New(*kubeAPIMinQPS, *kubeAPIQPS, *kubeAPIBurst, *kubeAPILatencyThreshold)
+		config.RateLimiter = limiter
+		config.Wrap(limiter.WrapTransport)
+	}
+	if len(*kubeAPIResourceQPS) > 0 {
+		limits, err := resourceqps.ParseLimits(*kubeAPIResourceQPS)
+		if err != nil {
+			klog.Fatalf("Invalid --kube-api-resource-qps: %v", err)
+		}
+		defaultLimiter := config.RateLimiter
+		if defaultLimiter == nil {
+			defaultLimiter = flowcontrol.NewTokenBucketRateLimiter(config.QPS, config.Burst)
+		}
+		// Rate limiting moves from the client into the transport,
+		// which knows the resource of each request.
+		config.RateLimiter = flowcontrol.NewFakeAlwaysRateLimiter()
+		config.Wrap(resourceqps.WrapTransport(limits, defaultLimiter))
+	}
+	if apiFaults.Enabled() {
+		klog.Warningf("Injecting faults into Kubernetes API writes: %s", apiFaults)
+		config.Wrap(faultinject.WrapTransport(apiFaults))
+	}
+	if *shadowMode {
+		klog.Warning("Running in shadow mode, Kubernetes API writes and CSI calls which modify volumes are only logged")
+		config.Wrap(shadow.WrapTransport())
+	}
+	if *tracingEndpoint != "" {
+		// Only requests made while provisioning or deleting get
+		// traced, everything else passes through unchanged.
+		config.Wrap(otlp.WrapTransport())
+	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		klog.Fatalf("Failed to create client: %v", err)
 	}
+	var clientset kubernetes.Interface = client
+	if *claimEventLimit > 0 {
+		clientset = eventlimit.WrapClient(clientset, eventlimit.NewLimiter(*claimEventLimit, *claimEventWindow))
+	}
 
 	// snapclientset.NewForConfig creates a new Clientset for VolumesnapshotV1beta1Client
 	snapClient, err := snapclientset.NewForConfig(config)
@@ -186,13 +600,10 @@ func main() {
 		klog.Fatalf("Error getting server version: %v", err)
 	}
 
-	metricsManager := metrics.NewCSIMetricsManagerWithOptions("", /* driverName */
-		// Will be provided via default gatherer.
-		metrics.WithProcessStartTime(false),
-		metrics.WithSubsystem(metrics.SubsystemSidecar),
-	)
+	metricsManager := newMetricsManager("" /* driverName */, latencyBuckets, false)
 
-	grpcClient, err := ctrl.Connect(*csiEndpoint, metricsManager)
+	csiEndpoints := ctrl.SplitEndpoints(*csiEndpoint)
+	grpcClient, csiConn, err := connectCSI(csiEndpoints, metricsManager)
 	if err != nil {
 		klog.Error(err.Error())
 		os.Exit(1)
@@ -222,17 +633,15 @@ func main() {
 		klog.V(2).Infof("Supports migration from in-tree plugin: %s", supportsMigrationFromInTreePluginName)
 
 		// Create a new connection with the metrics manager with migrated label
-		metricsManager = metrics.NewCSIMetricsManagerWithOptions(provisionerName,
-			// Will be provided via default gatherer.
-			metrics.WithProcessStartTime(false),
-			metrics.WithMigration())
-		migratedGrpcClient, err := ctrl.Connect(*csiEndpoint, metricsManager)
+		metricsManager = newMetricsManager(provisionerName, latencyBuckets, true)
+		migratedGrpcClient, migratedCSIConn, err := connectCSI(csiEndpoints, metricsManager)
 		if err != nil {
 			klog.Error(err.Error())
 			os.Exit(1)
 		}
 		grpcClient.Close()
 		grpcClient = migratedGrpcClient
+		csiConn = migratedCSIConn
 
 		err = ctrl.Probe(grpcClient, *operationTimeout)
 		if err != nil {
@@ -241,6 +650,23 @@ func main() {
 		}
 	}
 
+	// Only calls made by the controllers are affected, not the
+	// ones during startup.
+	controllerConn := csiConn
+	if *csiCaptureFile != "" {
+		recorder, err := capture.OpenRecorder(*csiCaptureFile)
+		if err != nil {
+			klog.Fatalf("Failed to open --csi-capture-file: %v", err)
+		}
+		defer recorder.Close()
+		klog.Infof("Recording CreateVolume and DeleteVolume calls in %s", *csiCaptureFile)
+		controllerConn = capture.WrapConn(controllerConn, recorder)
+	}
+	if csiFaults.Enabled() {
+		klog.Warningf("Injecting faults into CSI calls: %s", csiFaults)
+	}
+	controllerConn = wrapControllerConn(controllerConn, csiFaults)
+
 	// Prepare http endpoint for metrics + leader election healthz
 	mux := http.NewServeMux()
 	gatherers := prometheus.Gatherers{
@@ -261,26 +687,77 @@ func main() {
 	}
 
 	// Generate a unique ID for this provisioner
-	timeStamp := time.Now().UnixNano() / int64(time.Millisecond)
-	identity := strconv.FormatInt(timeStamp, 10) + "-" + strconv.Itoa(rand.Intn(10000)) + "-" + provisionerName
+	var identity string
+	switch {
+	case *provisionerIdentity != "":
+		identity = *provisionerIdentity
+	case *provisionerIdentityFromPod:
+		// Pod names are unique within a namespace.
+		identity = os.Getenv("NAMESPACE") + "/" + os.Getenv("POD_NAME") + "-" + provisionerName
+	default:
+		timeStamp := time.Now().UnixNano() / int64(time.Millisecond)
+		identity = strconv.FormatInt(timeStamp, 10) + "-" + strconv.Itoa(rand.Intn(10000)) + "-" + provisionerName
+	}
 	if *enableNodeDeployment {
 		identity = identity + "-" + node
 	}
+	klog.V(2).Infof("Provisioner identity: %s", identity)
 
 	factory := informers.NewSharedInformerFactory(clientset, ctrl.ResyncPeriodOfCsiNodeInformer)
-	var factoryForNamespace informers.SharedInformerFactory // usually nil, only used for CSIStorageCapacity
+	var capacityInformer storageinformersv1beta1.CSIStorageCapacityInformer // usually nil, only used for CSIStorageCapacity
+	var capacityClient capacity.CSIStorageCapacitiesGetter
+	if runCapacity || *capacityAggregation {
+		capacityClient = newCapacityClient(config, clientset)
+	}
+	if claimShard.Count > 1 {
+		// Must be registered before anything else asks for the claim informer.
+		klog.Infof("Handling PVCs in shard %d of %d by %s", claimShard.Index, claimShard.Count, claimShard.Key)
+		factory.InformerFor(&v1.PersistentVolumeClaim{}, func(client kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+			return ctrl.NewShardedClaimInformer(client, resyncPeriod, claimShard)
+		})
+	}
 
 	// -------------------------------
 	// Listers
 	// Create informer to prevent hit the API server for all resource request
 	scLister := factory.Storage().V1().StorageClasses().Lister()
-	claimLister := factory.Core().V1().PersistentVolumeClaims().Lister()
+	var claimLister listersv1.PersistentVolumeClaimLister
+	if watchClaims {
+		claimLister = factory.Core().V1().PersistentVolumeClaims().Lister()
+	}
+	csiDriverLister := factory.Storage().V1().CSIDrivers().Lister()
+
+	// Secrets get their own factory because only some of them are
+	// needed and the informer may be restricted accordingly.
+	var secretFactory informers.SharedInformerFactory
+	var secretLister listersv1.SecretLister
+	if *secretInformer {
+		klog.Infof("Caching secrets in namespace %q with labels %q", *secretInformerNamespace, *secretInformerLabelSelector)
+		secretFactory = informers.NewSharedInformerFactoryWithOptions(clientset, ctrl.ResyncPeriodOfCsiNodeInformer,
+			informers.WithNamespace(*secretInformerNamespace),
+			informers.WithTweakListOptions(func(lo *metav1.ListOptions) {
+				lo.LabelSelector = *secretInformerLabelSelector
+			}),
+		)
+		secretLister = secretFactory.Core().V1().Secrets().Lister()
+	}
 
 	var vaLister storagelistersv1.VolumeAttachmentLister
-	if controllerCapabilities[csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME] {
-		klog.Info("CSI driver supports PUBLISH_UNPUBLISH_VOLUME, watching VolumeAttachments")
-		vaLister = factory.Storage().V1().VolumeAttachments().Lister()
-	} else {
+	switch {
+	case !runDelete:
+		// VolumeAttachments are only needed for deleting volumes.
+	case !*watchVolumeAttachments:
+		klog.Info("Not watching VolumeAttachments because of --watch-volumeattachments=false")
+	case !utilfeature.DefaultFeatureGate.Enabled(features.DeleteWaitForDetach):
+		klog.Info("Not watching VolumeAttachments because the DeleteWaitForDetach feature gate is disabled")
+	case controllerCapabilities[csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME] || *deleteWaitForAttachments:
+		if controllerCapabilities[csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME] {
+			klog.Info("CSI driver supports PUBLISH_UNPUBLISH_VOLUME, watching VolumeAttachments")
+		} else {
+			klog.Info("CSI driver does not support PUBLISH_UNPUBLISH_VOLUME, watching VolumeAttachments anyway because of --delete-wait-for-volume-attachments")
+		}
+		vaLister = newVolumeAttachmentLister(factory)
+	default:
 		klog.Info("CSI driver does not support PUBLISH_UNPUBLISH_VOLUME, not watching VolumeAttachments")
 	}
 
@@ -292,79 +769,81 @@ func main() {
 			ImmediateBinding: *nodeDeploymentImmediateBinding,
 			BaseDelay:        *nodeDeploymentBaseDelay,
 			MaxDelay:         *nodeDeploymentMaxDelay,
+			OrphanedVolumes:  orphanedVolumes,
+		}
+		if orphanedVolumes != ctrl.OrphanedVolumesIgnore {
+			// Finding orphaned volumes needs the real objects, in contrast
+			// to the local topology below.
+			nodeDeployment.NodeLister = factory.Core().V1().Nodes().Lister()
+			nodeDeployment.CSINodeLister = factory.Storage().V1().CSINodes().Lister()
 		}
 		nodeInfo, err := ctrl.GetNodeInfo(grpcClient, *operationTimeout)
 		if err != nil {
 			klog.Fatalf("Failed to get node info from CSI driver: %v", err)
 		}
-		nodeDeployment.NodeInfo = *nodeInfo
+		nodeDeployment.NodeInfo = *verifyNodeInfo(clientset, provisionerName, node, nodeInfo)
 	}
 
 	var nodeLister listersv1.NodeLister
 	var csiNodeLister storagelistersv1.CSINodeLister
+	var topologySynced []cache.InformerSynced
 	if ctrl.SupportsTopology(pluginCapabilities) {
 		if nodeDeployment != nil {
-			// Avoid watching in favor of fake, static objects. This is particularly relevant for
-			// Node objects, which can generate significant traffic.
-			csiNode := &storagev1.CSINode{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: nodeDeployment.NodeName,
-				},
-				Spec: storagev1.CSINodeSpec{
-					Drivers: []storagev1.CSINodeDriver{
-						{
-							Name:   provisionerName,
-							NodeID: nodeDeployment.NodeInfo.NodeId,
-						},
-					},
-				},
-			}
-			node := &v1.Node{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: nodeDeployment.NodeName,
-				},
-			}
-			if nodeDeployment.NodeInfo.AccessibleTopology != nil {
-				for key := range nodeDeployment.NodeInfo.AccessibleTopology.Segments {
-					csiNode.Spec.Drivers[0].TopologyKeys = append(csiNode.Spec.Drivers[0].TopologyKeys, key)
-				}
-				node.Labels = nodeDeployment.NodeInfo.AccessibleTopology.Segments
-			}
-			klog.Infof("using local topology with Node = %+v and CSINode = %+v", node, csiNode)
-
-			// We make those fake objects available to the topology code via informers which
-			// never change.
-			stoppedFactory := informers.NewSharedInformerFactory(clientset, 1000*time.Hour)
-			csiNodes := stoppedFactory.Storage().V1().CSINodes()
-			nodes := stoppedFactory.Core().V1().Nodes()
-			csiNodes.Informer().GetStore().Add(csiNode)
-			nodes.Informer().GetStore().Add(node)
-			csiNodeLister = csiNodes.Lister()
-			nodeLister = nodes.Lister()
-
+			csiNodeLister, nodeLister = localTopologyListers(clientset, provisionerName, nodeDeployment)
 		} else {
 			csiNodeLister = factory.Storage().V1().CSINodes().Lister()
 			nodeLister = factory.Core().V1().Nodes().Lister()
+			topologySynced = []cache.InformerSynced{
+				factory.Storage().V1().CSINodes().Informer().HasSynced,
+				factory.Core().V1().Nodes().Informer().HasSynced,
+			}
 		}
 	}
 
 	// -------------------------------
 	// PersistentVolumeClaims informer
-	rateLimiter := workqueue.NewItemExponentialFailureRateLimiter(*retryIntervalStart, *retryIntervalMax)
-	claimQueue := workqueue.NewNamedRateLimitingQueue(rateLimiter, "claims")
-	claimInformer := factory.Core().V1().PersistentVolumeClaims().Informer()
+	rateLimiter := newRateLimiter(cfg.RateLimiters.Default)
+	claimQueue := ctrl.NewNamedRateLimitingQueue(newRateLimiter(cfg.RateLimiters.Cloning), "cloning")
+	var claimInformer cache.SharedIndexInformer
+	if watchClaims {
+		claimInformer = factory.Core().V1().PersistentVolumeClaims().Informer()
+		if err := ctrl.AddClaimIndexers(claimInformer); err != nil {
+			klog.Fatalf("Failed to add PVC indexers: %v", err)
+		}
+	}
+	if runProvision {
+		nodeName := ""
+		if nodeDeployment != nil {
+			nodeName = nodeDeployment.NodeName
+		}
+		claimInformer.AddEventHandler(ctrl.NewBoundLatencyTracker(provisionerName, nodeName))
+	}
+
+	// Retries of CreateVolume and DeleteVolume optionally share a global
+	// budget, also with the deletion controller.
+	var retryBudgetLimiter workqueue.RateLimiter
+	if *retryBudget > 0 {
+		retryBudgetLimiter = ctrl.NewRetryBudget(*retryBudget)
+	}
+	provisionRateLimiter := newRateLimiter(cfg.RateLimiters.Claims)
+	if retryBudgetLimiter != nil {
+		provisionRateLimiter = ctrl.NewRetryBudgetRateLimiter(provisionRateLimiter, retryBudgetLimiter)
+	}
+	provisionRateLimiter = ctrl.NewBackoffTrackingRateLimiter(provisionRateLimiter)
 
 	// Setup options
-	provisionerOptions := []func(*controller.ProvisionController) error{
+	baseProvisionerOptions := []func(*controller.ProvisionController) error{
 		controller.LeaderElection(false), // Always disable leader election in provisioner lib. Leader election should be done here in the CSI provisioner level instead.
 		controller.FailedProvisionThreshold(0),
 		controller.FailedDeleteThreshold(0),
-		controller.RateLimiter(rateLimiter),
+		controller.RateLimiter(provisionRateLimiter),
 		controller.Threadiness(int(*workerThreads)),
 		controller.CreateProvisionedPVLimiter(workqueue.DefaultControllerRateLimiter()),
 		controller.ClaimsInformer(claimInformer),
-		controller.NodesLister(nodeLister),
 	}
+	provisionerOptions := append(baseProvisionerOptions[:len(baseProvisionerOptions):len(baseProvisionerOptions)],
+		controller.NodesLister(nodeLister),
+	)
 
 	if supportsMigrationFromInTreePluginName != "" {
 		provisionerOptions = append(provisionerOptions, controller.AdditionalProvisionerNames([]string{supportsMigrationFromInTreePluginName}))
@@ -378,7 +857,7 @@ func main() {
 		identity,
 		*volumeNamePrefix,
 		*volumeNameUUIDLength,
-		grpcClient,
+		controllerConn,
 		snapClient,
 		provisionerName,
 		pluginCapabilities,
@@ -395,41 +874,108 @@ func main() {
 		*extraCreateMetadata,
 		*defaultFSType,
 		nodeDeployment,
+		ctrl.ProvisionerOptions{
+			CSIDriverLister:        csiDriverLister,
+			MaxRequisiteTopologies: maxTopologyEntries(grpcClient, provisionerName),
+			TopologyLimitStrategy:  ctrl.TopologyLimitStrategy(*requisiteTopologyLimitStrategy),
+			TopologyMode:           ctrl.TopologyMode(*topologyMode),
+			LatencyAnnotations:     *latencyAnnotations,
+			Scheduler:              newStorageClassScheduler(),
+			ProvisioningFinalizer:  *provisioningFinalizer,
+			VolumeNameTemplate:     volumeNameTmpl,
+			MaxVolumeSize:          maxVolumeSizeLimit(grpcClient, provisionerName, controllerCapabilities),
+			SecretLister:           secretLister,
+			PVLister:               factory.Core().V1().PersistentVolumes().Lister(),
+		},
 	)
+	timeoutUpdaters := []ctrl.TimeoutUpdater{csiProvisioner.(ctrl.TimeoutUpdater)}
+
+	var canaryCheck *canary.Canary
+	if *canaryStorageClass != "" {
+		// Without any of the wrappers added below, in particular
+		// the one which selects the enabled controllers.
+		canaryCheck = canary.New(csiProvisioner, provisionerName, clientset, *canaryStorageClass, canaryVolumeSize,
+			os.Getenv("NAMESPACE"), os.Getenv("POD_NAME"))
+	}
+
+	var capabilityRefresher *ctrl.CapabilityRefresher
+	if *capabilityRefreshInterval > 0 {
+		capabilityRefresher = ctrl.NewCapabilityRefresher(
+			func() (rpc.PluginCapabilitySet, rpc.ControllerCapabilitySet, error) {
+				conn := grpcClient
+				if failover, ok := csiConn.(*ctrl.FailoverConn); ok {
+					conn = failover.Conn()
+				}
+				return ctrl.GetDriverCapabilities(conn, *operationTimeout)
+			},
+			pluginCapabilities,
+			controllerCapabilities,
+		)
+		for _, obj := range []interface{}{csiProvisioner, csiConn} {
+			if updater, ok := obj.(ctrl.CapabilitiesUpdater); ok {
+				capabilityRefresher.AddUpdater(updater)
+			}
+		}
+		capabilityRefresher.AddUpdater(ctrl.CapabilitiesUpdaterFunc(func(newPluginCapabilities rpc.PluginCapabilitySet, newControllerCapabilities rpc.ControllerCapabilitySet) {
+			// Informers and controllers were set up for the initial capabilities.
+			if ctrl.SupportsTopology(newPluginCapabilities) != ctrl.SupportsTopology(pluginCapabilities) ||
+				newControllerCapabilities[csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME] != controllerCapabilities[csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME] ||
+				newControllerCapabilities[csi.ControllerServiceCapability_RPC_CLONE_VOLUME] != controllerCapabilities[csi.ControllerServiceCapability_RPC_CLONE_VOLUME] ||
+				newControllerCapabilities[csi.ControllerServiceCapability_RPC_GET_CAPACITY] != controllerCapabilities[csi.ControllerServiceCapability_RPC_GET_CAPACITY] {
+				klog.Warning("Changes of topology, attach, cloning protection or capacity support of the CSI driver only take effect after restarting the external-provisioner")
+			}
+		}))
+	}
 
 	var capacityController *capacity.Controller
-	if *enableCapacity {
+	var topologyInformer topology.Informer
+	var lookupCapacityOwner func() (*metav1.OwnerReference, error)
+	if runCapacity {
 		namespace := os.Getenv("NAMESPACE")
 		if namespace == "" {
 			klog.Fatal("need NAMESPACE env variable for CSIStorageCapacity objects")
 		}
-		var controller *metav1.OwnerReference
-		if *capacityOwnerrefLevel >= 0 {
-			podName := os.Getenv("POD_NAME")
-			if podName == "" {
-				klog.Fatal("need POD_NAME env variable to determine CSIStorageCapacity owner")
+		podGVK := schema.GroupVersionKind{
+			Group:   "",
+			Version: "v1",
+			Kind:    "Pod",
+		}
+		podName := os.Getenv("POD_NAME")
+		if podName == "" && *capacityOwnerrefName == "" && (ownerGVK != nil || *capacityOwnerrefLevel >= 0) {
+			klog.Fatal("need POD_NAME env variable to determine CSIStorageCapacity owner")
+		}
+		switch {
+		case *capacityOwnerrefName != "":
+			lookupCapacityOwner = func() (*metav1.OwnerReference, error) {
+				return owner.Lookup(config, namespace, *capacityOwnerrefName, *ownerGVK, 0)
 			}
-			var err error
-			controller, err = owner.Lookup(config, namespace, podName,
-				schema.GroupVersionKind{
-					Group:   "",
-					Version: "v1",
-					Kind:    "Pod",
-				}, *capacityOwnerrefLevel)
-			if err != nil {
-				klog.Fatalf("look up owner(s) of pod: %v", err)
+		case ownerGVK != nil:
+			lookupCapacityOwner = func() (*metav1.OwnerReference, error) {
+				return owner.LookupKind(config, namespace, podName, podGVK, ownerGVK.GroupKind())
 			}
+		case *capacityOwnerrefLevel >= 0:
+			lookupCapacityOwner = func() (*metav1.OwnerReference, error) {
+				return owner.Lookup(config, namespace, podName, podGVK, *capacityOwnerrefLevel)
+			}
+		}
+		var controller *metav1.OwnerReference
+		if lookupCapacityOwner != nil {
+			controller, err = lookupCapacityOwner()
+		}
+		if err != nil {
+			klog.Fatalf("look up owner of CSIStorageCapacity objects: %v", err)
+		}
+		if controller != nil {
 			klog.Infof("using %s/%s %s as owner of CSIStorageCapacity objects", controller.APIVersion, controller.Kind, controller.Name)
 		}
 
-		var topologyInformer topology.Informer
 		if nodeDeployment == nil {
 			topologyInformer = topology.NewNodeTopology(
 				provisionerName,
 				clientset,
 				factory.Core().V1().Nodes(),
 				factory.Storage().V1().CSINodes(),
-				workqueue.NewNamedRateLimitingQueue(rateLimiter, "csitopology"),
+				ctrl.NewNamedRateLimitingQueue(newRateLimiter(cfg.RateLimiters.Topology), "csitopology"),
 			)
 		} else {
 			var segment topology.Segment
@@ -451,31 +997,41 @@ func main() {
 		// We only need objects from our own namespace. The normal factory would give
 		// us an informer for the entire cluster. We can further restrict the
 		// watch to just those objects with the right labels.
-		factoryForNamespace = informers.NewSharedInformerFactoryWithOptions(clientset,
+		capacityInformer = capacity.NewCSIStorageCapacityInformer(capacityClient,
+			namespace,
 			ctrl.ResyncPeriodOfCsiNodeInformer,
-			informers.WithNamespace(namespace),
-			informers.WithTweakListOptions(func(lo *metav1.ListOptions) {
+			func(lo *metav1.ListOptions) {
 				lo.LabelSelector = labels.Set{
 					capacity.DriverNameLabel: provisionerName,
 					capacity.ManagedByLabel:  managedByID,
 				}.AsSelector().String()
-			}),
+			},
 		)
 
+		var capacityWriteLimiter flowcontrol.RateLimiter
+		if *capacityWriteQPS > 0 {
+			capacityWriteLimiter = flowcontrol.NewTokenBucketRateLimiter(*capacityWriteQPS, *capacityWriteBurst)
+		}
+
 		capacityController = capacity.NewCentralCapacityController(
-			csi.NewControllerClient(grpcClient),
+			ctrl.NewControllerClient(controllerConn),
 			provisionerName,
 			clientset,
 			// Metrics for the queue is available in the default registry.
-			workqueue.NewNamedRateLimitingQueue(rateLimiter, "csistoragecapacity"),
+			ctrl.NewNamedRateLimitingQueue(newRateLimiter(cfg.RateLimiters.Capacity), "csistoragecapacity"),
 			controller,
 			managedByID,
 			namespace,
 			topologyInformer,
 			factory.Storage().V1().StorageClasses(),
-			factoryForNamespace.Storage().V1beta1().CSIStorageCapacities(),
+			capacityInformer,
 			*capacityPollInterval,
 			*capacityImmediateBinding,
+			*capacityDryRun,
+			capacityWriteLimiter,
+			*defaultFSType,
+			capacityClient,
+			*capacityCacheTTL,
 		)
 		legacyregistry.CustomMustRegister(capacityController)
 
@@ -483,21 +1039,160 @@ func main() {
 		csiProvisioner = capacity.NewProvisionWrapper(csiProvisioner, capacityController)
 	}
 
-	provisionController = controller.NewProvisionController(
-		clientset,
-		provisionerName,
-		csiProvisioner,
-		serverVersion.GitVersion,
-		provisionerOptions...,
-	)
+	if *capacityAggregation {
+		namespace := os.Getenv("NAMESPACE")
+		if namespace == "" {
+			klog.Fatal("need NAMESPACE env variable for --enable-capacity-aggregation")
+		}
+		// Objects of all instances of the driver, regardless of
+		// who manages them.
+		aggregationInformer := capacity.NewCSIStorageCapacityInformer(capacityClient,
+			namespace,
+			ctrl.ResyncPeriodOfCsiNodeInformer,
+			func(lo *metav1.ListOptions) {
+				lo.LabelSelector = labels.Set{
+					capacity.DriverNameLabel: provisionerName,
+				}.AsSelector().String()
+			},
+		)
+		aggregator := capacity.NewAggregator(provisionerName, aggregationInformer.Lister(), *capacityAggregationKeys)
+		legacyregistry.CustomMustRegister(aggregator)
+		// Not tied to leader election, every instance reports
+		// the same values.
+		go aggregationInformer.Informer().Run(context.Background().Done())
+	}
 
-	csiClaimController := ctrl.NewCloningProtectionController(
-		clientset,
-		claimLister,
-		claimInformer,
-		claimQueue,
-		controllerCapabilities,
-	)
+	// Further node-local drivers share informers and the cloning
+	// protection controller with the primary driver.
+	cloningCapabilities := rpc.ControllerCapabilitySet{}
+	for capability, supported := range controllerCapabilities {
+		cloningCapabilities[capability] = supported
+	}
+	var leakDetector *ctrl.LeakDetector
+	var strayVolumeCleaner *ctrl.StrayVolumeCleaner
+	if runDelete && *leakedVolumesLogInterval > 0 {
+		leakDetector = ctrl.NewLeakDetector(provisionerName, factory.Core().V1().PersistentVolumes().Informer())
+		csiProvisioner = leakDetector.Wrap(csiProvisioner)
+	}
+
+	var operationTracker *debugstate.OperationTracker
+	if *debugStateEndpoint {
+		operationTracker = debugstate.NewOperationTracker()
+	}
+
+	var additionalProvisionControllers []*controller.ProvisionController
+	driverNames := []string{provisionerName}
+	// The provisioners for the DeletionController, by the names in the
+	// provisioned-by annotation of PVs.
+	deletionProvisioners := map[string]controller.Provisioner{}
+	if runProvisionController {
+		wrappers := provisionerWrappers{
+			operationTracker: operationTracker,
+			claimShard:       claimShard,
+			factory:          factory,
+			provision:        runProvision,
+			delete:           runDelete,
+		}
+		var deletionProvisioner controller.Provisioner
+		csiProvisioner, deletionProvisioner = wrappers.wrap(csiProvisioner, provisionerName, nodeDeployment)
+		if runDelete && *strayVolumeCleanupAge > 0 {
+			deleter := csiProvisioner
+			if deletionProvisioner != nil {
+				deleter = deletionProvisioner
+			}
+			strayVolumeCleaner = ctrl.NewStrayVolumeCleaner(clientset, provisionerName, deleter, factory.Core().V1().PersistentVolumes().Lister(), *strayVolumeCleanupAge)
+		}
+		if deletionProvisioner != nil {
+			deletionProvisioners[provisionerName] = deletionProvisioner
+			if supportsMigrationFromInTreePluginName != "" {
+				deletionProvisioners[supportsMigrationFromInTreePluginName] = deletionProvisioner
+			}
+		}
+		provisionController = controller.NewProvisionController(
+			clientset,
+			provisionerName,
+			csiProvisioner,
+			serverVersion.GitVersion,
+			provisionerOptions...,
+		)
+
+		for _, endpoint := range *additionalCSIEndpoints {
+			driver := newAdditionalDriver(endpoint, clientset, snapClient, serverVersion.GitVersion, identity, factory, nodeDeployment, translator, scLister, claimLister, csiDriverLister, secretLister, baseProvisionerOptions, latencyBuckets, wrappers, csiFaults)
+			additionalProvisionControllers = append(additionalProvisionControllers, driver.provisionController)
+			driverNames = append(driverNames, driver.driverName)
+			timeoutUpdaters = append(timeoutUpdaters, driver.timeoutUpdater)
+			gatherers = append(gatherers, driver.metricsManager.GetRegistry())
+			if driver.deletionProvisioner != nil {
+				deletionProvisioners[driver.driverName] = driver.deletionProvisioner
+			}
+			if driver.controllerCapabilities[csi.ControllerServiceCapability_RPC_CLONE_VOLUME] {
+				cloningCapabilities[csi.ControllerServiceCapability_RPC_CLONE_VOLUME] = true
+			}
+		}
+	}
+
+	var deletionController *ctrl.DeletionController
+	if len(deletionProvisioners) > 0 {
+		deletionRateLimiter := newRateLimiter(cfg.RateLimiters.Deletion)
+		if retryBudgetLimiter != nil {
+			deletionRateLimiter = ctrl.NewRetryBudgetRateLimiter(deletionRateLimiter, retryBudgetLimiter)
+		}
+		deletionRateLimiter = ctrl.NewNamedBackoffTrackingRateLimiter(deletionRateLimiter, "deletion")
+		deletionController = ctrl.NewDeletionController(
+			deletionProvisioners,
+			clientset,
+			factory.Core().V1().PersistentVolumes().Lister(),
+			factory.Core().V1().PersistentVolumes().Informer(),
+			ctrl.NewNamedRateLimitingQueue(deletionRateLimiter, "deletion"),
+		)
+	}
+
+	var claimCleaner *ctrl.ClaimMetadataCleaner
+	if runProvision {
+		claimCleaner = ctrl.NewClaimMetadataCleaner(
+			driverNames,
+			clientset,
+			claimLister,
+			claimInformer,
+			ctrl.NewNamedRateLimitingQueue(rateLimiter, "claimcleanup"),
+		)
+	}
+
+	var csiClaimController *ctrl.CloningProtectionController
+	if runCloningProtection {
+		csiClaimController = ctrl.NewCloningProtectionController(
+			clientset,
+			claimLister,
+			claimInformer,
+			claimQueue,
+			cloningCapabilities,
+		)
+	}
+
+	var volumeAttributesController *ctrl.VolumeAttributesController
+	if runProvisionController && *volumeAttributesRefreshInterval > 0 {
+		if controllerCapabilities[csi.ControllerServiceCapability_RPC_GET_VOLUME] {
+			volumeAttributesController = ctrl.NewVolumeAttributesController(
+				ctrl.NewControllerClient(controllerConn),
+				provisionerName,
+				clientset,
+				factory.Core().V1().PersistentVolumes().Lister(),
+				ctrl.NewNamedRateLimitingQueue(rateLimiter, "volumeattributes"),
+				*volumeAttributesRefreshInterval,
+				*operationTimeout,
+			)
+		} else {
+			klog.Warningf("CSI driver %s does not support GET_VOLUME, ignoring --volume-attributes-refresh-interval", provisionerName)
+		}
+	}
+
+	// Push metrics, regardless whether we are the leader or not.
+	if *metricsExportEndpoint != "" {
+		go otlp.NewExporter(*metricsExportEndpoint, *metricsExportInterval, gatherers, otlpResource(provisionerName, nodeDeployment)).Run(context.Background())
+	}
+
+	// Gets closed once this instance runs the controllers.
+	running := make(chan struct{})
 
 	// Start HTTP server, regardless whether we are the leader or not.
 	if addr != "" {
@@ -511,6 +1206,54 @@ func main() {
 		// because both CSI metrics manager and component-base manage
 		// their own registry. Probably could be avoided by making
 		// CSI metrics manager a bit more flexible.
+		if capacityController != nil && *capacityRefreshEndpoint {
+			mux.Handle("/capacity/refresh", capacity.NewRefreshHandler(capacityController))
+		}
+		if capacityController != nil && *capacityReadyzIntervals > 0 {
+			mux.Handle("/readyz", capacity.NewReadyzHandler(capacityController, time.Duration(*capacityReadyzIntervals)**capacityPollInterval))
+		}
+		if canaryCheck != nil {
+			mux.Handle("/canary", canary.NewHandler(canaryCheck))
+		}
+		if *debugStateEndpoint {
+			dumper := &debugstate.Dumper{
+				DriverNames: driverNames,
+				Leader: func() bool {
+					select {
+					case <-running:
+						return true
+					default:
+						return false
+					}
+				},
+				ClaimRateLimiter: provisionRateLimiter,
+				Backoff: func(retries int) time.Duration {
+					backoff := *retryIntervalStart
+					for i := 1; i < retries && backoff < *retryIntervalMax; i++ {
+						backoff *= 2
+					}
+					if backoff > *retryIntervalMax {
+						backoff = *retryIntervalMax
+					}
+					return backoff
+				},
+				Operations: operationTracker,
+				Topology:   topologyInformer,
+				Capacity:   capacityController,
+			}
+			if claimInformer != nil {
+				dumper.Claims = claimInformer.GetStore()
+			}
+			mux.Handle("/debug/state", debugstate.NewHandler(dumper, clientset))
+		}
+		if *enableProfile {
+			klog.Infof("Starting profiling at %q", addr+"/debug/pprof/")
+			mux.HandleFunc("/debug/pprof/", pprof.Index)
+			mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+			mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+			mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+			mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		}
 		mux.Handle(*metricsPath,
 			promhttp.InstrumentMetricHandler(
 				reg,
@@ -524,27 +1267,183 @@ func main() {
 		}()
 	}
 
+	operationTimeouts := ctrl.OperationTimeouts{
+		CreateVolume:         *createVolumeTimeout,
+		DeleteVolume:         *deleteVolumeTimeout,
+		RestoreSnapshot:      *restoreTimeout,
+		CreateVolumeInFlight: *inFlightTimeout,
+	}
+	for _, updater := range timeoutUpdaters {
+		updater.UpdateOperationTimeouts(operationTimeouts)
+	}
+
+	if *configFile != "" && *configReloadInterval > 0 {
+		watcher := provisionerconfig.NewWatcher(*configFile, rateLimiterDefaults, flag.CommandLine, cfg)
+		watcher.Reloadable("v", func(value string) error {
+			return goflag.Lookup("v").Value.Set(value)
+		})
+		// The watcher invokes one callback at a time, so these
+		// variables need no further locking.
+		timeout := *operationTimeout
+		reloadableTimeout := func(name string, target *time.Duration, allowZero bool) {
+			watcher.Reloadable(name, func(value string) error {
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+				if d < 0 || d == 0 && !allowZero {
+					return fmt.Errorf("invalid timeout %v", d)
+				}
+				*target = d
+				for _, updater := range timeoutUpdaters {
+					updater.UpdateTimeout(timeout)
+					updater.UpdateOperationTimeouts(operationTimeouts)
+				}
+				return nil
+			})
+		}
+		reloadableTimeout("timeout", &timeout, false)
+		reloadableTimeout("create-volume-timeout", &operationTimeouts.CreateVolume, true)
+		reloadableTimeout("delete-volume-timeout", &operationTimeouts.DeleteVolume, true)
+		reloadableTimeout("snapshot-restore-timeout", &operationTimeouts.RestoreSnapshot, true)
+		reloadableTimeout("create-volume-in-flight-timeout", &operationTimeouts.CreateVolumeInFlight, true)
+		go watcher.Run(context.Background(), *configReloadInterval)
+	}
+
+	// Normally the process gets killed by SIGTERM. When capacity objects
+	// must be removed first, the signal stops the controllers instead.
+	terminate := context.Background()
+	if *capacityDeleteOnShutdown && capacityController != nil {
+		var stop context.CancelFunc
+		terminate, stop = signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+		go func() {
+			<-terminate.Done()
+			select {
+			case <-running:
+				// run deletes the objects and exits.
+			default:
+				// Not the leader, nothing to clean up.
+				klog.Info("Received termination signal, exiting")
+				klog.Flush()
+				os.Exit(0)
+			}
+		}()
+	}
+
 	run := func(ctx context.Context) {
+		close(running)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-terminate.Done():
+				klog.Info("Received termination signal, shutting down")
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
 		factory.Start(ctx.Done())
-		if factoryForNamespace != nil {
+		if capacityInformer != nil {
 			// Starting is enough, the capacity controller will
 			// wait for sync.
-			factoryForNamespace.Start(ctx.Done())
+			go capacityInformer.Informer().Run(ctx.Done())
+		}
+		if secretFactory != nil {
+			// Not waiting for sync, secrets which are not cached
+			// yet get fetched from the API server.
+			secretFactory.Start(ctx.Done())
+		}
+		syncCtx := ctx
+		if *cacheSyncTimeout > 0 {
+			var cancel context.CancelFunc
+			syncCtx, cancel = context.WithTimeout(ctx, *cacheSyncTimeout)
+			defer cancel()
 		}
-		cacheSyncResult := factory.WaitForCacheSync(ctx.Done())
-		for _, v := range cacheSyncResult {
-			if !v {
-				klog.Fatalf("Failed to sync Informers!")
+		cacheSyncResult := factory.WaitForCacheSync(syncCtx.Done())
+		for informerType, synced := range cacheSyncResult {
+			critical := criticalInformers[informerType] || topologySynced != nil && topologyInformers[informerType]
+			if !synced && !critical {
+				// The informer keeps running and catches up in the background.
+				klog.Warningf("Informer for %s not synced after %s, continuing in degraded mode", informerType, *cacheSyncTimeout)
+			}
+		}
+		// Provisioning cannot start without the critical informers,
+		// so keep waiting for those without a timeout.
+		criticalSynced := []cache.InformerSynced{factory.Storage().V1().StorageClasses().Informer().HasSynced}
+		if claimInformer != nil {
+			criticalSynced = append(criticalSynced, claimInformer.HasSynced)
+		}
+		// Without them, the accessible topology of new volumes
+		// would be wrong.
+		criticalSynced = append(criticalSynced, topologySynced...)
+		if !cache.WaitForCacheSync(ctx.Done(), criticalSynced...) {
+			klog.Fatalf("Failed to sync Informers!")
+		}
+		if *fairSchedulingSlots == 0 && claimLister != nil {
+			// The priority annotation is only used by the fair scheduler.
+			if claims, err := ctrl.ClaimsWithPriority(claimLister); err == nil && len(claims) > 0 {
+				klog.Warningf("%d PVC(s), for example %s/%s, have the provisioner.storage.k8s.io/priority annotation, which has no effect without --fair-scheduling-slots",
+					len(claims), claims[0].Namespace, claims[0].Name)
 			}
 		}
 
 		if capacityController != nil {
 			go capacityController.Run(ctx, int(*capacityThreads))
+			if lookupCapacityOwner != nil && *capacityOwnerrefRefresh > 0 {
+				go capacityController.RefreshOwner(ctx, *capacityOwnerrefRefresh, lookupCapacityOwner)
+			}
 		}
 		if csiClaimController != nil {
 			go csiClaimController.Run(ctx, int(*finalizerThreads))
 		}
-		provisionController.Run(ctx)
+		if volumeAttributesController != nil {
+			go volumeAttributesController.Run(ctx)
+		}
+		if claimCleaner != nil {
+			go claimCleaner.Run(ctx)
+		}
+		if deletionController != nil {
+			go deletionController.Run(ctx, int(*workerThreads))
+		}
+		if leakDetector != nil {
+			go leakDetector.Run(ctx, *leakedVolumesLogInterval)
+		}
+		if strayVolumeCleaner != nil {
+			go strayVolumeCleaner.Run(ctx, *strayVolumeCleanupAge)
+		}
+		if capabilityRefresher != nil {
+			go capabilityRefresher.Run(ctx, *capabilityRefreshInterval)
+		}
+		if *workqueueItemAgeWarning > 0 {
+			go ctrl.NewQueueAgeAlerter(clientset, os.Getenv("NAMESPACE"), os.Getenv("POD_NAME"), *workqueueItemAgeWarning).Run(ctx)
+		}
+		if canaryCheck != nil {
+			go canaryCheck.Run(ctx, *canaryInterval)
+		}
+		for _, additionalProvisionController := range additionalProvisionControllers {
+			go additionalProvisionController.Run(ctx)
+		}
+		if provisionController != nil {
+			provisionController.Run(ctx)
+		} else {
+			<-ctx.Done()
+		}
+
+		if terminate.Err() != nil {
+			// Losing leadership is not a reason to delete the
+			// objects, the new leader continues to maintain them.
+			deleteCtx, cancel := context.WithTimeout(context.Background(), *capacityShutdownTimeout)
+			defer cancel()
+			if err := capacityController.DeleteAll(deleteCtx); err != nil {
+				klog.Errorf("Deleting CSIStorageCapacity objects during shutdown failed: %v", err)
+			}
+			// With leader election, run is called in a goroutine and
+			// returning would not end the process.
+			klog.Flush()
+			os.Exit(0)
+		}
 	}
 
 	if !*enableLeaderElection {
@@ -553,15 +1452,27 @@ func main() {
 		// this lock name pattern is also copied from sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller
 		// to preserve backwards compatibility
 		lockName := strings.Replace(provisionerName, "/", "-", -1)
+		if !runProvision || !runDelete || !runCloningProtection {
+			// Deployments which run different controllers must
+			// not compete for leadership. The usual lock name is
+			// kept when everything besides capacity is enabled.
+			lockName += "-" + strings.Join(enabledControllers.List(), "-")
+		}
+		if claimShard.Count > 1 {
+			// Each shard has its own leader.
+			lockName += fmt.Sprintf("-shard-%d-of-%d", claimShard.Index, claimShard.Count)
+		}
 
-		// create a new clientset for leader election
-		leClientset, err := kubernetes.NewForConfig(config)
+		// create a new clientset for leader election, with its own
+		// token bucket for --kube-api-qps and --kube-api-burst and
+		// none of the transport wrappers of the other clients
+		leClientset, err := kubernetes.NewForConfig(leConfig)
 		if err != nil {
 			klog.Fatalf("Failed to create leaderelection client: %v", err)
 		}
 
 		le := leaderelection.NewLeaderElection(leClientset, lockName, run)
-		if *httpEndpoint != "" {
+		if addr != "" {
 			le.PrepareHealthCheck(mux, leaderelection.DefaultHealthCheckTimeout)
 		}
 
@@ -575,3 +1486,410 @@ func main() {
 	}
 
 }
+
+// newVolumeAttachmentLister returns a lister that finds the VolumeAttachments
+// of a PV through an index. The informer is shared by all drivers and the
+// index gets added only once.
+func newVolumeAttachmentLister(factory informers.SharedInformerFactory) storagelistersv1.VolumeAttachmentLister {
+	vaInformer := factory.Storage().V1().VolumeAttachments()
+	if vaIndexedLister == nil {
+		lister, err := ctrl.NewIndexedVolumeAttachmentLister(vaInformer)
+		if err != nil {
+			klog.Fatalf("Failed to add VolumeAttachment indexer: %v", err)
+		}
+		vaIndexedLister = lister
+	}
+	// Deletion must not proceed while the informer is still
+	// catching up after a partial startup.
+	return ctrl.NewSyncedVolumeAttachmentLister(vaIndexedLister, vaInformer.Informer().HasSynced)
+}
+
+// localTopologyListers returns listers with fake, static CSINode and Node
+// objects that reflect the topology reported by the driver on the node.
+// This avoids watching, which is particularly relevant for Node objects
+// because those can generate significant traffic.
+func localTopologyListers(clientset kubernetes.Interface, provisionerName string, nodeDeployment *ctrl.NodeDeployment) (storagelistersv1.CSINodeLister, listersv1.NodeLister) {
+	csiNode := &storagev1.CSINode{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: nodeDeployment.NodeName,
+		},
+		Spec: storagev1.CSINodeSpec{
+			Drivers: []storagev1.CSINodeDriver{
+				{
+					Name:   provisionerName,
+					NodeID: nodeDeployment.NodeInfo.NodeId,
+				},
+			},
+		},
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: nodeDeployment.NodeName,
+		},
+	}
+	if nodeDeployment.NodeInfo.AccessibleTopology != nil {
+		for key := range nodeDeployment.NodeInfo.AccessibleTopology.Segments {
+			csiNode.Spec.Drivers[0].TopologyKeys = append(csiNode.Spec.Drivers[0].TopologyKeys, key)
+		}
+		node.Labels = nodeDeployment.NodeInfo.AccessibleTopology.Segments
+	}
+	klog.Infof("using local topology with Node = %+v and CSINode = %+v", node, csiNode)
+
+	// We make those fake objects available to the topology code via informers which
+	// never change.
+	stoppedFactory := informers.NewSharedInformerFactory(clientset, 1000*time.Hour)
+	csiNodes := stoppedFactory.Storage().V1().CSINodes()
+	nodes := stoppedFactory.Core().V1().Nodes()
+	csiNodes.Informer().GetStore().Add(csiNode)
+	nodes.Informer().GetStore().Add(node)
+	return csiNodes.Lister(), nodes.Lister()
+}
+
+// verifyNodeInfo warns about differences between the NodeGetInfo
+// response of a node-local driver and what kubelet registered for it.
+// With --node-deployment-prefer-registered-topology, it returns the
+// registered information instead when they differ.
+func verifyNodeInfo(clientset kubernetes.Interface, driverName, nodeName string, nodeInfo *csi.NodeGetInfoResponse) *csi.NodeGetInfoResponse {
+	ctx, cancel := context.WithTimeout(context.Background(), *operationTimeout)
+	defer cancel()
+	registered, differences, err := ctrl.VerifyNodeInfo(ctx, clientset, driverName, nodeName, nodeInfo)
+	if err != nil {
+		klog.Warningf("Cannot compare NodeGetInfo of driver %s with its registration on node %s: %v", driverName, nodeName, err)
+		return nodeInfo
+	}
+	if registered == nil {
+		klog.V(2).Infof("Driver %s is not registered on node %s yet, using NodeGetInfo", driverName, nodeName)
+		return nodeInfo
+	}
+	for _, difference := range differences {
+		klog.Warningf("NodeGetInfo of driver %s differs from its registration on node %s: %s", driverName, nodeName, difference)
+	}
+	if len(differences) > 0 && *nodeDeploymentPreferRegistered {
+		klog.Infof("Using node ID and topology registered for driver %s on node %s: %+v", driverName, nodeName, registered)
+		return registered
+	}
+	return nodeInfo
+}
+
+// newRateLimiter returns a rate limiter for work queues as configured
+// in the rateLimiters section of --config.
+func newRateLimiter(r provisionerconfig.RateLimiter) workqueue.RateLimiter {
+	return ctrl.NewRateLimiter(r.InitialDelay.Duration, r.MaxDelay.Duration, r.Jitter, r.QPS, r.Burst)
+}
+
+// newStorageClassScheduler returns a new scheduler for one provisioner
+// instance if enabled with --fair-scheduling-slots, nil otherwise.
+func newStorageClassScheduler() *ctrl.StorageClassScheduler {
+	if *fairSchedulingSlots == 0 {
+		return nil
+	}
+	return ctrl.NewStorageClassScheduler(int(*fairSchedulingSlots))
+}
+
+// additionalDriver is a node-local CSI driver from --additional-csi-address.
+type additionalDriver struct {
+	driverName             string
+	provisionController    *controller.ProvisionController
+	controllerCapabilities rpc.ControllerCapabilitySet
+	metricsManager         metrics.CSIMetricsManager
+	timeoutUpdater         ctrl.TimeoutUpdater
+	// deletionProvisioner is set when the DeletionController
+	// deletes the volumes of the driver.
+	deletionProvisioner controller.Provisioner
+}
+
+// newMetricsManager returns a metrics manager for CSI calls. Custom
+// histogram buckets need an implementation other than the one from
+// csi-lib-utils.
+func newMetricsManager(driverName string, latencyBuckets []float64, migration bool) metrics.CSIMetricsManager {
+	if latencyBuckets != nil {
+		return csimetrics.NewManager(driverName, latencyBuckets, migration)
+	}
+	options := []metrics.MetricsManagerOption{
+		// Will be provided via default gatherer.
+		metrics.WithProcessStartTime(false),
+		metrics.WithSubsystem(metrics.SubsystemSidecar),
+	}
+	if migration {
+		options = append(options, metrics.WithMigration())
+	}
+	return metrics.NewCSIMetricsManagerWithOptions(driverName, options...)
+}
+
+// newCapacityClient uses the v1 CSIStorageCapacity API if the API
+// server supports it, otherwise v1beta1.
+func newCapacityClient(config *rest.Config, clientset kubernetes.Interface) capacity.CSIStorageCapacitiesGetter {
+	version, err := capacity.DetectAPIVersion(clientset.Discovery())
+	if err != nil {
+		klog.Fatalf("Failed to determine the CSIStorageCapacity API version: %v", err)
+	}
+	klog.Infof("Using storage.k8s.io/%s for CSIStorageCapacity objects", version)
+	if version == capacity.APIVersionV1beta1 {
+		return capacity.NewV1beta1Client(clientset)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed to create dynamic client: %v", err)
+	}
+	return capacity.NewV1Client(dynamicClient)
+}
+
+// maxTopologyEntries returns --max-requisite-topologies if set, otherwise
+// the limit reported by the driver.
+func maxTopologyEntries(grpcClient *grpc.ClientConn, driverName string) int {
+	if *maxRequisiteTopologies > 0 {
+		return *maxRequisiteTopologies
+	}
+	maxEntries, err := ctrl.GetMaxTopologyEntries(grpcClient, *operationTimeout)
+	if err != nil {
+		klog.Fatalf("Error getting maximum number of topology entries of CSI driver %s: %s", driverName, err)
+	}
+	if maxEntries > 0 {
+		klog.Infof("CSI driver %s accepts at most %d topology entries", driverName, maxEntries)
+	}
+	return maxEntries
+}
+
+// maxVolumeSizeLimit returns --max-volume-size if set, otherwise the
+// maximum volume size reported by the driver. Drivers may reject
+// GetCapacity without parameters, so failing to get it only disables
+// the check.
+func maxVolumeSizeLimit(grpcClient *grpc.ClientConn, driverName string, controllerCapabilities rpc.ControllerCapabilitySet) int64 {
+	if maxVolumeSizeBytes > 0 {
+		return maxVolumeSizeBytes
+	}
+	if !controllerCapabilities[csi.ControllerServiceCapability_RPC_GET_CAPACITY] {
+		return 0
+	}
+	size, err := ctrl.GetMaxVolumeSize(grpcClient, *operationTimeout)
+	if err != nil {
+		klog.Warningf("Error getting maximum volume size of CSI driver %s, not checking the size of PVCs: %s", driverName, err)
+		return 0
+	}
+	if size > 0 {
+		klog.Infof("CSI driver %s creates volumes of at most %s", driverName, resource.NewQuantity(size, resource.BinarySI))
+	}
+	return size
+}
+
+// otlpResource identifies this instance in exported metrics and traces.
+func otlpResource(driverName string, nodeDeployment *ctrl.NodeDeployment) map[string]string {
+	resource := map[string]string{
+		"service.name": "csi-provisioner",
+		"csi.driver":   driverName,
+	}
+	if nodeDeployment != nil {
+		resource["k8s.node.name"] = nodeDeployment.NodeName
+	}
+	return resource
+}
+
+// csiTLSConfig returns the settings for tcp:// CSI endpoints.
+func csiTLSConfig() *ctrl.TLSConfig {
+	return &ctrl.TLSConfig{
+		CAFile:     *csiTLSCAFile,
+		CertFile:   *csiTLSCertFile,
+		KeyFile:    *csiTLSKeyFile,
+		ServerName: *csiTLSServerName,
+	}
+}
+
+// connectCSI connects to the CSI driver. The first result is used during
+// startup. The second one is for the controllers. With more than one
+// endpoint it fails over between them, otherwise it is the same as the
+// first result and loss of the connection ends the process.
+func connectCSI(endpoints []string, metricsManager metrics.CSIMetricsManager) (*grpc.ClientConn, grpc.ClientConnInterface, error) {
+	if len(endpoints) <= 1 {
+		conn, err := ctrl.Connect(strings.Join(endpoints, ""), metricsManager, csiTLSConfig())
+		return conn, conn, err
+	}
+	klog.Infof("Failover between CSI endpoints %s", strings.Join(endpoints, ", "))
+	failover, err := ctrl.ConnectFailover(endpoints, metricsManager, *operationTimeout, csiTLSConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+	return failover.Conn(), failover, nil
+}
+
+// wrapControllerConn adds the optional wrappers for the CSI calls made
+// by the controllers of a driver.
+func wrapControllerConn(conn grpc.ClientConnInterface, csiFaults faultinject.Faults) grpc.ClientConnInterface {
+	if csiFaults.Enabled() {
+		conn = faultinject.WrapConn(conn, csiFaults)
+	}
+	if *shadowMode {
+		conn = shadow.WrapConn(conn)
+	}
+	if *tracingEndpoint != "" {
+		conn = otlp.WrapConn(conn)
+	}
+	return conn
+}
+
+// provisionerWrappers adds the optional wrappers around the provisioner
+// of a driver which are the same for the primary and the additional
+// drivers.
+type provisionerWrappers struct {
+	operationTracker *debugstate.OperationTracker
+	claimShard       ctrl.ClaimShard
+	factory          informers.SharedInformerFactory
+	provision        bool
+	delete           bool
+}
+
+// wrap returns the provisioner for the ProvisionController of the
+// driver and, if the DeletionController deletes its volumes, the
+// provisioner for that.
+func (w provisionerWrappers) wrap(p controller.Provisioner, driverName string, nodeDeployment *ctrl.NodeDeployment) (controller.Provisioner, controller.Provisioner) {
+	if *workerRampUp > 0 {
+		p = ctrl.NewSlowStartProvisioner(p, int(*workerThreads), *workerRampUp)
+	}
+	if *reservedWorkerThreads > 0 {
+		p = ctrl.NewReservedProvisioner(p, int(*workerThreads), int(*reservedWorkerThreads), *reservedNamespaces)
+	}
+	if w.operationTracker != nil {
+		p = w.operationTracker.Wrap(p)
+	}
+	if *provisionerInstanceID != "" {
+		p = ctrl.NewInstanceProvisioner(p, *provisionerInstanceID, *adoptedInstanceIDs)
+	}
+	if w.claimShard.Count > 1 {
+		p = ctrl.NewShardedProvisioner(p, w.claimShard)
+	}
+	if !w.provision || !w.delete {
+		p = ctrl.NewSelectiveProvisioner(p, w.provision, w.delete)
+	}
+	if *tracingEndpoint != "" {
+		tracer := otlp.NewTracer(*tracingEndpoint, *tracingExportInterval, *tracingSamplingRatio, otlpResource(driverName, nodeDeployment))
+		p = ctrl.NewTracingProvisioner(p, tracer, w.factory.Core().V1().PersistentVolumes().Informer())
+		go tracer.Run(context.Background())
+	}
+	p = ctrl.NewPanicGuardProvisioner(p)
+	if w.delete && *useDeletionController {
+		return ctrl.NewSelectiveProvisioner(p, w.provision, false), p
+	}
+	return p, nil
+}
+
+// newAdditionalDriver connects to a node-local CSI driver and sets up
+// provisioning for it in the same way as for the primary driver.
+// Storage capacity tracking is only supported for the primary driver.
+func newAdditionalDriver(
+	endpoint string,
+	clientset kubernetes.Interface,
+	snapClient snapclientset.Interface,
+	serverGitVersion string,
+	identity string,
+	factory informers.SharedInformerFactory,
+	primaryNodeDeployment *ctrl.NodeDeployment,
+	translator ctrl.ProvisionerCSITranslator,
+	scLister storagelistersv1.StorageClassLister,
+	claimLister listersv1.PersistentVolumeClaimLister,
+	csiDriverLister storagelistersv1.CSIDriverLister,
+	secretLister listersv1.SecretLister,
+	baseProvisionerOptions []func(*controller.ProvisionController) error,
+	latencyBuckets []float64,
+	wrappers provisionerWrappers,
+	csiFaults faultinject.Faults,
+) *additionalDriver {
+	metricsManager := newMetricsManager("" /* driverName */, latencyBuckets, false)
+	grpcClient, err := ctrl.Connect(endpoint, metricsManager, csiTLSConfig())
+	if err != nil {
+		klog.Fatalf("Failed to connect to CSI driver at %s: %v", endpoint, err)
+	}
+	if err := ctrl.Probe(grpcClient, *operationTimeout); err != nil {
+		klog.Fatalf("Failed to probe CSI driver at %s: %v", endpoint, err)
+	}
+	provisionerName, err := ctrl.GetDriverName(grpcClient, *operationTimeout)
+	if err != nil {
+		klog.Fatalf("Error getting CSI driver name for %s: %s", endpoint, err)
+	}
+	klog.V(2).Infof("Detected additional CSI driver %s at %s", provisionerName, endpoint)
+	metricsManager.SetDriverName(provisionerName)
+
+	pluginCapabilities, controllerCapabilities, err := ctrl.GetDriverCapabilities(grpcClient, *operationTimeout)
+	if err != nil {
+		klog.Fatalf("Error getting CSI driver capabilities for %s: %s", provisionerName, err)
+	}
+
+	nodeDeployment := *primaryNodeDeployment
+	nodeInfo, err := ctrl.GetNodeInfo(grpcClient, *operationTimeout)
+	if err != nil {
+		klog.Fatalf("Failed to get node info from CSI driver %s: %v", provisionerName, err)
+	}
+	nodeDeployment.NodeInfo = *verifyNodeInfo(clientset, provisionerName, nodeDeployment.NodeName, nodeInfo)
+
+	var vaLister storagelistersv1.VolumeAttachmentLister
+	if wrappers.delete && *watchVolumeAttachments && utilfeature.DefaultFeatureGate.Enabled(features.DeleteWaitForDetach) && (controllerCapabilities[csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME] || *deleteWaitForAttachments) {
+		vaLister = newVolumeAttachmentLister(factory)
+	}
+
+	var nodeLister listersv1.NodeLister
+	var csiNodeLister storagelistersv1.CSINodeLister
+	if ctrl.SupportsTopology(pluginCapabilities) {
+		csiNodeLister, nodeLister = localTopologyListers(clientset, provisionerName, &nodeDeployment)
+	}
+
+	controllerConn := wrapControllerConn(grpcClient, csiFaults)
+
+	csiProvisioner := ctrl.NewCSIProvisioner(
+		clientset,
+		*operationTimeout,
+		identity+"-"+provisionerName,
+		*volumeNamePrefix,
+		*volumeNameUUIDLength,
+		controllerConn,
+		snapClient,
+		provisionerName,
+		pluginCapabilities,
+		controllerCapabilities,
+		"", // supportsMigrationFromInTreePluginName
+		*strictTopology,
+		*immediateTopology,
+		translator,
+		scLister,
+		csiNodeLister,
+		nodeLister,
+		claimLister,
+		vaLister,
+		*extraCreateMetadata,
+		*defaultFSType,
+		&nodeDeployment,
+		ctrl.ProvisionerOptions{
+			CSIDriverLister:        csiDriverLister,
+			MaxRequisiteTopologies: maxTopologyEntries(grpcClient, provisionerName),
+			TopologyLimitStrategy:  ctrl.TopologyLimitStrategy(*requisiteTopologyLimitStrategy),
+			TopologyMode:           ctrl.TopologyMode(*topologyMode),
+			LatencyAnnotations:     *latencyAnnotations,
+			Scheduler:              newStorageClassScheduler(),
+			ProvisioningFinalizer:  *provisioningFinalizer,
+			VolumeNameTemplate:     volumeNameTmpl,
+			MaxVolumeSize:          maxVolumeSizeLimit(grpcClient, provisionerName, controllerCapabilities),
+			SecretLister:           secretLister,
+			PVLister:               factory.Core().V1().PersistentVolumes().Lister(),
+		},
+	)
+
+	if wrappers.provision {
+		factory.Core().V1().PersistentVolumeClaims().Informer().AddEventHandler(ctrl.NewBoundLatencyTracker(provisionerName, nodeDeployment.NodeName))
+	}
+	provisioner, deletionProvisioner := wrappers.wrap(csiProvisioner, provisionerName, &nodeDeployment)
+
+	provisionerOptions := append(baseProvisionerOptions[:len(baseProvisionerOptions):len(baseProvisionerOptions)],
+		controller.NodesLister(nodeLister),
+	)
+	return &additionalDriver{
+		driverName: provisionerName,
+		provisionController: controller.NewProvisionController(
+			clientset,
+			provisionerName,
+			provisioner,
+			serverGitVersion,
+			provisionerOptions...,
+		),
+		controllerCapabilities: controllerCapabilities,
+		metricsManager:         metricsManager,
+		timeoutUpdater:         csiProvisioner.(ctrl.TimeoutUpdater),
+		deletionProvisioner:    deletionProvisioner,
+	}
+}
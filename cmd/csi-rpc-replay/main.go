@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// csi-rpc-replay sends CreateVolume and DeleteVolume calls that were
+// captured by the external-provisioner with --csi-capture-file to a CSI
+// driver again and compares the results.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	goflag "flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/connection"
+	"github.com/kubernetes-csi/csi-lib-utils/metrics"
+	flag "github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/capture"
+)
+
+var (
+	csiEndpoint = flag.String("csi-address", "/run/csi/socket", "The gRPC endpoint of the CSI driver.")
+	captureFile = flag.String("capture-file", "", "The file with the captured calls.")
+	secretsFile = flag.String("secrets-file", "", "A JSON file with a map of secrets. Captured calls do not contain secret values, so calls which used secrets get these instead.")
+	timeout     = flag.Duration("timeout", 10*time.Second, "Timeout for each call.")
+	verbose     = flag.Bool("verbose", false, "Print recorded and replayed responses.")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.CommandLine.AddGoFlagSet(goflag.CommandLine)
+	flag.Parse()
+
+	if *captureFile == "" {
+		klog.Fatal("--capture-file is required.")
+	}
+	file, err := os.Open(*captureFile)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	records, err := capture.ReadRecords(file)
+	file.Close()
+	if err != nil {
+		klog.Fatalf("Reading %s failed: %v", *captureFile, err)
+	}
+
+	var secrets map[string]string
+	if *secretsFile != "" {
+		data, err := ioutil.ReadFile(*secretsFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &secrets); err != nil {
+			klog.Fatalf("Parsing %s failed: %v", *secretsFile, err)
+		}
+	}
+
+	conn, err := connection.Connect(*csiEndpoint, metrics.NewCSIMetricsManager(""))
+	if err != nil {
+		klog.Fatal(err)
+	}
+	defer conn.Close()
+
+	different := 0
+	for i, recorded := range records {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		replayed, err := capture.Replay(ctx, conn, recorded, secrets)
+		cancel()
+		if err != nil {
+			klog.Fatalf("Replaying call #%d failed: %v", i+1, err)
+		}
+		same, err := recorded.SameResult(replayed)
+		if err != nil {
+			klog.Fatalf("Comparing results of call #%d failed: %v", i+1, err)
+		}
+		result := "same result"
+		if !same {
+			result = "different result"
+			different++
+		}
+		fmt.Printf("#%d %s recorded %s, replayed %s: %s\n", i+1, recorded.Method, recorded.Code, replayed.Code, result)
+		if *verbose || !same {
+			fmt.Printf("  request:  %s\n", recorded.Request)
+			fmt.Printf("  recorded: %s %s\n", recorded.Response, recorded.Message)
+			fmt.Printf("  replayed: %s %s\n", replayed.Response, replayed.Message)
+		}
+	}
+	if different > 0 {
+		fmt.Printf("%d of %d calls had a different result\n", different, len(records))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package adaptiveqps contains a client-side rate limiter for Kubernetes
+// API requests which slows down when the API server is under stress.
+//
+// The QPS gets halved when the API server rejects a request with 429 Too
+// Many Requests, which is how API Priority and Fairness signals
+// overload, or when a request takes longer than a threshold. While
+// requests succeed quickly, the QPS gets increased again step by step
+// until it reaches the configured maximum.
+package adaptiveqps
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// adjustInterval is the minimum time between two changes of the
+	// QPS. This avoids reacting more than once to a burst of
+	// rejected requests.
+	adjustInterval = time.Second
+	// increaseSteps is the number of increases needed to get from
+	// zero to the maximum QPS.
+	increaseSteps = 20
+)
+
+var currentQPS = metrics.NewGauge(
+	&metrics.GaugeOpts{
+		Name:           "kube_api_client_qps",
+		Help:           "Current QPS limit for requests to the Kubernetes API server when adapting it to the API server load.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(currentQPS)
+}
+
+// Limiter implements flowcontrol.RateLimiter. It must also observe
+// the responses through WrapTransport.
+type Limiter struct {
+	minQPS, maxQPS   float32
+	burst            int
+	latencyThreshold time.Duration
+	now              func() time.Time
+
+	mutex      sync.Mutex
+	qps        float32
+	limiter    flowcontrol.RateLimiter
+	lastAdjust time.Time
+}
+
+var _ flowcontrol.RateLimiter = &Limiter{}
+
+// New creates a limiter which starts with the maximum QPS and never
+// goes below the minimum QPS. Requests which take longer than the
+// latency threshold count as a sign of overload, zero disables that
+// check.
+func New(minQPS, maxQPS float32, burst int, latencyThreshold time.Duration) *Limiter {
+	l := &Limiter{
+		minQPS:           minQPS,
+		maxQPS:           maxQPS,
+		burst:            burst,
+		latencyThreshold: latencyThreshold,
+		now:              time.Now,
+	}
+	l.setQPS(maxQPS)
+	return l
+}
+
+func (l *Limiter) current() flowcontrol.RateLimiter {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limiter
+}
+
+// setQPS must be called with the mutex locked, except during New.
+// Requests which are already waiting continue to use the previous
+// limiter.
+func (l *Limiter) setQPS(qps float32) {
+	l.qps = qps
+	l.limiter = flowcontrol.NewTokenBucketRateLimiter(qps, l.burst)
+	currentQPS.Set(float64(qps))
+}
+
+func (l *Limiter) TryAccept() bool {
+	return l.current().TryAccept()
+}
+
+func (l *Limiter) Accept() {
+	l.current().Accept()
+}
+
+func (l *Limiter) Stop() {
+	l.current().Stop()
+}
+
+func (l *Limiter) QPS() float32 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.qps
+}
+
+func (l *Limiter) Wait(ctx context.Context) error {
+	return l.current().Wait(ctx)
+}
+
+// decrease halves the QPS.
+func (l *Limiter) decrease(reason string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	if now.Sub(l.lastAdjust) < adjustInterval || l.qps <= l.minQPS {
+		return
+	}
+	l.lastAdjust = now
+	qps := l.qps / 2
+	if qps < l.minQPS {
+		qps = l.minQPS
+	}
+	klog.V(2).Infof("Reducing Kubernetes API QPS from %.2f to %.2f because of %s", l.qps, qps, reason)
+	l.setQPS(qps)
+}
+
+// increase raises the QPS by a fraction of the maximum.
+func (l *Limiter) increase() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	if now.Sub(l.lastAdjust) < adjustInterval || l.qps >= l.maxQPS {
+		return
+	}
+	l.lastAdjust = now
+	qps := l.qps + l.maxQPS/increaseSteps
+	if qps > l.maxQPS {
+		qps = l.maxQPS
+	}
+	klog.V(4).Infof("Increasing Kubernetes API QPS from %.2f to %.2f", l.qps, qps)
+	l.setQPS(qps)
+}
+
+// WrapTransport can be used for rest.Config.Wrap. The returned transport
+// adapts the limiter to the responses.
+func (l *Limiter) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return &observingTransport{rt: rt, limiter: l}
+}
+
+type observingTransport struct {
+	rt      http.RoundTripper
+	limiter *Limiter
+}
+
+func (t *observingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := t.limiter.now()
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		// Connection problems are not handled by slowing down.
+		return resp, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		t.limiter.decrease("rejected requests")
+	case req.URL.Query().Get("watch") == "true":
+		// The response of a watch gets streamed, the
+		// latency says nothing about the API server.
+	case t.limiter.latencyThreshold > 0 && t.limiter.now().Sub(start) > t.limiter.latencyThreshold:
+		t.limiter.decrease("slow responses")
+	default:
+		t.limiter.increase()
+	}
+	return resp, err
+}
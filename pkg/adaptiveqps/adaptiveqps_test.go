@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adaptiveqps
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeTransport returns the status code and advances the fake clock by
+// the latency.
+type fakeTransport struct {
+	now     *time.Time
+	code    int
+	latency time.Duration
+}
+
+func (t *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	*t.now = t.now.Add(t.latency)
+	return &http.Response{StatusCode: t.code, Request: req}, nil
+}
+
+func TestAdapt(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := New(1, 10, 10, time.Second)
+	limiter.now = func() time.Time { return now }
+	transport := &fakeTransport{now: &now, code: http.StatusOK}
+	rt := limiter.WrapTransport(transport)
+
+	send := func(url string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rt.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectQPS := func(expected float32) {
+		t.Helper()
+		if qps := limiter.QPS(); qps != expected {
+			t.Fatalf("expected QPS %.2f, got %.2f", expected, qps)
+		}
+	}
+
+	expectQPS(10)
+	send("https://example.com/api/v1/persistentvolumes")
+	expectQPS(10)
+
+	transport.code = http.StatusTooManyRequests
+	send("https://example.com/api/v1/persistentvolumes")
+	expectQPS(5)
+	// Ignored, too soon after the previous change.
+	send("https://example.com/api/v1/persistentvolumes")
+	expectQPS(5)
+
+	now = now.Add(time.Second)
+	transport.code = http.StatusOK
+	transport.latency = 2 * time.Second
+	send("https://example.com/api/v1/persistentvolumes")
+	expectQPS(2.5)
+	// Watches are not slow.
+	send("https://example.com/api/v1/persistentvolumes?watch=true")
+	expectQPS(2.5)
+	send("https://example.com/api/v1/persistentvolumes")
+	expectQPS(1.25)
+	now = now.Add(time.Second)
+	send("https://example.com/api/v1/persistentvolumes")
+	expectQPS(1)
+	now = now.Add(time.Second)
+	send("https://example.com/api/v1/persistentvolumes")
+	expectQPS(1)
+
+	// Recovers step by step.
+	transport.latency = 0
+	now = now.Add(time.Second)
+	send("https://example.com/api/v1/persistentvolumes")
+	expectQPS(1.5)
+	for i := 0; i < 30; i++ {
+		now = now.Add(time.Second)
+		send("https://example.com/api/v1/persistentvolumes")
+	}
+	expectQPS(10)
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package canary contains a self-test which provisions and deletes a
+// small volume, without creating PVC or PV objects, to verify that
+// provisioning works end-to-end with the credentials and parameters of
+// a storage class.
+package canary
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+const (
+	annStorageProvisioner = "volume.beta.kubernetes.io/storage-provisioner"
+
+	// claimName is used for the PVC that only exists in memory.
+	claimName = "csi-provisioner-canary"
+)
+
+var (
+	checksTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name:           "canary_checks_total",
+			Help:           "Number of canary checks which provision and delete a volume, by result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"result"},
+	)
+	lastCheckSuccess = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           "canary_last_check_success",
+			Help:           "1 if the most recent canary check succeeded, 0 if it failed.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	lastSuccessTimestamp = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           "canary_last_success_timestamp_seconds",
+			Help:           "Time of the most recent successful canary check in seconds since the epoch.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	checkDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Name:           "canary_check_duration_seconds",
+			Help:           "Duration of provisioning and deleting the canary volume in seconds, by operation and result.",
+			Buckets:        []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 25, 50, 120, 300, 600},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation", "result"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(checksTotal)
+	legacyregistry.MustRegister(lastCheckSuccess)
+	legacyregistry.MustRegister(lastSuccessTimestamp)
+	legacyregistry.MustRegister(checkDuration)
+}
+
+// Canary provisions and deletes a volume with a storage class.
+type Canary struct {
+	provisioner      controller.Provisioner
+	driverName       string
+	client           kubernetes.Interface
+	storageClassName string
+	size             resource.Quantity
+	namespace        string
+	pod              *v1.ObjectReference
+	eventRecorder    record.EventRecorder
+	now              func() time.Time
+
+	// running is 1 while Run is active.
+	running int32
+	// mutex ensures that only one check runs at a time.
+	mutex sync.Mutex
+	// succeeded is true if the previous check succeeded.
+	succeeded bool
+}
+
+// New creates a canary for the driver. The namespace is used for the
+// PVC that gets passed to the provisioner. If the pod name is not empty,
+// events about the result are recorded for the pod in that namespace.
+func New(
+	provisioner controller.Provisioner,
+	driverName string,
+	client kubernetes.Interface,
+	storageClassName string,
+	size resource.Quantity,
+	namespace, podName string,
+) *Canary {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.Infof)
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "external-provisioner"})
+
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	c := &Canary{
+		provisioner:      provisioner,
+		driverName:       driverName,
+		client:           client,
+		storageClassName: storageClassName,
+		size:             size,
+		namespace:        namespace,
+		eventRecorder:    eventRecorder,
+		now:              time.Now,
+	}
+	if podName != "" {
+		c.pod = &v1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  namespace,
+			Name:       podName,
+		}
+	}
+	return c
+}
+
+// Run checks once and then once per interval, if non-zero. Checks
+// triggered through the HTTP handler are accepted until the context is
+// done. The provisioner must be ready to use, i.e. informers must have
+// synced.
+func (c *Canary) Run(ctx context.Context, interval time.Duration) {
+	atomic.StoreInt32(&c.running, 1)
+	defer atomic.StoreInt32(&c.running, 0)
+
+	// Errors are already reported by Check.
+	if interval <= 0 {
+		_ = c.Check(ctx)
+		<-ctx.Done()
+		return
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) { _ = c.Check(ctx) }, interval)
+}
+
+// Check provisions and deletes a volume and reports the result.
+func (c *Canary) Check(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	klog.V(3).Infof("Canary check with storage class %s", c.storageClassName)
+	if err := c.check(ctx); err != nil {
+		klog.Errorf("Canary check with storage class %s failed: %v", c.storageClassName, err)
+		checksTotal.WithLabelValues("failure").Inc()
+		lastCheckSuccess.Set(0)
+		c.event(v1.EventTypeWarning, "CanaryFailed", fmt.Sprintf("Provisioning and deleting a volume with storage class %s failed: %v", c.storageClassName, err))
+		c.succeeded = false
+		return err
+	}
+	klog.V(2).Infof("Canary check with storage class %s succeeded", c.storageClassName)
+	checksTotal.WithLabelValues("success").Inc()
+	lastCheckSuccess.Set(1)
+	lastSuccessTimestamp.Set(float64(c.now().Unix()))
+	if !c.succeeded {
+		// Periodic checks only report when the situation changes.
+		c.event(v1.EventTypeNormal, "CanarySucceeded", fmt.Sprintf("Provisioned and deleted a volume with storage class %s", c.storageClassName))
+	}
+	c.succeeded = true
+	return nil
+}
+
+func (c *Canary) check(ctx context.Context) error {
+	class, err := c.client.StorageV1().StorageClasses().Get(ctx, c.storageClassName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get storage class: %v", err)
+	}
+	if class.Provisioner != c.driverName {
+		return fmt.Errorf("storage class uses provisioner %q instead of %q", class.Provisioner, c.driverName)
+	}
+
+	uid := uuid.NewUUID()
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimName,
+			Namespace: c.namespace,
+			UID:       uid,
+			Annotations: map[string]string{
+				annStorageProvisioner: c.driverName,
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: c.size,
+				},
+			},
+			StorageClassName: &class.Name,
+		},
+	}
+	pvName := "canary-" + string(uid)
+	start := c.now()
+	pv, state, err := c.provisioner.Provision(ctx, controller.ProvisionOptions{
+		StorageClass: class,
+		PVName:       pvName,
+		PVC:          claim,
+	})
+	observeDuration("provision", start, c.now(), err)
+	if err != nil {
+		if state == controller.ProvisioningInBackground {
+			return fmt.Errorf("provision volume %s: %v, the volume may have been created and then needs to be deleted manually", pvName, err)
+		}
+		return fmt.Errorf("provision volume %s: %v", pvName, err)
+	}
+
+	// Same as the provisioner library before creating the PV.
+	pv.Spec.StorageClassName = class.Name
+	pv.Spec.ClaimRef = &v1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  claim.Namespace,
+		Name:       claim.Name,
+		UID:        claim.UID,
+	}
+	start = c.now()
+	err = c.provisioner.Delete(ctx, pv)
+	observeDuration("delete", start, c.now(), err)
+	if err != nil {
+		return fmt.Errorf("delete volume %s: %v, the volume needs to be deleted manually", volumeID(pv), err)
+	}
+	return nil
+}
+
+func observeDuration(operation string, start, end time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	checkDuration.WithLabelValues(operation, result).Observe(end.Sub(start).Seconds())
+}
+
+func (c *Canary) event(eventType, reason, message string) {
+	if c.pod != nil {
+		c.eventRecorder.Event(c.pod, eventType, reason, message)
+	}
+}
+
+func volumeID(pv *v1.PersistentVolume) string {
+	if pv.Spec.CSI != nil {
+		return pv.Spec.CSI.VolumeHandle
+	}
+	return pv.Name
+}
+
+// NewHandler returns an HTTP handler which runs a check for POST
+// requests and reports the result. Checks are only possible while Run
+// is active.
+func NewHandler(c *Canary) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		if atomic.LoadInt32(&c.running) == 0 {
+			http.Error(w, "canary checks only run in the leader", http.StatusServiceUnavailable)
+			return
+		}
+		if err := c.Check(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package canary
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/testutil"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+const (
+	driverName = "test-driver"
+	className  = "test-class"
+)
+
+type fakeProvisioner struct {
+	provisionErr error
+	deleteErr    error
+
+	provisioned *controller.ProvisionOptions
+	deleted     *v1.PersistentVolume
+}
+
+func (f *fakeProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	f.provisioned = &options
+	if f.provisionErr != nil {
+		return nil, controller.ProvisioningFinished, f.provisionErr
+	}
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: options.PVName},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:       driverName,
+					VolumeHandle: "volume-" + options.PVName,
+				},
+			},
+		},
+	}, controller.ProvisioningFinished, nil
+}
+
+func (f *fakeProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	f.deleted = pv
+	return f.deleteErr
+}
+
+func TestCheck(t *testing.T) {
+	testcases := map[string]struct {
+		provisioner     string
+		provisionErr    error
+		deleteErr       error
+		expectProvision bool
+		expectDelete    bool
+		expectReason    string
+	}{
+		"success": {
+			expectProvision: true,
+			expectDelete:    true,
+			expectReason:    "CanarySucceeded",
+		},
+		"other provisioner": {
+			provisioner:  "other-driver",
+			expectReason: "CanaryFailed",
+		},
+		"provision failure": {
+			provisionErr:    errors.New("no credentials"),
+			expectProvision: true,
+			expectReason:    "CanaryFailed",
+		},
+		"delete failure": {
+			deleteErr:       errors.New("no credentials"),
+			expectProvision: true,
+			expectDelete:    true,
+			expectReason:    "CanaryFailed",
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			provisioner := tc.provisioner
+			if provisioner == "" {
+				provisioner = driverName
+			}
+			client := fakeclientset.NewSimpleClientset(&storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: className},
+				Provisioner: provisioner,
+			})
+			p := &fakeProvisioner{provisionErr: tc.provisionErr, deleteErr: tc.deleteErr}
+			c := New(p, driverName, client, className, resource.MustParse("1Mi"), "kube-system", "provisioner-pod")
+			recorder := record.NewFakeRecorder(10)
+			c.eventRecorder = recorder
+
+			err := c.Check(context.Background())
+			expectErr := tc.expectReason == "CanaryFailed"
+			if expectErr && err == nil {
+				t.Error("expected error, got none")
+			}
+			if !expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.expectProvision != (p.provisioned != nil) {
+				t.Errorf("expected provision %v, got %v", tc.expectProvision, p.provisioned != nil)
+			}
+			if p.provisioned != nil {
+				claim := p.provisioned.PVC
+				if claim.Namespace != "kube-system" || claim.Annotations[annStorageProvisioner] != driverName {
+					t.Errorf("unexpected claim: %+v", claim)
+				}
+			}
+			if tc.expectDelete != (p.deleted != nil) {
+				t.Errorf("expected delete %v, got %v", tc.expectDelete, p.deleted != nil)
+			}
+			if p.deleted != nil && (p.deleted.Spec.StorageClassName != className || p.deleted.Spec.ClaimRef == nil) {
+				t.Errorf("deleted PV without storage class or claim ref: %+v", p.deleted.Spec)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, tc.expectReason) {
+					t.Errorf("expected event with reason %s, got %q", tc.expectReason, event)
+				}
+			default:
+				t.Errorf("expected event with reason %s, got none", tc.expectReason)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	client := fakeclientset.NewSimpleClientset(&storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: className},
+		Provisioner: driverName,
+	})
+	p := &fakeProvisioner{}
+	c := New(p, driverName, client, className, resource.MustParse("1Mi"), "", "")
+	handler := NewHandler(c)
+
+	post := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/canary", nil))
+		return w.Code
+	}
+	if code := post(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d while not running, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, 0)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// The initial check in Run holds the mutex, so the handler
+	// check cannot overlap with it.
+	for code := post(); code != http.StatusOK; code = post() {
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if p.deleted == nil {
+		t.Error("expected volume to be deleted")
+	}
+}
+
+func TestPeriodicCheck(t *testing.T) {
+	client := fakeclientset.NewSimpleClientset(&storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: className},
+		Provisioner: driverName,
+	})
+	p := &fakeProvisioner{}
+	c := New(p, driverName, client, className, resource.MustParse("1Mi"), "kube-system", "provisioner-pod")
+	recorder := record.NewFakeRecorder(10)
+	c.eventRecorder = recorder
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	check := func(expectedReason string) {
+		t.Helper()
+		_ = c.Check(ctx)
+		select {
+		case event := <-recorder.Events:
+			if expectedReason == "" || !strings.Contains(event, expectedReason) {
+				t.Errorf("expected event %q, got %q", expectedReason, event)
+			}
+		default:
+			if expectedReason != "" {
+				t.Errorf("expected event %q, got none", expectedReason)
+			}
+		}
+	}
+
+	check("CanarySucceeded")
+	expectLastSuccess(t, 1000)
+	// Unchanged, no event.
+	check("")
+	p.provisionErr = errors.New("backend down")
+	now = time.Unix(2000, 0)
+	check("CanaryFailed")
+	check("CanaryFailed")
+	expectLastSuccess(t, 1000)
+	p.provisionErr = nil
+	check("CanarySucceeded")
+}
+
+func expectLastSuccess(t *testing.T, expected float64) {
+	t.Helper()
+	value, err := testutil.GetGaugeMetricValue(lastSuccessTimestamp)
+	if err != nil {
+		t.Fatalf("get metric: %v", err)
+	}
+	if value != expected {
+		t.Errorf("expected last success timestamp %v, got %v", expected, value)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	storagelistersv1beta1 "k8s.io/client-go/listers/storage/v1beta1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/klog/v2"
+)
+
+var (
+	aggregatedCapacityDesc = metrics.NewDesc(
+		"csistoragecapacity_aggregated_capacity_bytes",
+		"Sum of the capacity in the CSIStorageCapacity objects of the driver, by storage class and segment.",
+		[]string{"driver_name", "storage_class", "segment"}, nil,
+		metrics.ALPHA,
+		"",
+	)
+	aggregatedMaximumVolumeSizeDesc = metrics.NewDesc(
+		"csistoragecapacity_aggregated_maximum_volume_size_bytes",
+		"Largest maximum volume size in the CSIStorageCapacity objects of the driver which report one, by storage class and segment.",
+		[]string{"driver_name", "storage_class", "segment"}, nil,
+		metrics.ALPHA,
+		"",
+	)
+	aggregatedObjectsDesc = metrics.NewDesc(
+		"csistoragecapacity_aggregated_objects",
+		"Number of CSIStorageCapacity objects of the driver, by storage class and segment.",
+		[]string{"driver_name", "storage_class", "segment"}, nil,
+		metrics.ALPHA,
+		"",
+	)
+)
+
+// Aggregator reports the sum of the capacity in CSIStorageCapacity
+// objects as metrics. It is meant for a central instance when
+// node-local instances publish one object per node, because then
+// there is no single object which shows the free space of a larger
+// pool, like a zone.
+//
+// The objects get grouped by storage class and the values of the
+// aggregation keys in their topology. Without keys, there is one
+// group per storage class. Roll-ups are not published as
+// CSIStorageCapacity objects because the scheduler would treat them
+// as the capacity that is available for a single volume.
+type Aggregator struct {
+	metrics.BaseStableCollector
+
+	driverName string
+	lister     storagelistersv1beta1.CSIStorageCapacityLister
+	keys       []string
+}
+
+var _ metrics.StableCollector = &Aggregator{}
+
+// NewAggregator creates an aggregator for the objects of the driver
+// in the lister.
+func NewAggregator(driverName string, lister storagelistersv1beta1.CSIStorageCapacityLister, keys []string) *Aggregator {
+	return &Aggregator{
+		driverName: driverName,
+		lister:     lister,
+		keys:       keys,
+	}
+}
+
+type aggregationGroup struct {
+	storageClassName, segment string
+}
+
+type aggregatedCapacity struct {
+	capacity, maximumVolumeSize int64
+	hasMaximumVolumeSize        bool
+	objects                     int
+}
+
+// DescribeWithStability implements the metrics.StableCollector interface.
+func (a *Aggregator) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- aggregatedCapacityDesc
+	ch <- aggregatedMaximumVolumeSizeDesc
+	ch <- aggregatedObjectsDesc
+}
+
+// CollectWithStability implements the metrics.StableCollector interface.
+func (a *Aggregator) CollectWithStability(ch chan<- metrics.Metric) {
+	for group, aggregated := range a.aggregate() {
+		ch <- metrics.NewLazyConstMetric(aggregatedCapacityDesc,
+			metrics.GaugeValue,
+			float64(aggregated.capacity),
+			a.driverName, group.storageClassName, group.segment,
+		)
+		if aggregated.hasMaximumVolumeSize {
+			ch <- metrics.NewLazyConstMetric(aggregatedMaximumVolumeSizeDesc,
+				metrics.GaugeValue,
+				float64(aggregated.maximumVolumeSize),
+				a.driverName, group.storageClassName, group.segment,
+			)
+		}
+		ch <- metrics.NewLazyConstMetric(aggregatedObjectsDesc,
+			metrics.GaugeValue,
+			float64(aggregated.objects),
+			a.driverName, group.storageClassName, group.segment,
+		)
+	}
+}
+
+func (a *Aggregator) aggregate() map[aggregationGroup]*aggregatedCapacity {
+	capacities, err := a.lister.List(labels.SelectorFromSet(labels.Set{DriverNameLabel: a.driverName}))
+	if err != nil {
+		klog.Errorf("Capacity Aggregator: listing CSIStorageCapacity objects: %v", err)
+		return nil
+	}
+	result := map[aggregationGroup]*aggregatedCapacity{}
+	for _, capacity := range capacities {
+		group := aggregationGroup{storageClassName: capacity.StorageClassName}
+		var segment []string
+		for _, key := range a.keys {
+			value := ""
+			if capacity.NodeTopology != nil {
+				value = capacity.NodeTopology.MatchLabels[key]
+			}
+			segment = append(segment, key+"="+value)
+		}
+		group.segment = strings.Join(segment, ",")
+
+		aggregated := result[group]
+		if aggregated == nil {
+			aggregated = &aggregatedCapacity{}
+			result[group] = aggregated
+		}
+		aggregated.objects++
+		if capacity.Capacity != nil {
+			aggregated.capacity += capacity.Capacity.Value()
+		}
+		if capacity.MaximumVolumeSize != nil {
+			if size := capacity.MaximumVolumeSize.Value(); !aggregated.hasMaximumVolumeSize || size > aggregated.maximumVolumeSize {
+				aggregated.maximumVolumeSize = size
+			}
+			aggregated.hasMaximumVolumeSize = true
+		}
+	}
+	return result
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	storagelistersv1beta1 "k8s.io/client-go/listers/storage/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestAggregator(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	add := func(driver, storageClassName, zone, capacity, maximumVolumeSize string) {
+		c := &storagev1beta1.CSIStorageCapacity{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("csisc-%d", len(indexer.List())),
+				Namespace: ownerNamespace,
+				Labels:    map[string]string{DriverNameLabel: driver},
+			},
+			StorageClassName: storageClassName,
+			NodeTopology: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"zone": zone, "node": fmt.Sprintf("node-%d", len(indexer.List()))},
+			},
+		}
+		if capacity != "" {
+			quantity := resource.MustParse(capacity)
+			c.Capacity = &quantity
+		}
+		if maximumVolumeSize != "" {
+			quantity := resource.MustParse(maximumVolumeSize)
+			c.MaximumVolumeSize = &quantity
+		}
+		if err := indexer.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(driverName, "fast", "a", "1Gi", "")
+	add(driverName, "fast", "a", "2Gi", "1Gi")
+	add(driverName, "fast", "b", "4Gi", "3Gi")
+	add(driverName, "slow", "b", "", "")
+	add("other-driver", "fast", "a", "8Gi", "")
+	lister := storagelistersv1beta1.NewCSIStorageCapacityLister(indexer)
+
+	testcases := map[string]struct {
+		keys   []string
+		expect map[aggregationGroup]aggregatedCapacity
+	}{
+		"by storage class": {
+			expect: map[aggregationGroup]aggregatedCapacity{
+				{storageClassName: "fast"}: {capacity: 7 << 30, maximumVolumeSize: 3 << 30, hasMaximumVolumeSize: true, objects: 3},
+				{storageClassName: "slow"}: {objects: 1},
+			},
+		},
+		"by zone": {
+			keys: []string{"zone"},
+			expect: map[aggregationGroup]aggregatedCapacity{
+				{storageClassName: "fast", segment: "zone=a"}: {capacity: 3 << 30, maximumVolumeSize: 1 << 30, hasMaximumVolumeSize: true, objects: 2},
+				{storageClassName: "fast", segment: "zone=b"}: {capacity: 4 << 30, maximumVolumeSize: 3 << 30, hasMaximumVolumeSize: true, objects: 1},
+				{storageClassName: "slow", segment: "zone=b"}: {objects: 1},
+			},
+		},
+		"missing key": {
+			keys: []string{"rack"},
+			expect: map[aggregationGroup]aggregatedCapacity{
+				{storageClassName: "fast", segment: "rack="}: {capacity: 7 << 30, maximumVolumeSize: 3 << 30, hasMaximumVolumeSize: true, objects: 3},
+				{storageClassName: "slow", segment: "rack="}: {objects: 1},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			actual := map[aggregationGroup]aggregatedCapacity{}
+			for group, aggregated := range NewAggregator(driverName, lister, tc.keys).aggregate() {
+				actual[group] = *aggregated
+			}
+			if !reflect.DeepEqual(actual, tc.expect) {
+				t.Errorf("expected %+v, got %+v", tc.expect, actual)
+			}
+		})
+	}
+}
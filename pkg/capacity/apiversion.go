@@ -0,0 +1,256 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	storageinformersv1beta1 "k8s.io/client-go/informers/storage/v1beta1"
+	"k8s.io/client-go/kubernetes"
+	storagelistersv1beta1 "k8s.io/client-go/listers/storage/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// APIVersionV1 and APIVersionV1beta1 are the versions of the
+	// CSIStorageCapacity API which are supported.
+	APIVersionV1      = "v1"
+	APIVersionV1beta1 = "v1beta1"
+
+	csiStorageCapacities = "csistoragecapacities"
+)
+
+var v1Resource = storagev1.SchemeGroupVersion.WithResource(csiStorageCapacities)
+
+// CSIStorageCapacitiesGetter provides access to CSIStorageCapacity
+// objects. The typed client of client-go only has the v1beta1 API,
+// therefore objects are always represented by the v1beta1 type. The v1
+// API has the same fields.
+type CSIStorageCapacitiesGetter interface {
+	CSIStorageCapacities(namespace string) CSIStorageCapacityInterface
+}
+
+// CSIStorageCapacityInterface is the subset of the typed client for
+// CSIStorageCapacity objects that is needed by the controller.
+type CSIStorageCapacityInterface interface {
+	Create(ctx context.Context, capacity *storagev1beta1.CSIStorageCapacity, opts metav1.CreateOptions) (*storagev1beta1.CSIStorageCapacity, error)
+	Update(ctx context.Context, capacity *storagev1beta1.CSIStorageCapacity, opts metav1.UpdateOptions) (*storagev1beta1.CSIStorageCapacity, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	List(ctx context.Context, opts metav1.ListOptions) (*storagev1beta1.CSIStorageCapacityList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+}
+
+// DetectAPIVersion returns APIVersionV1 if the API server supports
+// CSIStorageCapacity in storage.k8s.io/v1, otherwise APIVersionV1beta1.
+func DetectAPIVersion(client discovery.DiscoveryInterface) (string, error) {
+	resources, err := client.ServerResourcesForGroupVersion(storagev1.SchemeGroupVersion.String())
+	if err != nil {
+		return "", fmt.Errorf("discover resources in %s: %v", storagev1.SchemeGroupVersion, err)
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == csiStorageCapacities {
+			return APIVersionV1, nil
+		}
+	}
+	return APIVersionV1beta1, nil
+}
+
+// NewV1beta1Client uses the typed client.
+func NewV1beta1Client(client kubernetes.Interface) CSIStorageCapacitiesGetter {
+	return v1beta1Client{client: client}
+}
+
+type v1beta1Client struct {
+	client kubernetes.Interface
+}
+
+func (c v1beta1Client) CSIStorageCapacities(namespace string) CSIStorageCapacityInterface {
+	return c.client.StorageV1beta1().CSIStorageCapacities(namespace)
+}
+
+// NewV1Client uses the dynamic client for the v1 API and converts
+// between that and the v1beta1 type.
+func NewV1Client(client dynamic.Interface) CSIStorageCapacitiesGetter {
+	return v1Client{client: client}
+}
+
+type v1Client struct {
+	client dynamic.Interface
+}
+
+func (c v1Client) CSIStorageCapacities(namespace string) CSIStorageCapacityInterface {
+	return v1Capacities{resource: c.client.Resource(v1Resource).Namespace(namespace)}
+}
+
+type v1Capacities struct {
+	resource dynamic.ResourceInterface
+}
+
+func (c v1Capacities) Create(ctx context.Context, capacity *storagev1beta1.CSIStorageCapacity, opts metav1.CreateOptions) (*storagev1beta1.CSIStorageCapacity, error) {
+	obj, err := toV1(capacity)
+	if err != nil {
+		return nil, err
+	}
+	obj, err = c.resource.Create(ctx, obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	return fromV1(obj)
+}
+
+func (c v1Capacities) Update(ctx context.Context, capacity *storagev1beta1.CSIStorageCapacity, opts metav1.UpdateOptions) (*storagev1beta1.CSIStorageCapacity, error) {
+	obj, err := toV1(capacity)
+	if err != nil {
+		return nil, err
+	}
+	obj, err = c.resource.Update(ctx, obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	return fromV1(obj)
+}
+
+func (c v1Capacities) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.resource.Delete(ctx, name, opts)
+}
+
+func (c v1Capacities) List(ctx context.Context, opts metav1.ListOptions) (*storagev1beta1.CSIStorageCapacityList, error) {
+	list, err := c.resource.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &storagev1beta1.CSIStorageCapacityList{
+		ListMeta: metav1.ListMeta{
+			ResourceVersion:    list.GetResourceVersion(),
+			Continue:           list.GetContinue(),
+			RemainingItemCount: list.GetRemainingItemCount(),
+		},
+	}
+	for i := range list.Items {
+		capacity, err := fromV1(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, *capacity)
+	}
+	return result, nil
+}
+
+func (c v1Capacities) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := c.resource.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		obj, ok := in.Object.(*unstructured.Unstructured)
+		if in.Type == watch.Error {
+			// The dynamic client decodes the metav1.Status of error
+			// events as unstructured object, too, but consumers like
+			// the reflector expect a metav1.Status.
+			if ok {
+				status := &metav1.Status{}
+				if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, status); err == nil {
+					in.Object = status
+				}
+			}
+			return in, true
+		}
+		if !ok {
+			return in, true
+		}
+		capacity, err := fromV1(obj)
+		if err != nil {
+			return watch.Event{
+				Type:   watch.Error,
+				Object: &metav1.Status{Status: metav1.StatusFailure, Message: err.Error()},
+			}, true
+		}
+		in.Object = capacity
+		return in, true
+	}), nil
+}
+
+func toV1(capacity *storagev1beta1.CSIStorageCapacity) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(capacity)
+	if err != nil {
+		return nil, fmt.Errorf("convert CSIStorageCapacity to v1: %v", err)
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetGroupVersionKind(storagev1.SchemeGroupVersion.WithKind("CSIStorageCapacity"))
+	return obj, nil
+}
+
+func fromV1(obj *unstructured.Unstructured) (*storagev1beta1.CSIStorageCapacity, error) {
+	capacity := &storagev1beta1.CSIStorageCapacity{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, capacity); err != nil {
+		return nil, fmt.Errorf("convert CSIStorageCapacity from v1: %v", err)
+	}
+	// Like objects from the typed client.
+	capacity.TypeMeta = metav1.TypeMeta{}
+	return capacity, nil
+}
+
+// NewCSIStorageCapacityInformer creates an informer for one namespace
+// which works with any of the clients. Unlike informers from a shared
+// informer factory, it must be started separately.
+func NewCSIStorageCapacityInformer(client CSIStorageCapacitiesGetter, namespace string, resyncPeriod time.Duration, tweakListOptions func(*metav1.ListOptions)) storageinformersv1beta1.CSIStorageCapacityInformer {
+	tweak := func(options *metav1.ListOptions) {
+		if tweakListOptions != nil {
+			tweakListOptions(options)
+		}
+	}
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				tweak(&options)
+				return client.CSIStorageCapacities(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				tweak(&options)
+				return client.CSIStorageCapacities(namespace).Watch(context.TODO(), options)
+			},
+		},
+		&storagev1beta1.CSIStorageCapacity{},
+		resyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+	return capacityInformer{informer: informer}
+}
+
+type capacityInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+var _ storageinformersv1beta1.CSIStorageCapacityInformer = capacityInformer{}
+
+func (c capacityInformer) Informer() cache.SharedIndexInformer {
+	return c.informer
+}
+
+func (c capacityInformer) Lister() storagelistersv1beta1.CSIStorageCapacityLister {
+	return storagelistersv1beta1.NewCSIStorageCapacityLister(c.informer.GetIndexer())
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDetectAPIVersion(t *testing.T) {
+	testcases := map[string]struct {
+		resources     []*metav1.APIResourceList
+		expectVersion string
+		expectErr     bool
+	}{
+		"v1": {
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "storage.k8s.io/v1",
+				APIResources: []metav1.APIResource{{Name: "storageclasses"}, {Name: "csistoragecapacities"}},
+			}},
+			expectVersion: APIVersionV1,
+		},
+		"v1beta1": {
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "storage.k8s.io/v1",
+				APIResources: []metav1.APIResource{{Name: "storageclasses"}},
+			}},
+			expectVersion: APIVersionV1beta1,
+		},
+		"discovery failure": {
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			client := fakeclientset.NewSimpleClientset()
+			client.Discovery().(*fakediscovery.FakeDiscovery).Resources = tc.resources
+			version, err := DetectAPIVersion(client.Discovery())
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if version != tc.expectVersion {
+				t.Errorf("expected version %q, got %q", tc.expectVersion, version)
+			}
+		})
+	}
+}
+
+func TestV1Client(t *testing.T) {
+	ctx := context.Background()
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1Resource: "CSIStorageCapacityList"})
+	client := NewV1Client(dynamicClient)
+	capacities := client.CSIStorageCapacities(ownerNamespace)
+
+	informer := NewCSIStorageCapacityInformer(client, ownerNamespace, 0, nil)
+	stop := make(chan struct{})
+	defer close(stop)
+	go informer.Informer().Run(stop)
+
+	quantity := resource.MustParse("1Gi")
+	capacity, err := capacities.Create(ctx, &storagev1beta1.CSIStorageCapacity{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "csisc-1",
+			Namespace: ownerNamespace,
+			Labels:    map[string]string{DriverNameLabel: driverName},
+		},
+		StorageClassName: "fast",
+		Capacity:         &quantity,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if capacity.StorageClassName != "fast" || capacity.Capacity.Cmp(quantity) != 0 {
+		t.Errorf("unexpected object after create: %+v", capacity)
+	}
+	obj, err := dynamicClient.Resource(v1Resource).Namespace(ownerNamespace).Get(ctx, "csisc-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get v1 object: %v", err)
+	}
+	if obj.GetAPIVersion() != "storage.k8s.io/v1" || obj.GetKind() != "CSIStorageCapacity" {
+		t.Errorf("expected storage.k8s.io/v1 CSIStorageCapacity, got %s %s", obj.GetAPIVersion(), obj.GetKind())
+	}
+
+	updated := resource.MustParse("2Gi")
+	capacity.Capacity = &updated
+	if _, err := capacities.Update(ctx, capacity, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	list, err := capacities.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Capacity.Cmp(updated) != 0 {
+		t.Errorf("unexpected objects after update: %+v", list.Items)
+	}
+
+	if err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		capacity, err := informer.Lister().CSIStorageCapacities(ownerNamespace).Get("csisc-1")
+		return err == nil && capacity.Capacity.Cmp(updated) == 0, nil
+	}); err != nil {
+		t.Fatalf("informer did not receive update: %v", err)
+	}
+
+	if err := capacities.Delete(ctx, "csisc-1", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		capacities, err := informer.Lister().List(labels.Everything())
+		return err == nil && len(capacities) == 0, nil
+	}); err != nil {
+		t.Fatalf("informer did not receive deletion: %v", err)
+	}
+}
+
+func TestV1ClientWatchError(t *testing.T) {
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1Resource: "CSIStorageCapacityList"})
+	fakeWatch := watch.NewFake()
+	dynamicClient.PrependWatchReactor("csistoragecapacities", k8stesting.DefaultWatchReactor(fakeWatch, nil))
+	w, err := NewV1Client(dynamicClient).CSIStorageCapacities(ownerNamespace).Watch(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer w.Stop()
+
+	// The dynamic client delivers the status of an error event as
+	// unstructured object.
+	go fakeWatch.Error(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Status",
+		"status":     metav1.StatusFailure,
+		"reason":     string(metav1.StatusReasonExpired),
+		"message":    "too old resource version",
+		"code":       int64(410),
+	}})
+	event := <-w.ResultChan()
+	if event.Type != watch.Error {
+		t.Fatalf("expected error event, got %s", event.Type)
+	}
+	status, ok := event.Object.(*metav1.Status)
+	if !ok {
+		t.Fatalf("expected *metav1.Status, got %T", event.Object)
+	}
+	if status.Reason != metav1.StatusReasonExpired || status.Code != 410 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/external-provisioner/pkg/capacity/topology"
+	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
+)
+
+// capacityCache remembers GetCapacity responses for a short time.
+// Storage classes which only differ in their name or in parameters
+// that are not passed to the driver lead to identical requests, for
+// example when several classes share the same storage pool. Those then
+// share one GetCapacity call. Concurrent identical requests wait for
+// the call that is already in progress.
+//
+// Entries get invalidated when provisioning or deleting a volume
+// probably changed the capacity, so the following refresh asks the
+// driver again.
+type capacityCache struct {
+	client CSICapacityClient
+	ttl    time.Duration
+	now    func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	// done gets closed when the call has finished. resp, err and
+	// expires must not be accessed before that.
+	done    chan struct{}
+	resp    *csi.GetCapacityResponse
+	err     error
+	expires time.Time
+
+	parametersHash string
+	segment        map[string]string
+}
+
+var _ CSICapacityClient = &capacityCache{}
+
+func newCapacityCache(client CSICapacityClient, ttl time.Duration) *capacityCache {
+	return &capacityCache{
+		client:  client,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]*cacheEntry{},
+	}
+}
+
+// GetCapacity implements CSICapacityClient.
+func (c *capacityCache) GetCapacity(ctx context.Context, in *csi.GetCapacityRequest, opts ...grpc.CallOption) (*csi.GetCapacityResponse, error) {
+	key := cacheKey(in)
+	c.mutex.Lock()
+	entry, found := c.entries[key]
+	if found {
+		select {
+		case <-entry.done:
+			if c.now().After(entry.expires) {
+				found = false
+			}
+		default:
+			// In progress.
+		}
+	}
+	if found {
+		c.mutex.Unlock()
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if entry.err == nil {
+			klog.V(5).Infof("Capacity Controller: using cached GetCapacity response %+v", entry.resp)
+		}
+		return entry.resp, entry.err
+	}
+	entry = &cacheEntry{
+		done:           make(chan struct{}),
+		parametersHash: parametersHash(in.Parameters),
+	}
+	if in.AccessibleTopology != nil {
+		entry.segment = in.AccessibleTopology.Segments
+	}
+	c.entries[key] = entry
+	c.mutex.Unlock()
+
+	resp, err := c.client.GetCapacity(ctx, in, opts...)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry.resp, entry.err, entry.expires = resp, err, c.now().Add(c.ttl)
+	close(entry.done)
+	if err != nil && c.entries[key] == entry {
+		// Errors are returned to those who waited for the
+		// call, but not cached.
+		delete(c.entries, key)
+	}
+	return resp, err
+}
+
+// invalidate removes entries for the parameters hash and segment. An
+// empty hash matches all entries. The segment matches all entries whose
+// segment contains all of its entries, so an empty segment also
+// matches all entries. A call which is in progress finishes normally,
+// but its response does not get cached.
+func (c *capacityCache) invalidate(hash string, segment topology.Segment) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, entry := range c.entries {
+		if (hash == "" || entry.parametersHash == hash) &&
+			(len(segment) == 0 || segment.Matches(entry.segment)) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// cacheKey returns a string which is the same for requests that are
+// semantically identical.
+func cacheKey(in *csi.GetCapacityRequest) string {
+	key := parametersHash(in.Parameters)
+	if in.AccessibleTopology != nil {
+		key += "/" + parametersHash(in.AccessibleTopology.Segments)
+	} else {
+		key += "/-"
+	}
+	for _, capability := range in.VolumeCapabilities {
+		key += "/" + capability.String()
+	}
+	return key
+}
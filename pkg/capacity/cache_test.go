@@ -0,0 +1,173 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/external-provisioner/pkg/capacity/topology"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+// countingCapacity returns the number of calls as available capacity.
+type countingCapacity struct {
+	mutex sync.Mutex
+	calls int64
+	err   error
+	// block, if set, delays the response until it gets closed.
+	block chan struct{}
+}
+
+func (cc *countingCapacity) GetCapacity(ctx context.Context, in *csi.GetCapacityRequest, opts ...grpc.CallOption) (*csi.GetCapacityResponse, error) {
+	if cc.block != nil {
+		<-cc.block
+	}
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.calls++
+	if cc.err != nil {
+		return nil, cc.err
+	}
+	return &csi.GetCapacityResponse{AvailableCapacity: cc.calls}, nil
+}
+
+func capacityRequest(parameters map[string]string, segment map[string]string) *csi.GetCapacityRequest {
+	req := &csi.GetCapacityRequest{
+		Parameters:         parameters,
+		VolumeCapabilities: []*csi.VolumeCapability{volumeCapability(makeSC(testSC{name: "sc"}), "")},
+	}
+	if segment != nil {
+		req.AccessibleTopology = &csi.Topology{Segments: segment}
+	}
+	return req
+}
+
+func TestCapacityCache(t *testing.T) {
+	ctx := context.Background()
+	client := &countingCapacity{}
+	cache := newCapacityCache(client, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	get := func(req *csi.GetCapacityRequest) int64 {
+		resp, err := cache.GetCapacity(ctx, req)
+		require.NoError(t, err)
+		return resp.AvailableCapacity
+	}
+	fast := map[string]string{"pool": "fast"}
+	slow := map[string]string{"pool": "slow"}
+	zoneA := map[string]string{"zone": "a"}
+	zoneB := map[string]string{"zone": "b"}
+
+	require.Equal(t, int64(1), get(capacityRequest(fast, zoneA)))
+	require.Equal(t, int64(1), get(capacityRequest(map[string]string{"pool": "fast"}, map[string]string{"zone": "a"})), "same request")
+	require.Equal(t, int64(2), get(capacityRequest(fast, zoneB)), "other segment")
+	require.Equal(t, int64(3), get(capacityRequest(slow, zoneA)), "other parameters")
+	require.Equal(t, int64(4), get(capacityRequest(fast, nil)), "no segment")
+
+	cache.invalidate("", topology.Segment{{Key: "zone", Value: "a"}})
+	require.Equal(t, int64(5), get(capacityRequest(fast, zoneA)), "invalidated segment")
+	require.Equal(t, int64(6), get(capacityRequest(slow, zoneA)), "invalidated segment")
+	require.Equal(t, int64(2), get(capacityRequest(fast, zoneB)), "other segment still cached")
+	require.Equal(t, int64(4), get(capacityRequest(fast, nil)), "no segment still cached")
+
+	cache.invalidate(parametersHash(slow), nil)
+	require.Equal(t, int64(7), get(capacityRequest(slow, zoneA)), "invalidated parameters")
+	require.Equal(t, int64(5), get(capacityRequest(fast, zoneA)), "other parameters still cached")
+
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, int64(8), get(capacityRequest(fast, zoneA)), "expired")
+
+	client.err = errors.New("unavailable")
+	_, err := cache.GetCapacity(ctx, capacityRequest(fast, zoneB))
+	require.Error(t, err)
+	client.err = nil
+	require.Equal(t, int64(10), get(capacityRequest(fast, zoneB)), "errors are not cached")
+}
+
+func TestCapacityCacheConcurrent(t *testing.T) {
+	ctx := context.Background()
+	client := &countingCapacity{block: make(chan struct{})}
+	cache := newCapacityCache(client, time.Minute)
+	req := capacityRequest(map[string]string{"pool": "fast"}, nil)
+
+	var wg sync.WaitGroup
+	results := make([]int64, 5)
+	for i := range results {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cache.GetCapacity(ctx, req)
+			if err != nil {
+				t.Errorf("GetCapacity: %v", err)
+				return
+			}
+			results[i] = resp.AvailableCapacity
+		}()
+	}
+	// Give all goroutines a chance to find the call in progress.
+	time.Sleep(100 * time.Millisecond)
+	close(client.block)
+	wg.Wait()
+	require.Equal(t, []int64{1, 1, 1, 1, 1}, results)
+}
+
+func TestCapacityCacheInvalidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sc := makeSC(testSC{name: "direct-sc", driverName: driverName, parameters: map[string]string{"pool": "fast"}})
+	client := fakeclientset.NewSimpleClientset([]runtime.Object{sc}...)
+	c, _ := fakeController(ctx, client, &defaultOwner, &mockCapacity{}, topology.NewMock(&layer0), false /* immediate binding */)
+	c.prepare(ctx)
+	counter := &countingCapacity{}
+	c.cache = newCapacityCache(counter, time.Hour)
+	get := func() int64 {
+		resp, err := c.cache.GetCapacity(ctx, capacityRequest(sc.Parameters, layer0.GetLabelMap()))
+		require.NoError(t, err)
+		return resp.AvailableCapacity
+	}
+
+	require.Equal(t, int64(1), get())
+	require.Equal(t, int64(1), get())
+	c.refreshTopology(v1.VolumeNodeAffinity{
+		Required: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchExpressions: []v1.NodeSelectorRequirement{{
+					Key:      layer0[0].Key,
+					Operator: v1.NodeSelectorOpIn,
+					Values:   []string{layer0[0].Value},
+				}},
+			}},
+		},
+	})
+	require.Equal(t, int64(2), get(), "refresh by topology")
+	c.refreshSC("no-such-sc")
+	require.Equal(t, int64(2), get(), "unknown storage class")
+	c.refreshSC(sc.Name)
+	require.Equal(t, int64(3), get(), "refresh by storage class")
+	c.RefreshCapacity("", nil)
+	require.Equal(t, int64(4), get(), "refresh request")
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// prefixedFsTypeKey is the storage class parameter for the filesystem,
+// the same one that the provisioner uses for CreateVolume.
+const prefixedFsTypeKey = "csi.storage.k8s.io/fstype"
+
+// volumeCapability returns the capability that is passed to GetCapacity
+// for the storage class. It describes a mounted volume with the
+// filesystem and mount options that CreateVolume will get for the same
+// class, because some drivers report different capacity depending on
+// those, for example for thick and thin provisioned filesystems.
+//
+// The access mode is not known because it depends on the PVC.
+func volumeCapability(sc *storagev1.StorageClass, defaultFSType string) *csi.VolumeCapability {
+	fsType := ""
+	for key, value := range sc.Parameters {
+		if strings.ToLower(key) == "fstype" || key == prefixedFsTypeKey {
+			fsType = value
+		}
+	}
+	if fsType == "" {
+		fsType = defaultFSType
+	}
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				FsType:     fsType,
+				MountFlags: sc.MountOptions,
+			},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_UNKNOWN,
+		},
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"reflect"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+func TestVolumeCapability(t *testing.T) {
+	testcases := map[string]struct {
+		parameters    map[string]string
+		mountOptions  []string
+		defaultFSType string
+		expectFSType  string
+	}{
+		"nothing": {},
+		"default": {
+			defaultFSType: "ext4",
+			expectFSType:  "ext4",
+		},
+		"prefixed": {
+			parameters:    map[string]string{prefixedFsTypeKey: "xfs"},
+			mountOptions:  []string{"discard", "nouuid"},
+			defaultFSType: "ext4",
+			expectFSType:  "xfs",
+		},
+		"deprecated": {
+			parameters:   map[string]string{"fsType": "btrfs"},
+			mountOptions: []string{"compress=zstd"},
+			expectFSType: "btrfs",
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			sc := &storagev1.StorageClass{Parameters: tc.parameters, MountOptions: tc.mountOptions}
+			mount := volumeCapability(sc, tc.defaultFSType).GetMount()
+			if mount == nil {
+				t.Fatal("expected mount access type")
+			}
+			if mount.FsType != tc.expectFSType {
+				t.Errorf("expected fstype %q, got %q", tc.expectFSType, mount.FsType)
+			}
+			if !reflect.DeepEqual(mount.MountFlags, tc.mountOptions) {
+				t.Errorf("expected mount flags %v, got %v", tc.mountOptions, mount.MountFlags)
+			}
+		})
+	}
+}
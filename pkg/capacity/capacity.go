@@ -20,6 +20,8 @@ package capacity
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"reflect"
 	"sort"
@@ -28,6 +30,7 @@ import (
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/external-provisioner/pkg/capacity/topology"
+	"github.com/kubernetes-csi/external-provisioner/pkg/panicguard"
 	"google.golang.org/grpc"
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
@@ -37,11 +40,13 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	storageinformersv1 "k8s.io/client-go/informers/storage/v1"
 	storageinformersv1beta1 "k8s.io/client-go/informers/storage/v1beta1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/component-base/metrics"
 	"k8s.io/klog/v2"
@@ -50,6 +55,11 @@ import (
 const (
 	DriverNameLabel = "csi.storage.k8s.io/drivername"
 	ManagedByLabel  = "csi.storage.k8s.io/managed-by"
+	// ParametersHashLabel identifies the storage class parameters that
+	// were used for GetCapacity. Objects with a different value than
+	// other objects for the same storage class have not been refreshed
+	// since the storage class was replaced.
+	ParametersHashLabel = "csi.storage.k8s.io/parameters-hash"
 )
 
 // Controller creates and updates CSIStorageCapacity objects.  It
@@ -82,8 +92,10 @@ type Controller struct {
 	csiController    CSICapacityClient
 	driverName       string
 	client           kubernetes.Interface
+	capacityClient   CSIStorageCapacitiesGetter
 	queue            workqueue.RateLimitingInterface
 	owner            *metav1.OwnerReference
+	ownerLock        sync.Mutex
 	managedByID      string
 	ownerNamespace   string
 	topologyInformer topology.Informer
@@ -91,6 +103,12 @@ type Controller struct {
 	cInformer        storageinformersv1beta1.CSIStorageCapacityInformer
 	pollPeriod       time.Duration
 	immediateBinding bool
+	dryRun           bool
+	writeLimiter     flowcontrol.RateLimiter
+	defaultFSType    string
+	// cache is set when GetCapacity responses get cached. It then
+	// is also used as csiController.
+	cache *capacityCache
 
 	// capacities contains one entry for each object that is
 	// supposed to exist. Entries that exist on the API server
@@ -99,6 +117,12 @@ type Controller struct {
 	// races.
 	capacities     map[workItem]*storagev1beta1.CSIStorageCapacity
 	capacitiesLock sync.Mutex
+
+	// started is set by Run, lastRefresh after each successful
+	// GetCapacity call and object update. Both are protected by
+	// capacitiesLock and used by CheckFreshness.
+	started     time.Time
+	lastRefresh time.Time
 }
 
 type workItem struct {
@@ -164,11 +188,25 @@ func NewCentralCapacityController(
 	cInformer storageinformersv1beta1.CSIStorageCapacityInformer,
 	pollPeriod time.Duration,
 	immediateBinding bool,
+	dryRun bool,
+	writeLimiter flowcontrol.RateLimiter,
+	defaultFSType string,
+	capacityClient CSIStorageCapacitiesGetter,
+	cacheTTL time.Duration,
 ) *Controller {
+	if capacityClient == nil {
+		capacityClient = NewV1beta1Client(client)
+	}
+	var responseCache *capacityCache
+	if cacheTTL > 0 {
+		responseCache = newCapacityCache(csiController, cacheTTL)
+		csiController = responseCache
+	}
 	c := &Controller{
 		csiController:    csiController,
 		driverName:       driverName,
 		client:           client,
+		capacityClient:   capacityClient,
 		queue:            queue,
 		owner:            owner,
 		managedByID:      managedByID,
@@ -178,6 +216,10 @@ func NewCentralCapacityController(
 		cInformer:        cInformer,
 		pollPeriod:       pollPeriod,
 		immediateBinding: immediateBinding,
+		dryRun:           dryRun,
+		writeLimiter:     writeLimiter,
+		defaultFSType:    defaultFSType,
+		cache:            responseCache,
 		capacities:       map[workItem]*storagev1beta1.CSIStorageCapacity{},
 	}
 
@@ -231,6 +273,10 @@ func (c *Controller) Run(ctx context.Context, threadiness int) {
 	klog.Info("Starting Capacity Controller")
 	defer c.queue.ShutDown()
 
+	c.capacitiesLock.Lock()
+	c.started = time.Now()
+	c.capacitiesLock.Unlock()
+
 	c.prepare(ctx)
 	for i := 0; i < threadiness; i++ {
 		go wait.UntilWithContext(ctx, func(ctx context.Context) {
@@ -343,6 +389,9 @@ func (c *Controller) onTopologyChanges(added []*topology.Segment, removed []*top
 			continue
 		}
 		for _, segment := range added {
+			if !segmentAllowed(segment, sc) {
+				continue
+			}
 			c.addWorkItem(segment, sc)
 		}
 		for _, segment := range removed {
@@ -368,6 +417,10 @@ func (c *Controller) onSCAddOrUpdate(sc *storagev1.StorageClass) {
 	c.capacitiesLock.Lock()
 	defer c.capacitiesLock.Unlock()
 	for _, segment := range segments {
+		if !segmentAllowed(segment, sc) {
+			klog.V(5).Infof("Capacity Controller: segment %s not allowed by storage class %s", segment.SimpleString(), sc.Name)
+			continue
+		}
 		c.addWorkItem(segment, sc)
 	}
 }
@@ -407,6 +460,7 @@ func (c *Controller) refreshTopology(nodeAffinity v1.VolumeNodeAffinity) {
 			klog.Errorf("Capacity Controller: skipping refresh: unexpected node selector term %+v: %v", term, err)
 			continue
 		}
+		c.invalidateCache(nil, segment)
 		for item := range c.capacities {
 			if item.segment.Compare(segment) == 0 {
 				klog.V(5).Infof("Capacity Controller: skipping refresh: enqueuing %+v because of the topology", item)
@@ -436,9 +490,59 @@ func termToSegment(term v1.NodeSelectorTerm) (segment topology.Segment, err erro
 	return
 }
 
+// largestCapacity checks the known CSIStorageCapacity objects for the
+// storage class and, if a node is given, for those topology segments that
+// the node belongs to. It returns true if no information is available,
+// the capacity of at least one segment is unknown or at least one segment
+// has enough capacity for a volume of the given size. Otherwise it returns
+// false together with the segment that allows the largest volume, the
+// size of that volume and whether it is limited by the maximum volume
+// size instead of the available capacity.
+func (c *Controller) largestCapacity(storageClassName string, node *v1.Node, size int64) (bool, *topology.Segment, *resource.Quantity, bool) {
+	c.capacitiesLock.Lock()
+	defer c.capacitiesLock.Unlock()
+
+	var largestSegment *topology.Segment
+	var largest *resource.Quantity
+	var largestIsMaxVolumeSize bool
+	for item, capacity := range c.capacities {
+		if item.storageClassName != storageClassName ||
+			(node != nil && !item.segment.Matches(node.Labels)) {
+			continue
+		}
+		if capacity == nil || capacity.Capacity == nil {
+			// Unknown capacity, let the driver decide.
+			return true, nil, nil, false
+		}
+		available, isMaxVolumeSize := capacity.Capacity, false
+		if capacity.MaximumVolumeSize != nil && capacity.MaximumVolumeSize.Cmp(*available) < 0 {
+			available, isMaxVolumeSize = capacity.MaximumVolumeSize, true
+		}
+		if available.Value() >= size {
+			return true, nil, nil, false
+		}
+		if largest == nil || available.Cmp(*largest) > 0 {
+			largestSegment = item.segment
+			largest = available
+			largestIsMaxVolumeSize = isMaxVolumeSize
+		}
+	}
+	if largest == nil {
+		// Nothing known about the storage class, let the driver decide.
+		return true, nil, nil, false
+	}
+	return false, largestSegment, largest, largestIsMaxVolumeSize
+}
+
 // refreshSC identifies all work items matching the storage class and schedules
 // a refresh.
 func (c *Controller) refreshSC(storageClassName string) {
+	if c.cache != nil {
+		if sc, err := c.scInformer.Lister().Get(storageClassName); err == nil {
+			c.invalidateCache(sc, nil)
+		}
+	}
+
 	c.capacitiesLock.Lock()
 	defer c.capacitiesLock.Unlock()
 
@@ -450,6 +554,51 @@ func (c *Controller) refreshSC(storageClassName string) {
 	}
 }
 
+// invalidateCache ensures that the next GetCapacity call for the
+// storage class and segment asks the driver. A nil storage class
+// matches all storage classes, an empty segment all segments.
+func (c *Controller) invalidateCache(sc *storagev1.StorageClass, segment topology.Segment) {
+	if c.cache == nil {
+		return
+	}
+	hash := ""
+	if sc != nil {
+		hash = parametersHash(sc.Parameters)
+	}
+	c.cache.invalidate(hash, segment)
+}
+
+// segmentAllowed returns false if the allowed topologies of the storage
+// class rule out volumes in the segment, in which case there is no need
+// to ask the driver about capacity. Keys that the segment doesn't have
+// are not checked because a volume in the segment might still satisfy
+// them.
+func segmentAllowed(segment *topology.Segment, sc *storagev1.StorageClass) bool {
+	if len(sc.AllowedTopologies) == 0 {
+		return true
+	}
+	segmentLabels := segment.GetLabelMap()
+	for _, term := range sc.AllowedTopologies { // OR
+		if termAllows(term, segmentLabels) {
+			return true
+		}
+	}
+	return false
+}
+
+func termAllows(term v1.TopologySelectorTerm, segmentLabels map[string]string) bool {
+	for _, expression := range term.MatchLabelExpressions { // AND
+		value, ok := segmentLabels[expression.Key]
+		if !ok {
+			continue
+		}
+		if !sets.NewString(expression.Values...).Has(value) {
+			return false
+		}
+	}
+	return true
+}
+
 // addWorkItem ensures that there is an item in c.capacities. It
 // must be called while holding c.capacitiesLock!
 func (c *Controller) addWorkItem(segment *topology.Segment, sc *storagev1.StorageClass) {
@@ -514,11 +663,13 @@ func (c *Controller) runWorker(ctx context.Context) {
 }
 
 // processNextWorkItem processes items from queue.
-func (c *Controller) processNextWorkItem(ctx context.Context) bool {
+func (c *Controller) processNextWorkItem(ctx context.Context) (more bool) {
 	obj, shutdown := c.queue.Get()
 	if shutdown {
 		return false
 	}
+	more = true
+	defer panicguard.Recover(c.queue, "csistoragecapacity", obj)
 
 	err := func() error {
 		defer c.queue.Done(obj)
@@ -558,6 +709,7 @@ func (c *Controller) syncCapacity(ctx context.Context, item workItem) error {
 	c.capacitiesLock.Lock()
 	capacity, found := c.capacities[item]
 	c.capacitiesLock.Unlock()
+	owner := c.getOwner()
 
 	klog.V(5).Infof("Capacity Controller: refreshing %+v", item)
 	if !found {
@@ -578,18 +730,8 @@ func (c *Controller) syncCapacity(ctx context.Context, item workItem) error {
 	}
 
 	req := &csi.GetCapacityRequest{
-		Parameters: sc.Parameters,
-		// The assumption is that the capacity is independent of the
-		// capabilities. The standard makes it mandatory to pass something,
-		// therefore we pick something rather arbitrarily.
-		VolumeCapabilities: []*csi.VolumeCapability{
-			{
-				AccessType: &csi.VolumeCapability_Mount{},
-				AccessMode: &csi.VolumeCapability_AccessMode{
-					Mode: csi.VolumeCapability_AccessMode_UNKNOWN,
-				},
-			},
-		},
+		Parameters:         sc.Parameters,
+		VolumeCapabilities: []*csi.VolumeCapability{volumeCapability(sc, c.defaultFSType)},
 	}
 	if item.segment != nil {
 		req.AccessibleTopology = &csi.Topology{
@@ -602,6 +744,7 @@ func (c *Controller) syncCapacity(ctx context.Context, item workItem) error {
 	}
 
 	quantity := resource.NewQuantity(resp.AvailableCapacity, resource.BinarySI)
+	paramsHash := parametersHash(sc.Parameters)
 	var maximumVolumeSize *resource.Quantity
 	if resp.MaximumVolumeSize != nil {
 		maximumVolumeSize = resource.NewQuantity(resp.MaximumVolumeSize.Value, resource.BinarySI)
@@ -613,8 +756,9 @@ func (c *Controller) syncCapacity(ctx context.Context, item workItem) error {
 			ObjectMeta: metav1.ObjectMeta{
 				GenerateName: "csisc-",
 				Labels: map[string]string{
-					DriverNameLabel: c.driverName,
-					ManagedByLabel:  c.managedByID,
+					DriverNameLabel:     c.driverName,
+					ManagedByLabel:      c.managedByID,
+					ParametersHashLabel: paramsHash,
 				},
 			},
 			StorageClassName:  item.storageClassName,
@@ -622,12 +766,21 @@ func (c *Controller) syncCapacity(ctx context.Context, item workItem) error {
 			Capacity:          quantity,
 			MaximumVolumeSize: maximumVolumeSize,
 		}
-		if c.owner != nil {
-			capacity.OwnerReferences = []metav1.OwnerReference{*c.owner}
+		if owner != nil {
+			capacity.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		if c.dryRun {
+			klog.Infof("Capacity Controller: dry run, not creating object in namespace %s for storage class %s and segment %s with capacity %v, labels %v and owners %v",
+				c.ownerNamespace, item.storageClassName, item.segment.SimpleString(), quantity, capacity.Labels, ownerNames(capacity))
+			c.markRefreshed()
+			return nil
+		}
+		if err := c.waitForWrite(ctx); err != nil {
+			return fmt.Errorf("create CSIStorageCapacity for %+v: %v", item, err)
 		}
 		var err error
 		klog.V(5).Infof("Capacity Controller: creating new object for %+v, new capacity %v", item, quantity)
-		capacity, err = c.client.StorageV1beta1().CSIStorageCapacities(c.ownerNamespace).Create(ctx, capacity, metav1.CreateOptions{})
+		capacity, err = c.capacityClient.CSIStorageCapacities(c.ownerNamespace).Create(ctx, capacity, metav1.CreateOptions{})
 		if err != nil {
 			return fmt.Errorf("create CSIStorageCapacity for %+v: %v", item, err)
 		}
@@ -637,20 +790,33 @@ func (c *Controller) syncCapacity(ctx context.Context, item workItem) error {
 		// scenario that we end up creating two objects for the same work item, the second
 		// one will be recognized as duplicate and get deleted again once we receive it.
 	} else if capacity.Capacity.Value() == quantity.Value() &&
-		(c.owner == nil || c.isOwnedByUs(capacity)) {
-		klog.V(5).Infof("Capacity Controller: no need to update %s for %+v, same capacity %v and correct owner", capacity.Name, item, quantity)
+		sameQuantity(capacity.MaximumVolumeSize, maximumVolumeSize) &&
+		capacity.Labels[ParametersHashLabel] == paramsHash &&
+		(owner == nil || isOwnedBy(capacity, owner)) {
+		klog.V(5).Infof("Capacity Controller: no need to update %s for %+v, same capacity %v, maximum volume size, parameters and correct owner", capacity.Name, item, quantity)
+		c.markRefreshed()
 		return nil
 	} else {
 		// Update existing object. Must not modify object in the informer cache.
 		capacity := capacity.DeepCopy()
 		capacity.Capacity = quantity
 		capacity.MaximumVolumeSize = maximumVolumeSize
-		if c.owner != nil && !c.isOwnedByUs(capacity) {
-			capacity.OwnerReferences = append(capacity.OwnerReferences, *c.owner)
+		capacity.Labels[ParametersHashLabel] = paramsHash
+		if owner != nil && !isOwnedBy(capacity, owner) {
+			capacity.OwnerReferences = replaceController(capacity.OwnerReferences, *owner)
+		}
+		if c.dryRun {
+			klog.Infof("Capacity Controller: dry run, not updating %s for storage class %s and segment %s with capacity %v, labels %v and owners %v",
+				capacity.Name, item.storageClassName, item.segment.SimpleString(), quantity, capacity.Labels, ownerNames(capacity))
+			c.markRefreshed()
+			return nil
+		}
+		if err := c.waitForWrite(ctx); err != nil {
+			return fmt.Errorf("update CSIStorageCapacity for %+v: %v", item, err)
 		}
 		var err error
 		klog.V(5).Infof("Capacity Controller: updating %s for %+v, new capacity %v", capacity.Name, item, quantity)
-		capacity, err = c.client.StorageV1beta1().CSIStorageCapacities(capacity.Namespace).Update(ctx, capacity, metav1.UpdateOptions{})
+		capacity, err = c.capacityClient.CSIStorageCapacities(capacity.Namespace).Update(ctx, capacity, metav1.UpdateOptions{})
 		if err != nil {
 			return fmt.Errorf("update CSIStorageCapacity for %+v: %v", item, err)
 		}
@@ -659,13 +825,57 @@ func (c *Controller) syncCapacity(ctx context.Context, item workItem) error {
 		// object to avoid races.
 	}
 
+	c.markRefreshed()
 	return nil
 }
 
+// sameQuantity treats nil as different from all values because it
+// means that the value is unknown.
+func sameQuantity(a, b *resource.Quantity) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Value() == b.Value()
+}
+
+// ownerNames returns kind/name of all owners, for logging.
+func ownerNames(capacity *storagev1beta1.CSIStorageCapacity) []string {
+	var names []string
+	for _, owner := range capacity.OwnerReferences {
+		names = append(names, owner.Kind+"/"+owner.Name)
+	}
+	return names
+}
+
+// parametersHash returns a short, stable hash of storage class
+// parameters which can be used as label value.
+func parametersHash(parameters map[string]string) string {
+	keys := make([]string, 0, len(parameters))
+	for key := range parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(parameters[key]))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
 // deleteCapacity ensures that the object is gone when done.
 func (c *Controller) deleteCapacity(ctx context.Context, capacity *storagev1beta1.CSIStorageCapacity) error {
+	if c.dryRun {
+		klog.Infof("Capacity Controller: dry run, not removing CSIStorageCapacity %s", capacity.Name)
+		return nil
+	}
+	if err := c.waitForWrite(ctx); err != nil {
+		return err
+	}
 	klog.V(5).Infof("Capacity Controller: removing CSIStorageCapacity %s", capacity.Name)
-	err := c.client.StorageV1beta1().CSIStorageCapacities(capacity.Namespace).Delete(ctx, capacity.Name, metav1.DeleteOptions{})
+	err := c.capacityClient.CSIStorageCapacities(capacity.Namespace).Delete(ctx, capacity.Name, metav1.DeleteOptions{})
 	if err != nil && apierrs.IsNotFound(err) {
 		return nil
 	}
@@ -808,11 +1018,11 @@ func (c *Controller) isObsolete(capacity *storagev1beta1.CSIStorageCapacity) boo
 	return true
 }
 
-// isOwnedByUs implements the same logic as https://pkg.go.dev/k8s.io/apimachinery/pkg/apis/meta/v1?tab=doc#IsControlledBy,
+// isOwnedBy implements the same logic as https://pkg.go.dev/k8s.io/apimachinery/pkg/apis/meta/v1?tab=doc#IsControlledBy,
 // just with the expected owner identified directly with the UID.
-func (c *Controller) isOwnedByUs(capacity *storagev1beta1.CSIStorageCapacity) bool {
+func isOwnedBy(capacity *storagev1beta1.CSIStorageCapacity, controller *metav1.OwnerReference) bool {
 	for _, owner := range capacity.OwnerReferences {
-		if owner.Controller != nil && *owner.Controller && owner.UID == c.owner.UID {
+		if owner.Controller != nil && *owner.Controller && owner.UID == controller.UID {
 			return true
 		}
 	}
@@ -361,6 +361,121 @@ func TestCapacityController(t *testing.T) {
 			},
 			expectedTotalProcessed: 1,
 		},
+		"reuse one capacity object, update maximum volume size": {
+			topology: topology.NewMock(&layer0),
+			storage: mockCapacity{
+				capacity: map[string]interface{}{
+					// This matches layer0.
+					"foo": "1Gi,2Mi",
+				},
+			},
+			initialSCs: []testSC{
+				{
+					name:       "other-sc",
+					driverName: driverName,
+				},
+			},
+			initialCapacities: []testCapacity{
+				{
+					uid:              "test-capacity-1",
+					segment:          layer0,
+					storageClassName: "other-sc",
+					quantity:         "1Gi",
+					maxVolume:        "1Mi",
+				},
+			},
+			expectedCapacities: []testCapacity{
+				{
+					uid:              "test-capacity-1",
+					resourceVersion:  csiscRev + "1",
+					segment:          layer0,
+					storageClassName: "other-sc",
+					quantity:         "1Gi",
+					maxVolume:        "2Mi",
+				},
+			},
+			expectedObjectsPrepared: objects{
+				goal:    1,
+				current: 1,
+			},
+			expectedTotalProcessed: 1,
+		},
+		"reuse one capacity object, remove maximum volume size": {
+			topology: topology.NewMock(&layer0),
+			storage: mockCapacity{
+				capacity: map[string]interface{}{
+					// This matches layer0.
+					"foo": "1Gi",
+				},
+			},
+			initialSCs: []testSC{
+				{
+					name:       "other-sc",
+					driverName: driverName,
+				},
+			},
+			initialCapacities: []testCapacity{
+				{
+					uid:              "test-capacity-1",
+					segment:          layer0,
+					storageClassName: "other-sc",
+					quantity:         "1Gi",
+					maxVolume:        "1Mi",
+				},
+			},
+			expectedCapacities: []testCapacity{
+				{
+					uid:              "test-capacity-1",
+					resourceVersion:  csiscRev + "1",
+					segment:          layer0,
+					storageClassName: "other-sc",
+					quantity:         "1Gi",
+				},
+			},
+			expectedObjectsPrepared: objects{
+				goal:    1,
+				current: 1,
+			},
+			expectedTotalProcessed: 1,
+		},
+		"reuse one capacity object, update parameters hash": {
+			topology: topology.NewMock(&layer0),
+			storage: mockCapacity{
+				capacity: map[string]interface{}{
+					// This matches layer0.
+					"foo": "1Gi",
+				},
+			},
+			initialSCs: []testSC{
+				{
+					name:       "other-sc",
+					driverName: driverName,
+				},
+			},
+			initialCapacities: []testCapacity{
+				{
+					uid:              "test-capacity-1",
+					segment:          layer0,
+					storageClassName: "other-sc",
+					quantity:         "1Gi",
+					parametersHash:   "outdated",
+				},
+			},
+			expectedCapacities: []testCapacity{
+				{
+					uid:              "test-capacity-1",
+					resourceVersion:  csiscRev + "1",
+					segment:          layer0,
+					storageClassName: "other-sc",
+					quantity:         "1Gi",
+				},
+			},
+			expectedObjectsPrepared: objects{
+				goal:    1,
+				current: 1,
+			},
+			expectedTotalProcessed: 1,
+		},
 		"reuse one capacity object, add owner": {
 			topology: topology.NewMock(&layer0),
 			storage: mockCapacity{
@@ -577,6 +692,60 @@ func TestCapacityController(t *testing.T) {
 			},
 			expectedTotalProcessed: 4,
 		},
+		"two segments, two classes, one restricted": {
+			topology: topology.NewMock(&layer0, &layer0other),
+			storage: mockCapacity{
+				capacity: map[string]interface{}{
+					// This matches layer0.
+					"foo": "1Gi",
+					"bar": "2Gi",
+				},
+			},
+			initialSCs: []testSC{
+				{
+					name:       "direct-sc",
+					driverName: driverName,
+				},
+				{
+					name:       "restricted-sc",
+					driverName: driverName,
+					allowedTopologies: []v1.TopologySelectorTerm{
+						{
+							MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{
+								{
+									Key:    "layer0",
+									Values: []string{"bar"},
+								},
+							},
+						},
+					},
+				},
+			},
+			expectedCapacities: []testCapacity{
+				{
+					resourceVersion:  csiscRev + "0",
+					segment:          layer0,
+					storageClassName: "direct-sc",
+					quantity:         "1Gi",
+				},
+				{
+					resourceVersion:  csiscRev + "0",
+					segment:          layer0other,
+					storageClassName: "direct-sc",
+					quantity:         "2Gi",
+				},
+				{
+					resourceVersion:  csiscRev + "0",
+					segment:          layer0other,
+					storageClassName: "restricted-sc",
+					quantity:         "2Gi",
+				},
+			},
+			expectedObjectsPrepared: objects{
+				goal: 3,
+			},
+			expectedTotalProcessed: 3,
+		},
 		"two segments, two classes, four objects updated": {
 			topology: topology.NewMock(&layer0, &layer0other),
 			storage: mockCapacity{
@@ -1360,6 +1529,11 @@ func fakeController(ctx context.Context, client *fakeclientset.Clientset, owner
 		cInformer,
 		1000*time.Hour, // Not used, but even if it was, we wouldn't want automatic capacity polling while the test runs...
 		immediateBinding,
+		false, /* dry run */
+		nil,   /* write limiter */
+		"",    /* default fstype */
+		nil,   /* capacity client */
+		0,     /* GetCapacity cache TTL */
 	)
 
 	// This ensures that the informers are running and up-to-date.
@@ -1583,6 +1757,17 @@ type testCapacity struct {
 	maxVolume        string
 	owner            *metav1.OwnerReference
 	managedByID      string
+	// parametersHash overrides the hash label, which is otherwise
+	// derived from testSCParameters.
+	parametersHash string
+}
+
+// testSCParameters contains the parameters of those storage classes in
+// the tests which have some.
+var testSCParameters = map[string]map[string]string{
+	"triple-sc": {
+		mockMultiplier: "3",
+	},
 }
 
 func (tc testCapacity) getCapacity() *resource.Quantity {
@@ -1618,9 +1803,14 @@ func makeCapacity(in testCapacity) *storagev1beta1.CSIStorageCapacity {
 	switch in.managedByID {
 	case noManager:
 	case "":
+		paramsHash := in.parametersHash
+		if paramsHash == "" {
+			paramsHash = parametersHash(testSCParameters[in.storageClassName])
+		}
 		labels = map[string]string{
-			DriverNameLabel: driverName,
-			ManagedByLabel:  managedByID,
+			DriverNameLabel:     driverName,
+			ManagedByLabel:      managedByID,
+			ParametersHashLabel: paramsHash,
 		}
 	default:
 		labels = map[string]string{
@@ -1643,10 +1833,11 @@ func makeCapacity(in testCapacity) *storagev1beta1.CSIStorageCapacity {
 }
 
 type testSC struct {
-	name             string
-	driverName       string
-	parameters       map[string]string
-	immediateBinding bool
+	name              string
+	driverName        string
+	parameters        map[string]string
+	immediateBinding  bool
+	allowedTopologies []v1.TopologySelectorTerm
 }
 
 func makeSC(in testSC) *storagev1.StorageClass {
@@ -1661,6 +1852,7 @@ func makeSC(in testSC) *storagev1.StorageClass {
 		Provisioner:       in.driverName,
 		Parameters:        in.parameters,
 		VolumeBindingMode: &volumeBinding,
+		AllowedTopologies: in.allowedTopologies,
 	}
 }
 
@@ -1994,3 +2186,86 @@ func itemsAsSortedStringSlice(queue *rateLimitingQueue) []string {
 	sort.Strings(content)
 	return content
 }
+
+func TestParametersHash(t *testing.T) {
+	empty := parametersHash(nil)
+	if len(empty) != 16 {
+		t.Errorf("expected hash with 16 characters, got %q", empty)
+	}
+	if hash := parametersHash(map[string]string{}); hash != empty {
+		t.Errorf("expected same hash for nil and empty parameters, got %q and %q", empty, hash)
+	}
+	a := parametersHash(map[string]string{"a": "1", "b": "2"})
+	if a == empty {
+		t.Error("expected different hash for parameters")
+	}
+	for _, other := range []map[string]string{
+		{"a": "12"},
+		{"a": "1b", "": "2"},
+		{"a": "1", "b": "3"},
+	} {
+		if hash := parametersHash(other); hash == a {
+			t.Errorf("expected different hash for %v", other)
+		}
+	}
+}
+
+func TestSegmentAllowed(t *testing.T) {
+	segment := &topology.Segment{{Key: "zone", Value: "a"}, {Key: "rack", Value: "1"}}
+	allowed := func(terms ...[]v1.TopologySelectorLabelRequirement) []v1.TopologySelectorTerm {
+		var result []v1.TopologySelectorTerm
+		for _, term := range terms {
+			result = append(result, v1.TopologySelectorTerm{MatchLabelExpressions: term})
+		}
+		return result
+	}
+	testcases := map[string]struct {
+		allowedTopologies []v1.TopologySelectorTerm
+		expectAllowed     bool
+	}{
+		"no-restrictions": {
+			expectAllowed: true,
+		},
+		"match": {
+			allowedTopologies: allowed(
+				[]v1.TopologySelectorLabelRequirement{{Key: "zone", Values: []string{"b", "a"}}},
+			),
+			expectAllowed: true,
+		},
+		"mismatch": {
+			allowedTopologies: allowed(
+				[]v1.TopologySelectorLabelRequirement{{Key: "zone", Values: []string{"b"}}},
+			),
+		},
+		"partial-mismatch": {
+			allowedTopologies: allowed(
+				[]v1.TopologySelectorLabelRequirement{
+					{Key: "zone", Values: []string{"a"}},
+					{Key: "rack", Values: []string{"2"}},
+				},
+			),
+		},
+		"second-term": {
+			allowedTopologies: allowed(
+				[]v1.TopologySelectorLabelRequirement{{Key: "zone", Values: []string{"b"}}},
+				[]v1.TopologySelectorLabelRequirement{{Key: "rack", Values: []string{"1"}}},
+			),
+			expectAllowed: true,
+		},
+		"unknown-key": {
+			allowedTopologies: allowed(
+				[]v1.TopologySelectorLabelRequirement{{Key: "region", Values: []string{"x"}}},
+			),
+			expectAllowed: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			sc := &storagev1.StorageClass{AllowedTopologies: tc.allowedTopologies}
+			if actual := segmentAllowed(segment, sc); actual != tc.expectAllowed {
+				t.Errorf("expected %v, got %v", tc.expectAllowed, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// DeleteAll removes all CSIStorageCapacity objects managed by the
+// controller. It must only be called after Run has returned, otherwise
+// the objects get re-created.
+//
+// The objects are listed with the API server instead of the informer
+// because the informer might not have been synced or might already be
+// stopped.
+func (c *Controller) DeleteAll(ctx context.Context) error {
+	capacities, err := c.capacityClient.CSIStorageCapacities(c.ownerNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{
+			DriverNameLabel: c.driverName,
+			ManagedByLabel:  c.managedByID,
+		}.AsSelector().String(),
+	})
+	if err != nil {
+		return fmt.Errorf("list CSIStorageCapacity objects: %v", err)
+	}
+	klog.Infof("Capacity Controller: removing %d CSIStorageCapacity objects", len(capacities.Items))
+	for i := range capacities.Items {
+		capacity := &capacities.Items[i]
+		if !c.isManaged(capacity) {
+			continue
+		}
+		if err := c.deleteCapacity(ctx, capacity); err != nil {
+			return fmt.Errorf("delete CSIStorageCapacity %s: %v", capacity.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"testing"
+
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeleteAll(t *testing.T) {
+	capacity := func(name, driver, manager string) *storagev1beta1.CSIStorageCapacity {
+		return &storagev1beta1.CSIStorageCapacity{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ownerNamespace,
+				Labels: map[string]string{
+					DriverNameLabel: driver,
+					ManagedByLabel:  manager,
+				},
+			},
+		}
+	}
+	client := fakeclientset.NewSimpleClientset(
+		capacity("ours-1", driverName, managedByID),
+		capacity("ours-2", driverName, managedByID),
+		capacity("other-manager", driverName, otherManager),
+		capacity("other-driver", "other-driver", managedByID),
+	)
+	c := &Controller{
+		client:         client,
+		capacityClient: NewV1beta1Client(client),
+		driverName:     driverName,
+		managedByID:    managedByID,
+		ownerNamespace: ownerNamespace,
+	}
+
+	ctx := context.Background()
+	if err := c.DeleteAll(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	capacities, err := client.StorageV1beta1().CSIStorageCapacities(ownerNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var remaining []string
+	for _, capacity := range capacities.Items {
+		remaining = append(remaining, capacity.Name)
+	}
+	if len(remaining) != 2 || remaining[0] != "other-driver" || remaining[1] != "other-manager" {
+		t.Errorf("expected other-driver and other-manager to remain, got %v", remaining)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDryRun(t *testing.T) {
+	existing := &storagev1beta1.CSIStorageCapacity{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "existing",
+			Namespace: ownerNamespace,
+			Labels: map[string]string{
+				DriverNameLabel: driverName,
+				ManagedByLabel:  managedByID,
+			},
+		},
+		StorageClassName: "other-sc",
+		NodeTopology:     layer0.GetLabelSelector(),
+		Capacity:         resource.NewQuantity(1, resource.BinarySI),
+	}
+	sc := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "sc"},
+		Provisioner: driverName,
+	}
+	otherSC := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "other-sc"},
+		Provisioner: driverName,
+	}
+	client := fakeclientset.NewSimpleClientset(existing)
+	scInformer := informers.NewSharedInformerFactory(client, 0).Storage().V1().StorageClasses()
+	for _, sc := range []*storagev1.StorageClass{sc, otherSC} {
+		if err := scInformer.Informer().GetStore().Add(sc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newItem := workItem{segment: &layer0, storageClassName: sc.Name}
+	existingItem := workItem{segment: &layer0, storageClassName: otherSC.Name}
+	c := &Controller{
+		csiController: &mockCapacity{
+			capacity: map[string]interface{}{
+				"foo": "10Gi",
+			},
+		},
+		client:         client,
+		capacityClient: NewV1beta1Client(client),
+		driverName:     driverName,
+		managedByID:    managedByID,
+		ownerNamespace: ownerNamespace,
+		owner:          &defaultOwner,
+		scInformer:     scInformer,
+		dryRun:         true,
+		capacities: map[workItem]*storagev1beta1.CSIStorageCapacity{
+			newItem:      nil,
+			existingItem: existing,
+		},
+	}
+
+	ctx := context.Background()
+	for _, item := range []workItem{newItem, existingItem} {
+		if err := c.syncCapacity(ctx, item); err != nil {
+			t.Fatalf("sync %+v: unexpected error: %v", item, err)
+		}
+	}
+	if c.lastRefresh.IsZero() {
+		t.Error("expected dry run to count as refresh")
+	}
+	if err := c.DeleteAll(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	capacities, err := client.StorageV1beta1().CSIStorageCapacities(ownerNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(capacities.Items) != 1 {
+		t.Fatalf("expected only the existing object, got %+v", capacities.Items)
+	}
+	if capacity := capacities.Items[0]; capacity.Capacity.Value() != 1 || len(capacity.OwnerReferences) != 0 {
+		t.Errorf("existing object was modified: %+v", capacity)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// RefreshOwner calls lookup at the given interval until the context is
+// done. When the owner changed, for example because the owning
+// StatefulSet was deleted and re-created with a different UID while
+// the pod kept running, all managed objects get updated to reference
+// the new owner. Otherwise the garbage collector would delete them
+// because their owner no longer exists.
+func (c *Controller) RefreshOwner(ctx context.Context, interval time.Duration, lookup func() (*metav1.OwnerReference, error)) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		owner, err := lookup()
+		if err != nil {
+			klog.Errorf("Capacity Controller: refreshing owner failed, keeping the current one: %v", err)
+			return
+		}
+		c.setOwner(owner)
+	}, interval)
+}
+
+func (c *Controller) getOwner() *metav1.OwnerReference {
+	c.ownerLock.Lock()
+	defer c.ownerLock.Unlock()
+	return c.owner
+}
+
+// setOwner replaces the owner and triggers an update of all objects
+// if the UID is different.
+func (c *Controller) setOwner(owner *metav1.OwnerReference) {
+	c.ownerLock.Lock()
+	old := c.owner
+	if old == nil || owner == nil || old.UID == owner.UID {
+		c.ownerLock.Unlock()
+		return
+	}
+	c.owner = owner
+	c.ownerLock.Unlock()
+
+	klog.Infof("Capacity Controller: owner %s/%s %s changed UID from %s to %s, updating all CSIStorageCapacity objects",
+		owner.APIVersion, owner.Kind, owner.Name, old.UID, owner.UID)
+	c.pollCapacities()
+}
+
+// replaceController returns the owner references with the new
+// controller instead of the old one, if there was one. An object may
+// only have one controller.
+func replaceController(owners []metav1.OwnerReference, controller metav1.OwnerReference) []metav1.OwnerReference {
+	var result []metav1.OwnerReference
+	for _, owner := range owners {
+		if owner.Controller != nil && *owner.Controller {
+			continue
+		}
+		result = append(result, owner)
+	}
+	return append(result, controller)
+}
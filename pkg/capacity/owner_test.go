@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOwnerRefresh(t *testing.T) {
+	recreatedOwner := defaultOwner
+	recreatedOwner.UID = "22222222-2d62-4f40-bbcf-b7765aac5a6d"
+	other := metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Name:       "unrelated",
+		UID:        "33333333-2d62-4f40-bbcf-b7765aac5a6d",
+	}
+	existing := &storagev1beta1.CSIStorageCapacity{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "existing",
+			Namespace: ownerNamespace,
+			Labels: map[string]string{
+				DriverNameLabel:     driverName,
+				ManagedByLabel:      managedByID,
+				ParametersHashLabel: parametersHash(nil),
+			},
+			OwnerReferences: []metav1.OwnerReference{defaultOwner, other},
+		},
+		StorageClassName: "sc",
+		NodeTopology:     layer0.GetLabelSelector(),
+		Capacity:         resource.NewQuantity(10*1024*1024*1024, resource.BinarySI),
+	}
+	client := fakeclientset.NewSimpleClientset(existing)
+	scInformer := informers.NewSharedInformerFactory(client, 0).Storage().V1().StorageClasses()
+	if err := scInformer.Informer().GetStore().Add(&storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "sc"},
+		Provisioner: driverName,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	item := workItem{segment: &layer0, storageClassName: "sc"}
+	queue := &rateLimitingQueue{}
+	owner := defaultOwner
+	c := &Controller{
+		csiController: &mockCapacity{
+			capacity: map[string]interface{}{
+				"foo": "10Gi",
+			},
+		},
+		client:         client,
+		capacityClient: NewV1beta1Client(client),
+		driverName:     driverName,
+		managedByID:    managedByID,
+		ownerNamespace: ownerNamespace,
+		owner:          &owner,
+		scInformer:     scInformer,
+		queue:          queue,
+		capacities: map[workItem]*storagev1beta1.CSIStorageCapacity{
+			item: existing,
+		},
+	}
+
+	// Same owner, nothing to do.
+	sameOwner := defaultOwner
+	c.setOwner(&sameOwner)
+	if queue.Len() != 0 {
+		t.Fatalf("expected no work items, got %v", queue.items)
+	}
+
+	c.setOwner(&recreatedOwner)
+	if queue.Len() != 1 {
+		t.Fatalf("expected one work item, got %v", queue.items)
+	}
+	ctx := context.Background()
+	if err := c.syncCapacity(ctx, item); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, err := client.StorageV1beta1().CSIStorageCapacities(ownerNamespace).Get(ctx, existing.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []metav1.OwnerReference{other, recreatedOwner}
+	if !reflect.DeepEqual(updated.OwnerReferences, expected) {
+		t.Errorf("expected owner references %+v, got %+v", expected, updated.OwnerReferences)
+	}
+}
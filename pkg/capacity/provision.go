@@ -18,14 +18,27 @@ package capacity
 
 import (
 	"context"
+	"fmt"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
 )
 
+const (
+	// EventReasonInsufficientCapacity is used for the event that gets
+	// emitted for a PVC when no topology segment has enough capacity
+	// for it according to the CSIStorageCapacity objects.
+	EventReasonInsufficientCapacity = "ProvisioningInsufficientCapacity"
+)
+
 type provisionWrapper struct {
 	controller.Provisioner
-	c *Controller
+	c             *Controller
+	eventRecorder record.EventRecorder
 }
 
 var _ controller.Provisioner = &provisionWrapper{}
@@ -33,13 +46,44 @@ var _ controller.BlockProvisioner = &provisionWrapper{}
 var _ controller.Qualifier = &provisionWrapper{}
 
 func NewProvisionWrapper(p controller.Provisioner, c *Controller) controller.Provisioner {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.Infof)
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: c.client.CoreV1().Events(v1.NamespaceAll)})
+	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "external-provisioner"})
+
 	return &provisionWrapper{
-		Provisioner: p,
-		c:           c,
+		Provisioner:   p,
+		c:             c,
+		eventRecorder: eventRecorder,
 	}
 }
 
 func (p *provisionWrapper) Provision(ctx context.Context, options controller.ProvisionOptions) (pv *v1.PersistentVolume, state controller.ProvisioningState, err error) {
+	if options.StorageClass != nil && options.PVC != nil {
+		requested := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		sufficient, segment, largest, isMaxVolumeSize := p.c.largestCapacity(options.StorageClass.Name, options.SelectedNode, requested.Value())
+		if !sufficient {
+			// Calling CreateVolume is pointless when we already
+			// know that it will fail. Tell the user why and
+			// refresh the storage class in case that our
+			// information is stale.
+			limit := "largest available capacity"
+			if isMaxVolumeSize {
+				limit = "maximum volume size"
+			}
+			msg := fmt.Sprintf("requested %s, but the %s for storage class %q is %s in topology segment %s",
+				requested.String(), limit, options.StorageClass.Name, largest.String(), segment.SimpleString())
+			p.eventRecorder.Event(options.PVC, v1.EventTypeWarning, EventReasonInsufficientCapacity, msg)
+			p.c.refreshSC(options.StorageClass.Name)
+			state = controller.ProvisioningFinished
+			if options.SelectedNode != nil {
+				// Give the scheduler a chance to pick a different node.
+				state = controller.ProvisioningReschedule
+			}
+			return nil, state, fmt.Errorf("insufficient capacity: %s", msg)
+		}
+	}
+
 	pv, state, err = p.Provisioner.Provision(ctx, options)
 	if err == nil && pv != nil {
 		if pv.Spec.NodeAffinity != nil {
@@ -0,0 +1,218 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/capacity/topology"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+type fakeProvisioner struct {
+	called bool
+}
+
+func (f *fakeProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	f.called = true
+	return &v1.PersistentVolume{}, controller.ProvisioningFinished, nil
+}
+
+func (f *fakeProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	return nil
+}
+
+func TestInsufficientCapacity(t *testing.T) {
+	testcases := map[string]struct {
+		// capacities maps the simple string of a segment to
+		// the capacity for that segment. Segments without
+		// entry have no known capacity.
+		capacities   map[string]testCapacity
+		size         string
+		selectedNode *v1.Node
+
+		expectCalled bool
+		expectState  controller.ProvisioningState
+		expectEvent  string
+	}{
+		"no capacity known": {
+			size:         "1Gi",
+			expectCalled: true,
+		},
+		"enough capacity": {
+			capacities: map[string]testCapacity{
+				layer0.SimpleString():      {quantity: "1Gi"},
+				layer0other.SimpleString(): {quantity: "2Gi"},
+			},
+			size:         "2Gi",
+			expectCalled: true,
+		},
+		"insufficient capacity": {
+			capacities: map[string]testCapacity{
+				layer0.SimpleString():      {quantity: "1Gi"},
+				layer0other.SimpleString(): {quantity: "2Gi"},
+			},
+			size:        "3Gi",
+			expectState: controller.ProvisioningFinished,
+			expectEvent: "Warning ProvisioningInsufficientCapacity requested 3Gi, but the largest available capacity for storage class \"direct-sc\" is 2Gi in topology segment layer0: bar",
+		},
+		"maximum volume size too small": {
+			capacities: map[string]testCapacity{
+				layer0.SimpleString(): {quantity: "10Gi", maxVolume: "1Gi"},
+			},
+			selectedNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "node-foo",
+					Labels: layer0.GetLabelMap(),
+				},
+			},
+			size:        "2Gi",
+			expectState: controller.ProvisioningReschedule,
+			expectEvent: "Warning ProvisioningInsufficientCapacity requested 2Gi, but the maximum volume size for storage class \"direct-sc\" is 1Gi in topology segment layer0: foo",
+		},
+		"maximum volume size smaller than capacity elsewhere": {
+			capacities: map[string]testCapacity{
+				layer0.SimpleString():      {quantity: "10Gi", maxVolume: "1Gi"},
+				layer0other.SimpleString(): {quantity: "2Gi"},
+			},
+			size:        "3Gi",
+			expectState: controller.ProvisioningFinished,
+			expectEvent: "Warning ProvisioningInsufficientCapacity requested 3Gi, but the largest available capacity for storage class \"direct-sc\" is 2Gi in topology segment layer0: bar",
+		},
+		"maximum volume size larger than capacity": {
+			capacities: map[string]testCapacity{
+				layer0.SimpleString(): {quantity: "1Gi", maxVolume: "10Gi"},
+			},
+			selectedNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "node-foo",
+					Labels: layer0.GetLabelMap(),
+				},
+			},
+			size:        "2Gi",
+			expectState: controller.ProvisioningReschedule,
+			expectEvent: "Warning ProvisioningInsufficientCapacity requested 2Gi, but the largest available capacity for storage class \"direct-sc\" is 1Gi in topology segment layer0: foo",
+		},
+		"capacity unknown in other segment": {
+			capacities: map[string]testCapacity{
+				layer0.SimpleString(): {quantity: "1Gi"},
+			},
+			size:         "2Gi",
+			expectCalled: true,
+		},
+		"insufficient capacity for selected node": {
+			capacities: map[string]testCapacity{
+				layer0.SimpleString():      {quantity: "1Gi"},
+				layer0other.SimpleString(): {quantity: "2Gi"},
+			},
+			size: "2Gi",
+			selectedNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "node-foo",
+					Labels: layer0.GetLabelMap(),
+				},
+			},
+			expectState: controller.ProvisioningReschedule,
+			expectEvent: "Warning ProvisioningInsufficientCapacity requested 2Gi, but the largest available capacity for storage class \"direct-sc\" is 1Gi in topology segment layer0: foo",
+		},
+		"enough capacity for selected node": {
+			capacities: map[string]testCapacity{
+				layer0.SimpleString():      {quantity: "1Gi"},
+				layer0other.SimpleString(): {quantity: "2Gi"},
+			},
+			size: "2Gi",
+			selectedNode: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "node-bar",
+					Labels: layer0other.GetLabelMap(),
+				},
+			},
+			expectCalled: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			sc := testSC{
+				name:       "direct-sc",
+				driverName: driverName,
+			}
+			clientSet := fakeclientset.NewSimpleClientset([]runtime.Object{makeSC(sc)}...)
+			c, _ := fakeController(ctx, clientSet, &defaultOwner, &mockCapacity{}, topology.NewMock(&layer0, &layer0other), false /* immediate binding */)
+			c.prepare(ctx)
+			c.capacitiesLock.Lock()
+			for item := range c.capacities {
+				if in, ok := tc.capacities[item.segment.SimpleString()]; ok {
+					in.segment = *item.segment
+					in.storageClassName = item.storageClassName
+					c.capacities[item] = makeCapacity(in)
+				}
+			}
+			c.capacitiesLock.Unlock()
+
+			inner := &fakeProvisioner{}
+			recorder := record.NewFakeRecorder(10)
+			p := NewProvisionWrapper(inner, c).(*provisionWrapper)
+			p.eventRecorder = recorder
+
+			options := controller.ProvisionOptions{
+				StorageClass: makeSC(sc),
+				PVC: &v1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "pvc",
+						Namespace: "default",
+					},
+					Spec: v1.PersistentVolumeClaimSpec{
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{
+								v1.ResourceStorage: resource.MustParse(tc.size),
+							},
+						},
+					},
+				},
+				SelectedNode: tc.selectedNode,
+			}
+			_, state, err := p.Provision(ctx, options)
+			require.Equal(t, tc.expectCalled, inner.called, "CreateVolume called")
+			if tc.expectCalled {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Equal(t, tc.expectState, state)
+			var events []string
+			close(recorder.Events)
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			require.Equal(t, tc.expectEvent, strings.Join(events, "\n"))
+		})
+	}
+}
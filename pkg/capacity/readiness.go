@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+func (c *Controller) markRefreshed() {
+	c.capacitiesLock.Lock()
+	defer c.capacitiesLock.Unlock()
+	c.lastRefresh = time.Now()
+}
+
+// CheckFreshness returns an error if the controller is running, has
+// CSIStorageCapacity objects to maintain and none of them was refreshed
+// successfully within maxAge. Before the first refresh, the time since
+// starting the controller is checked instead. A controller which does
+// not run, for example because it is not the leader, is considered
+// fresh.
+func (c *Controller) CheckFreshness(maxAge time.Duration) error {
+	return c.checkFreshness(time.Now(), maxAge)
+}
+
+func (c *Controller) checkFreshness(now time.Time, maxAge time.Duration) error {
+	c.capacitiesLock.Lock()
+	defer c.capacitiesLock.Unlock()
+
+	if c.started.IsZero() || len(c.capacities) == 0 {
+		return nil
+	}
+	last := c.lastRefresh
+	what := "last successful capacity refresh"
+	if last.IsZero() {
+		last = c.started
+		what = "no successful capacity refresh since start"
+	}
+	if age := now.Sub(last); age > maxAge {
+		return fmt.Errorf("%s %s ago, more than %s", what, age.Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+// NewReadyzHandler returns an HTTP handler which responds with 200 if
+// capacity data is fresh according to Controller.CheckFreshness and
+// with 503 otherwise.
+func NewReadyzHandler(c *Controller, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := c.CheckFreshness(maxAge); err != nil {
+			klog.Warningf("Capacity Controller: not ready: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+}
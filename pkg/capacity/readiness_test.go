@@ -0,0 +1,103 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+)
+
+func TestCheckFreshness(t *testing.T) {
+	now := time.Now()
+	maxAge := 3 * time.Minute
+	item := workItem{segment: &layer0, storageClassName: "sc"}
+
+	testcases := map[string]struct {
+		started     time.Time
+		lastRefresh time.Time
+		noItems     bool
+		expectErr   bool
+	}{
+		"not running": {},
+		"no objects": {
+			started: now.Add(-time.Hour),
+			noItems: true,
+		},
+		"starting": {
+			started: now.Add(-time.Minute),
+		},
+		"never refreshed": {
+			started:   now.Add(-time.Hour),
+			expectErr: true,
+		},
+		"fresh": {
+			started:     now.Add(-time.Hour),
+			lastRefresh: now.Add(-time.Minute),
+		},
+		"stale": {
+			started:     now.Add(-time.Hour),
+			lastRefresh: now.Add(-5 * time.Minute),
+			expectErr:   true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			c := &Controller{
+				capacities:  map[workItem]*storagev1beta1.CSIStorageCapacity{},
+				started:     tc.started,
+				lastRefresh: tc.lastRefresh,
+			}
+			if !tc.noItems {
+				c.capacities[item] = nil
+			}
+			err := c.checkFreshness(now, maxAge)
+			if tc.expectErr && err == nil {
+				t.Error("expected error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	c := &Controller{
+		capacities: map[workItem]*storagev1beta1.CSIStorageCapacity{
+			{segment: &layer0, storageClassName: "sc"}: nil,
+		},
+		started: time.Now().Add(-time.Hour),
+	}
+	handler := NewReadyzHandler(c, time.Minute)
+
+	check := func(expectCode int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != expectCode {
+			t.Errorf("expected status %d, got %d: %s", expectCode, w.Code, w.Body.String())
+		}
+	}
+	check(http.StatusServiceUnavailable)
+	c.markRefreshed()
+	check(http.StatusOK)
+}
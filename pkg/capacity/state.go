@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"sort"
+)
+
+// WorkItemState describes one CSIStorageCapacity object which is
+// supposed to exist.
+type WorkItemState struct {
+	StorageClassName string `json:"storageClassName"`
+	Segment          string `json:"segment"`
+	// Object is the name of the CSIStorageCapacity object, empty
+	// if it has not been created yet.
+	Object   string `json:"object,omitempty"`
+	Capacity string `json:"capacity,omitempty"`
+	// Retries is the number of failed attempts to update the object.
+	Retries int `json:"retries"`
+}
+
+// WorkItems returns the current work items, sorted by storage class and
+// segment. It is meant for debugging.
+func (c *Controller) WorkItems() []WorkItemState {
+	c.capacitiesLock.Lock()
+	defer c.capacitiesLock.Unlock()
+
+	items := make([]WorkItemState, 0, len(c.capacities))
+	for item, capacity := range c.capacities {
+		state := WorkItemState{
+			StorageClassName: item.storageClassName,
+			Segment:          item.segment.SimpleString(),
+			Retries:          c.queue.NumRequeues(item),
+		}
+		if capacity != nil {
+			state.Object = capacity.Name
+			if capacity.Capacity != nil {
+				state.Capacity = capacity.Capacity.String()
+			}
+		}
+		items = append(items, state)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].StorageClassName != items[j].StorageClassName {
+			return items[i].StorageClassName < items[j].StorageClassName
+		}
+		return items[i].Segment < items[j].Segment
+	})
+	return items
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"reflect"
+	"testing"
+
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestWorkItems(t *testing.T) {
+	quantity := resource.MustParse("1Gi")
+	created := workItem{segment: &layer0, storageClassName: "sc-b"}
+	pending := workItem{segment: &layer0, storageClassName: "sc-a"}
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	queue.AddRateLimited(pending)
+
+	c := &Controller{
+		queue: queue,
+		capacities: map[workItem]*storagev1beta1.CSIStorageCapacity{
+			created: {
+				ObjectMeta: metav1.ObjectMeta{Name: "csisc-1"},
+				Capacity:   &quantity,
+			},
+			pending: nil,
+		},
+	}
+	expected := []WorkItemState{
+		{
+			StorageClassName: "sc-a",
+			Segment:          layer0.SimpleString(),
+			Retries:          1,
+		},
+		{
+			StorageClassName: "sc-b",
+			Segment:          layer0.SimpleString(),
+			Object:           "csisc-1",
+			Capacity:         "1Gi",
+		},
+	}
+	if items := c.WorkItems(); !reflect.DeepEqual(items, expected) {
+		t.Errorf("expected %+v, got %+v", expected, items)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sort"
 	"sync"
 
+	"github.com/kubernetes-csi/external-provisioner/pkg/panicguard"
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
@@ -32,9 +33,23 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 )
 
+var incompleteTopologyNodes = metrics.NewGauge(
+	&metrics.GaugeOpts{
+		Name:           "incomplete_topology_nodes",
+		Help:           "Number of nodes with the CSI driver which lack some of the topology labels that the driver reported. They are excluded from the topology segments.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(incompleteTopologyNodes)
+}
+
 // NewNodeTopology returns an informer that synthesizes storage
 // topology segments based on the accessible topology that each CSI
 // driver node instance reports.  See
@@ -163,6 +178,9 @@ type nodeTopology struct {
 	mutex sync.Mutex
 	// segments hold a list of all currently known topology segments.
 	segments []*Segment
+	// incomplete contains the names of nodes which were skipped
+	// because of missing topology labels.
+	incomplete map[string]bool
 	// callbacks contains all callbacks that need to be invoked
 	// after making changes to the list of known segments.
 	callbacks []Callback
@@ -220,17 +238,26 @@ func (nt *nodeTopology) HasSynced() bool {
 	return false
 }
 
-func (nt *nodeTopology) processNextWorkItem(ctx context.Context) bool {
+func (nt *nodeTopology) processNextWorkItem(ctx context.Context) (more bool) {
 	obj, shutdown := nt.queue.Get()
 	if shutdown {
 		return false
 	}
+	more = true
 	defer nt.queue.Done(obj)
-	nt.sync(ctx)
+	defer panicguard.Recover(nt.queue, "csitopology", obj)
+	if nt.sync(ctx) > 0 {
+		// Normally a node update fixes the labels and triggers
+		// another sync, but don't rely on that.
+		nt.queue.AddRateLimited(obj)
+	} else {
+		nt.queue.Forget(obj)
+	}
 	return true
 }
 
-func (nt *nodeTopology) sync(ctx context.Context) {
+// sync returns the number of nodes with incomplete topology labels.
+func (nt *nodeTopology) sync(ctx context.Context) int {
 	// For all nodes on which the driver is registered, collect the topology key/value pairs
 	// and sort them by key name to make the result deterministic. Skip all segments that have
 	// been seen before.
@@ -246,9 +273,15 @@ func (nt *nodeTopology) sync(ctx context.Context) {
 	csiNodes, err := nt.csiNodeInformer.Lister().List(labels.Everything())
 	if err != nil {
 		utilruntime.HandleError(err)
-		return
+		return 0
 	}
 	existingSegments := make([]*Segment, 0, len(segments))
+	// The map gets replaced, not modified, so reading the old one
+	// without holding the mutex is safe.
+	nt.mutex.Lock()
+	wasIncomplete := nt.incomplete
+	nt.mutex.Unlock()
+	incomplete := map[string]bool{}
 node:
 	for _, csiNode := range csiNodes {
 		topologyKeys := nt.driverTopologyKeys(csiNode)
@@ -265,10 +298,12 @@ node:
 			// This shouldn't happen. If it does,
 			// something is very wrong and we give up.
 			utilruntime.HandleError(err)
-			return
+			return 0
 		}
 
 		newSegment := Segment{}
+		// Sort a copy, the slice belongs to the informer cache.
+		topologyKeys = append([]string(nil), topologyKeys...)
 		sort.Strings(topologyKeys)
 		for _, key := range topologyKeys {
 			value, ok := node.Labels[key]
@@ -277,6 +312,10 @@ node:
 				// it in CSINode, but we haven't seen the corresponding
 				// node update yet as the label is not set. Ignore the node
 				// for now, we'll sync up when we get the node update.
+				if !wasIncomplete[node.Name] {
+					klog.Warningf("capacity topology: node %s has no label %q for the topology of driver %s, ignoring the node until it gets labeled", node.Name, key, nt.driverName)
+				}
+				incomplete[node.Name] = true
 				continue node
 			}
 			newSegment = append(newSegment, SegmentEntry{key, value})
@@ -306,6 +345,13 @@ node:
 	// Lock while making changes, but unlock before actually invoking callbacks.
 	nt.mutex.Lock()
 	nt.segments = existingSegments
+	for nodeName := range wasIncomplete {
+		if !incomplete[nodeName] {
+			klog.V(3).Infof("capacity topology: node %s no longer has incomplete topology labels", nodeName)
+		}
+	}
+	nt.incomplete = incomplete
+	incompleteTopologyNodes.Set(float64(len(incomplete)))
 
 	// Theoretically callbacks could change while we don't have
 	// the lock, so make a copy.
@@ -326,4 +372,5 @@ node:
 	} else {
 		klog.V(5).Infof("topology unchanged")
 	}
+	return len(incomplete)
 }
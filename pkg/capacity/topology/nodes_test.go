@@ -427,6 +427,54 @@ func TestNodeTopology(t *testing.T) {
 	}
 }
 
+// TestIncompleteNodes checks that nodes with missing topology labels
+// are tracked until they get labeled.
+func TestIncompleteNodes(t *testing.T) {
+	ctx := context.Background()
+	clientSet := fakeclientset.NewSimpleClientset(makeNodes([]testNode{
+		{
+			name: node1,
+			driverKeys: map[string][]string{
+				driverName: localStorageKeys,
+			},
+			labels: localStorageLabelsNode1,
+		},
+		{
+			name: node2,
+			driverKeys: map[string][]string{
+				driverName: localStorageKeys,
+			},
+		},
+	})...)
+	nt := fakeNodeTopology(ctx, driverName, clientSet)
+	if err := waitForInformers(ctx, nt); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if incomplete := nt.sync(ctx); incomplete != 1 {
+		t.Fatalf("expected one incomplete node, got %d", incomplete)
+	}
+	if !nt.incomplete[node2] {
+		t.Fatalf("expected %s to be incomplete, got %v", node2, nt.incomplete)
+	}
+	validateSegments(t, "incomplete", nt.List(), []*Segment{localStorageNode1})
+
+	node, err := clientSet.CoreV1().Nodes().Get(ctx, node2, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	node.Labels = localStorageLabelsNode2
+	if _, err := clientSet.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The informer catches up asynchronously.
+	if err := wait.PollImmediate(time.Millisecond, time.Minute, func() (bool, error) {
+		return nt.sync(ctx) == 0, nil
+	}); err != nil {
+		t.Fatalf("node %s still incomplete: %v", node2, err)
+	}
+	validateSegments(t, "complete", nt.List(), []*Segment{localStorageNode1, localStorageNode2})
+}
+
 type segmentsFound map[*Segment]bool
 
 func (sf segmentsFound) Found() []*Segment {
@@ -101,6 +101,20 @@ func (s Segment) GetLabelSelector() *metav1.LabelSelector {
 	}
 }
 
+// Matches returns true if all key/value pairs of the segment are
+// also set in the labels. A nil segment matches nothing.
+func (s Segment) Matches(labels map[string]string) bool {
+	if s == nil {
+		return false
+	}
+	for _, entry := range s {
+		if value, ok := labels[entry.Key]; !ok || value != entry.Value {
+			return false
+		}
+	}
+	return true
+}
+
 // GetLabelMap returns nil if the Segment itself is nil,
 // otherwise a map with all key/value pairs.
 func (s Segment) GetLabelMap() map[string]string {
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/capacity/topology"
+	"k8s.io/klog/v2"
+)
+
+// Trigger is implemented by the capacity controller. Code which knows
+// that the available capacity has changed, for example after resizing
+// a volume, can use it to get CSIStorageCapacity objects updated before
+// the next periodic poll.
+type Trigger interface {
+	// RefreshCapacity schedules an update of all CSIStorageCapacity
+	// objects for the storage class and topology segment. An empty
+	// storage class name matches all storage classes. The segment
+	// matches all segments which contain all of its entries, so an
+	// empty segment matches all segments.
+	RefreshCapacity(storageClassName string, segment topology.Segment)
+}
+
+var _ Trigger = &Controller{}
+
+// RefreshCapacity implements the Trigger interface.
+func (c *Controller) RefreshCapacity(storageClassName string, segment topology.Segment) {
+	if storageClassName == "" {
+		c.invalidateCache(nil, segment)
+	} else if sc, err := c.scInformer.Lister().Get(storageClassName); err == nil {
+		c.invalidateCache(sc, segment)
+	}
+
+	c.capacitiesLock.Lock()
+	defer c.capacitiesLock.Unlock()
+
+	for item := range c.capacities {
+		if (storageClassName == "" || item.storageClassName == storageClassName) &&
+			(len(segment) == 0 || segment.Matches(item.segment.GetLabelMap())) {
+			klog.V(5).Infof("Capacity Controller: enqueuing %+v because of a refresh request", item)
+			c.queue.Add(item)
+		}
+	}
+}
+
+// NewRefreshHandler returns an HTTP handler which triggers a capacity
+// refresh for POST requests. The optional "storageclass" query parameter
+// selects the storage class, "segment" query parameters with
+// <key>=<value> as value select the topology segment.
+func NewRefreshHandler(trigger Trigger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		segment, err := parseSegment(query["segment"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		trigger.RefreshCapacity(query.Get("storageclass"), segment)
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func parseSegment(entries []string) (topology.Segment, error) {
+	var segment topology.Segment
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid segment %q, must be <key>=<value>", entry)
+		}
+		segment = append(segment, topology.SegmentEntry{Key: parts[0], Value: parts[1]})
+	}
+	sort.Sort(&segment)
+	return segment, nil
+}
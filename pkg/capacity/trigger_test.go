@@ -0,0 +1,160 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/capacity/topology"
+	"github.com/stretchr/testify/require"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRefreshCapacity(t *testing.T) {
+	testcases := map[string]struct {
+		storageClassName string
+		segment          topology.Segment
+
+		expectItems []string
+	}{
+		"everything": {
+			expectItems: []string{
+				"direct-sc, [layer0: bar]",
+				"direct-sc, [layer0: foo]",
+				"triple-sc, [layer0: bar]",
+				"triple-sc, [layer0: foo]",
+			},
+		},
+		"storage class": {
+			storageClassName: "triple-sc",
+			expectItems: []string{
+				"triple-sc, [layer0: bar]",
+				"triple-sc, [layer0: foo]",
+			},
+		},
+		"segment": {
+			segment: layer0,
+			expectItems: []string{
+				"direct-sc, [layer0: foo]",
+				"triple-sc, [layer0: foo]",
+			},
+		},
+		"storage class and segment": {
+			storageClassName: "direct-sc",
+			segment:          layer0other,
+			expectItems: []string{
+				"direct-sc, [layer0: bar]",
+			},
+		},
+		"unknown segment": {
+			segment: topology.Segment{{Key: "layer0", Value: "no-such-value"}},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			objects := makeSCs([]testSC{
+				{
+					name:       "direct-sc",
+					driverName: driverName,
+				},
+				{
+					name:       "triple-sc",
+					driverName: driverName,
+				},
+			})
+			clientSet := fakeclientset.NewSimpleClientset(objects...)
+			c, _ := fakeController(ctx, clientSet, &defaultOwner, &mockCapacity{}, topology.NewMock(&layer0, &layer0other), false /* immediate binding */)
+			c.prepare(ctx)
+
+			queue := c.queue.(*rateLimitingQueue)
+			queue.clear()
+
+			c.RefreshCapacity(tc.storageClassName, tc.segment)
+			require.Equal(t, tc.expectItems, itemsAsSortedStringSlice(queue))
+		})
+	}
+}
+
+type fakeTrigger struct {
+	storageClassName string
+	segment          topology.Segment
+	called           bool
+}
+
+func (f *fakeTrigger) RefreshCapacity(storageClassName string, segment topology.Segment) {
+	f.called = true
+	f.storageClassName = storageClassName
+	f.segment = segment
+}
+
+func TestRefreshHandler(t *testing.T) {
+	testcases := map[string]struct {
+		method string
+		url    string
+
+		expectStatus           int
+		expectStorageClassName string
+		expectSegment          topology.Segment
+	}{
+		"everything": {
+			method:       http.MethodPost,
+			url:          "/",
+			expectStatus: http.StatusAccepted,
+		},
+		"storage class and segment": {
+			method:                 http.MethodPost,
+			url:                    "/?storageclass=fast&segment=zone%3Deast&segment=rack%3D1",
+			expectStatus:           http.StatusAccepted,
+			expectStorageClassName: "fast",
+			expectSegment: topology.Segment{
+				{Key: "rack", Value: "1"},
+				{Key: "zone", Value: "east"},
+			},
+		},
+		"invalid segment": {
+			method:       http.MethodPost,
+			url:          "/?segment=zone",
+			expectStatus: http.StatusBadRequest,
+		},
+		"GET": {
+			method:       http.MethodGet,
+			url:          "/",
+			expectStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			trigger := &fakeTrigger{}
+			recorder := httptest.NewRecorder()
+			NewRefreshHandler(trigger).ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.url, nil))
+			require.Equal(t, tc.expectStatus, recorder.Code, "status code")
+			require.Equal(t, tc.expectStatus == http.StatusAccepted, trigger.called, "trigger called")
+			require.Equal(t, tc.expectStorageClassName, trigger.storageClassName, "storage class")
+			require.Equal(t, tc.expectSegment, trigger.segment, "segment")
+		})
+	}
+}
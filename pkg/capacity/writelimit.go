@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// slowWriteThreshold is how long waiting for the write limiter may
+// take before it gets logged.
+const slowWriteThreshold = time.Second
+
+// waitForWrite blocks until the write limiter allows another create,
+// update or delete call. Without a limiter, writes are only limited by
+// the client.
+//
+// A refresh cycle potentially changes all objects at once. Limiting
+// writes separately turns that into bursts of bounded size, so
+// provisioning and other users of the same client still get their
+// share of the client's QPS while the refresh is in progress.
+func (c *Controller) waitForWrite(ctx context.Context) error {
+	if c.writeLimiter == nil {
+		return nil
+	}
+	start := time.Now()
+	if err := c.writeLimiter.Wait(ctx); err != nil {
+		return fmt.Errorf("waiting for write limiter: %v", err)
+	}
+	if delay := time.Since(start); delay > slowWriteThreshold {
+		klog.V(3).Infof("Capacity Controller: write delayed by %v by the write limiter", delay)
+	}
+	return nil
+}
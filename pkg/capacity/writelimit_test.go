@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// countingLimiter counts Wait calls and fails once the limit is reached.
+type countingLimiter struct {
+	flowcontrol.RateLimiter
+	waits, limit int
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	if l.waits >= l.limit {
+		return errors.New("limit reached")
+	}
+	l.waits++
+	return nil
+}
+
+func TestWriteLimiter(t *testing.T) {
+	client := fakeclientset.NewSimpleClientset()
+	scInformer := informers.NewSharedInformerFactory(client, 0).Storage().V1().StorageClasses()
+	if err := scInformer.Informer().GetStore().Add(&storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "sc"},
+		Provisioner: driverName,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	item := workItem{segment: &layer0, storageClassName: "sc"}
+	limiter := &countingLimiter{limit: 1}
+	c := &Controller{
+		csiController: &mockCapacity{
+			capacity: map[string]interface{}{
+				"foo": "10Gi",
+			},
+		},
+		client:         client,
+		capacityClient: NewV1beta1Client(client),
+		driverName:     driverName,
+		managedByID:    managedByID,
+		ownerNamespace: ownerNamespace,
+		owner:          &defaultOwner,
+		scInformer:     scInformer,
+		writeLimiter:   limiter,
+		capacities: map[workItem]*storagev1beta1.CSIStorageCapacity{
+			item: nil,
+		},
+	}
+
+	ctx := context.Background()
+	if err := c.syncCapacity(ctx, item); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if limiter.waits != 1 {
+		t.Fatalf("expected one wait for create, got %d", limiter.waits)
+	}
+	capacities, err := client.StorageV1beta1().CSIStorageCapacities(ownerNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(capacities.Items) != 1 {
+		t.Fatalf("expected one object, got %+v", capacities.Items)
+	}
+
+	// The limiter refuses further writes.
+	if err := c.deleteCapacity(ctx, &capacities.Items[0]); err == nil {
+		t.Fatal("expected error from write limiter")
+	}
+	capacities, err = client.StorageV1beta1().CSIStorageCapacities(ownerNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(capacities.Items) != 1 {
+		t.Errorf("expected object to remain, got %+v", capacities.Items)
+	}
+}
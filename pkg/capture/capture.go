@@ -0,0 +1,268 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package capture records CreateVolume and DeleteVolume calls with their
+// results and sends recorded calls again. This helps to reproduce driver
+// problems that were observed in a different cluster.
+//
+// Captured calls are stored as one JSON object per line. Requests and
+// responses are in protobuf text format, with the values of all secrets
+// removed.
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/prototext"
+	"k8s.io/klog/v2"
+)
+
+const (
+	methodCreateVolume = "/csi.v1.Controller/CreateVolume"
+	methodDeleteVolume = "/csi.v1.Controller/DeleteVolume"
+
+	// strippedSecret replaces the values of secrets.
+	strippedSecret = "***stripped***"
+)
+
+// Record is one captured call.
+type Record struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Duration string    `json:"duration"`
+	Request  string    `json:"request"`
+	Response string    `json:"response,omitempty"`
+	// Code is the gRPC status code of the result, "OK" for success.
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Recorder appends records to a writer.
+type Recorder struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewRecorder writes records to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{encoder: json.NewEncoder(w)}
+}
+
+// OpenRecorder appends records to the file, which gets created if needed.
+func OpenRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(file)
+	r.closer = file
+	return r, nil
+}
+
+// Close closes the file opened by OpenRecorder.
+func (r *Recorder) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+func (r *Recorder) record(rec *Record) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.encoder.Encode(rec); err != nil {
+		klog.Warningf("Recording %s call failed: %v", rec.Method, err)
+	}
+}
+
+// WrapConn returns a connection which records all CreateVolume and
+// DeleteVolume calls.
+func WrapConn(conn grpc.ClientConnInterface, recorder *Recorder) grpc.ClientConnInterface {
+	return &capturingConn{ClientConnInterface: conn, recorder: recorder}
+}
+
+type capturingConn struct {
+	grpc.ClientConnInterface
+	recorder *Recorder
+}
+
+func (c *capturingConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	if method != methodCreateVolume && method != methodDeleteVolume {
+		return c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+	}
+
+	start := time.Now()
+	err := c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+	rec, recErr := newRecord(start, time.Since(start), method, args, reply, err)
+	if recErr != nil {
+		klog.Warningf("Recording %s call failed: %v", method, recErr)
+	} else {
+		c.recorder.record(rec)
+	}
+	return err
+}
+
+func newRecord(start time.Time, duration time.Duration, method string, args, reply interface{}, err error) (*Record, error) {
+	req, ok := args.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("unexpected request type %T", args)
+	}
+	request, encodeErr := marshal(stripSecrets(req))
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+	st := status.Convert(err)
+	rec := &Record{
+		Time:     start,
+		Method:   method,
+		Duration: duration.String(),
+		Request:  request,
+		Code:     st.Code().String(),
+		Message:  st.Message(),
+	}
+	if err == nil {
+		resp, ok := reply.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("unexpected response type %T", reply)
+		}
+		if rec.Response, encodeErr = marshal(resp); encodeErr != nil {
+			return nil, encodeErr
+		}
+	}
+	return rec, nil
+}
+
+// stripSecrets returns a copy of the request without secret values.
+// The keys are kept, they show which secrets were used.
+func stripSecrets(req proto.Message) proto.Message {
+	var secrets *map[string]string
+	req = proto.Clone(req)
+	switch req := req.(type) {
+	case *csi.CreateVolumeRequest:
+		secrets = &req.Secrets
+	case *csi.DeleteVolumeRequest:
+		secrets = &req.Secrets
+	default:
+		return req
+	}
+	if len(*secrets) > 0 {
+		stripped := make(map[string]string, len(*secrets))
+		for key := range *secrets {
+			stripped[key] = strippedSecret
+		}
+		*secrets = stripped
+	}
+	return req
+}
+
+func marshal(msg proto.Message) (string, error) {
+	data, err := prototext.Marshal(proto.MessageV2(msg))
+	return string(data), err
+}
+
+// ReadRecords reads all records.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	// Requests with many parameters or topology entries
+	// can be larger than the default limit.
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// Replay sends the recorded request again. Stripped secrets are replaced
+// by the given secrets, if any. The result is returned as a new record.
+func Replay(ctx context.Context, conn grpc.ClientConnInterface, rec Record, secrets map[string]string) (*Record, error) {
+	req, reply, err := newMessages(rec.Method)
+	if err != nil {
+		return nil, err
+	}
+	if err := prototext.Unmarshal([]byte(rec.Request), proto.MessageV2(req)); err != nil {
+		return nil, fmt.Errorf("decode request: %v", err)
+	}
+	if secrets != nil {
+		switch req := req.(type) {
+		case *csi.CreateVolumeRequest:
+			if len(req.Secrets) > 0 {
+				req.Secrets = secrets
+			}
+		case *csi.DeleteVolumeRequest:
+			if len(req.Secrets) > 0 {
+				req.Secrets = secrets
+			}
+		}
+	}
+
+	start := time.Now()
+	err = conn.Invoke(ctx, rec.Method, req, reply)
+	return newRecord(start, time.Since(start), rec.Method, req, reply, err)
+}
+
+// SameResult compares the status code and response of two records
+// for the same method.
+func (r Record) SameResult(other *Record) (bool, error) {
+	if r.Code != other.Code {
+		return false, nil
+	}
+	if r.Response == "" || other.Response == "" {
+		return r.Response == other.Response, nil
+	}
+	_, resp, err := newMessages(r.Method)
+	if err != nil {
+		return false, err
+	}
+	_, otherResp, _ := newMessages(r.Method)
+	if err := prototext.Unmarshal([]byte(r.Response), proto.MessageV2(resp)); err != nil {
+		return false, fmt.Errorf("decode response: %v", err)
+	}
+	if err := prototext.Unmarshal([]byte(other.Response), proto.MessageV2(otherResp)); err != nil {
+		return false, fmt.Errorf("decode response: %v", err)
+	}
+	return proto.Equal(resp, otherResp), nil
+}
+
+func newMessages(method string) (req, reply proto.Message, err error) {
+	switch method {
+	case methodCreateVolume:
+		return &csi.CreateVolumeRequest{}, &csi.CreateVolumeResponse{}, nil
+	case methodDeleteVolume:
+		return &csi.DeleteVolumeRequest{}, &csi.DeleteVolumeResponse{}, nil
+	default:
+		return nil, nil, fmt.Errorf("method %s is not supported", method)
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capture
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeConn creates volumes with the requested name as ID and fails
+// all other calls with the configured error.
+type fakeConn struct {
+	grpc.ClientConnInterface
+	err     error
+	secrets map[string]string
+	calls   int
+}
+
+func (c *fakeConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	c.calls++
+	if c.err != nil {
+		return c.err
+	}
+	switch req := args.(type) {
+	case *csi.CreateVolumeRequest:
+		c.secrets = req.Secrets
+		reply.(*csi.CreateVolumeResponse).Volume = &csi.Volume{
+			VolumeId:      req.Name,
+			CapacityBytes: req.CapacityRange.GetRequiredBytes(),
+		}
+	case *csi.DeleteVolumeRequest:
+		c.secrets = req.Secrets
+	}
+	return nil
+}
+
+func TestCaptureAndReplay(t *testing.T) {
+	var buffer bytes.Buffer
+	fake := &fakeConn{}
+	conn := WrapConn(fake, NewRecorder(&buffer))
+	ctx := context.Background()
+
+	createReq := &csi.CreateVolumeRequest{
+		Name:          "pvc-1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1024},
+		Parameters:    map[string]string{"type": "fast"},
+		Secrets:       map[string]string{"password": "secret-value"},
+	}
+	if err := conn.Invoke(ctx, methodCreateVolume, createReq, &csi.CreateVolumeResponse{}); err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	fake.err = status.Error(codes.NotFound, "no such volume")
+	if err := conn.Invoke(ctx, methodDeleteVolume, &csi.DeleteVolumeRequest{VolumeId: "pvc-1"}, &csi.DeleteVolumeResponse{}); err == nil {
+		t.Fatal("DeleteVolume: expected error, got none")
+	}
+	// Not recorded.
+	if err := conn.Invoke(ctx, "/csi.v1.Controller/ControllerGetCapabilities", &csi.ControllerGetCapabilitiesRequest{}, &csi.ControllerGetCapabilitiesResponse{}); err == nil {
+		t.Fatal("ControllerGetCapabilities: expected error, got none")
+	}
+	if createReq.Secrets["password"] != "secret-value" {
+		t.Error("original request was modified")
+	}
+	if strings.Contains(buffer.String(), "secret-value") {
+		t.Errorf("secret value was recorded:\n%s", buffer.String())
+	}
+
+	records, err := ReadRecords(&buffer)
+	if err != nil {
+		t.Fatalf("ReadRecords: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Method != methodCreateVolume || records[0].Code != codes.OK.String() || records[0].Response == "" {
+		t.Errorf("unexpected CreateVolume record: %+v", records[0])
+	}
+	if records[1].Method != methodDeleteVolume || records[1].Code != codes.NotFound.String() || records[1].Message != "no such volume" {
+		t.Errorf("unexpected DeleteVolume record: %+v", records[1])
+	}
+
+	testcases := map[string]struct {
+		record        Record
+		err           error
+		secrets       map[string]string
+		expectSame    bool
+		expectSecrets map[string]string
+	}{
+		"same success": {
+			record:        records[0],
+			expectSame:    true,
+			expectSecrets: map[string]string{"password": strippedSecret},
+		},
+		"secrets": {
+			record:        records[0],
+			secrets:       map[string]string{"password": "other-value"},
+			expectSame:    true,
+			expectSecrets: map[string]string{"password": "other-value"},
+		},
+		"different error": {
+			record: records[0],
+			err:    status.Error(codes.Internal, "backend failure"),
+		},
+		"same error": {
+			record:     records[1],
+			err:        status.Error(codes.NotFound, "volume not found"),
+			expectSame: true,
+		},
+		"no secrets needed": {
+			record:     records[1],
+			err:        status.Error(codes.NotFound, "volume not found"),
+			secrets:    map[string]string{"password": "other-value"},
+			expectSame: true,
+		},
+		"now succeeds": {
+			record: records[1],
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeConn{err: tc.err}
+			replayed, err := Replay(ctx, fake, tc.record, tc.secrets)
+			if err != nil {
+				t.Fatalf("Replay: %v", err)
+			}
+			if fake.calls != 1 {
+				t.Errorf("expected one call, got %d", fake.calls)
+			}
+			if tc.err == nil && len(fake.secrets) != len(tc.expectSecrets) {
+				t.Errorf("expected secrets %v, got %v", tc.expectSecrets, fake.secrets)
+			}
+			for key, value := range tc.expectSecrets {
+				if fake.secrets[key] != value {
+					t.Errorf("expected secrets %v, got %v", tc.expectSecrets, fake.secrets)
+				}
+			}
+			same, err := tc.record.SameResult(replayed)
+			if err != nil {
+				t.Fatalf("SameResult: %v", err)
+			}
+			if same != tc.expectSame {
+				t.Errorf("expected same result %v, got %v: recorded %+v, replayed %+v", tc.expectSame, same, tc.record, replayed)
+			}
+		})
+	}
+}
+
+func TestReplayUnsupported(t *testing.T) {
+	_, err := Replay(context.Background(), &fakeConn{}, Record{Method: "/csi.v1.Controller/ListVolumes"}, nil)
+	if err == nil {
+		t.Error("expected error, got none")
+	}
+}
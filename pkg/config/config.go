@@ -0,0 +1,177 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config reads the YAML file given with --config. It contains
+// values for command line flags, which avoids repeating them in many
+// deployments, and settings which are too structured for flags.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the content of the configuration file.
+type Config struct {
+	// Flags contains values for command line flags, by name without
+	// the leading dashes. Flags given on the command line take
+	// precedence.
+	Flags map[string]string `json:"flags,omitempty"`
+	// RateLimiters configures the retries of failed work queue items.
+	RateLimiters RateLimiters `json:"rateLimiters,omitempty"`
+}
+
+// RateLimiters has one rate limiter configuration for each group of
+// work queues. Fields which are not set in one of them are taken from
+// Default.
+type RateLimiters struct {
+	// Default is used for all work queues which are not listed
+	// separately.
+	Default RateLimiter `json:"default,omitempty"`
+	// Claims is used for provisioning and deleting volumes.
+	Claims RateLimiter `json:"claims,omitempty"`
+	// Capacity is used for updating CSIStorageCapacity objects.
+	Capacity RateLimiter `json:"capacity,omitempty"`
+	// Topology is used for tracking topology segments.
+	Topology RateLimiter `json:"topology,omitempty"`
+	// Cloning is used for removing the finalizer of clone sources.
+	Cloning RateLimiter `json:"cloning,omitempty"`
+	// Deletion is used for deleting volumes with --deletion-controller.
+	Deletion RateLimiter `json:"deletion,omitempty"`
+}
+
+// RateLimiter combines exponential backoff per item with an optional
+// token bucket that is shared by all items of a work queue.
+type RateLimiter struct {
+	// InitialDelay is the delay before the first retry of an item. It
+	// doubles with each further failure.
+	InitialDelay metav1.Duration `json:"initialDelay,omitempty"`
+	// MaxDelay is the upper limit for the delay of an item.
+	MaxDelay metav1.Duration `json:"maxDelay,omitempty"`
+	// Jitter is the fraction of each delay, between 0 and 1, by which
+	// it gets shortened randomly, so that items which failed at the
+	// same time do not get retried all at once.
+	Jitter float64 `json:"jitter,omitempty"`
+	// QPS is the number of retries per second across all items. Zero
+	// disables the shared limit.
+	QPS float64 `json:"qps,omitempty"`
+	// Burst is the number of retries that may happen at once despite
+	// the QPS.
+	Burst int `json:"burst,omitempty"`
+}
+
+// Load reads the file and fills in the defaults. Unknown fields are
+// treated as error, because they are most likely typos.
+func Load(path string, defaults RateLimiter) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	config.SetDefaults(defaults)
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Default returns the configuration that is used without a file.
+func Default(defaults RateLimiter) *Config {
+	config := &Config{}
+	config.SetDefaults(defaults)
+	return config
+}
+
+// SetDefaults fills in fields which are not set, first in Default from
+// defaults and then in the other rate limiters from Default.
+func (c *Config) SetDefaults(defaults RateLimiter) {
+	r := &c.RateLimiters
+	r.Default.setDefaults(defaults)
+	for _, rateLimiter := range []*RateLimiter{&r.Claims, &r.Capacity, &r.Topology, &r.Cloning, &r.Deletion} {
+		rateLimiter.setDefaults(r.Default)
+	}
+}
+
+// Validate checks the configuration after SetDefaults.
+func (c *Config) Validate() error {
+	r := &c.RateLimiters
+	for name, rateLimiter := range map[string]RateLimiter{
+		"default":  r.Default,
+		"claims":   r.Claims,
+		"capacity": r.Capacity,
+		"topology": r.Topology,
+		"cloning":  r.Cloning,
+		"deletion": r.Deletion,
+	} {
+		if err := rateLimiter.validate(); err != nil {
+			return fmt.Errorf("rateLimiters.%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (r *RateLimiter) setDefaults(defaults RateLimiter) {
+	if r.InitialDelay.Duration == 0 {
+		r.InitialDelay = defaults.InitialDelay
+	}
+	if r.MaxDelay.Duration == 0 {
+		r.MaxDelay = defaults.MaxDelay
+	}
+	if r.Jitter == 0 {
+		r.Jitter = defaults.Jitter
+	}
+	if r.QPS == 0 {
+		r.QPS = defaults.QPS
+		if r.Burst == 0 {
+			r.Burst = defaults.Burst
+		}
+	}
+}
+
+func (r RateLimiter) validate() error {
+	if r.InitialDelay.Duration <= 0 {
+		return fmt.Errorf("initialDelay must be positive")
+	}
+	if r.MaxDelay.Duration < r.InitialDelay.Duration {
+		return fmt.Errorf("maxDelay must not be smaller than initialDelay")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	if r.QPS < 0 {
+		return fmt.Errorf("qps must not be negative")
+	}
+	if r.QPS > 0 && r.Burst < 1 {
+		return fmt.Errorf("burst must be at least one when qps is set")
+	}
+	return nil
+}
+
+// NewRateLimiterDefaults returns the rate limiter configuration for the
+// given delays, without jitter and without a shared limit.
+func NewRateLimiterDefaults(initialDelay, maxDelay time.Duration) RateLimiter {
+	return RateLimiter{
+		InitialDelay: metav1.Duration{Duration: initialDelay},
+		MaxDelay:     metav1.Duration{Duration: maxDelay},
+	}
+}
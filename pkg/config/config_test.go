@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoad(t *testing.T) {
+	defaults := NewRateLimiterDefaults(time.Second, 5*time.Minute)
+	duration := func(d time.Duration) metav1.Duration { return metav1.Duration{Duration: d} }
+
+	testcases := map[string]struct {
+		content     string
+		expected    RateLimiters
+		expectError string
+	}{
+		"empty": {
+			expected: RateLimiters{
+				Default:  defaults,
+				Claims:   defaults,
+				Capacity: defaults,
+				Topology: defaults,
+				Cloning:  defaults,
+				Deletion: defaults,
+			},
+		},
+		"overrides": {
+			content: `
+rateLimiters:
+  default:
+    maxDelay: 10m
+    jitter: 0.1
+  claims:
+    initialDelay: 5s
+    qps: 10
+    burst: 100
+`,
+			expected: func() RateLimiters {
+				def := RateLimiter{InitialDelay: duration(time.Second), MaxDelay: duration(10 * time.Minute), Jitter: 0.1}
+				return RateLimiters{
+					Default:  def,
+					Claims:   RateLimiter{InitialDelay: duration(5 * time.Second), MaxDelay: duration(10 * time.Minute), Jitter: 0.1, QPS: 10, Burst: 100},
+					Capacity: def,
+					Topology: def,
+					Cloning:  def,
+					Deletion: def,
+				}
+			}(),
+		},
+		"unknown field": {
+			content:     "rateLimiters:\n  claim:\n    qps: 1\n",
+			expectError: `unknown field "claim"`,
+		},
+		"invalid jitter": {
+			content:     "rateLimiters:\n  capacity:\n    jitter: 2\n",
+			expectError: "rateLimiters.capacity: jitter must be between 0 and 1",
+		},
+		"missing burst": {
+			content:     "rateLimiters:\n  topology:\n    qps: 1\n",
+			expectError: "rateLimiters.topology: burst must be at least one when qps is set",
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := Load(path, defaults)
+			if tc.expectError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(config.RateLimiters, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, config.RateLimiters)
+			}
+		})
+	}
+}
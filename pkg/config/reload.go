@@ -0,0 +1,200 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+var reloads = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name:           "config_file_reloads_total",
+		Help:           "Number of times a change of the --config file was detected, by result: applied, restart_required or failed.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"result"},
+)
+
+func init() {
+	legacyregistry.MustRegister(reloads)
+}
+
+// ApplyFlags sets the flags from the file. Flags which were given on
+// the command line take precedence and are left unchanged.
+func (c *Config) ApplyFlags(fs *flag.FlagSet) error {
+	for _, name := range sortedKeys(c.Flags) {
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("flags: unknown flag %q", name)
+		}
+		if f.Changed {
+			klog.V(2).Infof("Flag --%s from the command line overrides the config file", name)
+			continue
+		}
+		if err := f.Value.Set(c.Flags[name]); err != nil {
+			return fmt.Errorf("flags: invalid value %q for %q: %v", c.Flags[name], name, err)
+		}
+	}
+	return nil
+}
+
+// Watcher checks the file for changes and applies flags which can be
+// changed while the external-provisioner is running. Other changes
+// only take effect after a restart.
+type Watcher struct {
+	path     string
+	defaults RateLimiter
+	fs       *flag.FlagSet
+
+	// commandLine contains the flags which were given on the
+	// command line. The file has no effect on them.
+	commandLine sets.String
+
+	mutex      sync.Mutex
+	current    *Config
+	reloadable map[string]func(value string) error
+}
+
+// NewWatcher creates a watcher for the file from which the current
+// configuration was loaded and applied with ApplyFlags.
+func NewWatcher(path string, defaults RateLimiter, fs *flag.FlagSet, current *Config) *Watcher {
+	commandLine := sets.NewString()
+	fs.Visit(func(f *flag.Flag) {
+		commandLine.Insert(f.Name)
+	})
+	return &Watcher{
+		path:        path,
+		defaults:    defaults,
+		fs:          fs,
+		commandLine: commandLine,
+		current:     current,
+		reloadable:  map[string]func(value string) error{},
+	}
+}
+
+// Reloadable declares that the flag may change at runtime. The
+// callback parses the new value and applies it. The flag variable
+// itself keeps the value from startup, because it is read without
+// locking.
+func (w *Watcher) Reloadable(name string, apply func(value string) error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.reloadable[name] = apply
+}
+
+// Run checks the file at the given interval until the context is done.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		w.check()
+	}, interval)
+}
+
+func (w *Watcher) check() {
+	config, err := Load(w.path, w.defaults)
+	if err != nil {
+		reloads.WithLabelValues("failed").Inc()
+		klog.Errorf("Reloading %s failed, keeping the current configuration: %v", w.path, err)
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if reflect.DeepEqual(config, w.current) {
+		return
+	}
+
+	var restartRequired []string
+	values := map[string]string{}
+	for _, name := range sets.StringKeySet(config.Flags).Union(sets.StringKeySet(w.current.Flags)).List() {
+		value, ok := config.Flags[name]
+		if oldValue, oldOk := w.current.Flags[name]; ok == oldOk && value == oldValue {
+			continue
+		}
+		if w.commandLine.Has(name) {
+			continue
+		}
+		f := w.fs.Lookup(name)
+		if f == nil {
+			reloads.WithLabelValues("failed").Inc()
+			klog.Errorf("Reloading %s failed, keeping the current configuration: unknown flag %q", w.path, name)
+			return
+		}
+		if _, reloadable := w.reloadable[name]; !reloadable {
+			restartRequired = append(restartRequired, "flags."+name)
+			continue
+		}
+		if !ok {
+			// Removed from the file, back to the default.
+			value = f.DefValue
+		}
+		values[name] = value
+	}
+	if !reflect.DeepEqual(config.RateLimiters, w.current.RateLimiters) {
+		restartRequired = append(restartRequired, "rateLimiters")
+	}
+
+	failed := false
+	for _, name := range sortedKeys(values) {
+		value := values[name]
+		if err := w.reloadable[name](value); err != nil {
+			reloads.WithLabelValues("failed").Inc()
+			klog.Errorf("Reloading %s: invalid value %q for --%s, keeping the current value: %v", w.path, value, name, err)
+			// Remember the value which is still in effect, so that
+			// the next check tries the invalid value again and
+			// reports it until the file gets fixed.
+			failed = true
+			if oldValue, ok := w.current.Flags[name]; ok {
+				if config.Flags == nil {
+					config.Flags = map[string]string{}
+				}
+				config.Flags[name] = oldValue
+			} else {
+				delete(config.Flags, name)
+			}
+			continue
+		}
+		klog.Infof("Reloading %s: --%s is now %q", w.path, name, value)
+	}
+	if len(restartRequired) > 0 {
+		reloads.WithLabelValues("restart_required").Inc()
+		klog.Warningf("Reloading %s: changes of %v only take effect after a restart", w.path, restartRequired)
+	} else if !failed {
+		reloads.WithLabelValues("applied").Inc()
+	}
+	w.current = config
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
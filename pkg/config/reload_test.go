@@ -0,0 +1,222 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestApplyFlags(t *testing.T) {
+	testcases := map[string]struct {
+		flags       map[string]string
+		args        []string
+		expectA     string
+		expectB     int
+		expectError string
+	}{
+		"none": {
+			expectA: "default",
+			expectB: 1,
+		},
+		"from file": {
+			flags:   map[string]string{"a": "file", "b": "2"},
+			expectA: "file",
+			expectB: 2,
+		},
+		"command line wins": {
+			flags:   map[string]string{"a": "file", "b": "2"},
+			args:    []string{"--a=command-line"},
+			expectA: "command-line",
+			expectB: 2,
+		},
+		"unknown flag": {
+			flags:       map[string]string{"c": "x"},
+			expectError: `unknown flag "c"`,
+		},
+		"invalid value": {
+			flags:       map[string]string{"b": "x"},
+			expectError: `invalid value "x" for "b"`,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			a := fs.String("a", "default", "")
+			b := fs.Int("b", 1, "")
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			err := (&Config{Flags: tc.flags}).ApplyFlags(fs)
+			if tc.expectError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *a != tc.expectA || *b != tc.expectB {
+				t.Errorf("expected a=%q b=%d, got a=%q b=%d", tc.expectA, tc.expectB, *a, *b)
+			}
+		})
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	defaults := NewRateLimiterDefaults(time.Second, 5*time.Minute)
+	initial := "flags:\n  timeout: 10s\n  workers: \"5\"\n"
+
+	testcases := map[string]struct {
+		args    []string
+		content string
+		expect  []string
+	}{
+		"unchanged": {
+			content: initial,
+		},
+		"reloadable": {
+			content: "flags:\n  timeout: 20s\n  workers: \"5\"\n",
+			expect:  []string{"20s"},
+		},
+		"removed": {
+			content: "flags:\n  workers: \"5\"\n",
+			expect:  []string{"1m0s"},
+		},
+		"restart required": {
+			content: "flags:\n  timeout: 10s\n  workers: \"10\"\n",
+		},
+		"rate limiters": {
+			content: initial + "rateLimiters:\n  claims:\n    qps: 1\n    burst: 1\n",
+		},
+		"command line": {
+			args:    []string{"--timeout=30s"},
+			content: "flags:\n  timeout: 20s\n  workers: \"5\"\n",
+		},
+		"invalid file": {
+			content: "flags: [",
+		},
+		"unknown flag": {
+			content: initial + "  other: x\n",
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := ioutil.WriteFile(path, []byte(initial), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.Duration("timeout", time.Minute, "")
+			fs.Int("workers", 1, "")
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			current, err := Load(path, defaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := current.ApplyFlags(fs); err != nil {
+				t.Fatal(err)
+			}
+
+			w := NewWatcher(path, defaults, fs, current)
+			var applied []string
+			w.Reloadable("timeout", func(value string) error {
+				applied = append(applied, value)
+				return nil
+			})
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			w.check()
+			if !reflect.DeepEqual(applied, tc.expect) {
+				t.Errorf("expected applied values %q, got %q", tc.expect, applied)
+			}
+		})
+	}
+}
+
+func TestWatcherInvalidValue(t *testing.T) {
+	defaults := NewRateLimiterDefaults(time.Second, 5*time.Minute)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("flags:\n  timeout: 10s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Duration("timeout", time.Minute, "")
+	current, err := Load(path, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := current.ApplyFlags(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWatcher(path, defaults, fs, current)
+	var attempts []string
+	w.Reloadable("timeout", func(value string) error {
+		attempts = append(attempts, value)
+		_, err := time.ParseDuration(value)
+		return err
+	})
+	counters := func() (applied, failed float64) {
+		applied, _ = testutil.GetCounterMetricValue(reloads.WithLabelValues("applied"))
+		failed, _ = testutil.GetCounterMetricValue(reloads.WithLabelValues("failed"))
+		return
+	}
+	appliedBefore, failedBefore := counters()
+
+	// An invalid value is neither counted as applied nor forgotten,
+	// so each check reports it again.
+	if err := ioutil.WriteFile(path, []byte("flags:\n  timeout: x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.check()
+	w.check()
+	if expect := []string{"x", "x"}; !reflect.DeepEqual(attempts, expect) {
+		t.Errorf("expected attempts %q, got %q", expect, attempts)
+	}
+	applied, failed := counters()
+	if applied != appliedBefore || failed != failedBefore+2 {
+		t.Errorf("expected two failed and no applied reloads, got %v failed and %v applied", failed-failedBefore, applied-appliedBefore)
+	}
+
+	// Fixing the file applies the new value once.
+	if err := ioutil.WriteFile(path, []byte("flags:\n  timeout: 20s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.check()
+	w.check()
+	if expect := []string{"x", "x", "20s"}; !reflect.DeepEqual(attempts, expect) {
+		t.Errorf("expected attempts %q, got %q", expect, attempts)
+	}
+	applied, _ = counters()
+	if applied != appliedBefore+1 {
+		t.Errorf("expected one applied reload, got %v", applied-appliedBefore)
+	}
+}
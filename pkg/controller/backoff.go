@@ -0,0 +1,258 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	backoffDelayName = "workqueue_backoff_delay_seconds"
+	backoffDelayHelp = "Current retry delays of items which wait for their next attempt, by work queue."
+)
+
+var (
+	// backoffDelayBuckets cover the default delays for claims and
+	// volumes, from one second up to half an hour.
+	backoffDelayBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+	backoffDelayDesc = metrics.NewDesc(
+		backoffDelayName,
+		backoffDelayHelp,
+		[]string{"name"}, nil,
+		metrics.ALPHA,
+		"",
+	)
+	// backoffDelayPromDesc is how backoffDelayDesc gets registered.
+	// It is needed because component-base has no helper for constant
+	// histograms.
+	backoffDelayPromDesc = prometheus.NewDesc(backoffDelayName, "[ALPHA] "+backoffDelayHelp, []string{"name"}, nil)
+
+	backoffs = &backoffCollector{}
+)
+
+func init() {
+	legacyregistry.CustomMustRegister(backoffs)
+}
+
+// backoffCollector reports the delays of all rate limiters created
+// with NewBackoffTrackingRateLimiter or
+// NewNamedBackoffTrackingRateLimiter.
+type backoffCollector struct {
+	metrics.BaseStableCollector
+
+	mutex    sync.Mutex
+	limiters []*backoffTrackingRateLimiter
+}
+
+var _ metrics.StableCollector = &backoffCollector{}
+
+func (c *backoffCollector) add(r *backoffTrackingRateLimiter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.limiters = append(c.limiters, r)
+}
+
+// DescribeWithStability implements the metrics.StableCollector interface.
+func (c *backoffCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- backoffDelayDesc
+}
+
+// CollectWithStability implements the metrics.StableCollector interface.
+func (c *backoffCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	if backoffDelayDesc.IsHidden() {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, r := range c.limiters {
+		for _, queue := range r.queues {
+			count, sum, buckets := r.histogram(queue)
+			ch <- prometheus.MustNewConstHistogram(backoffDelayPromDesc, count, sum, buckets, queue)
+		}
+	}
+}
+
+const (
+	// claimQueueName and volumeQueueName are the names of the work
+	// queues in the provisioner library which share the rate limiter.
+	claimQueueName  = "claims"
+	volumeQueueName = "volumes"
+)
+
+// backoffTrackingRateLimiter remembers the delay that the wrapped rate
+// limiter returned for each item and since when the item has been
+// failing until the item gets forgotten, which happens when it was
+// processed successfully.
+type backoffTrackingRateLimiter struct {
+	workqueue.RateLimiter
+	now func() time.Time
+	// queues are the names of the work queues which use the rate
+	// limiter, queueOf determines the queue of an item.
+	queues  []string
+	queueOf func(item interface{}) string
+
+	mutex  sync.Mutex
+	delays map[interface{}]backoff
+}
+
+type backoff struct {
+	delay time.Duration
+	until time.Time
+	// since is the time of the first failure.
+	since time.Time
+}
+
+// NewBackoffTrackingRateLimiter wraps the rate limiter for claims and
+// volumes of the provisioner library. The workqueue_backoff_delay_seconds
+// metric then shows the current delays of all claims and volumes which
+// wait for a retry. Many items with long delays mean that provisioning
+// is slow because of failures, not because of a lack of throughput.
+//
+// The rate limiter also reports the
+// workqueue_oldest_unprocessed_item_age_seconds metric for the claims
+// and volumes queues. The library does not tell when items get added,
+// so the age is measured from the first failure of an item.
+func NewBackoffTrackingRateLimiter(rateLimiter workqueue.RateLimiter) workqueue.RateLimiter {
+	r := newBackoffTrackingRateLimiter(rateLimiter, []string{claimQueueName, volumeQueueName}, libraryQueueOf)
+	backoffs.add(r)
+	queueAges.add(libraryQueue{limiter: r, name: claimQueueName})
+	queueAges.add(libraryQueue{limiter: r, name: volumeQueueName})
+	return r
+}
+
+// NewNamedBackoffTrackingRateLimiter wraps the rate limiter of a single
+// work queue such that the workqueue_backoff_delay_seconds metric also
+// covers that queue. The queue must have been created with
+// NewNamedRateLimitingQueue, which reports the age of its items itself.
+func NewNamedBackoffTrackingRateLimiter(rateLimiter workqueue.RateLimiter, name string) workqueue.RateLimiter {
+	r := newBackoffTrackingRateLimiter(rateLimiter, []string{name}, func(interface{}) string { return name })
+	backoffs.add(r)
+	return r
+}
+
+func newBackoffTrackingRateLimiter(rateLimiter workqueue.RateLimiter, queues []string, queueOf func(item interface{}) string) *backoffTrackingRateLimiter {
+	return &backoffTrackingRateLimiter{
+		RateLimiter: rateLimiter,
+		now:         time.Now,
+		queues:      queues,
+		queueOf:     queueOf,
+		delays:      map[interface{}]backoff{},
+	}
+}
+
+func (r *backoffTrackingRateLimiter) When(item interface{}) time.Duration {
+	delay := r.RateLimiter.When(item)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := r.now()
+	since := now
+	if existing, ok := r.delays[item]; ok {
+		since = existing.since
+	}
+	r.delays[item] = backoff{delay: delay, until: now.Add(delay), since: since}
+	return delay
+}
+
+func (r *backoffTrackingRateLimiter) Forget(item interface{}) {
+	r.RateLimiter.Forget(item)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.delays, item)
+}
+
+// histogram returns the delays of the items of the queue which are
+// still waiting.
+func (r *backoffTrackingRateLimiter) histogram(queue string) (count uint64, sum float64, buckets map[float64]uint64) {
+	buckets = make(map[float64]uint64, len(backoffDelayBuckets))
+	for _, bound := range backoffDelayBuckets {
+		buckets[bound] = 0
+	}
+	now := r.now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for item, b := range r.delays {
+		if !b.until.After(now) || r.queueOf(item) != queue {
+			continue
+		}
+		seconds := b.delay.Seconds()
+		count++
+		sum += seconds
+		for _, bound := range backoffDelayBuckets {
+			if seconds <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+	return
+}
+
+// libraryQueueOf determines the queue of an item. The library queues claims
+// by their UID and volumes by their name. Volume names generated by
+// the external-provisioner have a prefix and therefore are not UIDs.
+func libraryQueueOf(item interface{}) string {
+	key := fmt.Sprintf("%v", item)
+	if _, err := uuid.Parse(key); err == nil && len(key) == 36 {
+		return claimQueueName
+	}
+	return volumeQueueName
+}
+
+// oldestFailing returns the item of the queue which has been failing
+// for the longest time and how long that was, nil and zero if there is
+// none.
+func (r *backoffTrackingRateLimiter) oldestFailing(queue string) (interface{}, time.Duration) {
+	now := r.now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var oldestItem interface{}
+	var oldest time.Duration
+	for item, b := range r.delays {
+		if r.queueOf(item) != queue {
+			continue
+		}
+		if age := now.Sub(b.since); age > oldest {
+			oldestItem = item
+			oldest = age
+		}
+	}
+	return oldestItem, oldest
+}
+
+// libraryQueue is one of the queues of the provisioner library, as far
+// as its rate limiter can observe it.
+type libraryQueue struct {
+	limiter *backoffTrackingRateLimiter
+	name    string
+}
+
+func (q libraryQueue) queueName() string {
+	return q.name
+}
+
+func (q libraryQueue) oldestUnprocessed() (interface{}, time.Duration) {
+	return q.limiter.oldestFailing(q.name)
+}
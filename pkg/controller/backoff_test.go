@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestBackoffTracking(t *testing.T) {
+	now := time.Now()
+	r := newBackoffTrackingRateLimiter(workqueue.NewItemExponentialFailureRateLimiter(5*time.Second, time.Hour), []string{claimQueueName, volumeQueueName}, libraryQueueOf)
+	r.now = func() time.Time { return now }
+	claim1 := "0a6a3a34-1a4f-4c1f-9b0e-8d3a1b5f2c11"
+	claim2 := "7d0f2d8e-52b6-4c8d-a0b4-3f6e1d2c9a22"
+	claim3 := "c3b1e5f7-9a2d-4e6b-8c4f-1a2b3c4d5e33"
+	volume := "pvc-0a6a3a34-1a4f-4c1f-9b0e-8d3a1b5f2c11"
+
+	// claim1: 5s, 10s, 20s, 40s, 80s
+	for i := 0; i < 5; i++ {
+		r.When(claim1)
+	}
+	r.When(claim2) // 5s
+	r.When(claim3)
+	r.Forget(claim3)
+	r.When(volume) // 5s
+	r.When(volume) // 10s
+	now = now.Add(7 * time.Second)
+
+	registry := metrics.NewKubeRegistry()
+	collector := &backoffCollector{}
+	collector.add(r)
+	registry.CustomMustRegister(collector)
+	if err := testutil.GatherAndCompare(registry, bytes.NewBufferString(`# HELP workqueue_backoff_delay_seconds [ALPHA] Current retry delays of items which wait for their next attempt, by work queue.
+# TYPE workqueue_backoff_delay_seconds histogram
+workqueue_backoff_delay_seconds_bucket{name="claims",le="1"} 0
+workqueue_backoff_delay_seconds_bucket{name="claims",le="5"} 0
+workqueue_backoff_delay_seconds_bucket{name="claims",le="10"} 0
+workqueue_backoff_delay_seconds_bucket{name="claims",le="30"} 0
+workqueue_backoff_delay_seconds_bucket{name="claims",le="60"} 0
+workqueue_backoff_delay_seconds_bucket{name="claims",le="120"} 1
+workqueue_backoff_delay_seconds_bucket{name="claims",le="300"} 1
+workqueue_backoff_delay_seconds_bucket{name="claims",le="600"} 1
+workqueue_backoff_delay_seconds_bucket{name="claims",le="1800"} 1
+workqueue_backoff_delay_seconds_bucket{name="claims",le="+Inf"} 1
+workqueue_backoff_delay_seconds_sum{name="claims"} 80
+workqueue_backoff_delay_seconds_count{name="claims"} 1
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="1"} 0
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="5"} 0
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="10"} 1
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="30"} 1
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="60"} 1
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="120"} 1
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="300"} 1
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="600"} 1
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="1800"} 1
+workqueue_backoff_delay_seconds_bucket{name="volumes",le="+Inf"} 1
+workqueue_backoff_delay_seconds_sum{name="volumes"} 10
+workqueue_backoff_delay_seconds_count{name="volumes"} 1
+`), "workqueue_backoff_delay_seconds"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNamedBackoffTracking(t *testing.T) {
+	now := time.Now()
+	r := NewNamedBackoffTrackingRateLimiter(workqueue.NewItemExponentialFailureRateLimiter(5*time.Second, time.Hour), "deletion").(*backoffTrackingRateLimiter)
+	r.now = func() time.Time { return now }
+	// Items of a named queue are counted regardless of their key.
+	r.When("0a6a3a34-1a4f-4c1f-9b0e-8d3a1b5f2c11")
+	r.When("pvc-0a6a3a34-1a4f-4c1f-9b0e-8d3a1b5f2c11")
+
+	registry := metrics.NewKubeRegistry()
+	collector := &backoffCollector{}
+	collector.add(r)
+	registry.CustomMustRegister(collector)
+	if err := testutil.GatherAndCompare(registry, bytes.NewBufferString(`# HELP workqueue_backoff_delay_seconds [ALPHA] Current retry delays of items which wait for their next attempt, by work queue.
+# TYPE workqueue_backoff_delay_seconds histogram
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="1"} 0
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="5"} 2
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="10"} 2
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="30"} 2
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="60"} 2
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="120"} 2
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="300"} 2
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="600"} 2
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="1800"} 2
+workqueue_backoff_delay_seconds_bucket{name="deletion",le="+Inf"} 2
+workqueue_backoff_delay_seconds_sum{name="deletion"} 10
+workqueue_backoff_delay_seconds_count{name="deletion"} 2
+`), "workqueue_backoff_delay_seconds"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLibraryQueueAge(t *testing.T) {
+	now := time.Now()
+	r := newBackoffTrackingRateLimiter(workqueue.NewItemExponentialFailureRateLimiter(5*time.Second, time.Hour), []string{claimQueueName, volumeQueueName}, libraryQueueOf)
+	r.now = func() time.Time { return now }
+	claims := libraryQueue{limiter: r, name: claimQueueName}
+	volumes := libraryQueue{limiter: r, name: volumeQueueName}
+	claim1 := "0a6a3a34-1a4f-4c1f-9b0e-8d3a1b5f2c11"
+	claim2 := "7d0f2d8e-52b6-4c8d-a0b4-3f6e1d2c9a22"
+	volume := "pvc-0a6a3a34-1a4f-4c1f-9b0e-8d3a1b5f2c11"
+
+	if item, age := claims.oldestUnprocessed(); item != nil || age != 0 {
+		t.Errorf("expected no failing claim, got %v for %v", item, age)
+	}
+
+	r.When(claim1)
+	now = now.Add(time.Minute)
+	r.When(claim2)
+	r.When(volume)
+	now = now.Add(time.Minute)
+	// Failing again does not reset the age.
+	r.When(claim1)
+	if item, age := claims.oldestUnprocessed(); item != claim1 || age != 2*time.Minute {
+		t.Errorf("expected claim %s failing for 2m, got %v for %v", claim1, item, age)
+	}
+	if item, age := volumes.oldestUnprocessed(); item != volume || age != time.Minute {
+		t.Errorf("expected volume %s failing for 1m, got %v for %v", volume, item, age)
+	}
+
+	// Success makes the next item the oldest one.
+	r.Forget(claim1)
+	if item, age := claims.oldestUnprocessed(); item != claim2 || age != time.Minute {
+		t.Errorf("expected claim %s failing for 1m, got %v for %v", claim2, item, age)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var timeToBound = metrics.NewHistogramVec(
+	&metrics.HistogramOpts{
+		Name:           "persistentvolumeclaim_time_to_bound_seconds",
+		Help:           "Time from PVC creation, or from node selection for PVCs with late binding, until the PVC is bound.",
+		Buckets:        []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"storage_class"},
+)
+
+func init() {
+	legacyregistry.MustRegister(timeToBound)
+}
+
+// BoundLatencyTracker watches PVCs of a driver and observes in the
+// persistentvolumeclaim_time_to_bound_seconds histogram how long it took
+// until they were bound.
+type BoundLatencyTracker struct {
+	driverName string
+	nodeName   string
+	started    time.Time
+	now        func() time.Time
+	observe    func(storageClassName string, seconds float64)
+
+	mutex   sync.Mutex
+	pending map[types.UID]pendingClaim
+	// bound has the times when pending PVCs got bound during the
+	// last throughputWindow, oldest first.
+	bound []time.Time
+}
+
+type pendingClaim struct {
+	// selectedNode is when the selected node annotation was seen first,
+	// zero if not seen yet.
+	selectedNode time.Time
+	// unknown is set when the annotation already existed while the
+	// tracker was not running yet. Such PVCs are not measured.
+	unknown bool
+}
+
+var _ cache.ResourceEventHandler = &BoundLatencyTracker{}
+
+// NewBoundLatencyTracker creates a tracker for PVCs of the given driver.
+// In a deployment on each node, nodeName must be set to the node of the
+// instance, which then only tracks PVCs for that node. The tracker also
+// provides the provisioning throughput metrics.
+func NewBoundLatencyTracker(driverName, nodeName string) *BoundLatencyTracker {
+	t := newBoundLatencyTracker(driverName, nodeName)
+	throughputs.add(t)
+	return t
+}
+
+func newBoundLatencyTracker(driverName, nodeName string) *BoundLatencyTracker {
+	return &BoundLatencyTracker{
+		driverName: driverName,
+		nodeName:   nodeName,
+		started:    time.Now(),
+		now:        time.Now,
+		observe: func(storageClassName string, seconds float64) {
+			timeToBound.WithLabelValues(storageClassName).Observe(seconds)
+		},
+		pending: map[types.UID]pendingClaim{},
+	}
+}
+
+// OnAdd implements cache.ResourceEventHandler.
+func (t *BoundLatencyTracker) OnAdd(obj interface{}) {
+	if claim, ok := obj.(*v1.PersistentVolumeClaim); ok {
+		t.update(claim)
+	}
+}
+
+// OnUpdate implements cache.ResourceEventHandler.
+func (t *BoundLatencyTracker) OnUpdate(oldObj, newObj interface{}) {
+	if claim, ok := newObj.(*v1.PersistentVolumeClaim); ok {
+		t.update(claim)
+	}
+}
+
+// OnDelete implements cache.ResourceEventHandler.
+func (t *BoundLatencyTracker) OnDelete(obj interface{}) {
+	if unknown, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = unknown.Obj
+	}
+	if claim, ok := obj.(*v1.PersistentVolumeClaim); ok {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		delete(t.pending, claim.UID)
+	}
+}
+
+func (t *BoundLatencyTracker) update(claim *v1.PersistentVolumeClaim) {
+	if claim.Annotations[annStorageProvisioner] != t.driverName &&
+		claim.Annotations[annMigratedTo] != t.driverName {
+		return
+	}
+	selectedNode, hasSelectedNode := claim.Annotations[annSelectedNode]
+	if t.nodeName != "" && selectedNode != t.nodeName {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	pending, known := t.pending[claim.UID]
+	if claim.Status.Phase == v1.ClaimBound {
+		if !known {
+			// Already bound when we saw it first.
+			return
+		}
+		delete(t.pending, claim.UID)
+		t.bound = append(t.pruneBound(now), now)
+		if pending.unknown {
+			return
+		}
+		start := claim.CreationTimestamp.Time
+		if !pending.selectedNode.IsZero() {
+			start = pending.selectedNode
+		}
+		storageClassName := ""
+		if claim.Spec.StorageClassName != nil {
+			storageClassName = *claim.Spec.StorageClassName
+		}
+		t.observe(storageClassName, now.Sub(start).Seconds())
+		return
+	}
+
+	if hasSelectedNode && pending.selectedNode.IsZero() {
+		pending.selectedNode = now
+		if !known && claim.CreationTimestamp.Time.Before(t.started) {
+			// Node selection may have happened anytime before
+			// we started.
+			pending.unknown = true
+		}
+	}
+	t.pending[claim.UID] = pending
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestBoundLatencyTracker(t *testing.T) {
+	start := time.Now()
+	before := start.Add(-time.Hour)
+	sc := "fast"
+
+	claim := func(created time.Time, driver, node string, phase v1.PersistentVolumeClaimPhase) *v1.PersistentVolumeClaim {
+		claim := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				UID:               types.UID("uid"),
+				CreationTimestamp: metav1.NewTime(created),
+				Annotations:       map[string]string{annStorageProvisioner: driver},
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &sc,
+			},
+			Status: v1.PersistentVolumeClaimStatus{
+				Phase: phase,
+			},
+		}
+		if node != "" {
+			claim.Annotations[annSelectedNode] = node
+		}
+		return claim
+	}
+
+	type step struct {
+		after time.Duration
+		claim *v1.PersistentVolumeClaim
+	}
+	testcases := map[string]struct {
+		nodeName string
+		steps    []step
+		expected []float64
+	}{
+		"immediate binding": {
+			steps: []step{
+				{0, claim(start, driverName, "", v1.ClaimPending)},
+				{5 * time.Second, claim(start, driverName, "", v1.ClaimBound)},
+			},
+			expected: []float64{5},
+		},
+		"immediate binding, created before start": {
+			steps: []step{
+				{0, claim(before, driverName, "", v1.ClaimPending)},
+				{5 * time.Second, claim(before, driverName, "", v1.ClaimBound)},
+			},
+			expected: []float64{3605},
+		},
+		"late binding": {
+			steps: []step{
+				{0, claim(start, driverName, "", v1.ClaimPending)},
+				{10 * time.Second, claim(start, driverName, "node-1", v1.ClaimPending)},
+				{3 * time.Second, claim(start, driverName, "node-1", v1.ClaimBound)},
+			},
+			expected: []float64{3},
+		},
+		"late binding, node selected before start": {
+			steps: []step{
+				{0, claim(before, driverName, "node-1", v1.ClaimPending)},
+				{3 * time.Second, claim(before, driverName, "node-1", v1.ClaimBound)},
+			},
+		},
+		"already bound": {
+			steps: []step{
+				{0, claim(start, driverName, "", v1.ClaimBound)},
+			},
+		},
+		"other driver": {
+			steps: []step{
+				{0, claim(start, "other-driver", "", v1.ClaimPending)},
+				{5 * time.Second, claim(start, "other-driver", "", v1.ClaimBound)},
+			},
+		},
+		"own node": {
+			nodeName: "node-1",
+			steps: []step{
+				{0, claim(start, driverName, "", v1.ClaimPending)},
+				{10 * time.Second, claim(start, driverName, "node-1", v1.ClaimPending)},
+				{3 * time.Second, claim(start, driverName, "node-1", v1.ClaimBound)},
+			},
+			expected: []float64{3},
+		},
+		"other node": {
+			nodeName: "node-2",
+			steps: []step{
+				{0, claim(start, driverName, "", v1.ClaimPending)},
+				{10 * time.Second, claim(start, driverName, "node-1", v1.ClaimPending)},
+				{3 * time.Second, claim(start, driverName, "node-1", v1.ClaimBound)},
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			now := start
+			var observed []float64
+			tracker := newBoundLatencyTracker(driverName, tc.nodeName)
+			tracker.started = start
+			tracker.now = func() time.Time { return now }
+			tracker.observe = func(storageClassName string, seconds float64) {
+				if storageClassName != sc {
+					t.Errorf("expected storage class %q, got %q", sc, storageClassName)
+				}
+				observed = append(observed, seconds)
+			}
+
+			for i, step := range tc.steps {
+				now = now.Add(step.after)
+				if i == 0 {
+					tracker.OnAdd(step.claim)
+				} else {
+					tracker.OnUpdate(tc.steps[i-1].claim, step.claim)
+				}
+			}
+			if !reflect.DeepEqual(tc.expected, observed) {
+				t.Errorf("expected observations %v, got %v", tc.expected, observed)
+			}
+			if len(tracker.pending) != 0 {
+				t.Errorf("expected no pending PVCs, got %v", tracker.pending)
+			}
+		})
+	}
+}
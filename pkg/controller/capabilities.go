@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/rpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// CapabilitiesUpdater is implemented by everything that needs to know
+// about changed driver capabilities.
+type CapabilitiesUpdater interface {
+	UpdateCapabilities(pluginCapabilities rpc.PluginCapabilitySet, controllerCapabilities rpc.ControllerCapabilitySet)
+}
+
+// CapabilitiesUpdaterFunc turns a function into a CapabilitiesUpdater.
+type CapabilitiesUpdaterFunc func(pluginCapabilities rpc.PluginCapabilitySet, controllerCapabilities rpc.ControllerCapabilitySet)
+
+// UpdateCapabilities implements CapabilitiesUpdater.
+func (f CapabilitiesUpdaterFunc) UpdateCapabilities(pluginCapabilities rpc.PluginCapabilitySet, controllerCapabilities rpc.ControllerCapabilitySet) {
+	f(pluginCapabilities, controllerCapabilities)
+}
+
+// CapabilityRefresher periodically retrieves the capabilities of the
+// CSI driver and informs the updaters when they changed, for example
+// because the driver was upgraded.
+type CapabilityRefresher struct {
+	getCapabilities func() (rpc.PluginCapabilitySet, rpc.ControllerCapabilitySet, error)
+
+	mutex                  sync.Mutex
+	pluginCapabilities     rpc.PluginCapabilitySet
+	controllerCapabilities rpc.ControllerCapabilitySet
+	updaters               []CapabilitiesUpdater
+}
+
+// NewCapabilityRefresher creates a refresher which starts with the
+// capabilities that were retrieved during startup.
+func NewCapabilityRefresher(
+	getCapabilities func() (rpc.PluginCapabilitySet, rpc.ControllerCapabilitySet, error),
+	pluginCapabilities rpc.PluginCapabilitySet,
+	controllerCapabilities rpc.ControllerCapabilitySet,
+) *CapabilityRefresher {
+	return &CapabilityRefresher{
+		getCapabilities:        getCapabilities,
+		pluginCapabilities:     pluginCapabilities,
+		controllerCapabilities: controllerCapabilities,
+	}
+}
+
+// AddUpdater registers an updater. Must be called before Run.
+func (r *CapabilityRefresher) AddUpdater(updater CapabilitiesUpdater) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.updaters = append(r.updaters, updater)
+}
+
+// Run retrieves the capabilities once per interval until the context
+// is done.
+func (r *CapabilityRefresher) Run(ctx context.Context, interval time.Duration) {
+	klog.Infof("Refreshing CSI driver capabilities every %s", interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) { r.refresh() }, interval)
+}
+
+func (r *CapabilityRefresher) refresh() {
+	pluginCapabilities, controllerCapabilities, err := r.getCapabilities()
+	if err != nil {
+		// Keep the old capabilities, the driver might
+		// just be restarting.
+		klog.Warningf("Refreshing CSI driver capabilities failed: %v", err)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if reflect.DeepEqual(pluginCapabilities, r.pluginCapabilities) &&
+		reflect.DeepEqual(controllerCapabilities, r.controllerCapabilities) {
+		return
+	}
+	klog.Infof("CSI driver capabilities changed: plugin %v -> %v, controller %v -> %v",
+		r.pluginCapabilities, pluginCapabilities,
+		r.controllerCapabilities, controllerCapabilities)
+	r.pluginCapabilities = pluginCapabilities
+	r.controllerCapabilities = controllerCapabilities
+	for _, updater := range r.updaters {
+		updater.UpdateCapabilities(pluginCapabilities, controllerCapabilities)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/rpc"
+)
+
+func TestCapabilityRefresher(t *testing.T) {
+	pluginCapabilities := rpc.PluginCapabilitySet{
+		csi.PluginCapability_Service_CONTROLLER_SERVICE: true,
+	}
+	initial := rpc.ControllerCapabilitySet{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME: true,
+	}
+	upgraded := rpc.ControllerCapabilitySet{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME:   true,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT: true,
+	}
+
+	current := initial
+	var getErr error
+	r := NewCapabilityRefresher(func() (rpc.PluginCapabilitySet, rpc.ControllerCapabilitySet, error) {
+		return pluginCapabilities, current, getErr
+	}, pluginCapabilities, initial)
+
+	p := &csiProvisioner{
+		pluginCapabilities:     pluginCapabilities,
+		controllerCapabilities: initial,
+	}
+	updates := 0
+	r.AddUpdater(p)
+	r.AddUpdater(CapabilitiesUpdaterFunc(func(rpc.PluginCapabilitySet, rpc.ControllerCapabilitySet) { updates++ }))
+
+	snapshot := &requiredCapabilities{snapshot: true}
+	if err := p.checkDriverCapabilities(snapshot); err == nil {
+		t.Fatal("expected error for snapshot restore with initial capabilities")
+	}
+
+	r.refresh()
+	if updates != 0 {
+		t.Errorf("expected no update for unchanged capabilities, got %d", updates)
+	}
+
+	current = upgraded
+	getErr = errors.New("driver restarting")
+	r.refresh()
+	if updates != 0 {
+		t.Errorf("expected no update after error, got %d", updates)
+	}
+
+	getErr = nil
+	r.refresh()
+	if updates != 1 {
+		t.Errorf("expected one update after upgrade, got %d", updates)
+	}
+	if err := p.checkDriverCapabilities(snapshot); err != nil {
+		t.Errorf("unexpected error for snapshot restore after upgrade: %v", err)
+	}
+
+	r.refresh()
+	if updates != 1 {
+		t.Errorf("expected no further update, got %d", updates)
+	}
+}
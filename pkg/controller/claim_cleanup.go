@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/panicguard"
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// transientClaimAnnotations are added to PVCs by the external-provisioner
+// while provisioning and have no meaning once the PVC is bound.
+var transientClaimAnnotations = []string{
+	annProvisioningFailedReason,
+	annProvisioningFailedMessage,
+}
+
+// ClaimMetadataCleaner removes transient annotations and a left over
+// pvcProvisioningFinalizer from bound PVCs of the drivers with a single
+// patch per PVC, so that the PVC metadata ends up the same as before
+// provisioning.
+type ClaimMetadataCleaner struct {
+	driverNames sets.String
+	client      kubernetes.Interface
+	claimLister corelisters.PersistentVolumeClaimLister
+	queue       workqueue.RateLimitingInterface
+}
+
+// NewClaimMetadataCleaner creates a cleaner which gets notified about
+// claim changes by the informer.
+func NewClaimMetadataCleaner(
+	driverNames []string,
+	client kubernetes.Interface,
+	claimLister corelisters.PersistentVolumeClaimLister,
+	claimInformer cache.SharedInformer,
+	queue workqueue.RateLimitingInterface,
+) *ClaimMetadataCleaner {
+	c := &ClaimMetadataCleaner{
+		driverNames: sets.NewString(driverNames...),
+		client:      client,
+		claimLister: claimLister,
+		queue:       queue,
+	}
+	claimInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueClaim,
+		UpdateFunc: func(_ interface{}, newObj interface{}) { c.enqueueClaim(newObj) },
+	})
+	return c
+}
+
+// Run is the main ClaimMetadataCleaner handler.
+func (c *ClaimMetadataCleaner) Run(ctx context.Context) {
+	klog.Info("Starting ClaimMetadataCleaner")
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+
+	klog.Info("Started ClaimMetadataCleaner")
+	<-ctx.Done()
+	klog.Info("Shutting down ClaimMetadataCleaner")
+}
+
+func (c *ClaimMetadataCleaner) enqueueClaim(obj interface{}) {
+	claim, ok := obj.(*v1.PersistentVolumeClaim)
+	if !ok || !c.needsCleanup(claim) {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(claim)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *ClaimMetadataCleaner) needsCleanup(claim *v1.PersistentVolumeClaim) bool {
+	if claim.Status.Phase != v1.ClaimBound ||
+		(!c.driverNames.Has(claim.Annotations[annStorageProvisioner]) && !c.driverNames.Has(claim.Annotations[annMigratedTo])) {
+		return false
+	}
+	for _, ann := range transientClaimAnnotations {
+		if _, ok := claim.Annotations[ann]; ok {
+			return true
+		}
+	}
+	return checkFinalizer(claim, pvcProvisioningFinalizer)
+}
+
+func (c *ClaimMetadataCleaner) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
+	}
+}
+
+func (c *ClaimMetadataCleaner) processNextWorkItem(ctx context.Context) (more bool) {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	more = true
+	defer c.queue.Done(obj)
+	defer panicguard.Recover(c.queue, "claimcleanup", obj)
+
+	key, ok := obj.(string)
+	if !ok {
+		c.queue.Forget(obj)
+		utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+	if err := c.syncClaim(ctx, key); err != nil {
+		klog.Warningf("Retrying removing transient annotations from PVC %q after %v failures: %v", key, c.queue.NumRequeues(obj), err)
+		c.queue.AddRateLimited(obj)
+	} else {
+		c.queue.Forget(obj)
+	}
+	return true
+}
+
+func (c *ClaimMetadataCleaner) syncClaim(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	claim, err := c.claimLister.PersistentVolumeClaims(namespace).Get(name)
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !c.needsCleanup(claim) {
+		return nil
+	}
+
+	// A JSON merge patch with null values removes the annotations,
+	// regardless of what else changed in the meantime.
+	annotations := map[string]interface{}{}
+	for _, ann := range transientClaimAnnotations {
+		if _, ok := claim.Annotations[ann]; ok {
+			annotations[ann] = nil
+		}
+	}
+	metadata := map[string]interface{}{
+		"annotations": annotations,
+	}
+	if checkFinalizer(claim, pvcProvisioningFinalizer) {
+		// The list gets replaced, which is only safe when
+		// nothing changed in the meantime.
+		metadata["finalizers"] = removeFinalizer(claim.Finalizers, pvcProvisioningFinalizer)
+		metadata["resourceVersion"] = claim.ResourceVersion
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": metadata,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.client.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	klog.V(5).Infof("Removed transient metadata from bound PVC %s", key)
+	return nil
+}
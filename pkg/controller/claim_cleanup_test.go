@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestClaimMetadataCleaner(t *testing.T) {
+	failed := map[string]string{
+		annStorageProvisioner:        driverName,
+		annProvisioningFailedReason:  "SnapshotDriverMismatch",
+		annProvisioningFailedMessage: "some message",
+		"example.com/other":          "keep",
+	}
+	cleaned := map[string]string{
+		annStorageProvisioner: driverName,
+		"example.com/other":   "keep",
+	}
+	otherDriver := map[string]string{
+		annStorageProvisioner:       "other-driver",
+		annProvisioningFailedReason: "SnapshotDriverMismatch",
+	}
+
+	testcases := map[string]struct {
+		phase               v1.PersistentVolumeClaimPhase
+		annotations         map[string]string
+		finalizers          []string
+		expectedAnnotations map[string]string
+		expectedFinalizers  []string
+	}{
+		"bound": {
+			phase:               v1.ClaimBound,
+			annotations:         failed,
+			expectedAnnotations: cleaned,
+		},
+		"pending": {
+			phase:               v1.ClaimPending,
+			annotations:         failed,
+			expectedAnnotations: failed,
+		},
+		"clean": {
+			phase:               v1.ClaimBound,
+			annotations:         cleaned,
+			expectedAnnotations: cleaned,
+		},
+		"other driver": {
+			phase:               v1.ClaimBound,
+			annotations:         otherDriver,
+			expectedAnnotations: otherDriver,
+		},
+		"bound with finalizer": {
+			phase:               v1.ClaimBound,
+			annotations:         cleaned,
+			finalizers:          []string{"example.com/other", pvcProvisioningFinalizer},
+			expectedAnnotations: cleaned,
+			expectedFinalizers:  []string{"example.com/other"},
+		},
+		"pending with finalizer": {
+			phase:               v1.ClaimPending,
+			annotations:         cleaned,
+			finalizers:          []string{pvcProvisioningFinalizer},
+			expectedAnnotations: cleaned,
+			expectedFinalizers:  []string{pvcProvisioningFinalizer},
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			claim := &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-claim",
+					Namespace:   "default",
+					Annotations: tc.annotations,
+					Finalizers:  tc.finalizers,
+				},
+				Status: v1.PersistentVolumeClaimStatus{
+					Phase: tc.phase,
+				},
+			}
+			client := fakeclientset.NewSimpleClientset(claim)
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			indexer.Add(claim)
+			c := &ClaimMetadataCleaner{
+				driverNames: sets.NewString(driverName),
+				client:      client,
+				claimLister: corelisters.NewPersistentVolumeClaimLister(indexer),
+			}
+
+			ctx := context.Background()
+			if err := c.syncClaim(ctx, "default/test-claim"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			claim, err := client.CoreV1().PersistentVolumeClaims("default").Get(ctx, "test-claim", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(claim.Annotations, tc.expectedAnnotations) {
+				t.Errorf("expected annotations %v, got %v", tc.expectedAnnotations, claim.Annotations)
+			}
+			if !reflect.DeepEqual(claim.Finalizers, tc.expectedFinalizers) {
+				t.Errorf("expected finalizers %v, got %v", tc.expectedFinalizers, claim.Finalizers)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-utils/rpc"
+	"github.com/kubernetes-csi/external-provisioner/pkg/panicguard"
 	v1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -14,8 +15,11 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
@@ -39,6 +43,7 @@ type CloningProtectionController struct {
 	claimLister   corelisters.PersistentVolumeClaimLister
 	claimInformer cache.SharedInformer
 	claimQueue    workqueue.RateLimitingInterface
+	eventRecorder record.EventRecorder
 }
 
 // NewCloningProtectionController creates new controller for additional CSI claim protection capabilities
@@ -52,11 +57,17 @@ func NewCloningProtectionController(
 	if !controllerCapabilities[csi.ControllerServiceCapability_RPC_CLONE_VOLUME] {
 		return nil
 	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.Infof)
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "external-provisioner"})
+
 	controller := &CloningProtectionController{
 		client:        client,
 		claimLister:   claimLister,
 		claimInformer: claimInformer,
 		claimQueue:    claimQueue,
+		eventRecorder: eventRecorder,
 	}
 	return controller
 }
@@ -68,8 +79,27 @@ func (p *CloningProtectionController) Run(ctx context.Context, threadiness int)
 	defer p.claimQueue.ShutDown()
 
 	claimHandler := cache.ResourceEventHandlerFuncs{
-		AddFunc:    func(obj interface{}) { p.enqueueClaimUpdate(ctx, obj) },
-		UpdateFunc: func(_ interface{}, newObj interface{}) { p.enqueueClaimUpdate(ctx, newObj) },
+		AddFunc: func(obj interface{}) {
+			p.enqueueClaimUpdate(ctx, obj)
+			if claim, ok := obj.(*v1.PersistentVolumeClaim); ok && doneWithSourcePV(claim) {
+				p.enqueueSourcePV(claim)
+			}
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			p.enqueueClaimUpdate(ctx, newObj)
+			oldClaim, _ := oldObj.(*v1.PersistentVolumeClaim)
+			if claim, ok := newObj.(*v1.PersistentVolumeClaim); ok && doneWithSourcePV(claim) && (oldClaim == nil || !doneWithSourcePV(oldClaim)) {
+				p.enqueueSourcePV(claim)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if claim, ok := obj.(*v1.PersistentVolumeClaim); ok {
+				p.enqueueSourcePV(claim)
+			}
+		},
 	}
 	p.claimInformer.AddEventHandlerWithResyncPeriod(claimHandler, controller.DefaultResyncPeriod)
 
@@ -92,23 +122,29 @@ func (p *CloningProtectionController) runClaimWorker(ctx context.Context) {
 }
 
 // processNextClaimWorkItem processes items from claimQueue
-func (p *CloningProtectionController) processNextClaimWorkItem(ctx context.Context) bool {
+func (p *CloningProtectionController) processNextClaimWorkItem(ctx context.Context) (more bool) {
 	obj, shutdown := p.claimQueue.Get()
 	if shutdown {
 		return false
 	}
+	more = true
+	defer panicguard.Recover(p.claimQueue, "cloning", obj)
 
 	err := func(obj interface{}) error {
 		defer p.claimQueue.Done(obj)
-		var key string
-		var ok bool
-		if key, ok = obj.(string); !ok {
+		var err error
+		switch key := obj.(type) {
+		case string:
+			err = p.syncClaimHandler(ctx, key)
+		case sourcePVKey:
+			err = p.syncSourcePV(ctx, key)
+		default:
 			p.claimQueue.Forget(obj)
-			return fmt.Errorf("expected string in workqueue but got %#v", obj)
+			return fmt.Errorf("expected string or source PV in workqueue but got %#v", obj)
 		}
 
-		if err := p.syncClaimHandler(ctx, key); err != nil {
-			klog.Warningf("Retrying syncing claim %q after %v failures", key, p.claimQueue.NumRequeues(obj))
+		if err != nil {
+			klog.Warningf("Retrying syncing %v after %v failures", obj, p.claimQueue.NumRequeues(obj))
 			p.claimQueue.AddRateLimited(obj)
 		} else {
 			p.claimQueue.Forget(obj)
@@ -210,7 +246,84 @@ func (p *CloningProtectionController) syncClaim(ctx context.Context, claim *v1.P
 			klog.Infof("failed to remove clone finalizer from PVC %v", claim.Name)
 			return err
 		}
+		return nil
+	}
+	p.eventRecorder.Event(claim, v1.EventTypeNormal, "CloningProtectionRemoved",
+		fmt.Sprintf("Removed finalizer %s because no PVC is being cloned from this PVC anymore", pvcCloneFinalizer))
+
+	return nil
+}
+
+// sourcePVKey identifies a Released PV that claims in the namespace
+// were cloned from with the annCloneFromPV annotation.
+type sourcePVKey struct {
+	namespace string
+	pvName    string
+}
+
+func (k sourcePVKey) String() string {
+	return fmt.Sprintf("source PV %s of claims in namespace %s", k.pvName, k.namespace)
+}
+
+// doneWithSourcePV returns true if the claim was cloned from a Released
+// PV and no longer needs it because it is not pending anymore or is
+// being deleted.
+func doneWithSourcePV(claim *v1.PersistentVolumeClaim) bool {
+	return claim.Annotations[annCloneFromPV] != "" &&
+		(claim.Status.Phase != v1.ClaimPending || claim.DeletionTimestamp != nil)
+}
+
+// enqueueSourcePV queues the PV that the claim was cloned from, if any,
+// to check whether its finalizer can be removed.
+func (p *CloningProtectionController) enqueueSourcePV(claim *v1.PersistentVolumeClaim) {
+	pvName := claim.Annotations[annCloneFromPV]
+	if pvName == "" {
+		return
+	}
+	p.claimQueue.Add(sourcePVKey{namespace: claim.Namespace, pvName: pvName})
+}
+
+// syncSourcePV removes the finalizer from a Released PV once no pending
+// claim is being cloned from it anymore. Claims which are still
+// pending queue the PV again when they are done with it.
+func (p *CloningProtectionController) syncSourcePV(ctx context.Context, key sourcePVKey) error {
+	pv, err := p.client.CoreV1().PersistentVolumes().Get(ctx, key.pvName, metav1.GetOptions{})
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !checkFinalizer(pv, pvcCloneFinalizer) {
+		return nil
+	}
+
+	pvcList, err := p.claimLister.PersistentVolumeClaims(key.namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, pvc := range pvcList {
+		if pvc.Annotations[annCloneFromPV] == pv.Name && !doneWithSourcePV(pvc) {
+			klog.V(4).Infof("PVC %s/%s is still being cloned from PV %s", pvc.Namespace, pvc.Name, pv.Name)
+			return nil
+		}
 	}
 
+	finalizers := make([]string, 0)
+	for _, finalizer := range pv.Finalizers {
+		if finalizer != pvcCloneFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	pv = pv.DeepCopy()
+	pv.Finalizers = finalizers
+	if _, err := p.client.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	p.eventRecorder.Event(pv, v1.EventTypeNormal, "CloningProtectionRemoved",
+		fmt.Sprintf("Removed finalizer %s because no PVC is being cloned from this PV anymore", pvcCloneFinalizer))
 	return nil
 }
@@ -13,6 +13,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/record"
 	fakeclientset "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/util/workqueue"
 )
@@ -74,10 +75,12 @@ func TestCloneFinalizerRemoval(t *testing.T) {
 		cloneSource     runtime.Object
 		expectFinalizer bool
 		expectError     error
+		expectEvent     bool
 	}{
 		"delete source pvc with no cloning in progress": {
 			cloneSource:   pvcFinalizers(baseClaim(), pvcCloneFinalizer),
 			initialClaims: []runtime.Object{pvcDataSourceClone(srcName, pvcNamed(dstName, baseClaim()))},
+			expectEvent:   true,
 		},
 		"delete source pvc when destination pvc status is claim pending": {
 			cloneSource:     pvcFinalizers(baseClaim(), pvcCloneFinalizer),
@@ -97,9 +100,11 @@ func TestCloneFinalizerRemoval(t *testing.T) {
 		"delete source pvc located in another namespace should not block": {
 			cloneSource:   pvcNamespaced(srcNamespace+"1", pvcFinalizers(baseClaim(), pvcCloneFinalizer)),
 			initialClaims: []runtime.Object{pvcPhase(v1.ClaimPending, pvcDataSourceClone(srcName, pvcNamed(dstName+"1", baseClaim())))},
+			expectEvent:   true,
 		},
 		"delete source pvc which is not cloned by any other pvc": {
 			cloneSource: pvcFinalizers(baseClaim(), pvcCloneFinalizer),
+			expectEvent: true,
 		},
 		"delete source pvc without finalizer": {
 			cloneSource: baseClaim(),
@@ -115,6 +120,8 @@ func TestCloneFinalizerRemoval(t *testing.T) {
 			objects := append(tc.initialClaims, tc.cloneSource)
 			clientSet := fakeclientset.NewSimpleClientset(objects...)
 			cloningProtector := fakeCloningProtector(clientSet, objects...)
+			recorder := record.NewFakeRecorder(10)
+			cloningProtector.eventRecorder = recorder
 
 			// Simulate Delete behavior
 			claim := pvcDeletionMarked(tc.cloneSource.(*v1.PersistentVolumeClaim))
@@ -137,6 +144,20 @@ func TestCloneFinalizerRemoval(t *testing.T) {
 			} else if tc.expectError != nil && err != nil && tc.expectError.Error() != err.Error() {
 				t.Errorf("Unexpected error during 'syncClaim' run:\n\t%s\nExpected:\n\t%s", err, tc.expectError)
 			}
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if tc.expectEvent {
+				expected := "Normal CloningProtectionRemoved Removed finalizer " + pvcCloneFinalizer + " because no PVC is being cloned from this PVC anymore"
+				if len(events) != 1 || events[0] != expected {
+					t.Errorf("Expected event %q, got: %v", expected, events)
+				}
+			} else if len(events) > 0 {
+				t.Errorf("Unexpected events: %v", events)
+			}
 		})
 	}
 
@@ -178,6 +199,98 @@ func TestEnqueueClaimUpadate(t *testing.T) {
 	}
 }
 
+// TestSourcePVFinalizerRemoval tests finalizer removal from a Released PV that claims were cloned from
+func TestSourcePVFinalizerRemoval(t *testing.T) {
+	sourcePV := func(finalizers ...string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "released-pv", Finalizers: finalizers}}
+	}
+	clonedClaim := func(phase v1.PersistentVolumeClaimPhase) *v1.PersistentVolumeClaim {
+		claim := pvcPhase(phase, pvcNamed(dstName, baseClaim()))
+		claim.Annotations = map[string]string{annCloneFromPV: "released-pv"}
+		return claim
+	}
+	testcases := map[string]struct {
+		pv              *v1.PersistentVolume
+		claims          []runtime.Object
+		expectFinalizer bool
+		expectEvent     bool
+	}{
+		"cloning finished": {
+			pv:          sourcePV(pvcCloneFinalizer),
+			claims:      []runtime.Object{clonedClaim(v1.ClaimBound)},
+			expectEvent: true,
+		},
+		"cloning in progress": {
+			pv:              sourcePV(pvcCloneFinalizer),
+			claims:          []runtime.Object{clonedClaim(v1.ClaimPending)},
+			expectFinalizer: true,
+		},
+		"pending claim being deleted": {
+			pv:          sourcePV(pvcCloneFinalizer),
+			claims:      []runtime.Object{pvcDeletionMarked(clonedClaim(v1.ClaimPending))},
+			expectEvent: true,
+		},
+		"pending claim in another namespace": {
+			pv:          sourcePV(pvcCloneFinalizer),
+			claims:      []runtime.Object{pvcNamespaced(srcNamespace+"1", clonedClaim(v1.ClaimPending))},
+			expectEvent: true,
+		},
+		"no claims": {
+			pv:          sourcePV(pvcCloneFinalizer),
+			expectEvent: true,
+		},
+		"PV without finalizer": {
+			pv: sourcePV(),
+		},
+		"PV does not exist": {},
+	}
+
+	for k, tc := range testcases {
+		tc := tc
+		t.Run(k, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			objects := tc.claims
+			if tc.pv != nil {
+				objects = append(objects[:len(objects):len(objects)], tc.pv)
+			}
+			clientSet := fakeclientset.NewSimpleClientset(objects...)
+			cloningProtector := fakeCloningProtector(clientSet, tc.claims...)
+			recorder := record.NewFakeRecorder(10)
+			cloningProtector.eventRecorder = recorder
+
+			if err := cloningProtector.syncSourcePV(ctx, sourcePVKey{namespace: srcNamespace, pvName: "released-pv"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tc.pv != nil {
+				pv, err := clientSet.CoreV1().PersistentVolumes().Get(ctx, tc.pv.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("get PV: %v", err)
+				}
+				if hasFinalizer := checkFinalizer(pv, pvcCloneFinalizer); hasFinalizer != tc.expectFinalizer {
+					t.Errorf("expected finalizer %v, got %v", tc.expectFinalizer, hasFinalizer)
+				}
+			}
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if tc.expectEvent {
+				expected := "Normal CloningProtectionRemoved Removed finalizer " + pvcCloneFinalizer + " because no PVC is being cloned from this PV anymore"
+				if len(events) != 1 || events[0] != expected {
+					t.Errorf("Expected event %q, got: %v", expected, events)
+				}
+			} else if len(events) > 0 {
+				t.Errorf("Unexpected events: %v", events)
+			}
+		})
+	}
+}
+
 func fakeCloningProtector(client *fakeclientset.Clientset, objects ...runtime.Object) *CloningProtectionController {
 	utilruntime.ReallyCrash = false
 	controllerCapabilities := rpc.ControllerCapabilitySet{
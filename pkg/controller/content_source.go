@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// prefixedContentSourceKey is a storage class parameter which declares
+// which kind of data source PVCs of the class may have. It is meant for
+// classes where the backend populates new volumes itself, for example
+// from a golden image, and a data source would conflict with that.
+const prefixedContentSourceKey = csiParameterPrefix + "content-source"
+
+const (
+	// contentSourceAny allows all data sources. This is the default.
+	contentSourceAny = "any"
+	// contentSourceNone rejects all data sources.
+	contentSourceNone = "none"
+	// contentSourceSnapshot only allows restoring from snapshots.
+	contentSourceSnapshot = "snapshot"
+	// contentSourceVolume only allows cloning volumes.
+	contentSourceVolume = "volume"
+)
+
+// checkContentSource returns a permanentError when the data source
+// of the claim is not allowed by the storage class.
+func checkContentSource(claim *v1.PersistentVolumeClaim, sc *storagev1.StorageClass, rc *requiredCapabilities) error {
+	policy, ok := sc.Parameters[prefixedContentSourceKey]
+	if !ok {
+		return nil
+	}
+	var allowed bool
+	switch policy {
+	case contentSourceAny:
+		return nil
+	case contentSourceNone:
+		allowed = !rc.snapshot && !rc.clone
+	case contentSourceSnapshot:
+		allowed = !rc.clone
+	case contentSourceVolume:
+		allowed = !rc.snapshot
+	default:
+		return fmt.Errorf("invalid value %q for storage class parameter %s, must be one of %q, %q, %q or %q",
+			policy, prefixedContentSourceKey, contentSourceAny, contentSourceNone, contentSourceSnapshot, contentSourceVolume)
+	}
+	if allowed {
+		return nil
+	}
+	source := "volume"
+	if rc.snapshot {
+		source = "snapshot"
+	}
+	return &permanentError{
+		reason: "ContentSourceConflict",
+		message: fmt.Sprintf("storage class %s only allows content source %q, but PVC %s/%s requests a %s as data source",
+			sc.Name, policy, claim.Namespace, claim.Name, source),
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckContentSource(t *testing.T) {
+	none := &requiredCapabilities{}
+	snapshot := &requiredCapabilities{snapshot: true}
+	clone := &requiredCapabilities{clone: true}
+
+	testcases := map[string]struct {
+		policy         string
+		rc             *requiredCapabilities
+		expectConflict bool
+		expectOtherErr bool
+	}{
+		"no policy":               {rc: snapshot},
+		"any":                     {policy: contentSourceAny, rc: clone},
+		"none without source":     {policy: contentSourceNone, rc: none},
+		"none with snapshot":      {policy: contentSourceNone, rc: snapshot, expectConflict: true},
+		"none with clone":         {policy: contentSourceNone, rc: clone, expectConflict: true},
+		"snapshot with snapshot":  {policy: contentSourceSnapshot, rc: snapshot},
+		"snapshot with clone":     {policy: contentSourceSnapshot, rc: clone, expectConflict: true},
+		"snapshot without source": {policy: contentSourceSnapshot, rc: none},
+		"volume with clone":       {policy: contentSourceVolume, rc: clone},
+		"volume with snapshot":    {policy: contentSourceVolume, rc: snapshot, expectConflict: true},
+		"invalid":                 {policy: "golden", rc: none, expectOtherErr: true},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			claim := &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default"},
+			}
+			sc := &storagev1.StorageClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "golden-images"},
+				Parameters: map[string]string{},
+			}
+			if tc.policy != "" {
+				sc.Parameters[prefixedContentSourceKey] = tc.policy
+			}
+			err := checkContentSource(claim, sc, tc.rc)
+			var permanentErr *permanentError
+			isConflict := errors.As(err, &permanentErr)
+			switch {
+			case tc.expectConflict:
+				if !isConflict || permanentErr.reason != "ContentSourceConflict" {
+					t.Errorf("expected ContentSourceConflict, got %v", err)
+				}
+			case tc.expectOtherErr:
+				if err == nil || isConflict {
+					t.Errorf("expected invalid parameter error, got %v", err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
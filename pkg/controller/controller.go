@@ -23,6 +23,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -38,6 +40,7 @@ import (
 	_ "k8s.io/apimachinery/pkg/util/json"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/validation"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -48,6 +51,8 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
+	k8smetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/util"
@@ -55,6 +60,8 @@ import (
 	"github.com/kubernetes-csi/csi-lib-utils/connection"
 	"github.com/kubernetes-csi/csi-lib-utils/metrics"
 	"github.com/kubernetes-csi/csi-lib-utils/rpc"
+	"github.com/kubernetes-csi/external-provisioner/pkg/features"
+	"github.com/kubernetes-csi/external-provisioner/pkg/otlp"
 	snapapi "github.com/kubernetes-csi/external-snapshotter/client/v3/apis/volumesnapshot/v1beta1"
 	snapclientset "github.com/kubernetes-csi/external-snapshotter/client/v3/clientset/versioned"
 )
@@ -95,6 +102,10 @@ const (
 	prefixedControllerExpandSecretNameKey      = csiParameterPrefix + "controller-expand-secret-name"
 	prefixedControllerExpandSecretNamespaceKey = csiParameterPrefix + "controller-expand-secret-namespace"
 
+	// prefixedProvisionerServiceAccountKey selects the service account
+	// in the namespace of the PVC for CSIDriver.spec.tokenRequests.
+	prefixedProvisionerServiceAccountKey = csiParameterPrefix + "provisioner-service-account"
+
 	// [Deprecated] CSI Parameters that are put into fields but
 	// NOT stripped from the parameters passed to CreateVolume
 	provisionerSecretNameKey      = "csiProvisionerSecretName"
@@ -121,9 +132,10 @@ const (
 	backoffFactor   = 1.2
 	backoffSteps    = 10
 
-	snapshotKind     = "VolumeSnapshot"
-	snapshotAPIGroup = snapapi.GroupName       // "snapshot.storage.k8s.io"
-	pvcKind          = "PersistentVolumeClaim" // Native types don't require an API group
+	snapshotKind        = "VolumeSnapshot"
+	snapshotContentKind = "VolumeSnapshotContent"
+	snapshotAPIGroup    = snapapi.GroupName       // "snapshot.storage.k8s.io"
+	pvcKind             = "PersistentVolumeClaim" // Native types don't require an API group
 
 	tokenPVNameKey       = "pv.name"
 	tokenPVCNameKey      = "pvc.name"
@@ -137,6 +149,35 @@ const (
 	annStorageProvisioner = "volume.beta.kubernetes.io/storage-provisioner"
 	annSelectedNode       = "volume.kubernetes.io/selected-node"
 
+	// annCloneFromPV can be set on a PVC without data source to request
+	// a new volume which is a clone of a Released PV of the same driver.
+	annCloneFromPV = "csi.storage.k8s.io/clone-from-pv"
+
+	// annProvisioningLatency is set on new PVs when enabled with
+	// --latency-annotations. See formatProvisioningLatency.
+	annProvisioningLatency = "csi.storage.k8s.io/provisioning-latency"
+
+	// annProvisioningFailedReason and annProvisioningFailedMessage are
+	// set on a PVC when provisioning stopped because it cannot succeed.
+	// Other controllers can check for them instead of parsing events.
+	// They get removed by the ClaimMetadataCleaner once the PVC is bound.
+	annProvisioningFailedReason  = "csi.storage.k8s.io/provisioning-failed-reason"
+	annProvisioningFailedMessage = "csi.storage.k8s.io/provisioning-failed-message"
+
+	// annNodeDeploymentImmediateBinding can be set to "false" on a
+	// storage class with immediate binding to keep external-provisioner
+	// instances in node deployment from claiming its PVCs, for example
+	// because the class is meant for a central deployment.
+	annNodeDeploymentImmediateBinding = "csi.storage.k8s.io/node-deployment-immediate-binding"
+
+	// annDeletionSecretRefName and annDeletionSecretRefNamespace are set
+	// on new PVs to the provisioner secret that was used for CreateVolume.
+	// Delete uses them instead of the current storage class, which may
+	// have been modified or removed since then. Both are empty when no
+	// secret was used.
+	annDeletionSecretRefName      = "volume.kubernetes.io/provisioner-deletion-secret-name"
+	annDeletionSecretRefNamespace = "volume.kubernetes.io/provisioner-deletion-secret-namespace"
+
 	snapshotNotBound = "snapshot %s not bound"
 
 	pvcCloneFinalizer = "provisioner.storage.kubernetes.io/cloning-protection"
@@ -225,6 +266,13 @@ type NodeDeployment struct {
 	BaseDelay time.Duration
 	// MaxDelay is the maximum for the initial wait time.
 	MaxDelay time.Duration
+	// OrphanedVolumes determines whether this instance also deletes
+	// volumes which are not accessible by any existing node.
+	OrphanedVolumes OrphanedVolumePolicy
+	// NodeLister and CSINodeLister must have all Node and CSINode
+	// objects when OrphanedVolumes is enabled.
+	NodeLister    corelisters.NodeLister
+	CSINodeLister storagelistersv1.CSINodeLister
 }
 
 type internalNodeDeployment struct {
@@ -235,15 +283,20 @@ type internalNodeDeployment struct {
 type csiProvisioner struct {
 	client                                kubernetes.Interface
 	csiClient                             csi.ControllerClient
-	grpcClient                            *grpc.ClientConn
+	grpcClient                            grpc.ClientConnInterface
 	snapshotClient                        snapclientset.Interface
 	timeout                               time.Duration
+	timeoutLock                           sync.RWMutex
+	operationTimeouts                     OperationTimeouts
 	identity                              string
 	volumeNamePrefix                      string
 	defaultFSType                         string
 	volumeNameUUIDLength                  int
+	volumeNameTemplate                    *template.Template
+	maxVolumeSize                         int64
 	config                                *rest.Config
 	driverName                            string
+	capabilitiesMutex                     sync.RWMutex
 	pluginCapabilities                    rpc.PluginCapabilitySet
 	controllerCapabilities                rpc.ControllerCapabilitySet
 	supportsMigrationFromInTreePluginName string
@@ -255,9 +308,82 @@ type csiProvisioner struct {
 	nodeLister                            corelisters.NodeLister
 	claimLister                           corelisters.PersistentVolumeClaimLister
 	vaLister                              storagelistersv1.VolumeAttachmentLister
+	csiDriverLister                       storagelistersv1.CSIDriverLister
+	secretLister                          corelisters.SecretLister
+	pvLister                              corelisters.PersistentVolumeLister
+	maxRequisiteTopologies                int
+	topologyLimitStrategy                 TopologyLimitStrategy
+	topologyMode                          TopologyMode
+	latencyAnnotations                    bool
+	scheduler                             *StorageClassScheduler
+	provisioningFinalizer                 bool
 	extraCreateMetadata                   bool
 	eventRecorder                         record.EventRecorder
 	nodeDeployment                        *internalNodeDeployment
+
+	// protectedClaims contains the UIDs of PVCs which got the
+	// pvcProvisioningFinalizer.
+	protectedClaims sync.Map
+
+	// detachWaits maps the names of PVs whose deletion was postponed
+	// because of a VolumeAttachment to the time of the first attempt.
+	detachWaits sync.Map
+
+	// inFlight is used for CreateVolume calls when
+	// OperationTimeouts.CreateVolumeInFlight is set.
+	inFlight inFlightCreates
+}
+
+var deletionsDelayedByAttachment = k8smetrics.NewCounter(
+	&k8smetrics.CounterOpts{
+		Name:           "persistentvolume_deletion_delayed_by_attachment_total",
+		Help:           "Number of times that deleting a volume was postponed because a VolumeAttachment for its PV still existed.",
+		StabilityLevel: k8smetrics.ALPHA,
+	},
+)
+
+var deletionDetachWait = k8smetrics.NewHistogram(
+	&k8smetrics.HistogramOpts{
+		Name:           "persistentvolume_deletion_detach_wait_seconds",
+		Help:           "Time between the first attempt to delete a volume that was postponed because a VolumeAttachment for its PV still existed and the attempt which found no VolumeAttachment anymore.",
+		Buckets:        []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+		StabilityLevel: k8smetrics.ALPHA,
+	},
+)
+
+var provisioningFailedPermanently = k8smetrics.NewCounterVec(
+	&k8smetrics.CounterOpts{
+		Name:           "persistentvolumeclaim_provisioning_failed_permanently_total",
+		Help:           "Number of CreateVolume calls which failed with an error that retrying the same call cannot fix, by gRPC status code.",
+		StabilityLevel: k8smetrics.ALPHA,
+	},
+	[]string{"code"},
+)
+
+var unsupportedLifecycleMode = k8smetrics.NewCounterVec(
+	&k8smetrics.CounterOpts{
+		Name:           "persistentvolumeclaim_provisioning_lifecycle_mode_not_supported_total",
+		Help:           "Number of provisioning attempts which were rejected because the CSIDriver object does not list the persistent volume lifecycle mode, by driver name.",
+		StabilityLevel: k8smetrics.ALPHA,
+	},
+	[]string{"driver_name"},
+)
+
+var storageClassTranslations = k8smetrics.NewCounterVec(
+	&k8smetrics.CounterOpts{
+		Name:           "storageclass_in_tree_translations_total",
+		Help:           "Number of times that the parameters of a storage class for a migrated in-tree plugin were translated to CSI parameters while provisioning, by plugin name and result.",
+		StabilityLevel: k8smetrics.ALPHA,
+	},
+	[]string{"plugin_name", "result"},
+)
+
+func init() {
+	legacyregistry.MustRegister(deletionsDelayedByAttachment)
+	legacyregistry.MustRegister(deletionDetachWait)
+	legacyregistry.MustRegister(provisioningFailedPermanently)
+	legacyregistry.MustRegister(storageClassTranslations)
+	legacyregistry.MustRegister(unsupportedLifecycleMode)
 }
 
 var _ controller.Provisioner = &csiProvisioner{}
@@ -270,7 +396,14 @@ var (
 	provisionerIDKey = "storage.kubernetes.io/csiProvisionerIdentity"
 )
 
-func Connect(address string, metricsManager metrics.CSIMetricsManager) (*grpc.ClientConn, error) {
+// Connect connects to a CSI endpoint and blocks until that succeeds.
+// Loss of the connection to a Unix domain socket ends the process. For
+// tcp:// endpoints, gRPC reconnects in the background instead because
+// a network connection may also get interrupted temporarily.
+func Connect(address string, metricsManager metrics.CSIMetricsManager, tlsConfig *TLSConfig) (*grpc.ClientConn, error) {
+	if IsNetworkEndpoint(address) {
+		return dial(context.Background(), address, metricsManager, tlsConfig)
+	}
 	return connection.Connect(address, metricsManager, connection.OnConnectionLoss(connection.ExitOnConnectionLoss()))
 }
 
@@ -310,6 +443,83 @@ func GetNodeInfo(conn *grpc.ClientConn, timeout time.Duration) (*csi.NodeGetInfo
 	return client.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
 }
 
+// MaxTopologyEntriesManifestKey is the key in the manifest of the
+// GetPluginInfo response where a driver may report how many topology
+// entries it accepts in CreateVolume.
+const MaxTopologyEntriesManifestKey = "max-topology-entries"
+
+// GetMaxTopologyEntries returns the limit reported by the driver, or
+// zero if it reports none.
+func GetMaxTopologyEntries(conn *grpc.ClientConn, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client := csi.NewIdentityClient(conn)
+	rsp, err := client.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{})
+	if err != nil {
+		return 0, err
+	}
+	value, ok := rsp.GetManifest()[MaxTopologyEntriesManifestKey]
+	if !ok {
+		return 0, nil
+	}
+	maxEntries, err := strconv.Atoi(value)
+	if err != nil || maxEntries < 0 {
+		return 0, fmt.Errorf("invalid %s value %q in plugin manifest", MaxTopologyEntriesManifestKey, value)
+	}
+	return maxEntries, nil
+}
+
+// GetMaxVolumeSize asks the driver with a GetCapacity call without
+// parameters and topology about the largest volume that it can create.
+// It returns zero if the driver doesn't report a limit.
+func GetMaxVolumeSize(conn *grpc.ClientConn, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client := csi.NewControllerClient(conn)
+	rsp, err := client.GetCapacity(ctx, &csi.GetCapacityRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return rsp.GetMaximumVolumeSize().GetValue(), nil
+}
+
+// ProvisionerOptions contains optional settings for NewCSIProvisioner.
+// The zero value disables all of them.
+type ProvisionerOptions struct {
+	// CSIDriverLister is only needed when the lifecycle modes of the
+	// CSIDriver object are meant to be checked before provisioning.
+	CSIDriverLister storagelistersv1.CSIDriverLister
+	// MaxRequisiteTopologies limits the number of requisite topology
+	// entries passed to CreateVolume. Zero disables the limit.
+	MaxRequisiteTopologies int
+	// TopologyLimitStrategy determines which entries are kept when
+	// MaxRequisiteTopologies is exceeded.
+	TopologyLimitStrategy TopologyLimitStrategy
+	// TopologyMode is the default for storage classes which don't set
+	// one. Empty means TopologyModeRequisite.
+	TopologyMode TopologyMode
+	// LatencyAnnotations enables the provisioning latency annotation
+	// on new PVs.
+	LatencyAnnotations bool
+	// Scheduler, if set, limits concurrent provisioning per storage
+	// class.
+	Scheduler *StorageClassScheduler
+	// ProvisioningFinalizer enables the finalizer which protects a
+	// claim while CreateVolume may be in progress for it.
+	ProvisioningFinalizer bool
+	// VolumeNameTemplate replaces the default volume names.
+	VolumeNameTemplate *template.Template
+	// MaxVolumeSize is the largest volume that the driver can create.
+	// Zero means unknown.
+	MaxVolumeSize int64
+	// SecretLister, if set, is used to look up secrets first. They only
+	// get fetched from the API server when they are not in its cache.
+	SecretLister corelisters.SecretLister
+	// PVLister, if set, is used instead of the API server to check
+	// whether generated PV names or imported volumes are already in use.
+	PVLister corelisters.PersistentVolumeLister
+}
+
 // NewCSIProvisioner creates new CSI provisioner.
 //
 // vaLister is optional and only needed when VolumeAttachments are
@@ -319,7 +529,7 @@ func NewCSIProvisioner(client kubernetes.Interface,
 	identity string,
 	volumeNamePrefix string,
 	volumeNameUUIDLength int,
-	grpcClient *grpc.ClientConn,
+	grpcClient grpc.ClientConnInterface,
 	snapshotClient snapclientset.Interface,
 	driverName string,
 	pluginCapabilities rpc.PluginCapabilitySet,
@@ -336,13 +546,14 @@ func NewCSIProvisioner(client kubernetes.Interface,
 	extraCreateMetadata bool,
 	defaultFSType string,
 	nodeDeployment *NodeDeployment,
+	options ProvisionerOptions,
 ) controller.Provisioner {
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartLogging(klog.Infof)
 	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
 	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: fmt.Sprintf("external-provisioner")})
 
-	csiClient := csi.NewControllerClient(grpcClient)
+	csiClient := NewControllerClient(grpcClient)
 
 	provisioner := &csiProvisioner{
 		client:                                client,
@@ -354,6 +565,8 @@ func NewCSIProvisioner(client kubernetes.Interface,
 		volumeNamePrefix:                      volumeNamePrefix,
 		defaultFSType:                         defaultFSType,
 		volumeNameUUIDLength:                  volumeNameUUIDLength,
+		volumeNameTemplate:                    options.VolumeNameTemplate,
+		maxVolumeSize:                         options.MaxVolumeSize,
 		driverName:                            driverName,
 		pluginCapabilities:                    pluginCapabilities,
 		controllerCapabilities:                controllerCapabilities,
@@ -366,6 +579,15 @@ func NewCSIProvisioner(client kubernetes.Interface,
 		nodeLister:                            nodeLister,
 		claimLister:                           claimLister,
 		vaLister:                              vaLister,
+		csiDriverLister:                       options.CSIDriverLister,
+		secretLister:                          options.SecretLister,
+		pvLister:                              options.PVLister,
+		maxRequisiteTopologies:                options.MaxRequisiteTopologies,
+		topologyLimitStrategy:                 options.TopologyLimitStrategy,
+		topologyMode:                          options.TopologyMode,
+		latencyAnnotations:                    options.LatencyAnnotations,
+		scheduler:                             options.Scheduler,
+		provisioningFinalizer:                 options.ProvisioningFinalizer,
 		extraCreateMetadata:                   extraCreateMetadata,
 		eventRecorder:                         eventRecorder,
 	}
@@ -392,25 +614,29 @@ func NewCSIProvisioner(client kubernetes.Interface,
 // Before initiating Create/Delete API calls provisioner checks if Capabilities:
 // PluginControllerService,  ControllerCreateVolume sre supported and gets the  driver name.
 func (p *csiProvisioner) checkDriverCapabilities(rc *requiredCapabilities) error {
-	if !p.pluginCapabilities[csi.PluginCapability_Service_CONTROLLER_SERVICE] {
+	pluginCapabilities, controllerCapabilities := p.getCapabilities()
+	if !pluginCapabilities[csi.PluginCapability_Service_CONTROLLER_SERVICE] {
 		return fmt.Errorf("CSI driver does not support dynamic provisioning: plugin CONTROLLER_SERVICE capability is not reported")
 	}
 
-	if !p.controllerCapabilities[csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME] {
+	if !controllerCapabilities[csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME] {
 		return fmt.Errorf("CSI driver does not support dynamic provisioning: controller CREATE_DELETE_VOLUME capability is not reported")
 	}
 
 	if rc.snapshot {
 		// Check whether plugin supports create snapshot
 		// If not, create volume from snapshot cannot proceed
-		if !p.controllerCapabilities[csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT] {
-			return fmt.Errorf("CSI driver does not support snapshot restore: controller CREATE_DELETE_SNAPSHOT capability is not reported")
+		if !controllerCapabilities[csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT] {
+			return &permanentError{
+				reason:  "SnapshotRestoreNotSupported",
+				message: "CSI driver does not support snapshot restore: controller CREATE_DELETE_SNAPSHOT capability is not reported",
+			}
 		}
 	}
 	if rc.clone {
 		// Check whether plugin supports clone operations
 		// If not, create volume from pvc cannot proceed
-		if !p.controllerCapabilities[csi.ControllerServiceCapability_RPC_CLONE_VOLUME] {
+		if !controllerCapabilities[csi.ControllerServiceCapability_RPC_CLONE_VOLUME] {
 			return fmt.Errorf("CSI driver does not support clone operations: controller CLONE_VOLUME capability is not reported")
 		}
 	}
@@ -418,6 +644,66 @@ func (p *csiProvisioner) checkDriverCapabilities(rc *requiredCapabilities) error
 	return nil
 }
 
+// permanentError describes why a claim cannot be provisioned, for
+// example because its data source cannot be restored or cloned or
+// because the driver cannot create a volume of the requested size.
+// Retrying does not help in that case.
+type permanentError struct {
+	reason  string
+	message string
+}
+
+func (err *permanentError) Error() string {
+	return err.message
+}
+
+// requestTooSmallError is returned instead of calling CreateVolume when
+// the claim asks for less than the size of its data source. The size of
+// a pending claim cannot be changed, so the message tells the user how
+// much to request when creating the claim again.
+func requestTooSmallError(requested, minimum int64, source string) error {
+	minimumSize := resource.NewQuantity(minimum, resource.BinarySI)
+	return &permanentError{
+		reason: "RequestTooSmall",
+		message: fmt.Sprintf("requested volume size %s is less than the size %s of %s, request at least %s",
+			resource.NewQuantity(requested, resource.BinarySI), minimumSize, source, minimumSize),
+	}
+}
+
+// checkPermanentError turns a permanentError into a warning
+// event for the claim, failure annotations and an IgnoredError, which
+// stops provisioning until the claim gets synced again instead of
+// calling CreateVolume in vain. All other errors are returned unchanged.
+func (p *csiProvisioner) checkPermanentError(ctx context.Context, claim *v1.PersistentVolumeClaim, err error) error {
+	var permanentErr *permanentError
+	if !errors.As(err, &permanentErr) {
+		return err
+	}
+	p.eventRecorder.Event(claim, v1.EventTypeWarning, permanentErr.reason, permanentErr.message)
+	p.setProvisioningFailed(ctx, claim, permanentErr.reason, permanentErr.message)
+	return &controller.IgnoredError{
+		Reason: permanentErr.message,
+	}
+}
+
+// setProvisioningFailed records in the annotations of the claim why it
+// cannot be provisioned. The ClaimMetadataCleaner removes them once the
+// claim is bound, which happens when provisioning succeeds after all,
+// for example after the driver got updated.
+// Errors are only logged because the event already reports the problem.
+func (p *csiProvisioner) setProvisioningFailed(ctx context.Context, claim *v1.PersistentVolumeClaim, reason, message string) {
+	if claim.Annotations[annProvisioningFailedReason] == reason &&
+		claim.Annotations[annProvisioningFailedMessage] == message {
+		return
+	}
+	claim = claim.DeepCopy()
+	metav1.SetMetaDataAnnotation(&claim.ObjectMeta, annProvisioningFailedReason, reason)
+	metav1.SetMetaDataAnnotation(&claim.ObjectMeta, annProvisioningFailedMessage, message)
+	if _, err := p.client.CoreV1().PersistentVolumeClaims(claim.Namespace).Update(ctx, claim, metav1.UpdateOptions{}); err != nil {
+		klog.Warningf("failed to update provisioning failure annotations of PVC %s/%s: %v", claim.Namespace, claim.Name, err)
+	}
+}
+
 func makeVolumeName(prefix, pvcUID string, volumeNameUUIDLength int) (string, error) {
 	// create persistent name based on a volumeNamePrefix and volumeNameUUIDLength
 	// of PVC's UID
@@ -494,6 +780,15 @@ type prepareProvisionResult struct {
 	migratedVolume bool
 	req            *csi.CreateVolumeRequest
 	csiPVSource    *v1.CSIPersistentVolumeSource
+	// provisionerSecretRef is the secret used for CreateVolume, nil if none.
+	provisionerSecretRef *v1.SecretReference
+	// topologyDuration is the time spent on computing the accessibility requirements.
+	topologyDuration time.Duration
+	// sourcePVName is the PV that gets cloned, empty if none.
+	sourcePVName string
+	// importVolumeHandle is the existing volume that gets imported
+	// instead of calling CreateVolume, empty if none.
+	importVolumeHandle string
 }
 
 // prepareProvision does non-destructive parameter checking and preparations for provisioning a volume.
@@ -512,8 +807,10 @@ func (p *csiProvisioner) prepareProvision(ctx context.Context, claim *v1.Persist
 			klog.V(2).Infof("translating storage class for in-tree plugin %s to CSI", sc.Provisioner)
 			storageClass, err := p.translator.TranslateInTreeStorageClassToCSI(p.supportsMigrationFromInTreePluginName, sc)
 			if err != nil {
+				storageClassTranslations.WithLabelValues(sc.Provisioner, "error").Inc()
 				return nil, controller.ProvisioningFinished, fmt.Errorf("failed to translate storage class: %v", err)
 			}
+			storageClassTranslations.WithLabelValues(sc.Provisioner, "success").Inc()
 			sc = storageClass
 			migratedVolume = true
 		} else {
@@ -521,6 +818,10 @@ func (p *csiProvisioner) prepareProvision(ctx context.Context, claim *v1.Persist
 		}
 	}
 
+	if err := p.checkPersistentLifecycleMode(); err != nil {
+		return nil, controller.ProvisioningFinished, p.checkPermanentError(ctx, claim, err)
+	}
+
 	// Make sure the plugin is capable of fulfilling the requested options
 	rc := &requiredCapabilities{}
 	if claim.Spec.DataSource != nil {
@@ -537,25 +838,60 @@ func (p *csiProvisioner) prepareProvision(ctx context.Context, claim *v1.Persist
 			rc.snapshot = true
 		case pvcKind:
 			rc.clone = true
+		case snapshotContentKind:
+			if utilfeature.DefaultFeatureGate.Enabled(features.VolumeSnapshotContentDataSource) &&
+				claim.Spec.DataSource.APIGroup != nil && *claim.Spec.DataSource.APIGroup == snapshotAPIGroup {
+				rc.snapshot = true
+				break
+			}
+			fallthrough
 		default:
 			// DataSource is not VolumeSnapshot and PVC
 			// Assume external data populator to create the volume, and there is no more work for us to do
-			p.eventRecorder.Event(claim, v1.EventTypeNormal, "Provisioning", fmt.Sprintf("Assuming an external populator will provision the volume"))
+			source := claim.Spec.DataSource.Kind
+			if group := claim.Spec.DataSource.APIGroup; group != nil && *group != "" {
+				source += "." + *group
+			}
+			p.eventRecorder.Event(claim, v1.EventTypeNormal, "Provisioning",
+				fmt.Sprintf("Assuming an external populator will provision the volume from %s %s", source, claim.Spec.DataSource.Name))
 			return nil, controller.ProvisioningFinished, &controller.IgnoredError{
 				Reason: fmt.Sprintf("data source (%s) is not handled by the provisioner, assuming an external populator will provision it",
-					claim.Spec.DataSource.Kind),
+					source),
 			}
 		}
 	}
+	cloneFromPV := claim.Annotations[annCloneFromPV]
+	if cloneFromPV != "" {
+		if claim.Spec.DataSource != nil {
+			return nil, controller.ProvisioningFinished, fmt.Errorf("PVC %s has both a data source and the %s annotation, only one of them may be set", claim.Name, annCloneFromPV)
+		}
+		rc.clone = true
+	}
+	importVolumeHandle, err := checkVolumeImport(claim, sc)
+	if err != nil {
+		return nil, controller.ProvisioningFinished, p.checkPermanentError(ctx, claim, err)
+	}
+	if importVolumeHandle != "" {
+		if err := p.checkVolumeImportInUse(ctx, claim, importVolumeHandle); err != nil {
+			return nil, controller.ProvisioningFinished, p.checkPermanentError(ctx, claim, err)
+		}
+	}
+	if err := checkContentSource(claim, sc, rc); err != nil {
+		return nil, controller.ProvisioningFinished, p.checkPermanentError(ctx, claim, err)
+	}
 	if err := p.checkDriverCapabilities(rc); err != nil {
-		return nil, controller.ProvisioningFinished, err
+		return nil, controller.ProvisioningFinished, p.checkPermanentError(ctx, claim, err)
 	}
 
 	if claim.Spec.Selector != nil {
 		return nil, controller.ProvisioningFinished, fmt.Errorf("claim Selector is not supported")
 	}
 
-	pvName, err := makeVolumeName(p.volumeNamePrefix, fmt.Sprintf("%s", claim.ObjectMeta.UID), p.volumeNameUUIDLength)
+	pvName, err := p.volumeName(claim, sc)
+	if err != nil {
+		return nil, controller.ProvisioningFinished, err
+	}
+	pvName, err = p.avoidVolumeNameCollision(ctx, claim, pvName)
 	if err != nil {
 		return nil, controller.ProvisioningFinished, err
 	}
@@ -580,6 +916,14 @@ func (p *csiProvisioner) prepareProvision(ctx context.Context, claim *v1.Persist
 
 	capacity := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
 	volSizeBytes := capacity.Value()
+	if p.maxVolumeSize > 0 && volSizeBytes > p.maxVolumeSize {
+		// Some backends don't fail such requests, they time out.
+		return nil, controller.ProvisioningFinished, p.checkPermanentError(ctx, claim, &permanentError{
+			reason: "VolumeTooLarge",
+			message: fmt.Sprintf("requested volume size %s is larger than the maximum volume size %s of CSI driver %s",
+				resource.NewQuantity(volSizeBytes, resource.BinarySI), resource.NewQuantity(p.maxVolumeSize, resource.BinarySI), p.driverName),
+		})
+	}
 
 	// Get access mode
 	volumeCaps := make([]*csi.VolumeCapability, 0)
@@ -599,12 +943,36 @@ func (p *csiProvisioner) prepareProvision(ctx context.Context, claim *v1.Persist
 
 	if claim.Spec.DataSource != nil && (rc.clone || rc.snapshot) {
 		volumeContentSource, err := p.getVolumeContentSource(ctx, claim, sc)
+		var permanentErr *permanentError
+		if errors.As(err, &permanentErr) {
+			return nil, controller.ProvisioningFinished, p.checkPermanentError(ctx, claim, err)
+		}
 		if err != nil {
 			return nil, controller.ProvisioningNoChange, fmt.Errorf("error getting handle for DataSource Type %s by Name %s: %v", claim.Spec.DataSource.Kind, claim.Spec.DataSource.Name, err)
 		}
 		req.VolumeContentSource = volumeContentSource
 	}
 
+	sourcePVName := cloneFromPV
+	if claim.Spec.DataSource != nil && claim.Spec.DataSource.Kind == pvcKind && rc.clone {
+		// Already checked by getPVCSource.
+		if sourcePVC, err := p.claimLister.PersistentVolumeClaims(claim.Namespace).Get(claim.Spec.DataSource.Name); err == nil {
+			sourcePVName = sourcePVC.Spec.VolumeName
+		}
+	}
+
+	if cloneFromPV != "" {
+		volumeContentSource, err := p.getReleasedPVSource(ctx, claim, sc, cloneFromPV)
+		var permanentErr *permanentError
+		if errors.As(err, &permanentErr) {
+			return nil, controller.ProvisioningFinished, p.checkPermanentError(ctx, claim, err)
+		}
+		if err != nil {
+			return nil, controller.ProvisioningNoChange, fmt.Errorf("error getting handle for released PV %s: %v", cloneFromPV, err)
+		}
+		req.VolumeContentSource = volumeContentSource
+	}
+
 	if claim.Spec.DataSource != nil && rc.clone {
 		err = p.setCloneFinalizer(ctx, claim)
 		if err != nil {
@@ -612,21 +980,18 @@ func (p *csiProvisioner) prepareProvision(ctx context.Context, claim *v1.Persist
 		}
 	}
 
+	var topologyDuration time.Duration
 	if p.supportsTopology() {
-		requirements, err := GenerateAccessibilityRequirements(
-			p.client,
-			p.driverName,
-			claim.Name,
-			sc.AllowedTopologies,
-			selectedNode,
-			p.strictTopology,
-			p.immediateTopology,
-			p.csiNodeLister,
-			p.nodeLister)
+		topologyStart := time.Now()
+		_, topologySpan := otlp.Start(ctx, "topology")
+		requirements, state, err := p.accessibilityRequirements(claim, sc, selectedNode)
+		topologySpan.SetError(err)
+		topologySpan.End()
 		if err != nil {
-			return nil, controller.ProvisioningNoChange, fmt.Errorf("error generating accessibility requirements: %v", err)
+			return nil, state, err
 		}
 		req.AccessibilityRequirements = requirements
+		topologyDuration = time.Since(topologyStart)
 	}
 
 	// Resolve provision secret credentials.
@@ -639,10 +1004,20 @@ func (p *csiProvisioner) prepareProvision(ctx context.Context, claim *v1.Persist
 	if err != nil {
 		return nil, controller.ProvisioningNoChange, err
 	}
-	provisionerCredentials, err := getCredentials(ctx, p.client, provisionerSecretRef)
+	provisionerCredentials, err := getCredentials(ctx, p.client, p.secretLister, provisionerSecretRef)
+	if err != nil {
+		return nil, controller.ProvisioningNoChange, err
+	}
+	tokens, err := p.serviceAccountTokens(ctx, claim, sc)
 	if err != nil {
 		return nil, controller.ProvisioningNoChange, err
 	}
+	if tokens != "" {
+		if provisionerCredentials == nil {
+			provisionerCredentials = map[string]string{}
+		}
+		provisionerCredentials[serviceAccountTokensKey] = tokens
+	}
 	req.Secrets = provisionerCredentials
 
 	// Resolve controller publish, node stage, node publish secret references
@@ -684,14 +1059,26 @@ func (p *csiProvisioner) prepareProvision(ctx context.Context, claim *v1.Persist
 	}
 
 	return &prepareProvisionResult{
-		fsType:         fsType,
-		migratedVolume: migratedVolume,
-		req:            &req,
-		csiPVSource:    csiPVSource,
+		fsType:               fsType,
+		migratedVolume:       migratedVolume,
+		req:                  &req,
+		csiPVSource:          csiPVSource,
+		provisionerSecretRef: provisionerSecretRef,
+		topologyDuration:     topologyDuration,
+		sourcePVName:         sourcePVName,
+		importVolumeHandle:   importVolumeHandle,
 	}, controller.ProvisioningNoChange, nil
 }
 
 func (p *csiProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	pv, state, err := p.provision(ctx, options)
+	if p.provisioningFinalizer {
+		p.removeProvisioningFinalizer(ctx, options.PVC, state)
+	}
+	return pv, state, err
+}
+
+func (p *csiProvisioner) provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
 	claim := options.PVC
 	if claim.Annotations[annStorageProvisioner] != p.driverName && claim.Annotations[annMigratedTo] != p.driverName {
 		// The storage provisioner annotation may not equal driver name but the
@@ -718,6 +1105,15 @@ func (p *csiProvisioner) Provision(ctx context.Context, options controller.Provi
 		}
 	}
 
+	if p.scheduler != nil {
+		release, err := p.scheduler.acquire(ctx, util.GetPersistentVolumeClaimClass(claim), claimPriority(claim))
+		if err != nil {
+			return nil, controller.ProvisioningNoChange, fmt.Errorf("waiting for a free provisioning slot: %v", err)
+		}
+		defer release()
+	}
+
+	provisionStart := time.Now()
 	result, state, err := p.prepareProvision(ctx, claim, options.StorageClass, options.SelectedNode)
 	if result == nil {
 		return nil, state, err
@@ -727,10 +1123,35 @@ func (p *csiProvisioner) Provision(ctx context.Context, options controller.Provi
 	pvName := req.Name
 	provisionerCredentials := req.Secrets
 
+	isClone := req.GetVolumeContentSource().GetVolume() != nil
+	if isClone {
+		p.cloneSourceEvent(claim, "CloningStarted", fmt.Sprintf("Cloning into PVC %s started", claim.Name))
+	}
+
+	if p.provisioningFinalizer {
+		if err := p.addProvisioningFinalizer(ctx, claim); err != nil {
+			return nil, controller.ProvisioningNoChange, fmt.Errorf("failed to add finalizer %s: %v", pvcProvisioningFinalizer, err)
+		}
+	}
+
 	createCtx := markAsMigrated(ctx, result.migratedVolume)
-	createCtx, cancel := context.WithTimeout(createCtx, p.timeout)
+	createCtx, cancel := context.WithTimeout(createCtx, p.getCreateTimeout(req.GetVolumeContentSource().GetSnapshot() != nil))
 	defer cancel()
-	rep, err := p.csiClient.CreateVolume(createCtx, req)
+	createStart := time.Now()
+	var rep *csi.CreateVolumeResponse
+	if result.importVolumeHandle != "" {
+		rep, err = p.importVolume(createCtx, p.volumeHandleToId(result.importVolumeHandle), req)
+		if err != nil {
+			return nil, controller.ProvisioningFinished, p.checkPermanentError(ctx, claim, err)
+		}
+	} else if inFlightTimeout := p.getCreateInFlightTimeout(); inFlightTimeout > 0 {
+		rep, err = p.inFlight.createVolume(createCtx, req.Name, inFlightTimeout, func(ctx context.Context) (*csi.CreateVolumeResponse, error) {
+			return p.csiClient.CreateVolume(ctx, req)
+		})
+	} else {
+		rep, err = p.csiClient.CreateVolume(createCtx, req)
+	}
+	createDuration := time.Since(createStart)
 
 	if err != nil {
 		// Giving up after an error and telling the pod scheduler to retry with a different node
@@ -756,6 +1177,15 @@ func (p *csiProvisioner) Provision(ctx context.Context, options controller.Provi
 			mayReschedule,
 			state,
 			err)
+		if code := status.Code(err); state == controller.ProvisioningFinished && isTerminalError(code) {
+			// Retrying with exponential backoff would call
+			// CreateVolume with the same request forever.
+			provisioningFailedPermanently.WithLabelValues(code.String()).Inc()
+			return nil, state, p.checkPermanentError(ctx, claim, &permanentError{
+				reason:  "ProvisioningFailedPermanently",
+				message: fmt.Sprintf("CreateVolume failed with an error that retrying cannot fix, not retrying until the PVC gets updated or resynced: %v", err),
+			})
+		}
 		return nil, state, err
 	}
 
@@ -785,7 +1215,7 @@ func (p *csiProvisioner) Provision(ctx context.Context, options controller.Provi
 		return nil, controller.ProvisioningInBackground, capErr
 	}
 
-	if options.PVC.Spec.DataSource != nil {
+	if req.VolumeContentSource != nil {
 		contentSource := rep.GetVolume().ContentSource
 		if contentSource == nil {
 			sourceErr := fmt.Errorf("volume content source missing")
@@ -822,6 +1252,11 @@ func (p *csiProvisioner) Provision(ctx context.Context, options controller.Provi
 	if options.StorageClass.ReclaimPolicy != nil {
 		pv.Spec.PersistentVolumeReclaimPolicy = *options.StorageClass.ReclaimPolicy
 	}
+	if result.importVolumeHandle != "" {
+		// The volume existed before the claim, so deleting the claim
+		// must not delete the volume.
+		pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+	}
 
 	if p.supportsTopology() {
 		pv.Spec.NodeAffinity = GenerateVolumeNodeAffinity(rep.Volume.AccessibleTopology)
@@ -838,6 +1273,21 @@ func (p *csiProvisioner) Provision(ctx context.Context, options controller.Provi
 
 	klog.V(2).Infof("successfully created PV %v for PVC %v and csi volume name %v", pv.Name, options.PVC.Name, pv.Spec.CSI.VolumeHandle)
 
+	// Remember the secret for Delete, see annDeletionSecretRefName.
+	deletionSecretRef := result.provisionerSecretRef
+	if deletionSecretRef == nil {
+		deletionSecretRef = &v1.SecretReference{}
+	}
+	metav1.SetMetaDataAnnotation(&pv.ObjectMeta, annDeletionSecretRefName, deletionSecretRef.Name)
+	metav1.SetMetaDataAnnotation(&pv.ObjectMeta, annDeletionSecretRefNamespace, deletionSecretRef.Namespace)
+
+	setLineageAnnotations(pv, req.VolumeContentSource, result.sourcePVName)
+
+	if p.latencyAnnotations {
+		metav1.SetMetaDataAnnotation(&pv.ObjectMeta, annProvisioningLatency,
+			formatProvisioningLatency(provisionStart.Sub(claim.CreationTimestamp.Time), result.topologyDuration, createDuration))
+	}
+
 	if result.migratedVolume {
 		pv, err = p.translator.TranslateCSIPVToInTree(pv)
 		if err != nil {
@@ -852,10 +1302,26 @@ func (p *csiProvisioner) Provision(ctx context.Context, options controller.Provi
 		}
 	}
 
+	if isClone {
+		p.cloneSourceEvent(claim, "CloningCompleted", fmt.Sprintf("Cloning into PVC %s completed", claim.Name))
+	}
+
 	klog.V(5).Infof("successfully created PV %+v", pv.Spec.PersistentVolumeSource)
 	return pv, controller.ProvisioningFinished, nil
 }
 
+// formatProvisioningLatency describes where the time went until the
+// successful provisioning attempt returned a PV: how long the PVC
+// waited since its creation, how long computing the topology
+// requirements took and how long CreateVolume took. Storing the PV
+// happens afterwards and therefore is not included.
+func formatProvisioningLatency(wait, topology, createVolume time.Duration) string {
+	round := func(d time.Duration) string {
+		return d.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("wait=%s,topology=%s,createVolume=%s", round(wait), round(topology), round(createVolume))
+}
+
 func (p *csiProvisioner) setCloneFinalizer(ctx context.Context, pvc *v1.PersistentVolumeClaim) error {
 	claim, err := p.claimLister.PersistentVolumeClaims(pvc.Namespace).Get(pvc.Spec.DataSource.Name)
 	if err != nil {
@@ -865,14 +1331,163 @@ func (p *csiProvisioner) setCloneFinalizer(ctx context.Context, pvc *v1.Persiste
 	if !checkFinalizer(claim, pvcCloneFinalizer) {
 		claim.Finalizers = append(claim.Finalizers, pvcCloneFinalizer)
 		_, err := p.client.CoreV1().PersistentVolumeClaims(claim.Namespace).Update(ctx, claim, metav1.UpdateOptions{})
-		return err
+		if err != nil {
+			return err
+		}
+		p.eventRecorder.Event(claim, v1.EventTypeNormal, "CloningProtectionAdded",
+			fmt.Sprintf("Added finalizer %s because PVC %s is being cloned from this PVC, deletion is blocked until cloning has completed", pvcCloneFinalizer, pvc.Name))
 	}
 
 	return nil
 }
 
+// setPVCloneFinalizer is the equivalent of setCloneFinalizer for a
+// Released PV that gets cloned. The CloningProtectionController removes
+// the finalizer once no pending claim refers to the PV anymore.
+func (p *csiProvisioner) setPVCloneFinalizer(ctx context.Context, pvc *v1.PersistentVolumeClaim, pv *v1.PersistentVolume) error {
+	if checkFinalizer(pv, pvcCloneFinalizer) {
+		return nil
+	}
+	pv = pv.DeepCopy()
+	pv.Finalizers = append(pv.Finalizers, pvcCloneFinalizer)
+	if _, err := p.client.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	p.eventRecorder.Event(pv, v1.EventTypeNormal, "CloningProtectionAdded",
+		fmt.Sprintf("Added finalizer %s because PVC %s/%s is being cloned from this PV, deletion is blocked until cloning has completed", pvcCloneFinalizer, pvc.Namespace, pvc.Name))
+	return nil
+}
+
+// cloneSourceEvent records an event for the PVC that the claim gets cloned
+// from. Failures are only logged because the event is merely informative.
+func (p *csiProvisioner) cloneSourceEvent(claim *v1.PersistentVolumeClaim, reason, message string) {
+	if claim.Spec.DataSource == nil {
+		// Cloned from a released PV, there is no source PVC.
+		return
+	}
+	source, err := p.claimLister.PersistentVolumeClaims(claim.Namespace).Get(claim.Spec.DataSource.Name)
+	if err != nil {
+		klog.V(3).Infof("not recording %s event for clone source of PVC %s/%s: %v", reason, claim.Namespace, claim.Name, err)
+		return
+	}
+	p.eventRecorder.Event(source, v1.EventTypeNormal, reason, message)
+}
+
 func (p *csiProvisioner) supportsTopology() bool {
-	return SupportsTopology(p.pluginCapabilities)
+	pluginCapabilities, _ := p.getCapabilities()
+	// Topology support that was added after startup cannot be used
+	// without the informers.
+	return SupportsTopology(pluginCapabilities) && p.csiNodeLister != nil && p.nodeLister != nil
+}
+
+func (p *csiProvisioner) getCapabilities() (rpc.PluginCapabilitySet, rpc.ControllerCapabilitySet) {
+	p.capabilitiesMutex.RLock()
+	defer p.capabilitiesMutex.RUnlock()
+	return p.pluginCapabilities, p.controllerCapabilities
+}
+
+// accessibilityRequirements generates the topology for CreateVolume.
+// The state is the one for Provision in case of an error.
+func (p *csiProvisioner) accessibilityRequirements(claim *v1.PersistentVolumeClaim, sc *storagev1.StorageClass, selectedNode *v1.Node) (*csi.TopologyRequirement, controller.ProvisioningState, error) {
+	requirements, err := GenerateAccessibilityRequirements(
+		p.client,
+		p.driverName,
+		claim.Name,
+		sc.AllowedTopologies,
+		selectedNode,
+		p.strictTopology,
+		p.immediateTopology,
+		p.csiNodeLister,
+		p.nodeLister)
+	if err != nil {
+		return nil, controller.ProvisioningNoChange, fmt.Errorf("error generating accessibility requirements: %v", err)
+	}
+	topologyMode, err := p.topologyModeForClass(sc)
+	if err != nil {
+		return nil, controller.ProvisioningFinished, err
+	}
+	if topologyMode == TopologyModePreferredOnly {
+		requirements = PreferredOnlyAccessibilityRequirements(requirements, p.maxRequisiteTopologies)
+	} else {
+		requirements, err = LimitAccessibilityRequirements(requirements, p.maxRequisiteTopologies, p.topologyLimitStrategy, claim.Name)
+		if err != nil {
+			return nil, controller.ProvisioningNoChange, fmt.Errorf("error generating accessibility requirements: %v", err)
+		}
+	}
+	return requirements, controller.ProvisioningNoChange, nil
+}
+
+// UpdateCapabilities implements CapabilitiesUpdater.
+func (p *csiProvisioner) UpdateCapabilities(pluginCapabilities rpc.PluginCapabilitySet, controllerCapabilities rpc.ControllerCapabilitySet) {
+	p.capabilitiesMutex.Lock()
+	defer p.capabilitiesMutex.Unlock()
+	p.pluginCapabilities = pluginCapabilities
+	p.controllerCapabilities = controllerCapabilities
+}
+
+// OperationTimeouts overrides the timeout of NewCSIProvisioner for
+// individual CSI calls. Zero values keep that timeout.
+type OperationTimeouts struct {
+	CreateVolume time.Duration
+	DeleteVolume time.Duration
+	// RestoreSnapshot is used instead of CreateVolume when the
+	// volume gets created from a snapshot, which typically takes
+	// much longer than creating an empty volume.
+	RestoreSnapshot time.Duration
+	// CreateVolumeInFlight, if non-zero, lets CreateVolume calls
+	// which exceed their timeout continue in the background for up
+	// to this long in total. Further attempts for the same volume
+	// then wait for that call instead of calling CreateVolume again.
+	CreateVolumeInFlight time.Duration
+}
+
+// TimeoutUpdater is implemented by the provisioner returned by
+// NewCSIProvisioner. It changes the timeouts for CreateVolume and
+// DeleteVolume calls that start afterwards.
+type TimeoutUpdater interface {
+	UpdateTimeout(timeout time.Duration)
+	UpdateOperationTimeouts(timeouts OperationTimeouts)
+}
+
+func (p *csiProvisioner) getCreateTimeout(fromSnapshot bool) time.Duration {
+	p.timeoutLock.RLock()
+	defer p.timeoutLock.RUnlock()
+	if fromSnapshot && p.operationTimeouts.RestoreSnapshot > 0 {
+		return p.operationTimeouts.RestoreSnapshot
+	}
+	if p.operationTimeouts.CreateVolume > 0 {
+		return p.operationTimeouts.CreateVolume
+	}
+	return p.timeout
+}
+
+func (p *csiProvisioner) getCreateInFlightTimeout() time.Duration {
+	p.timeoutLock.RLock()
+	defer p.timeoutLock.RUnlock()
+	return p.operationTimeouts.CreateVolumeInFlight
+}
+
+func (p *csiProvisioner) getDeleteTimeout() time.Duration {
+	p.timeoutLock.RLock()
+	defer p.timeoutLock.RUnlock()
+	if p.operationTimeouts.DeleteVolume > 0 {
+		return p.operationTimeouts.DeleteVolume
+	}
+	return p.timeout
+}
+
+// UpdateTimeout implements TimeoutUpdater.
+func (p *csiProvisioner) UpdateTimeout(timeout time.Duration) {
+	p.timeoutLock.Lock()
+	defer p.timeoutLock.Unlock()
+	p.timeout = timeout
+}
+
+// UpdateOperationTimeouts implements TimeoutUpdater.
+func (p *csiProvisioner) UpdateOperationTimeouts(timeouts OperationTimeouts) {
+	p.timeoutLock.Lock()
+	defer p.timeoutLock.Unlock()
+	p.operationTimeouts = timeouts
 }
 
 func removePrefixedParameters(param map[string]string) (map[string]string, error) {
@@ -894,6 +1509,10 @@ func removePrefixedParameters(param map[string]string) (map[string]string, error
 			case prefixedControllerExpandSecretNamespaceKey:
 			case prefixedDefaultSecretNameKey:
 			case prefixedDefaultSecretNamespaceKey:
+			case prefixedContentSourceKey:
+			case prefixedAllowVolumeImportKey:
+			case prefixedProvisionerServiceAccountKey:
+			case prefixedTopologyModeKey:
 			default:
 				return map[string]string{}, fmt.Errorf("found unknown parameter key \"%s\" with reserved namespace %s", k, csiParameterPrefix)
 			}
@@ -914,6 +1533,8 @@ func (p *csiProvisioner) getVolumeContentSource(ctx context.Context, claim *v1.P
 	switch claim.Spec.DataSource.Kind {
 	case snapshotKind:
 		return p.getSnapshotSource(ctx, claim, sc)
+	case snapshotContentKind:
+		return p.getSnapshotContentSource(ctx, claim, sc)
 	case pvcKind:
 		return p.getPVCSource(ctx, claim, sc)
 	default:
@@ -953,8 +1574,13 @@ func (p *csiProvisioner) getPVCSource(ctx context.Context, claim *v1.PersistentV
 	requestedSize := capacity.Value()
 	srcCapacity := sourcePVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
 	srcPVCSize := srcCapacity.Value()
+	// The source volume may have been created or expanded to more than
+	// the source claim requested. Drivers then reject smaller clones.
+	if srcStatusCapacity, ok := sourcePVC.Status.Capacity[v1.ResourceName(v1.ResourceStorage)]; ok && srcStatusCapacity.Value() > srcPVCSize {
+		srcPVCSize = srcStatusCapacity.Value()
+	}
 	if requestedSize < srcPVCSize {
-		return nil, fmt.Errorf("error, new PVC request must be greater than or equal in size to the specified PVC data source, requested %v but source is %v", requestedSize, srcPVCSize)
+		return nil, requestTooSmallError(requestedSize, srcPVCSize, fmt.Sprintf("the source PVC %s/%s", sourcePVC.Namespace, sourcePVC.Name))
 	}
 
 	if sourcePVC.Spec.VolumeName == "" {
@@ -992,16 +1618,8 @@ func (p *csiProvisioner) getPVCSource(ctx context.Context, claim *v1.PersistentV
 		return nil, fmt.Errorf("claim in dataSource not bound or invalid")
 	}
 
-	if claim.Spec.VolumeMode == nil || *claim.Spec.VolumeMode == v1.PersistentVolumeFilesystem {
-		if sourcePV.Spec.VolumeMode != nil && *sourcePV.Spec.VolumeMode != v1.PersistentVolumeFilesystem {
-			return nil, fmt.Errorf("the source PVC and destination PVCs must have the same volume mode for cloning.  Source is Block, but new PVC requested Filesystem")
-		}
-	}
-
-	if claim.Spec.VolumeMode != nil && *claim.Spec.VolumeMode == v1.PersistentVolumeBlock {
-		if sourcePV.Spec.VolumeMode == nil || *sourcePV.Spec.VolumeMode != v1.PersistentVolumeBlock {
-			return nil, fmt.Errorf("the source PVC and destination PVCs must have the same volume mode for cloning.  Source is Filesystem, but new PVC requested Block")
-		}
+	if err := checkVolumeMode(claim, sourcePV.Spec.VolumeMode, fmt.Sprintf("source PVC %s/%s", sourcePVC.Namespace, sourcePVC.Name)); err != nil {
+		return nil, err
 	}
 
 	volumeSource := csi.VolumeContentSource_Volume{
@@ -1017,6 +1635,67 @@ func (p *csiProvisioner) getPVCSource(ctx context.Context, claim *v1.PersistentV
 	return volumeContentSource, nil
 }
 
+// getReleasedPVSource verifies that the PV named in the annCloneFromPV annotation
+// may be cloned for the claim and returns the VolumeContentSource for it.
+// To prevent access to data of other namespaces, the PV must have been bound to
+// a PVC in the same namespace as the claim. Like a source PVC, the PV gets
+// the pvcCloneFinalizer which blocks its deletion while it is being cloned.
+func (p *csiProvisioner) getReleasedPVSource(ctx context.Context, claim *v1.PersistentVolumeClaim, sc *storagev1.StorageClass, pvName string) (*csi.VolumeContentSource, error) {
+	sourcePV, err := p.client.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting PV %s from api server: %v", pvName, err)
+	}
+
+	if sourcePV.ObjectMeta.DeletionTimestamp != nil {
+		return nil, fmt.Errorf("PV %s is currently being deleted", pvName)
+	}
+
+	if sourcePV.Status.Phase != v1.VolumeReleased {
+		return nil, fmt.Errorf("PV %s status is %q, should instead be %q", pvName, sourcePV.Status.Phase, v1.VolumeReleased)
+	}
+
+	if sourcePV.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+		return nil, fmt.Errorf("PV %s has reclaim policy %q, should instead be %q", pvName, sourcePV.Spec.PersistentVolumeReclaimPolicy, v1.PersistentVolumeReclaimRetain)
+	}
+
+	if sourcePV.Spec.ClaimRef == nil || sourcePV.Spec.ClaimRef.Namespace != claim.Namespace {
+		return nil, fmt.Errorf("PV %s was not bound to a PVC in namespace %q", pvName, claim.Namespace)
+	}
+
+	if sourcePV.Spec.CSI == nil || sourcePV.Spec.CSI.Driver != sc.Provisioner {
+		return nil, fmt.Errorf("PV %s is not handled by CSI driver %s of StorageClass %s", pvName, sc.Provisioner, sc.Name)
+	}
+
+	if sourcePV.Spec.StorageClassName != sc.Name {
+		return nil, fmt.Errorf("the source PV and the destination PVC must be in the same storage class for cloning.  Source is in %q, but new PVC is in %q", sourcePV.Spec.StorageClassName, sc.Name)
+	}
+
+	capacity := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	srcCapacity := sourcePV.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if capacity.Cmp(srcCapacity) < 0 {
+		return nil, requestTooSmallError(capacity.Value(), srcCapacity.Value(), "the source PV "+pvName)
+	}
+
+	if err := checkVolumeMode(claim, sourcePV.Spec.VolumeMode, "source PV "+pvName); err != nil {
+		return nil, err
+	}
+
+	if err := p.setPVCloneFinalizer(ctx, claim, sourcePV); err != nil {
+		return nil, fmt.Errorf("add finalizer %s to PV %s: %v", pvcCloneFinalizer, pvName, err)
+	}
+
+	volumeSource := csi.VolumeContentSource_Volume{
+		Volume: &csi.VolumeContentSource_VolumeSource{
+			VolumeId: sourcePV.Spec.CSI.VolumeHandle,
+		},
+	}
+	klog.V(5).Infof("VolumeContentSource_Volume %+v", volumeSource)
+
+	return &csi.VolumeContentSource{
+		Type: &volumeSource,
+	}, nil
+}
+
 // getSnapshotSource verifies DataSource.Kind of type VolumeSnapshot, making sure that the requested Snapshot is available/ready
 // returns the VolumeContentSource for the requested snapshot
 func (p *csiProvisioner) getSnapshotSource(ctx context.Context, claim *v1.PersistentVolumeClaim, sc *storagev1.StorageClass) (*csi.VolumeContentSource, error) {
@@ -1047,8 +1726,19 @@ func (p *csiProvisioner) getSnapshotSource(ctx context.Context, claim *v1.Persis
 	}
 
 	if snapContentObj.Spec.Driver != sc.Provisioner {
-		klog.Warningf("snapshotcontent %s for snapshot %s/%s is handled by a different CSI driver than requested by StorageClass %s", *snapshotObj.Status.BoundVolumeSnapshotContentName, snapshotObj.Namespace, snapshotObj.Name, sc.Name)
-		return nil, fmt.Errorf(snapshotNotBound, claim.Spec.DataSource.Name)
+		return nil, &permanentError{
+			reason: "SnapshotDriverMismatch",
+			message: fmt.Sprintf("snapshot %s/%s was created by CSI driver %s and cannot be restored by CSI driver %s of StorageClass %s",
+				snapshotObj.Namespace, snapshotObj.Name, snapContentObj.Spec.Driver, sc.Provisioner, sc.Name),
+		}
+	}
+
+	if snapContentObj.Annotations[annAllowVolumeModeChange] != "true" {
+		if sourceMode := p.snapshotSourceVolumeMode(ctx, snapshotObj, snapContentObj); sourceMode != nil {
+			if err := checkVolumeMode(claim, sourceMode, fmt.Sprintf("the source volume of snapshot %s/%s", snapshotObj.Namespace, snapshotObj.Name)); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if snapshotObj.Status.ReadyToUse == nil || *snapshotObj.Status.ReadyToUse == false {
@@ -1078,7 +1768,7 @@ func (p *csiProvisioner) getSnapshotSource(ctx context.Context, claim *v1.Persis
 		// When restoring volume from a snapshot, the volume size should
 		// be equal to or larger than its snapshot size.
 		if int64(volSizeBytes) < int64(snapshotObj.Status.RestoreSize.Value()) {
-			return nil, fmt.Errorf("requested volume size %d is less than the size %d for the source snapshot %s", int64(volSizeBytes), int64(snapshotObj.Status.RestoreSize.Value()), snapshotObj.Name)
+			return nil, requestTooSmallError(int64(volSizeBytes), int64(snapshotObj.Status.RestoreSize.Value()), fmt.Sprintf("the source snapshot %s/%s", snapshotObj.Namespace, snapshotObj.Name))
 		}
 		if int64(volSizeBytes) > int64(snapshotObj.Status.RestoreSize.Value()) {
 			klog.Warningf("requested volume size %d is greater than the size %d for the source snapshot %s. Volume plugin needs to handle volume expansion.", int64(volSizeBytes), int64(snapshotObj.Status.RestoreSize.Value()), snapshotObj.Name)
@@ -1092,6 +1782,65 @@ func (p *csiProvisioner) getSnapshotSource(ctx context.Context, claim *v1.Persis
 	return volumeContentSource, nil
 }
 
+// getSnapshotContentSource verifies DataSource.Kind of type VolumeSnapshotContent, making sure that
+// the requested pre-provisioned snapshot is ready and may be used in the namespace of the claim.
+// Returns the VolumeContentSource for the requested snapshot.
+func (p *csiProvisioner) getSnapshotContentSource(ctx context.Context, claim *v1.PersistentVolumeClaim, sc *storagev1.StorageClass) (*csi.VolumeContentSource, error) {
+	snapContentObj, err := p.snapshotClient.SnapshotV1beta1().VolumeSnapshotContents().Get(ctx, claim.Spec.DataSource.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting snapshotcontent %s from api server: %v", claim.Spec.DataSource.Name, err)
+	}
+
+	if snapContentObj.ObjectMeta.DeletionTimestamp != nil {
+		return nil, fmt.Errorf("snapshotcontent %s is currently being deleted", claim.Spec.DataSource.Name)
+	}
+	klog.V(5).Infof("VolumeSnapshotContent %+v", snapContentObj)
+
+	// VolumeSnapshotContent objects are cluster-scoped. The reference
+	// to a VolumeSnapshot, which is mandatory also for pre-provisioned
+	// content, determines which namespace may use it.
+	if snapContentObj.Spec.VolumeSnapshotRef.Namespace != claim.Namespace {
+		return nil, fmt.Errorf("snapshotcontent %s is not meant for namespace %q", claim.Spec.DataSource.Name, claim.Namespace)
+	}
+
+	if snapContentObj.Spec.Driver != sc.Provisioner {
+		return nil, &permanentError{
+			reason: "SnapshotDriverMismatch",
+			message: fmt.Sprintf("snapshotcontent %s was created by CSI driver %s and cannot be restored by CSI driver %s of StorageClass %s",
+				snapContentObj.Name, snapContentObj.Spec.Driver, sc.Provisioner, sc.Name),
+		}
+	}
+
+	if snapContentObj.Status == nil || snapContentObj.Status.ReadyToUse == nil || !*snapContentObj.Status.ReadyToUse {
+		return nil, fmt.Errorf("snapshotcontent %s is not Ready", claim.Spec.DataSource.Name)
+	}
+
+	if snapContentObj.Status.SnapshotHandle == nil {
+		return nil, fmt.Errorf("snapshot handle %s is not available", claim.Spec.DataSource.Name)
+	}
+
+	if snapContentObj.Status.RestoreSize != nil {
+		capacity := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		volSizeBytes := capacity.Value()
+		// When restoring volume from a snapshot, the volume size should
+		// be equal to or larger than its snapshot size.
+		if volSizeBytes < *snapContentObj.Status.RestoreSize {
+			return nil, requestTooSmallError(volSizeBytes, *snapContentObj.Status.RestoreSize, "the source snapshotcontent "+snapContentObj.Name)
+		}
+	}
+
+	snapshotSource := csi.VolumeContentSource_Snapshot{
+		Snapshot: &csi.VolumeContentSource_SnapshotSource{
+			SnapshotId: *snapContentObj.Status.SnapshotHandle,
+		},
+	}
+	klog.V(5).Infof("VolumeContentSource_Snapshot %+v", snapshotSource)
+
+	return &csi.VolumeContentSource{
+		Type: &snapshotSource,
+	}, nil
+}
+
 func (p *csiProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume) error {
 	if volume == nil {
 		return fmt.Errorf("invalid CSI PV")
@@ -1117,15 +1866,22 @@ func (p *csiProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume
 
 	// If we run on a single node, then we shouldn't delete volumes
 	// that we didn't create. In practice, that means that the volume
-	// is accessible (only!) on this node.
+	// is accessible (only!) on this node. The exception are volumes
+	// of nodes which no longer exist, see NodeDeployment.OrphanedVolumes.
 	if p.nodeDeployment != nil {
 		accessible, err := VolumeIsAccessible(volume.Spec.NodeAffinity, p.nodeDeployment.NodeInfo.AccessibleTopology)
 		if err != nil {
 			return fmt.Errorf("checking volume affinity failed: %v", err)
 		}
 		if !accessible {
-			return &controller.IgnoredError{
-				Reason: "PV was not provisioned on this node",
+			orphaned, err := p.claimOrphanedVolume(volume)
+			if err != nil {
+				return err
+			}
+			if !orphaned {
+				return &controller.IgnoredError{
+					Reason: "PV was not provisioned on this node",
+				}
 			}
 		}
 	}
@@ -1140,9 +1896,17 @@ func (p *csiProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume
 	req := csi.DeleteVolumeRequest{
 		VolumeId: volumeId,
 	}
-	// get secrets if StorageClass specifies it
-	storageClassName := util.GetPersistentVolumeClass(volume)
-	if len(storageClassName) != 0 {
+	// Prefer the secret that was recorded when provisioning the volume,
+	// the storage class may have changed since then.
+	if secretRef, ok := deletionSecretRef(volume); ok {
+		credentials, err := getCredentials(ctx, p.client, p.secretLister, secretRef)
+		if err != nil {
+			// Continue with deletion, as the secret may have already been deleted.
+			klog.Errorf("Failed to get credentials for volume %s: %s", volume.Name, err.Error())
+		}
+		req.Secrets = credentials
+	} else if storageClassName := util.GetPersistentVolumeClass(volume); len(storageClassName) != 0 {
+		// PVs provisioned by older releases: get secrets if StorageClass specifies it.
 		if storageClass, err := p.scLister.Get(storageClassName); err == nil {
 			if migratedVolume && storageClass.Provisioner == p.supportsMigrationFromInTreePluginName {
 				klog.V(2).Infof("translating storage class for in-tree plugin %s to CSI", storageClass.Provisioner)
@@ -1163,7 +1927,7 @@ func (p *csiProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume
 				return fmt.Errorf("failed to get secretreference for volume %s: %v", volume.Name, err)
 			}
 
-			credentials, err := getCredentials(ctx, p.client, provisionerSecretRef)
+			credentials, err := getCredentials(ctx, p.client, p.secretLister, provisionerSecretRef)
 			if err != nil {
 				// Continue with deletion, as the secret may have already been deleted.
 				klog.Errorf("Failed to get credentials for volume %s: %s", volume.Name, err.Error())
@@ -1174,7 +1938,7 @@ func (p *csiProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume
 		}
 	}
 	deleteCtx := markAsMigrated(ctx, migratedVolume)
-	deleteCtx, cancel := context.WithTimeout(deleteCtx, p.timeout)
+	deleteCtx, cancel := context.WithTimeout(deleteCtx, p.getDeleteTimeout())
 	defer cancel()
 
 	if err := p.canDeleteVolume(volume); err != nil {
@@ -1186,6 +1950,69 @@ func (p *csiProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume
 	return err
 }
 
+// checkPersistentLifecycleMode returns a permanentError if the CSIDriver
+// object of the driver exists and lists lifecycle modes, but not the
+// persistent mode. Calling CreateVolume for such a driver is pointless
+// and retrying does not help until the CSIDriver object changes.
+func (p *csiProvisioner) checkPersistentLifecycleMode() error {
+	if p.csiDriverLister == nil {
+		return nil
+	}
+	csiDriver, err := p.csiDriverLister.Get(p.driverName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// The CSIDriver object is optional.
+			return nil
+		}
+		return fmt.Errorf("error getting CSIDriver %s: %v", p.driverName, err)
+	}
+	if len(csiDriver.Spec.VolumeLifecycleModes) == 0 {
+		// Defaults to persistent.
+		return nil
+	}
+	for _, mode := range csiDriver.Spec.VolumeLifecycleModes {
+		if mode == storagev1.VolumeLifecyclePersistent {
+			return nil
+		}
+	}
+	unsupportedLifecycleMode.WithLabelValues(p.driverName).Inc()
+	return &permanentError{
+		reason:  "PersistentLifecycleModeNotSupported",
+		message: fmt.Sprintf("CSI driver %s does not support persistent volumes, its CSIDriver object only lists volume lifecycle modes %v", p.driverName, csiDriver.Spec.VolumeLifecycleModes),
+	}
+}
+
+// syncedVolumeAttachmentLister refuses to list VolumeAttachments
+// until the underlying informer has synced. An incomplete list could
+// cause deletion of a volume that is still attached.
+type syncedVolumeAttachmentLister struct {
+	storagelistersv1.VolumeAttachmentLister
+	hasSynced cache.InformerSynced
+}
+
+// NewSyncedVolumeAttachmentLister wraps a lister such that List returns an
+// error as long as hasSynced returns false.
+func NewSyncedVolumeAttachmentLister(lister storagelistersv1.VolumeAttachmentLister, hasSynced cache.InformerSynced) storagelistersv1.VolumeAttachmentLister {
+	return &syncedVolumeAttachmentLister{
+		VolumeAttachmentLister: lister,
+		hasSynced:              hasSynced,
+	}
+}
+
+func (l *syncedVolumeAttachmentLister) List(selector labels.Selector) ([]*storagev1.VolumeAttachment, error) {
+	if !l.hasSynced() {
+		return nil, errors.New("VolumeAttachment informer not synced yet")
+	}
+	return l.VolumeAttachmentLister.List(selector)
+}
+
+func (l *syncedVolumeAttachmentLister) ListForPersistentVolume(pvName string) ([]*storagev1.VolumeAttachment, error) {
+	if !l.hasSynced() {
+		return nil, errors.New("VolumeAttachment informer not synced yet")
+	}
+	return listVolumeAttachmentsForPV(l.VolumeAttachmentLister, pvName)
+}
+
 func (p *csiProvisioner) canDeleteVolume(volume *v1.PersistentVolume) error {
 	if p.vaLister == nil {
 		// Nothing to check.
@@ -1193,15 +2020,20 @@ func (p *csiProvisioner) canDeleteVolume(volume *v1.PersistentVolume) error {
 	}
 
 	// Verify if volume is attached to a node before proceeding with deletion
-	vaList, err := p.vaLister.List(labels.Everything())
+	vaList, err := listVolumeAttachmentsForPV(p.vaLister, volume.Name)
 	if err != nil {
 		return fmt.Errorf("failed to list volumeattachments: %v", err)
 	}
 
-	for _, va := range vaList {
-		if va.Spec.Source.PersistentVolumeName != nil && *va.Spec.Source.PersistentVolumeName == volume.Name {
-			return fmt.Errorf("persistentvolume %s is still attached to node %s", volume.Name, va.Spec.NodeName)
-		}
+	if len(vaList) > 0 {
+		// The error causes the provisioner library to emit a
+		// VolumeFailedDelete event and to retry later.
+		deletionsDelayedByAttachment.Inc()
+		p.detachWaits.LoadOrStore(volume.Name, time.Now())
+		return fmt.Errorf("persistentvolume %s is still attached to node %s", volume.Name, vaList[0].Spec.NodeName)
+	}
+	if since, ok := p.detachWaits.LoadAndDelete(volume.Name); ok {
+		deletionDetachWait.Observe(time.Since(since.(time.Time)).Seconds())
 	}
 
 	return nil
@@ -1292,6 +2124,12 @@ func (p *csiProvisioner) checkNode(ctx context.Context, claim *v1.PersistentVolu
 			!p.nodeDeployment.ImmediateBinding {
 			return false, nil
 		}
+		if sc.Annotations[annNodeDeploymentImmediateBinding] == "false" {
+			if logger.Enabled() {
+				logger.Infof("%s: ignoring PVC %s/%s, storage class %s opted out of immediate binding in node deployment", caller, claim.Namespace, claim.Name, sc.Name)
+			}
+			return false, nil
+		}
 
 		// If the storage class has AllowedTopologies set, then
 		// it must match our own. We can find out by trying to
@@ -1660,14 +2498,43 @@ func resolveTemplate(template string, params map[string]string) (string, error)
 	return resolved, nil
 }
 
-func getCredentials(ctx context.Context, k8s kubernetes.Interface, ref *v1.SecretReference) (map[string]string, error) {
+// deletionSecretRef returns the provisioner secret recorded in the PV
+// annotations, nil if the volume was provisioned without a secret.
+// False means that the annotations are missing.
+func deletionSecretRef(volume *v1.PersistentVolume) (*v1.SecretReference, bool) {
+	name, ok := volume.Annotations[annDeletionSecretRefName]
+	if !ok {
+		return nil, false
+	}
+	namespace := volume.Annotations[annDeletionSecretRefNamespace]
+	if name == "" {
+		return nil, true
+	}
+	return &v1.SecretReference{Name: name, Namespace: namespace}, true
+}
+
+// getCredentials returns the data of the referenced secret. The
+// secretLister is optional. Secrets which are not in its cache, for
+// example because the informer is restricted to some namespaces or
+// labels or has not synced yet, get fetched from the API server.
+func getCredentials(ctx context.Context, k8s kubernetes.Interface, secretLister corelisters.SecretLister, ref *v1.SecretReference) (map[string]string, error) {
 	if ref == nil {
 		return nil, nil
 	}
 
-	secret, err := k8s.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("error getting secret %s in namespace %s: %v", ref.Name, ref.Namespace, err)
+	var secret *v1.Secret
+	var err error
+	if secretLister != nil {
+		secret, err = secretLister.Secrets(ref.Namespace).Get(ref.Name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("error getting secret %s in namespace %s: %v", ref.Name, ref.Namespace, err)
+		}
+	}
+	if secret == nil {
+		secret, err = k8s.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error getting secret %s in namespace %s: %v", ref.Name, ref.Namespace, err)
+		}
 	}
 
 	credentials := map[string]string{}
@@ -1729,10 +2596,26 @@ func checkError(err error, mayReschedule bool) controller.ProvisioningState {
 	return controller.ProvisioningFinished
 }
 
+// isTerminalError returns true for the gRPC status codes of CreateVolume
+// errors which report a problem with the request itself, like invalid
+// storage class parameters or an unsupported size, instead of a
+// temporary problem of the driver or storage backend.
+func isTerminalError(code codes.Code) bool {
+	switch code {
+	case codes.InvalidArgument,
+		codes.FailedPrecondition,
+		codes.AlreadyExists, // CSI: volume with the same name but incompatible parameters
+		codes.OutOfRange,    // CSI: unsupported capacity
+		codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
 func cleanupVolume(ctx context.Context, p *csiProvisioner, delReq *csi.DeleteVolumeRequest, provisionerCredentials map[string]string) error {
 	var err error
 	delReq.Secrets = provisionerCredentials
-	deleteCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	deleteCtx, cancel := context.WithTimeout(ctx, p.getDeleteTimeout())
 	defer cancel()
 	for i := 0; i < deleteVolumeRetryCount; i++ {
 		_, err = p.csiClient.DeleteVolume(deleteCtx, delReq)
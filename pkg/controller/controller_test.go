@@ -24,6 +24,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -32,18 +33,27 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelistersv1 "k8s.io/client-go/listers/storage/v1"
 	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	utilfeaturetesting "k8s.io/component-base/featuregate/testing"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
 	csitrans "k8s.io/csi-translation-lib"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
@@ -224,6 +234,7 @@ func TestStripPrefixedCSIParams(t *testing.T) {
 				prefixedControllerExpandSecretNamespaceKey:  "csiBar",
 				prefixedDefaultSecretNameKey:                "csiBar",
 				prefixedDefaultSecretNamespaceKey:           "csiBar",
+				prefixedContentSourceKey:                    "csiBar",
 			},
 			expectedParams: map[string]string{},
 		},
@@ -356,6 +367,63 @@ func TestGetDriverName(t *testing.T) {
 	}
 }
 
+func TestGetMaxTopologyEntries(t *testing.T) {
+	tests := []struct {
+		name        string
+		manifest    map[string]string
+		expectMax   int
+		expectError bool
+	}{
+		{
+			name:     "no manifest",
+			manifest: nil,
+		},
+		{
+			name:      "limit",
+			manifest:  map[string]string{MaxTopologyEntriesManifestKey: "100"},
+			expectMax: 100,
+		},
+		{
+			name:        "invalid",
+			manifest:    map[string]string{MaxTopologyEntriesManifestKey: "many"},
+			expectError: true,
+		},
+		{
+			name:        "negative",
+			manifest:    map[string]string{MaxTopologyEntriesManifestKey: "-1"},
+			expectError: true,
+		},
+	}
+
+	tmpdir := tempDir(t)
+	defer os.RemoveAll(tmpdir)
+	mockController, driver, identityServer, _, csiConn, err := createMockServer(t, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockController.Finish()
+	defer driver.Stop()
+
+	for _, test := range tests {
+		out := &csi.GetPluginInfoResponse{
+			Name:     "csi/example",
+			Manifest: test.manifest,
+		}
+		identityServer.EXPECT().GetPluginInfo(gomock.Any(), gomock.Any()).Return(out, nil).Times(1)
+
+		maxEntries, err := GetMaxTopologyEntries(csiConn.conn, timeout)
+		if test.expectError && err == nil {
+			t.Errorf("test %q: Expected error, got none", test.name)
+		}
+		if !test.expectError && err != nil {
+			t.Errorf("test %q: got error: %v", test.name, err)
+		}
+		if maxEntries != test.expectMax {
+			t.Errorf("test %q: expected %d, got %d", test.name, test.expectMax, maxEntries)
+		}
+	}
+}
+
 func TestBytesToQuantity(t *testing.T) {
 	tests := []struct {
 		testName    string
@@ -417,7 +485,7 @@ func TestCreateDriverReturnsInvalidCapacityDuringProvision(t *testing.T) {
 
 	pluginCaps, controllerCaps := provisionCapabilities()
 	csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test",
-		5, csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil)
+		5, csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{})
 
 	// Requested PVC with requestedBytes storage
 	deletePolicy := v1.PersistentVolumeReclaimDelete
@@ -521,6 +589,18 @@ func createFakePVC(requestBytes int64) *v1.PersistentVolumeClaim {
 	return createFakeNamedPVC(requestBytes, "fake-pvc", nil)
 }
 
+// makeCSIDriver returns a CSIDriver object for the test driver with the given lifecycle modes.
+func makeCSIDriver(modes ...storagev1.VolumeLifecycleMode) *storagev1.CSIDriver {
+	return &storagev1.CSIDriver{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: driverName,
+		},
+		Spec: storagev1.CSIDriverSpec{
+			VolumeLifecycleModes: modes,
+		},
+	}
+}
+
 // createFakePVCWithVolumeMode returns PVC with VolumeMode
 func createFakePVCWithVolumeMode(requestBytes int64, volumeMode v1.PersistentVolumeMode) *v1.PersistentVolumeClaim {
 	claim := createFakePVC(requestBytes)
@@ -820,11 +900,13 @@ type provisioningTestcase struct {
 	expectState        controller.ProvisioningState
 	expectCreateVolDo  func(t *testing.T, ctx context.Context, req *csi.CreateVolumeRequest)
 	withExtraMetadata  bool
+	latencyAnnotations bool // enable latency annotations and check that the PV has one
 	skipCreateVolume   bool
 	deploymentNode     string // fake distributed provisioning with this node as host
 	immediateBinding   bool   // enable immediate binding support for distributed provisioning
 	expectSelectedNode string // a specific selected-node of the PVC in the apiserver after the test, same as before if empty
 	expectNoProvision  bool   // if true, then ShouldProvision should return false
+	csiDriver          *storagev1.CSIDriver
 }
 
 type provisioningFSTypeTestcase struct {
@@ -1106,6 +1188,17 @@ func provisionTestcases() (int64, map[string]provisioningTestcase) {
 			},
 			expectState: controller.ProvisioningFinished,
 		},
+		"normal provision with latency annotations": {
+			volOpts: controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{
+					ReclaimPolicy: &deletePolicy,
+				},
+				PVName: "test-name",
+				PVC:    createFakePVC(requestedBytes),
+			},
+			latencyAnnotations: true,
+			expectState:        controller.ProvisioningFinished,
+		},
 		"multiple fsType provision": {
 			volOpts: controller.ProvisionOptions{
 				StorageClass: &storagev1.StorageClass{
@@ -1310,6 +1403,46 @@ func provisionTestcases() (int64, map[string]provisioningTestcase) {
 			},
 			expectState: controller.ProvisioningFinished,
 		},
+		"provision with ephemeral-only CSIDriver": {
+			volOpts: controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{
+					ReclaimPolicy: &deletePolicy,
+					Parameters:    map[string]string{},
+				},
+				PVName: "test-name",
+				PVC:    createFakePVC(requestedBytes),
+			},
+			csiDriver:   makeCSIDriver(storagev1.VolumeLifecycleEphemeral),
+			expectErr:   true,
+			expectState: controller.ProvisioningFinished,
+		},
+		"provision with persistent and ephemeral CSIDriver": {
+			volOpts: controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{
+					ReclaimPolicy: &deletePolicy,
+					Parameters:    map[string]string{},
+				},
+				PVName: "test-name",
+				PVC:    createFakePVC(requestedBytes),
+			},
+			csiDriver: makeCSIDriver(storagev1.VolumeLifecycleEphemeral, storagev1.VolumeLifecyclePersistent),
+			expectedPVSpec: &pvSpec{
+				Name:          "test-testi",
+				ReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+				Capacity: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): bytesToQuantity(requestedBytes),
+				},
+				CSIPVS: &v1.CSIPersistentVolumeSource{
+					Driver:       "test-driver",
+					VolumeHandle: "test-volume-id",
+					FSType:       "ext4",
+					VolumeAttributes: map[string]string{
+						"storage.kubernetes.io/csiProvisionerIdentity": "test-provisioner",
+					},
+				},
+			},
+			expectState: controller.ProvisioningFinished,
+		},
 		"provision with multiple access modes": {
 			volOpts: controller.ProvisionOptions{
 				StorageClass: &storagev1.StorageClass{
@@ -1989,6 +2122,31 @@ func provisionTestcases() (int64, map[string]provisioningTestcase) {
 			expectNoProvision:  true, // not owner yet and not becoming it
 			expectSelectedNode: "",   // not changed by ShouldProvision
 		},
+		"distributed immediate, storage class opted out": {
+			deploymentNode:   "foo",
+			immediateBinding: true,
+			volOpts: controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: fakeSCName,
+						Annotations: map[string]string{
+							annNodeDeploymentImmediateBinding: "false",
+						},
+					},
+					ReclaimPolicy: &deletePolicy,
+					Parameters: map[string]string{
+						"fstype": "ext3",
+					},
+					VolumeBindingMode: &immediateBinding,
+				},
+				PVName: "test-name",
+				PVC:    createFakePVC(requestedBytes),
+			},
+			expectErr:          true,
+			expectState:        controller.ProvisioningNoChange,
+			expectNoProvision:  true, // not owner and not becoming it
+			expectSelectedNode: "",   // not changed by ShouldProvision
+		},
 		"distributed immediate, allowed topologies okay": {
 			deploymentNode:   "foo",
 			immediateBinding: true,
@@ -2128,7 +2286,7 @@ func runFSTypeProvisionTest(t *testing.T, k string, tc provisioningFSTypeTestcas
 		myDefaultfsType = ""
 	}
 	csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
-		nil, provisionDriverName, pluginCaps, controllerCaps, supportsMigrationFromInTreePluginName, false, true, csitrans.New(), nil, nil, nil, nil, nil, false, myDefaultfsType, nil)
+		nil, provisionDriverName, pluginCaps, controllerCaps, supportsMigrationFromInTreePluginName, false, true, csitrans.New(), nil, nil, nil, nil, nil, false, myDefaultfsType, nil, ProvisionerOptions{})
 	out := &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			CapacityBytes: requestedBytes,
@@ -2289,6 +2447,7 @@ func runProvisionTest(t *testing.T, tc provisioningTestcase, requestedBytes int6
 	scInformer := informerFactory.Storage().V1().StorageClasses()
 	nodeInformer := informerFactory.Core().V1().Nodes()
 	csiNodeInformer := informerFactory.Storage().V1().CSINodes()
+	csiDriverInformer := informerFactory.Storage().V1().CSIDrivers()
 
 	var nodeDeployment *NodeDeployment
 	if tc.deploymentNode != "" {
@@ -2301,11 +2460,14 @@ func runProvisionTest(t *testing.T, tc provisioningTestcase, requestedBytes int6
 
 	pluginCaps, controllerCaps := provisionCapabilities()
 	csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
-		nil, provisionDriverName, pluginCaps, controllerCaps, supportsMigrationFromInTreePluginName, false, true, csitrans.New(), scInformer.Lister(), csiNodeInformer.Lister(), nodeInformer.Lister(), nil, nil, tc.withExtraMetadata, defaultfsType, nodeDeployment)
+		nil, provisionDriverName, pluginCaps, controllerCaps, supportsMigrationFromInTreePluginName, false, true, csitrans.New(), scInformer.Lister(), csiNodeInformer.Lister(), nodeInformer.Lister(), nil, nil, tc.withExtraMetadata, defaultfsType, nodeDeployment, ProvisionerOptions{CSIDriverLister: csiDriverInformer.Lister(), LatencyAnnotations: tc.latencyAnnotations})
 
 	// Adding objects to the informer ensures that they are consistent with
 	// the fake storage without having to start the informers.
 	claimInformer.Informer().GetStore().Add(tc.volOpts.PVC)
+	if tc.csiDriver != nil {
+		csiDriverInformer.Informer().GetStore().Add(tc.csiDriver)
+	}
 	if node != nil {
 		nodeInformer.Informer().GetStore().Add(node)
 	}
@@ -2363,6 +2525,16 @@ func runProvisionTest(t *testing.T, tc provisioningTestcase, requestedBytes int6
 				}
 			}
 		}
+
+		if pv != nil {
+			latency, ok := pv.Annotations[annProvisioningLatency]
+			if tc.latencyAnnotations && !strings.HasPrefix(latency, "wait=") {
+				t.Errorf("expected %s annotation, got: %q", annProvisioningLatency, latency)
+			}
+			if !tc.latencyAnnotations && ok {
+				t.Errorf("unexpected %s annotation: %q", annProvisioningLatency, latency)
+			}
+		}
 	} else {
 		provision := csiProvisioner.(controller.Qualifier).ShouldProvision(context.Background(), tc.volOpts.PVC)
 		if provision != !tc.expectNoProvision {
@@ -2464,6 +2636,8 @@ func TestProvisionFromSnapshot(t *testing.T) {
 		nilReadyToUse                     bool
 		nilContentStatus                  bool
 		nilSnapshotHandle                 bool
+		snapshotUnsupported               bool
+		expectEvent                       string
 	}
 	testcases := map[string]testcase{
 		"provision with volume snapshot data source": {
@@ -2545,6 +2719,7 @@ func TestProvisionFromSnapshot(t *testing.T) {
 			restoredVolSizeSmall: true,
 			snapshotStatusReady:  true,
 			expectErr:            true,
+			expectEvent:          "Warning RequestTooSmall requested volume size 100 is less than the size 1k of the source snapshot default/test-snapshot, request at least 1k",
 		},
 		"fail empty snapshot name": {
 			volOpts: controller.ProvisionOptions{
@@ -2829,6 +3004,40 @@ func TestProvisionFromSnapshot(t *testing.T) {
 			},
 			snapshotStatusReady: true,
 			expectErr:           true,
+			expectEvent:         "Warning SnapshotDriverMismatch snapshot default/test-snapshot was created by CSI driver test-driver and cannot be restored by CSI driver another-driver of StorageClass ",
+		},
+		"fail driver without snapshot support": {
+			volOpts: controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{
+					Parameters:  map[string]string{},
+					Provisioner: "test-driver",
+				},
+				PVName: "test-name",
+				PVC: &v1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						UID:         "testid",
+						Annotations: driverNameAnnotation,
+					},
+					Spec: v1.PersistentVolumeClaimSpec{
+						StorageClassName: &snapClassName,
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{
+								v1.ResourceName(v1.ResourceStorage): resource.MustParse(strconv.FormatInt(requestedBytes, 10)),
+							},
+						},
+						AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+						DataSource: &v1.TypedLocalObjectReference{
+							Name:     snapName,
+							Kind:     "VolumeSnapshot",
+							APIGroup: &apiGrp,
+						},
+					},
+				},
+			},
+			snapshotStatusReady: true,
+			snapshotUnsupported: true,
+			expectErr:           true,
+			expectEvent:         "Warning SnapshotRestoreNotSupported CSI driver does not support snapshot restore: controller CREATE_DELETE_SNAPSHOT capability is not reported",
 		},
 		"fail provision with no volume snapshot content status": {
 			volOpts: controller.ProvisionOptions{
@@ -3005,7 +3214,7 @@ func TestProvisionFromSnapshot(t *testing.T) {
 
 	doit := func(t *testing.T, tc testcase) {
 		var clientSet kubernetes.Interface
-		clientSet = fakeclientset.NewSimpleClientset()
+		clientSet = fakeclientset.NewSimpleClientset(tc.volOpts.PVC)
 		client := &fake.Clientset{}
 
 		client.AddReactor("get", "volumesnapshots", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
@@ -3043,8 +3252,13 @@ func TestProvisionFromSnapshot(t *testing.T) {
 		})
 
 		pluginCaps, controllerCaps := provisionFromSnapshotCapabilities()
+		if tc.snapshotUnsupported {
+			pluginCaps, controllerCaps = provisionCapabilities()
+		}
 		csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
-			client, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil)
+			client, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{})
+		recorder := record.NewFakeRecorder(10)
+		setEventRecorder(csiProvisioner, recorder)
 
 		out := &csi.CreateVolumeResponse{
 			Volume: &csi.Volume{
@@ -3088,6 +3302,28 @@ func TestProvisionFromSnapshot(t *testing.T) {
 			t.Errorf("got error: %v", err)
 		}
 
+		close(recorder.Events)
+		var events []string
+		for event := range recorder.Events {
+			events = append(events, event)
+		}
+		if tc.expectEvent != "" {
+			if _, ok := err.(*controller.IgnoredError); !ok {
+				t.Errorf("expected IgnoredError, got: %v", err)
+			}
+			if len(events) != 1 || events[0] != tc.expectEvent {
+				t.Errorf("expected event %q, got: %q", tc.expectEvent, events)
+			}
+			claim, err := clientSet.CoreV1().PersistentVolumeClaims(tc.volOpts.PVC.Namespace).Get(context.Background(), tc.volOpts.PVC.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get PVC: %v", err)
+			}
+			expectReason := strings.Fields(tc.expectEvent)[1]
+			if reason := claim.Annotations[annProvisioningFailedReason]; reason != expectReason {
+				t.Errorf("expected %s annotation %q, got: %q", annProvisioningFailedReason, expectReason, reason)
+			}
+		}
+
 		if tc.expectedPVSpec != nil {
 			if pv != nil {
 				if pv.Name != tc.expectedPVSpec.Name {
@@ -3114,6 +3350,160 @@ func TestProvisionFromSnapshot(t *testing.T) {
 	}
 }
 
+// TestProvisionFromSnapshotContent tests create volume from a pre-provisioned VolumeSnapshotContent
+func TestProvisionFromSnapshotContent(t *testing.T) {
+	var apiGrp = "snapshot.storage.k8s.io"
+	var requestedBytes int64 = 1000
+	var contentName = "test-snapcontent"
+	var snapClassName = "test-snapclass"
+	var timeNow = time.Now().UnixNano()
+
+	testcases := map[string]struct {
+		featureEnabled bool
+		namespace      string
+		provisioner    string
+		restoreSize    int64
+		notReady       bool
+		expectErr      bool
+		expectIgnored  bool
+		expectCSICall  bool
+	}{
+		"restore snapshotcontent": {
+			featureEnabled: true,
+			expectCSICall:  true,
+		},
+		"feature disabled": {
+			expectErr:     true,
+			expectIgnored: true,
+		},
+		"fail snapshotcontent for other namespace": {
+			featureEnabled: true,
+			namespace:      "other-namespace",
+			expectErr:      true,
+		},
+		"fail snapshotcontent of other driver": {
+			featureEnabled: true,
+			provisioner:    "another-driver",
+			expectErr:      true,
+			expectIgnored:  true,
+		},
+		"fail snapshotcontent larger than request": {
+			featureEnabled: true,
+			restoreSize:    2 * requestedBytes,
+			expectErr:      true,
+			expectIgnored:  true,
+		},
+		"fail snapshotcontent not ready": {
+			featureEnabled: true,
+			notReady:       true,
+			expectErr:      true,
+		},
+	}
+
+	tmpdir := tempDir(t)
+	defer os.RemoveAll(tmpdir)
+	mockController, driver, _, controllerServer, csiConn, err := createMockServer(t, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockController.Finish()
+	defer driver.Stop()
+
+	for k, tc := range testcases {
+		t.Run(k, func(t *testing.T) {
+			defer utilfeaturetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.VolumeSnapshotContentDataSource, tc.featureEnabled)()
+
+			client := &fake.Clientset{}
+			client.AddReactor("get", "volumesnapshotcontents", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+				restoreSize := requestedBytes
+				if tc.restoreSize != 0 {
+					restoreSize = tc.restoreSize
+				}
+				content := newContent(contentName, snapClassName, "sid", "pv-uid", "volume", "", "pre-provisioned-snapshot", &restoreSize, &timeNow)
+				if tc.namespace != "" {
+					content.Spec.VolumeSnapshotRef.Namespace = tc.namespace
+				}
+				if tc.notReady {
+					ready := false
+					content.Status.ReadyToUse = &ready
+				}
+				return true, content, nil
+			})
+
+			provisioner := "test-driver"
+			if tc.provisioner != "" {
+				provisioner = tc.provisioner
+			}
+			opts := controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{
+					Parameters:  map[string]string{},
+					Provisioner: provisioner,
+				},
+				PVName: "test-name",
+				PVC: &v1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						UID:         "testid",
+						Namespace:   "default",
+						Annotations: driverNameAnnotation,
+					},
+					Spec: v1.PersistentVolumeClaimSpec{
+						StorageClassName: &snapClassName,
+						Resources: v1.ResourceRequirements{
+							Requests: v1.ResourceList{
+								v1.ResourceName(v1.ResourceStorage): resource.MustParse(strconv.FormatInt(requestedBytes, 10)),
+							},
+						},
+						AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+						DataSource: &v1.TypedLocalObjectReference{
+							Name:     contentName,
+							Kind:     "VolumeSnapshotContent",
+							APIGroup: &apiGrp,
+						},
+					},
+				},
+			}
+
+			pluginCaps, controllerCaps := provisionFromSnapshotCapabilities()
+			csiProvisioner := NewCSIProvisioner(fakeclientset.NewSimpleClientset(), 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
+				client, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{})
+
+			if tc.expectCSICall {
+				out := &csi.CreateVolumeResponse{
+					Volume: &csi.Volume{
+						CapacityBytes: requestedBytes,
+						VolumeId:      "test-volume-id",
+						ContentSource: &csi.VolumeContentSource{
+							Type: &csi.VolumeContentSource_Snapshot{
+								Snapshot: &csi.VolumeContentSource_SnapshotSource{
+									SnapshotId: "sid",
+								},
+							},
+						},
+					},
+				}
+				controllerServer.EXPECT().CreateVolume(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+						if id := req.GetVolumeContentSource().GetSnapshot().GetSnapshotId(); id != "sid" {
+							t.Errorf("expected restore of snapshot sid, got volume content source %v", req.GetVolumeContentSource())
+						}
+						return out, nil
+					}).Times(1)
+			}
+
+			_, _, err := csiProvisioner.Provision(context.Background(), opts)
+			if tc.expectErr && err == nil {
+				t.Errorf("Expected error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("got error: %v", err)
+			}
+			if _, ignored := err.(*controller.IgnoredError); ignored != tc.expectIgnored {
+				t.Errorf("expected IgnoredError %v, got: %v", tc.expectIgnored, err)
+			}
+		})
+	}
+}
+
 // TestProvisionWithTopology is a basic test of provisioner integration with topology functions.
 func TestProvisionWithTopologyEnabled(t *testing.T) {
 	defer utilfeaturetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.Topology, true)()
@@ -3126,6 +3516,8 @@ func TestProvisionWithTopologyEnabled(t *testing.T) {
 		topologyKeys           []map[string][]string
 		expectedNodeAffinity   *v1.VolumeNodeAffinity
 		expectError            bool
+		storageClassParameters map[string]string
+		expectPreferredOnly    bool
 	}{
 		"topology success": {
 			driverSupportsTopology: true,
@@ -3158,28 +3550,72 @@ func TestProvisionWithTopologyEnabled(t *testing.T) {
 				},
 			},
 		},
-		"topology fail": {
+		"preferred only": {
 			driverSupportsTopology: true,
+			nodeLabels: []map[string]string{
+				{"com.example.csi/zone": "zone1", "com.example.csi/rack": "rack1"},
+				{"com.example.csi/zone": "zone1", "com.example.csi/rack": "rack2"},
+			},
 			topologyKeys: []map[string][]string{
 				{driverName: []string{"com.example.csi/zone", "com.example.csi/rack"}},
 				{driverName: []string{"com.example.csi/zone", "com.example.csi/rack"}},
 			},
-			expectError: true,
-		},
-		"driver doesn't support topology": {
-			driverSupportsTopology: false,
-			expectError:            false,
-		},
-	}
-
-	accessibleTopology := []*csi.Topology{
-		{
-			Segments: map[string]string{
-				"com.example.csi/zone": "zone1",
-				"com.example.csi/rack": "rack2",
-			},
-		},
-	}
+			storageClassParameters: map[string]string{prefixedTopologyModeKey: string(TopologyModePreferredOnly)},
+			expectPreferredOnly:    true,
+			expectedNodeAffinity: &v1.VolumeNodeAffinity{
+				Required: &v1.NodeSelector{
+					NodeSelectorTerms: []v1.NodeSelectorTerm{
+						{
+							MatchExpressions: []v1.NodeSelectorRequirement{
+								{
+									Key:      "com.example.csi/zone",
+									Operator: v1.NodeSelectorOpIn,
+									Values:   []string{"zone1"},
+								},
+								{
+									Key:      "com.example.csi/rack",
+									Operator: v1.NodeSelectorOpIn,
+									Values:   []string{"rack2"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"invalid topology mode": {
+			driverSupportsTopology: true,
+			nodeLabels: []map[string]string{
+				{"com.example.csi/zone": "zone1", "com.example.csi/rack": "rack1"},
+			},
+			topologyKeys: []map[string][]string{
+				{driverName: []string{"com.example.csi/zone", "com.example.csi/rack"}},
+			},
+			storageClassParameters: map[string]string{prefixedTopologyModeKey: "no-such-mode"},
+			expectError:            true,
+		},
+		"topology fail": {
+			driverSupportsTopology: true,
+			topologyKeys: []map[string][]string{
+				{driverName: []string{"com.example.csi/zone", "com.example.csi/rack"}},
+				{driverName: []string{"com.example.csi/zone", "com.example.csi/rack"}},
+			},
+			expectError: true,
+		},
+		"driver doesn't support topology": {
+			driverSupportsTopology: false,
+			expectError:            false,
+		},
+	}
+
+	accessibleTopology := []*csi.Topology{
+		{
+			Segments: map[string]string{
+				"com.example.csi/zone": "zone1",
+				"com.example.csi/rack": "rack2",
+			},
+		},
+	}
 
 	createVolumeOut := &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
@@ -3201,7 +3637,18 @@ func TestProvisionWithTopologyEnabled(t *testing.T) {
 			defer driver.Stop()
 
 			if !tc.expectError {
-				controllerServer.EXPECT().CreateVolume(gomock.Any(), gomock.Any()).Return(createVolumeOut, nil).Times(1)
+				controllerServer.EXPECT().CreateVolume(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+						requirements := req.GetAccessibilityRequirements()
+						if tc.expectPreferredOnly {
+							if len(requirements.GetRequisite()) != 0 || len(requirements.GetPreferred()) == 0 {
+								t.Errorf("expected only preferred topology, got %+v", requirements)
+							}
+						} else if tc.driverSupportsTopology && len(requirements.GetRequisite()) == 0 {
+							t.Errorf("expected requisite topology, got %+v", requirements)
+						}
+						return createVolumeOut, nil
+					}).Times(1)
 			}
 
 			nodes := buildNodes(tc.nodeLabels)
@@ -3224,10 +3671,10 @@ func TestProvisionWithTopologyEnabled(t *testing.T) {
 			defer close(stopChan)
 
 			csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5,
-				csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), scLister, csiNodeLister, nodeLister, claimLister, vaLister, false, defaultfsType, nil)
+				csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), scLister, csiNodeLister, nodeLister, claimLister, vaLister, false, defaultfsType, nil, ProvisionerOptions{})
 
 			pv, _, err := csiProvisioner.Provision(context.Background(), controller.ProvisionOptions{
-				StorageClass: &storagev1.StorageClass{},
+				StorageClass: &storagev1.StorageClass{Parameters: tc.storageClassParameters},
 				PVC:          createFakePVC(requestBytes),
 			})
 			if !tc.expectError {
@@ -3318,7 +3765,7 @@ func TestProvisionErrorHandling(t *testing.T) {
 					defer close(stopChan)
 
 					csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5,
-						csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), scLister, csiNodeLister, nodeLister, claimLister, vaLister, false, defaultfsType, nil)
+						csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), scLister, csiNodeLister, nodeLister, claimLister, vaLister, false, defaultfsType, nil, ProvisionerOptions{})
 
 					options := controller.ProvisionOptions{
 						StorageClass: &storagev1.StorageClass{},
@@ -3335,8 +3782,12 @@ func TestProvisionErrorHandling(t *testing.T) {
 					if err == nil {
 						t.Fatal("expected error, got nil")
 					}
-					st, ok := status.FromError(err)
-					if !ok {
+					if isTerminalError(code) {
+						// Not retried, with the status in the message of the event.
+						if _, ok := err.(*controller.IgnoredError); !ok || !strings.Contains(err.Error(), "code = "+code.String()) {
+							t.Errorf("expected IgnoredError for status %s, got: %v", code, err)
+						}
+					} else if st, ok := status.FromError(err); !ok {
 						t.Errorf("expected status %s, got error without status: %v", code, err)
 					} else if st.Code() != code {
 						t.Errorf("expected status %s, got %s", code, st.Code())
@@ -3391,7 +3842,7 @@ func TestProvisionWithTopologyDisabled(t *testing.T) {
 	clientSet := fakeclientset.NewSimpleClientset()
 	pluginCaps, controllerCaps := provisionWithTopologyCapabilities()
 	csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5,
-		csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil)
+		csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{})
 
 	out := &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
@@ -3839,7 +4290,7 @@ func runDeleteTest(t *testing.T, k string, tc deleteTestcase) {
 	pluginCaps, controllerCaps := provisionCapabilities()
 	scLister, _, _, _, vaLister, _ := listers(clientSet)
 	csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5,
-		csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), scLister, nil, nil, nil, vaLister, false, defaultfsType, nodeDeployment)
+		csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), scLister, nil, nil, nil, vaLister, false, defaultfsType, nodeDeployment, ProvisionerOptions{})
 
 	err = csiProvisioner.Delete(context.Background(), tc.persistentVolume)
 	if tc.expectErr && err == nil {
@@ -3901,6 +4352,12 @@ func generatePVCForProvisionFromPVC(srcNamespace, srcName, scName string, reques
 }
 
 // TestProvisionFromPVC tests create volume clone
+// setEventRecorder replaces the event recorder of a provisioner created
+// with NewCSIProvisioner.
+func setEventRecorder(provisioner controller.Provisioner, recorder record.EventRecorder) {
+	provisioner.(*csiProvisioner).eventRecorder = recorder
+}
+
 func TestProvisionFromPVC(t *testing.T) {
 	var requestedBytes int64 = 1000
 	fakeSc1 := "fake-sc-1"
@@ -3911,6 +4368,9 @@ func TestProvisionFromPVC(t *testing.T) {
 	lostPVC := "lost-pvc"
 	pendingPVC := "pending-pvc"
 	pvName := "test-testi"
+	// srcPVName must differ from the name of the new PV, otherwise it
+	// would be treated as a name collision.
+	srcPVName := "source-pv"
 	unboundPVName := "unbound-pv"
 	anotherDriverPVName := "another-class"
 	filesystemPVName := "filesystem-pv"
@@ -3939,7 +4399,7 @@ func TestProvisionFromPVC(t *testing.T) {
 		expectErr            bool                     // set to state, test is expected to return errors, default false
 	}{
 		"provision with pvc data source": {
-			clonePVName:      pvName,
+			clonePVName:      srcPVName,
 			volOpts:          generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes, ""),
 			expectFinalizers: true,
 			expectedPVSpec: &pvSpec{
@@ -3960,41 +4420,41 @@ func TestProvisionFromPVC(t *testing.T) {
 			},
 		},
 		"provision with pvc data source no clone capability": {
-			clonePVName:      pvName,
+			clonePVName:      srcPVName,
 			volOpts:          generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes, ""),
 			cloneUnsupported: true,
 			expectErr:        true,
 		},
 		"provision with pvc data source different storage classes": {
-			clonePVName: pvName,
+			clonePVName: srcPVName,
 			volOpts:     generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc2, requestedBytes, ""),
 			expectErr:   true,
 		},
 		"provision with pvc data source destination too small": {
-			clonePVName:          pvName,
+			clonePVName:          srcPVName,
 			volOpts:              generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes+1, ""),
 			expectFinalizers:     true,
 			restoredVolSizeSmall: true,
 			expectErr:            true,
 		},
 		"provision with pvc data source destination too large": {
-			clonePVName:        pvName,
+			clonePVName:        srcPVName,
 			volOpts:            generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes-1, ""),
 			restoredVolSizeBig: true,
 			expectErr:          true,
 		},
 		"provision with pvc data source not found": {
-			clonePVName: pvName,
+			clonePVName: srcPVName,
 			volOpts:     generatePVCForProvisionFromPVC(srcNamespace, "source-not-found", fakeSc1, requestedBytes, ""),
 			expectErr:   true,
 		},
 		"provision with source pvc storageclass nil": {
-			clonePVName: pvName,
+			clonePVName: srcPVName,
 			volOpts:     generatePVCForProvisionFromPVC(srcNamespace, "pvc-sc-nil", fakeSc1, requestedBytes, ""),
 			expectErr:   true,
 		},
 		"provision with requested pvc storageclass nil": {
-			clonePVName: pvName,
+			clonePVName: srcPVName,
 			volOpts:     generatePVCForProvisionFromPVC(srcNamespace, srcName, "", requestedBytes, ""),
 			expectErr:   true,
 		},
@@ -4004,35 +4464,35 @@ func TestProvisionFromPVC(t *testing.T) {
 			expectErr:   true,
 		},
 		"provision with pvc data source when pvc status is claim pending": {
-			clonePVName: pvName,
+			clonePVName: srcPVName,
 			volOpts:     generatePVCForProvisionFromPVC(srcNamespace, pendingPVC, fakeSc1, requestedBytes, ""),
 			expectErr:   true,
 		},
 		"provision with pvc data source when pvc status is claim lost": {
-			clonePVName: pvName,
+			clonePVName: srcPVName,
 			volOpts:     generatePVCForProvisionFromPVC(srcNamespace, lostPVC, fakeSc1, requestedBytes, ""),
 			expectErr:   true,
 		},
 		"provision with pvc data source when clone pv has released status": {
-			clonePVName:         pvName,
+			clonePVName:         srcPVName,
 			volOpts:             generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes, ""),
 			sourcePVStatusPhase: v1.VolumeReleased,
 			expectErr:           true,
 		},
 		"provision with pvc data source when clone pv has failed status": {
-			clonePVName:         pvName,
+			clonePVName:         srcPVName,
 			volOpts:             generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes, ""),
 			sourcePVStatusPhase: v1.VolumeFailed,
 			expectErr:           true,
 		},
 		"provision with pvc data source when clone pv has pending status": {
-			clonePVName:         pvName,
+			clonePVName:         srcPVName,
 			volOpts:             generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes, ""),
 			sourcePVStatusPhase: v1.VolumePending,
 			expectErr:           true,
 		},
 		"provision with pvc data source when clone pv has available status": {
-			clonePVName:         pvName,
+			clonePVName:         srcPVName,
 			volOpts:             generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes, ""),
 			sourcePVStatusPhase: v1.VolumeAvailable,
 			expectErr:           true,
@@ -4073,12 +4533,12 @@ func TestProvisionFromPVC(t *testing.T) {
 			expectErr:   true,
 		},
 		"provision block but data source is nil": {
-			clonePVName: pvName,
+			clonePVName: srcPVName,
 			volOpts:     generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes, "block"),
 			expectErr:   true,
 		},
 		"provision nil mode data source is nil": {
-			clonePVName:      pvName,
+			clonePVName:      srcPVName,
 			volOpts:          generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes, ""),
 			expectFinalizers: true,
 			expectErr:        false,
@@ -4095,7 +4555,7 @@ func TestProvisionFromPVC(t *testing.T) {
 			expectErr:   true,
 		},
 		"provision filesystem data source is nil": {
-			clonePVName:      pvName,
+			clonePVName:      srcPVName,
 			volOpts:          generatePVCForProvisionFromPVC(srcNamespace, srcName, fakeSc1, requestedBytes, "filesystem"),
 			expectFinalizers: true,
 			expectErr:        false,
@@ -4103,7 +4563,7 @@ func TestProvisionFromPVC(t *testing.T) {
 	}
 
 	for k, tc := range testcases {
-		tc := tc
+		k, tc := k, tc
 		t.Run(k, func(t *testing.T) {
 			t.Parallel()
 			var clientSet *fakeclientset.Clientset
@@ -4125,7 +4585,7 @@ func TestProvisionFromPVC(t *testing.T) {
 			}
 			pv := &v1.PersistentVolume{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: pvName,
+					Name: srcPVName,
 				},
 				Spec: v1.PersistentVolumeSpec{
 					PersistentVolumeSource: v1.PersistentVolumeSource{
@@ -4208,7 +4668,7 @@ func TestProvisionFromPVC(t *testing.T) {
 			// Create a fake claim with invalid PV
 			invalidClaim := fakeClaim(invalidPVC, srcNamespace, "fake-claim-uid", requestedBytes, "pv-not-present", v1.ClaimBound, &fakeSc1, "")
 			// Create a fake claim as source PVC storageclass nil
-			scNilClaim := fakeClaim("pvc-sc-nil", srcNamespace, "fake-claim-uid", requestedBytes, pvName, v1.ClaimBound, nil, "")
+			scNilClaim := fakeClaim("pvc-sc-nil", srcNamespace, "fake-claim-uid", requestedBytes, srcPVName, v1.ClaimBound, nil, "")
 			// Create a fake claim, with source PVC having a lost claim status
 			lostClaim := fakeClaim(lostPVC, srcNamespace, "fake-claim-uid", requestedBytes, tc.clonePVName, v1.ClaimLost, &fakeSc1, "")
 			// Create a fake claim, with source PVC having a pending claim status
@@ -4260,13 +4720,24 @@ func TestProvisionFromPVC(t *testing.T) {
 
 			// Phase: execute the test
 			csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
-				nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, claimLister, nil, false, defaultfsType, nil)
+				nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, claimLister, nil, false, defaultfsType, nil, ProvisionerOptions{})
+			recorder := record.NewFakeRecorder(10)
+			setEventRecorder(csiProvisioner, recorder)
 
 			pv, _, err = csiProvisioner.Provision(context.Background(), tc.volOpts)
 			if tc.expectErr && err == nil {
 				t.Errorf("test %q: Expected error, got none", k)
 			}
 
+			close(recorder.Events)
+			reasons := sets.NewString()
+			for event := range recorder.Events {
+				reasons.Insert(strings.Fields(event)[1])
+			}
+			if !tc.expectErr && !tc.restoredVolSizeSmall && !reasons.HasAll("CloningStarted", "CloningCompleted") {
+				t.Errorf("test %q: expected CloningStarted and CloningCompleted events, got: %v", k, reasons.List())
+			}
+
 			if tc.volOpts.PVC.Spec.DataSource != nil {
 				claim, _ := claimLister.PersistentVolumeClaims(tc.volOpts.PVC.Namespace).Get(tc.volOpts.PVC.Spec.DataSource.Name)
 				if claim != nil {
@@ -4306,6 +4777,203 @@ func TestProvisionFromPVC(t *testing.T) {
 	}
 }
 
+func TestProvisionFromReleasedPV(t *testing.T) {
+	var requestedBytes int64 = 1000
+	scName := "fake-sc"
+	namespace := "fake-namespace"
+	releasedPVName := "released-pv"
+
+	releasedPV := func(modify func(pv *v1.PersistentVolume)) *v1.PersistentVolume {
+		pv := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: releasedPVName,
+			},
+			Spec: v1.PersistentVolumeSpec{
+				Capacity: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): *resource.NewQuantity(requestedBytes, resource.BinarySI),
+				},
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{
+						Driver:       driverName,
+						VolumeHandle: "released-volume-id",
+					},
+				},
+				ClaimRef: &v1.ObjectReference{
+					Kind:      "PersistentVolumeClaim",
+					Namespace: namespace,
+					Name:      "deleted-pvc",
+					UID:       types.UID("deleted-pvc-uid"),
+				},
+				PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimRetain,
+				StorageClassName:              scName,
+			},
+			Status: v1.PersistentVolumeStatus{
+				Phase: v1.VolumeReleased,
+			},
+		}
+		if modify != nil {
+			modify(pv)
+		}
+		return pv
+	}
+
+	testcases := map[string]struct {
+		pv             *v1.PersistentVolume
+		withDataSource bool
+		expectErr      bool
+	}{
+		"clone released PV": {
+			pv: releasedPV(nil),
+		},
+		"fail PV not released": {
+			pv: releasedPV(func(pv *v1.PersistentVolume) {
+				pv.Status.Phase = v1.VolumeBound
+			}),
+			expectErr: true,
+		},
+		"fail PV with delete policy": {
+			pv: releasedPV(func(pv *v1.PersistentVolume) {
+				pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimDelete
+			}),
+			expectErr: true,
+		},
+		"fail PV from other namespace": {
+			pv: releasedPV(func(pv *v1.PersistentVolume) {
+				pv.Spec.ClaimRef.Namespace = "other-namespace"
+			}),
+			expectErr: true,
+		},
+		"fail PV of other driver": {
+			pv: releasedPV(func(pv *v1.PersistentVolume) {
+				pv.Spec.CSI.Driver = "other-driver"
+			}),
+			expectErr: true,
+		},
+		"fail PV of other storage class": {
+			pv: releasedPV(func(pv *v1.PersistentVolume) {
+				pv.Spec.StorageClassName = "other-sc"
+			}),
+			expectErr: true,
+		},
+		"fail PV larger than request": {
+			pv: releasedPV(func(pv *v1.PersistentVolume) {
+				pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)] = *resource.NewQuantity(2*requestedBytes, resource.BinarySI)
+			}),
+			expectErr: true,
+		},
+		"fail PV with block mode": {
+			pv: releasedPV(func(pv *v1.PersistentVolume) {
+				pv.Spec.VolumeMode = &volumeModeBlock
+			}),
+			expectErr: true,
+		},
+		"fail PV does not exist": {
+			expectErr: true,
+		},
+		"fail with data source": {
+			pv:             releasedPV(nil),
+			withDataSource: true,
+			expectErr:      true,
+		},
+	}
+
+	for k, tc := range testcases {
+		k, tc := k, tc
+		t.Run(k, func(t *testing.T) {
+			t.Parallel()
+
+			tmpdir := tempDir(t)
+			defer os.RemoveAll(tmpdir)
+			mockController, driver, _, controllerServer, csiConn, err := createMockServer(t, tmpdir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mockController.Finish()
+			defer driver.Stop()
+
+			var objects []runtime.Object
+			if tc.pv != nil {
+				objects = append(objects, tc.pv)
+			}
+			clientSet := fakeclientset.NewSimpleClientset(objects...)
+
+			claim := fakeClaim("pvc", namespace, "pvc-uid", requestedBytes, "", v1.ClaimPending, &scName, "")
+			claim.Annotations = map[string]string{
+				annStorageProvisioner: driverName,
+				annCloneFromPV:        releasedPVName,
+			}
+			if tc.withDataSource {
+				claim.Spec.DataSource = &v1.TypedLocalObjectReference{
+					Name: "source-pvc",
+					Kind: "PersistentVolumeClaim",
+				}
+			}
+
+			if !tc.expectErr {
+				out := &csi.CreateVolumeResponse{
+					Volume: &csi.Volume{
+						CapacityBytes: requestedBytes,
+						VolumeId:      "test-volume-id",
+						ContentSource: &csi.VolumeContentSource{
+							Type: &csi.VolumeContentSource_Volume{
+								Volume: &csi.VolumeContentSource_VolumeSource{
+									VolumeId: "released-volume-id",
+								},
+							},
+						},
+					},
+				}
+				controllerServer.EXPECT().CreateVolume(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+						if id := req.GetVolumeContentSource().GetVolume().GetVolumeId(); id != "released-volume-id" {
+							t.Errorf("expected clone of released-volume-id, got volume content source %v", req.GetVolumeContentSource())
+						}
+						return out, nil
+					}).Times(1)
+			}
+
+			_, _, _, claimLister, _, _ := listers(clientSet)
+			pluginCaps, controllerCaps := provisionFromPVCCapabilities()
+			csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
+				nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, claimLister, nil, false, defaultfsType, nil, ProvisionerOptions{})
+
+			sc := &storagev1.StorageClass{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: scName,
+				},
+				Provisioner: driverName,
+			}
+			pv, _, err := csiProvisioner.Provision(context.Background(), controller.ProvisionOptions{
+				StorageClass: sc,
+				PVName:       "test-name",
+				PVC:          claim,
+			})
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pv == nil || pv.Spec.CSI.VolumeHandle != "test-volume-id" {
+				t.Fatalf("expected PV for test-volume-id, got: %+v", pv)
+			}
+			if pv.Annotations[annSourcePV] != releasedPVName || pv.Annotations[annSourceVolumeHandle] != "released-volume-id" {
+				t.Errorf("expected lineage of %s, got annotations %v", releasedPVName, pv.Annotations)
+			}
+			sourcePV, err := clientSet.CoreV1().PersistentVolumes().Get(context.Background(), releasedPVName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get source PV: %v", err)
+			}
+			if !checkFinalizer(sourcePV, pvcCloneFinalizer) {
+				t.Errorf("expected finalizer %s on source PV, got %v", pvcCloneFinalizer, sourcePV.Finalizers)
+			}
+		})
+	}
+}
+
 func TestProvisionWithMigration(t *testing.T) {
 	var requestBytes int64 = 100000
 	var inTreePluginName = "in-tree-plugin"
@@ -4315,6 +4983,7 @@ func TestProvisionWithMigration(t *testing.T) {
 		name              string
 		scProvisioner     string
 		annotation        map[string]string
+		translationErr    error
 		expectTranslation bool
 		expectErr         bool
 	}{
@@ -4360,6 +5029,13 @@ func TestProvisionWithMigration(t *testing.T) {
 			annotation:    map[string]string{annStorageProvisioner: inTreePluginName},
 			expectErr:     true,
 		},
+		{
+			name:           "provision with failed translation",
+			scProvisioner:  inTreePluginName,
+			annotation:     map[string]string{annStorageProvisioner: driverName},
+			translationErr: fmt.Errorf("unsupported parameter"),
+			expectErr:      true,
+		},
 	}
 
 	for _, tc := range testcases {
@@ -4378,7 +5054,7 @@ func TestProvisionWithMigration(t *testing.T) {
 			pluginCaps, controllerCaps := provisionCapabilities()
 			csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner",
 				"test", 5, csiConn.conn, nil, driverName, pluginCaps, controllerCaps,
-				inTreePluginName, false, true, mockTranslator, nil, nil, nil, nil, nil, false, defaultfsType, nil)
+				inTreePluginName, false, true, mockTranslator, nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{})
 
 			// Set up return values (AnyTimes to avoid overfitting on implementation)
 
@@ -4386,6 +5062,9 @@ func TestProvisionWithMigration(t *testing.T) {
 			// Parameter indicating it has been translated
 			mockTranslator.EXPECT().TranslateInTreeStorageClassToCSI(gomock.Any(), gomock.Any()).DoAndReturn(
 				func(_ string, sc *storagev1.StorageClass) (*storagev1.StorageClass, error) {
+					if tc.translationErr != nil {
+						return nil, tc.translationErr
+					}
 					newSC := sc.DeepCopy()
 					newSC.Parameters[translatedKey] = "foo"
 					return newSC, nil
@@ -4441,7 +5120,24 @@ func TestProvisionWithMigration(t *testing.T) {
 				PVC:    createPVCWithAnnotation(tc.annotation, requestBytes),
 			}
 
+			storageClassTranslations.Reset()
 			pv, state, err := csiProvisioner.Provision(context.Background(), volOpts)
+			for result, expected := range map[string]bool{
+				"success": tc.expectTranslation,
+				"error":   tc.translationErr != nil,
+			} {
+				count, metricErr := testutil.GetCounterMetricValue(storageClassTranslations.WithLabelValues(inTreePluginName, result))
+				if metricErr != nil {
+					t.Fatalf("get translations: %v", metricErr)
+				}
+				expectCount := 0.0
+				if expected {
+					expectCount = 1
+				}
+				if count != expectCount {
+					t.Errorf("expected %v translations with result %s, got %v", expectCount, result, count)
+				}
+			}
 			if tc.expectErr && err == nil {
 				t.Errorf("Expected error, got none")
 			}
@@ -4555,7 +5251,7 @@ func TestDeleteMigration(t *testing.T) {
 			defer close(stopCh)
 			csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner",
 				"test", 5, csiConn.conn, nil, driverName, pluginCaps, controllerCaps, inTreePluginName,
-				false, true, mockTranslator, scLister, nil, nil, nil, vaLister, false, defaultfsType, nil)
+				false, true, mockTranslator, scLister, nil, nil, nil, vaLister, false, defaultfsType, nil, ProvisionerOptions{})
 
 			// Set mock return values (AnyTimes to avoid overfitting on implementation details)
 			mockTranslator.EXPECT().IsPVMigratable(gomock.Any()).Return(tc.expectTranslation).AnyTimes()
@@ -4624,3 +5320,603 @@ func createFakeCSIPV(volumeHandle string) *v1.PersistentVolume {
 		},
 	}
 }
+
+func TestSyncedVolumeAttachmentLister(t *testing.T) {
+	pvName := "pv"
+	clientSet := fakeclientset.NewSimpleClientset(&storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "va",
+		},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Source: storagev1.VolumeAttachmentSource{
+				PersistentVolumeName: &pvName,
+			},
+		},
+	})
+	_, _, _, _, vaLister, stopChan := listers(clientSet)
+	defer close(stopChan)
+
+	synced := false
+	lister := NewSyncedVolumeAttachmentLister(vaLister, func() bool { return synced })
+	if _, err := lister.List(labels.Everything()); err == nil {
+		t.Error("expected error while not synced, got none")
+	}
+
+	synced = true
+	vas, err := lister.List(labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vas) != 1 {
+		t.Errorf("expected one VolumeAttachment, got %d", len(vas))
+	}
+}
+
+func TestDeletionDetachWait(t *testing.T) {
+	pvName := "pv"
+	va := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "va",
+		},
+		Spec: storagev1.VolumeAttachmentSpec{
+			NodeName: "node",
+			Source: storagev1.VolumeAttachmentSource{
+				PersistentVolumeName: &pvName,
+			},
+		},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(va); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := &csiProvisioner{vaLister: storagelistersv1.NewVolumeAttachmentLister(indexer)}
+	volume := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: pvName}}
+	delayedBefore, _ := testutil.GetCounterMetricValue(deletionsDelayedByAttachment)
+	waitCount := func() uint64 {
+		histogram, err := testutil.GetHistogramFromGatherer(legacyregistry.DefaultGatherer, "persistentvolume_deletion_detach_wait_seconds")
+		if err != nil {
+			// Not gathered before the first observation.
+			return 0
+		}
+		return histogram.GetSampleCount()
+	}
+	waitsBefore := waitCount()
+
+	for i := 0; i < 2; i++ {
+		if err := p.canDeleteVolume(volume); err == nil {
+			t.Fatal("expected error while attached, got none")
+		}
+	}
+	if err := indexer.Delete(va); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.canDeleteVolume(volume); err != nil {
+		t.Fatalf("unexpected error after detach: %v", err)
+	}
+	if err := p.canDeleteVolume(volume); err != nil {
+		t.Fatalf("unexpected error after detach: %v", err)
+	}
+
+	delayed, _ := testutil.GetCounterMetricValue(deletionsDelayedByAttachment)
+	if delayed-delayedBefore != 2 {
+		t.Errorf("expected two postponed deletions, got %v", delayed-delayedBefore)
+	}
+	if waits := waitCount(); waits-waitsBefore != 1 {
+		t.Errorf("expected one observed wait, got %d", waits-waitsBefore)
+	}
+	if _, ok := p.detachWaits.Load(pvName); ok {
+		t.Error("wait for the deleted PV still tracked")
+	}
+}
+
+func TestProvisionForPopulator(t *testing.T) {
+	populatorGroup := "populator.example.com"
+	snapshotGroup := snapshotAPIGroup
+	testcases := map[string]struct {
+		dataSource  v1.TypedLocalObjectReference
+		expectEvent string
+	}{
+		"custom resource": {
+			dataSource:  v1.TypedLocalObjectReference{APIGroup: &populatorGroup, Kind: "VolumeImage", Name: "golden"},
+			expectEvent: "Normal Provisioning Assuming an external populator will provision the volume from VolumeImage.populator.example.com golden",
+		},
+		"core kind": {
+			dataSource:  v1.TypedLocalObjectReference{Kind: "ConfigMap", Name: "data"},
+			expectEvent: "Normal Provisioning Assuming an external populator will provision the volume from ConfigMap data",
+		},
+		"snapshot content without feature gate": {
+			dataSource:  v1.TypedLocalObjectReference{APIGroup: &snapshotGroup, Kind: snapshotContentKind, Name: "content"},
+			expectEvent: "Normal Provisioning Assuming an external populator will provision the volume from VolumeSnapshotContent.snapshot.storage.k8s.io content",
+		},
+	}
+
+	tmpdir := tempDir(t)
+	defer os.RemoveAll(tmpdir)
+	mockController, driver, _, _, csiConn, err := createMockServer(t, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockController.Finish()
+	defer driver.Stop()
+
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			defer utilfeaturetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.VolumeSnapshotContentDataSource, false)()
+
+			claim := createFakePVC(requestedBytes)
+			claim.Spec.DataSource = &tc.dataSource
+			pluginCaps, controllerCaps := provisionCapabilities()
+			csiProvisioner := NewCSIProvisioner(fakeclientset.NewSimpleClientset(claim), 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
+				nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{})
+			recorder := record.NewFakeRecorder(10)
+			setEventRecorder(csiProvisioner, recorder)
+
+			_, state, err := csiProvisioner.Provision(context.Background(), controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{Provisioner: driverName},
+				PVName:       "test-name",
+				PVC:          claim,
+			})
+			if _, ok := err.(*controller.IgnoredError); !ok {
+				t.Errorf("expected IgnoredError, got: %v", err)
+			}
+			if state != controller.ProvisioningFinished {
+				t.Errorf("expected state %s, got %s", controller.ProvisioningFinished, state)
+			}
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if len(events) != 1 || events[0] != tc.expectEvent {
+				t.Errorf("expected event %q, got: %q", tc.expectEvent, events)
+			}
+		})
+	}
+}
+
+func TestProvisionMaxVolumeSize(t *testing.T) {
+	testcases := map[string]struct {
+		maxVolumeSize int64
+		expectEvent   string
+	}{
+		"no limit": {},
+		"within limit": {
+			maxVolumeSize: requestedBytes,
+		},
+		"too large": {
+			maxVolumeSize: requestedBytes / 2,
+			expectEvent:   "Warning VolumeTooLarge requested volume size 1k is larger than the maximum volume size 500 of CSI driver test-driver",
+		},
+	}
+
+	tmpdir := tempDir(t)
+	defer os.RemoveAll(tmpdir)
+	mockController, driver, _, controllerServer, csiConn, err := createMockServer(t, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockController.Finish()
+	defer driver.Stop()
+
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			claim := createFakePVC(requestedBytes)
+			pluginCaps, controllerCaps := provisionCapabilities()
+			csiProvisioner := NewCSIProvisioner(fakeclientset.NewSimpleClientset(claim), 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
+				nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{MaxVolumeSize: tc.maxVolumeSize})
+			recorder := record.NewFakeRecorder(10)
+			setEventRecorder(csiProvisioner, recorder)
+
+			calls := 1
+			if tc.expectEvent != "" {
+				calls = 0
+			}
+			controllerServer.EXPECT().CreateVolume(gomock.Any(), gomock.Any()).Return(&csi.CreateVolumeResponse{
+				Volume: &csi.Volume{CapacityBytes: requestedBytes, VolumeId: "test-volume-id"},
+			}, nil).Times(calls)
+
+			deletePolicy := v1.PersistentVolumeReclaimDelete
+			_, state, err := csiProvisioner.Provision(context.Background(), controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{ReclaimPolicy: &deletePolicy},
+				PVName:       "test-name",
+				PVC:          claim,
+			})
+			if state != controller.ProvisioningFinished {
+				t.Errorf("expected state %s, got %s", controller.ProvisioningFinished, state)
+			}
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if tc.expectEvent == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if _, ok := err.(*controller.IgnoredError); !ok {
+				t.Errorf("expected IgnoredError, got: %v", err)
+			}
+			if len(events) != 1 || events[0] != tc.expectEvent {
+				t.Errorf("expected event %q, got: %q", tc.expectEvent, events)
+			}
+		})
+	}
+}
+
+func TestProvisionTerminalError(t *testing.T) {
+	tmpdir := tempDir(t)
+	defer os.RemoveAll(tmpdir)
+	mockController, driver, _, controllerServer, csiConn, err := createMockServer(t, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockController.Finish()
+	defer driver.Stop()
+
+	claim := createFakePVC(requestedBytes)
+	client := fakeclientset.NewSimpleClientset(claim)
+	pluginCaps, controllerCaps := provisionCapabilities()
+	csiProvisioner := NewCSIProvisioner(client, 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
+		nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{})
+	recorder := record.NewFakeRecorder(10)
+	setEventRecorder(csiProvisioner, recorder)
+	failedBefore, _ := testutil.GetCounterMetricValue(provisioningFailedPermanently.WithLabelValues(codes.InvalidArgument.String()))
+
+	controllerServer.EXPECT().CreateVolume(gomock.Any(), gomock.Any()).Return(nil, status.Error(codes.InvalidArgument, "unknown parameter \"tyep\"")).Times(1)
+	_, state, err := csiProvisioner.Provision(context.Background(), controller.ProvisionOptions{
+		StorageClass: &storagev1.StorageClass{Parameters: map[string]string{"tyep": "ssd"}},
+		PVName:       "test-name",
+		PVC:          claim,
+	})
+	if _, ok := err.(*controller.IgnoredError); !ok {
+		t.Errorf("expected IgnoredError, got: %v", err)
+	}
+	if state != controller.ProvisioningFinished {
+		t.Errorf("expected state %s, got %s", controller.ProvisioningFinished, state)
+	}
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	expectEvent := "Warning ProvisioningFailedPermanently CreateVolume failed with an error that retrying cannot fix, not retrying until the PVC gets updated or resynced: rpc error: code = InvalidArgument desc = unknown parameter \"tyep\""
+	if len(events) != 1 || events[0] != expectEvent {
+		t.Errorf("expected event %q, got: %q", expectEvent, events)
+	}
+	updated, err := client.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(context.Background(), claim.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Annotations[annProvisioningFailedReason] != "ProvisioningFailedPermanently" {
+		t.Errorf("expected %s annotation, got %v", annProvisioningFailedReason, updated.Annotations)
+	}
+	failed, _ := testutil.GetCounterMetricValue(provisioningFailedPermanently.WithLabelValues(codes.InvalidArgument.String()))
+	if failed != failedBefore+1 {
+		t.Errorf("expected the counter to increase by one, got %v after %v", failed, failedBefore)
+	}
+}
+
+func TestProvisionLifecycleModeNotSupported(t *testing.T) {
+	claim := createFakePVC(requestedBytes)
+	client := fakeclientset.NewSimpleClientset(claim)
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	csiDriverInformer := informerFactory.Storage().V1().CSIDrivers()
+	csiDriverInformer.Informer().GetStore().Add(makeCSIDriver(storagev1.VolumeLifecycleEphemeral))
+	pluginCaps, controllerCaps := provisionCapabilities()
+	// CreateVolume must not be called, so no CSI driver is needed.
+	csiProvisioner := NewCSIProvisioner(client, 5*time.Second, "test-provisioner", "test", 5, nil,
+		nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{CSIDriverLister: csiDriverInformer.Lister()})
+	recorder := record.NewFakeRecorder(10)
+	setEventRecorder(csiProvisioner, recorder)
+	rejectedBefore, _ := testutil.GetCounterMetricValue(unsupportedLifecycleMode.WithLabelValues(driverName))
+
+	_, state, err := csiProvisioner.Provision(context.Background(), controller.ProvisionOptions{
+		StorageClass: &storagev1.StorageClass{Parameters: map[string]string{}},
+		PVName:       "test-name",
+		PVC:          claim,
+	})
+	if _, ok := err.(*controller.IgnoredError); !ok {
+		t.Errorf("expected IgnoredError, got: %v", err)
+	}
+	if state != controller.ProvisioningFinished {
+		t.Errorf("expected state %s, got %s", controller.ProvisioningFinished, state)
+	}
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Warning PersistentLifecycleModeNotSupported ") {
+		t.Errorf("expected PersistentLifecycleModeNotSupported event, got: %q", events)
+	}
+	updated, err := client.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(context.Background(), claim.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Annotations[annProvisioningFailedReason] != "PersistentLifecycleModeNotSupported" {
+		t.Errorf("expected %s annotation, got %v", annProvisioningFailedReason, updated.Annotations)
+	}
+	rejected, _ := testutil.GetCounterMetricValue(unsupportedLifecycleMode.WithLabelValues(driverName))
+	if rejected != rejectedBefore+1 {
+		t.Errorf("expected the counter to increase by one, got %v after %v", rejected, rejectedBefore)
+	}
+}
+
+func TestGetMaxVolumeSize(t *testing.T) {
+	tmpdir := tempDir(t)
+	defer os.RemoveAll(tmpdir)
+	mockController, driver, _, controllerServer, csiConn, err := createMockServer(t, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockController.Finish()
+	defer driver.Stop()
+
+	controllerServer.EXPECT().GetCapacity(gomock.Any(), gomock.Any()).Return(&csi.GetCapacityResponse{
+		AvailableCapacity: 100 * requestedBytes,
+		MaximumVolumeSize: &wrapperspb.Int64Value{Value: 10 * requestedBytes},
+	}, nil).Times(1)
+	controllerServer.EXPECT().GetCapacity(gomock.Any(), gomock.Any()).Return(&csi.GetCapacityResponse{
+		AvailableCapacity: 100 * requestedBytes,
+	}, nil).Times(1)
+
+	for _, expected := range []int64{10 * requestedBytes, 0} {
+		size, err := GetMaxVolumeSize(csiConn.conn, 5*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if size != expected {
+			t.Errorf("expected maximum volume size %d, got %d", expected, size)
+		}
+	}
+}
+
+func TestFormatProvisioningLatency(t *testing.T) {
+	latency := formatProvisioningLatency(1500*time.Millisecond, 3141*time.Microsecond, 2*time.Second)
+	expected := "wait=1.5s,topology=3ms,createVolume=2s"
+	if latency != expected {
+		t.Errorf("expected %q, got %q", expected, latency)
+	}
+}
+
+func TestDeletionSecretSnapshot(t *testing.T) {
+	secret := func(name, value string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Data:       map[string][]byte{"key": []byte(value)},
+		}
+	}
+	storageClass := func(secretName string) *storagev1.StorageClass {
+		sc := &storagev1.StorageClass{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "sc-name",
+			},
+			Provisioner: driverName,
+		}
+		if secretName != "" {
+			sc.Parameters = map[string]string{
+				prefixedProvisionerSecretNameKey:      secretName,
+				prefixedProvisionerSecretNamespaceKey: "default",
+			}
+		}
+		return sc
+	}
+	volume := func(annotations map[string]string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pv",
+				Annotations: annotations,
+			},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{
+						VolumeHandle: "vol-id-1",
+					},
+				},
+				ClaimRef: &v1.ObjectReference{
+					Name:      "pvc-name",
+					Namespace: "default",
+				},
+				StorageClassName: "sc-name",
+			},
+		}
+	}
+
+	testcases := map[string]struct {
+		provisionSecret string
+		deleteSecret    string
+		pv              *v1.PersistentVolume
+		expectSecrets   map[string]string
+	}{
+		"secret changed": {
+			provisionSecret: "old-secret",
+			deleteSecret:    "new-secret",
+			expectSecrets:   map[string]string{"key": "old"},
+		},
+		"secret added": {
+			deleteSecret: "new-secret",
+		},
+		"secret removed": {
+			provisionSecret: "old-secret",
+			expectSecrets:   map[string]string{"key": "old"},
+		},
+		"no annotations": {
+			pv:            volume(nil),
+			deleteSecret:  "new-secret",
+			expectSecrets: map[string]string{"key": "new"},
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tmpdir := tempDir(t)
+			defer os.RemoveAll(tmpdir)
+			mockController, driver, _, controllerServer, csiConn, err := createMockServer(t, tmpdir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mockController.Finish()
+			defer driver.Stop()
+
+			ctx := context.Background()
+			// The storage class has already been modified when deleting.
+			clientSet := fakeclientset.NewSimpleClientset(secret("old-secret", "old"), secret("new-secret", "new"), storageClass(tc.deleteSecret))
+			pluginCaps, controllerCaps := provisionCapabilities()
+			scLister, _, _, _, vaLister, stopChan := listers(clientSet)
+			defer close(stopChan)
+			csiProvisioner := NewCSIProvisioner(clientSet, 5*time.Second, "test-provisioner", "test", 5,
+				csiConn.conn, nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), scLister, nil, nil, nil, vaLister, false, defaultfsType, nil, ProvisionerOptions{})
+
+			pv := tc.pv
+			if pv == nil {
+				controllerServer.EXPECT().CreateVolume(gomock.Any(), gomock.Any()).Return(&csi.CreateVolumeResponse{
+					Volume: &csi.Volume{
+						CapacityBytes: requestedBytes,
+						VolumeId:      "vol-id-1",
+					},
+				}, nil).Times(1)
+				opts := controller.ProvisionOptions{
+					StorageClass: storageClass(tc.provisionSecret),
+					PVName:       "pv",
+					PVC:          createFakePVC(requestedBytes),
+				}
+				opts.PVC.Namespace = "default"
+				provisioned, _, err := csiProvisioner.Provision(ctx, opts)
+				if err != nil {
+					t.Fatalf("provision: %v", err)
+				}
+				pv = volume(provisioned.Annotations)
+			}
+
+			controllerServer.EXPECT().DeleteVolume(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+					if !reflect.DeepEqual(req.Secrets, tc.expectSecrets) && (len(req.Secrets) != 0 || len(tc.expectSecrets) != 0) {
+						t.Errorf("expected secrets %v, got %v", tc.expectSecrets, req.Secrets)
+					}
+					return &csi.DeleteVolumeResponse{}, nil
+				}).Times(1)
+			if err := csiProvisioner.Delete(ctx, pv); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+		})
+	}
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	testcases := map[string]struct {
+		timeouts      OperationTimeouts
+		expectCreate  time.Duration
+		expectRestore time.Duration
+		expectDelete  time.Duration
+	}{
+		"defaults": {
+			expectCreate:  time.Minute,
+			expectRestore: time.Minute,
+			expectDelete:  time.Minute,
+		},
+		"create and delete": {
+			timeouts:      OperationTimeouts{CreateVolume: 2 * time.Minute, DeleteVolume: time.Second},
+			expectCreate:  2 * time.Minute,
+			expectRestore: 2 * time.Minute,
+			expectDelete:  time.Second,
+		},
+		"restore": {
+			timeouts:      OperationTimeouts{RestoreSnapshot: time.Hour},
+			expectCreate:  time.Minute,
+			expectRestore: time.Hour,
+			expectDelete:  time.Minute,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			p := &csiProvisioner{timeout: time.Minute}
+			p.UpdateOperationTimeouts(tc.timeouts)
+			if timeout := p.getCreateTimeout(false); timeout != tc.expectCreate {
+				t.Errorf("expected CreateVolume timeout %v, got %v", tc.expectCreate, timeout)
+			}
+			if timeout := p.getCreateTimeout(true); timeout != tc.expectRestore {
+				t.Errorf("expected restore timeout %v, got %v", tc.expectRestore, timeout)
+			}
+			if timeout := p.getDeleteTimeout(); timeout != tc.expectDelete {
+				t.Errorf("expected DeleteVolume timeout %v, got %v", tc.expectDelete, timeout)
+			}
+		})
+	}
+}
+
+func TestGetCredentials(t *testing.T) {
+	secret := func(name, value string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Data:       map[string][]byte{"key": []byte(value)},
+		}
+	}
+
+	testcases := map[string]struct {
+		apiSecret    *v1.Secret
+		cachedSecret *v1.Secret
+		noLister     bool
+		expectValue  string
+		expectGet    bool
+		expectErr    bool
+	}{
+		"no lister": {
+			apiSecret:   secret("secret", "api"),
+			noLister:    true,
+			expectValue: "api",
+			expectGet:   true,
+		},
+		"cached": {
+			apiSecret:    secret("secret", "api"),
+			cachedSecret: secret("secret", "cached"),
+			expectValue:  "cached",
+		},
+		"not cached": {
+			apiSecret:    secret("secret", "api"),
+			cachedSecret: secret("other-secret", "cached"),
+			expectValue:  "api",
+			expectGet:    true,
+		},
+		"not found": {
+			expectGet: true,
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			var objects []runtime.Object
+			if tc.apiSecret != nil {
+				objects = append(objects, tc.apiSecret)
+			}
+			client := fakeclientset.NewSimpleClientset(objects...)
+			var secretLister corelisters.SecretLister
+			if !tc.noLister {
+				indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+				if tc.cachedSecret != nil {
+					if err := indexer.Add(tc.cachedSecret); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+				}
+				secretLister = corelisters.NewSecretLister(indexer)
+			}
+
+			credentials, err := getCredentials(context.Background(), client, secretLister, &v1.SecretReference{Name: "secret", Namespace: "default"})
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if credentials["key"] != tc.expectValue {
+				t.Errorf("expected value %q, got %q", tc.expectValue, credentials["key"])
+			}
+			if get := len(client.Actions()) > 0; get != tc.expectGet {
+				t.Errorf("expected secret from API server %v, got actions %v", tc.expectGet, client.Actions())
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/panicguard"
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+var (
+	deletionBacklog = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "persistentvolume_deletion_backlog",
+			Help:           "Number of released PVs which are waiting for their volume to be deleted by the deletion controller.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"driver_name"},
+	)
+	deletionDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Name:           "persistentvolume_deletion_duration_seconds",
+			Help:           "Time from when the deletion controller noticed a released PV until the volume and the PV were deleted, including retries.",
+			Buckets:        []float64{1, 5, 10, 30, 60, 300, 600, 1800, 3600},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"driver_name"},
+	)
+	deletionFailures = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name:           "persistentvolume_deletion_failures_total",
+			Help:           "Number of failed attempts of the deletion controller to delete a volume or its PV.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"driver_name"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(deletionBacklog)
+	legacyregistry.MustRegister(deletionDuration)
+	legacyregistry.MustRegister(deletionFailures)
+}
+
+// DeletionController deletes the volumes of released PVs with reclaim
+// policy Delete and then the PVs, like the provisioner library does for
+// its "volumes" work queue. It has its own work queue and rate limiter,
+// so a backlog of deletions neither delays provisioning nor gets
+// delayed by it, and can be observed and tuned separately.
+//
+// The provisioner library must not delete the same PVs. This is
+// achieved by wrapping the provisioners that it uses with a
+// selectiveProvisioner which has deleting disabled.
+type DeletionController struct {
+	provisioners  map[string]controller.Provisioner
+	client        kubernetes.Interface
+	pvLister      corelisters.PersistentVolumeLister
+	queue         workqueue.RateLimitingInterface
+	eventRecorder record.EventRecorder
+	now           func() time.Time
+
+	mutex sync.Mutex
+	// pending contains the time when each PV was first seen as
+	// released, by PV name.
+	pending map[string]pendingDeletion
+}
+
+type pendingDeletion struct {
+	driverName string
+	since      time.Time
+}
+
+// NewDeletionController creates a controller which gets notified about
+// PV changes by the informer. provisioners maps the names in the
+// pv.kubernetes.io/provisioned-by annotation, including the name of an
+// in-tree plugin that gets migrated, to the provisioner which deletes
+// those volumes. The provisioners must include all wrappers except the
+// one which disables deleting for the provisioner library.
+func NewDeletionController(
+	provisioners map[string]controller.Provisioner,
+	client kubernetes.Interface,
+	pvLister corelisters.PersistentVolumeLister,
+	pvInformer cache.SharedInformer,
+	queue workqueue.RateLimitingInterface,
+) *DeletionController {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.Infof)
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "external-provisioner"})
+
+	c := &DeletionController{
+		provisioners:  provisioners,
+		client:        client,
+		pvLister:      pvLister,
+		queue:         queue,
+		eventRecorder: eventRecorder,
+		now:           time.Now,
+		pending:       map[string]pendingDeletion{},
+	}
+	pvInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueVolume,
+		UpdateFunc: func(_ interface{}, newObj interface{}) { c.enqueueVolume(newObj) },
+		DeleteFunc: c.forgetVolume,
+	})
+	return c
+}
+
+// Run is the main DeletionController handler. It deletes up to
+// threadiness volumes in parallel.
+func (c *DeletionController) Run(ctx context.Context, threadiness int) {
+	klog.Info("Starting DeletionController")
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	for i := 0; i < threadiness; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	klog.Info("Started DeletionController")
+	<-ctx.Done()
+	klog.Info("Shutting down DeletionController")
+}
+
+func (c *DeletionController) enqueueVolume(obj interface{}) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		return
+	}
+	driverName, ok := c.driverFor(pv)
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	if _, ok := c.pending[pv.Name]; !ok {
+		c.pending[pv.Name] = pendingDeletion{driverName: driverName, since: c.now()}
+		c.updateBacklog(driverName)
+	}
+	c.mutex.Unlock()
+	c.queue.Add(pv.Name)
+}
+
+func (c *DeletionController) forgetVolume(obj interface{}) {
+	if unknown, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = unknown.Obj
+	}
+	if pv, ok := obj.(*v1.PersistentVolume); ok {
+		c.done(pv.Name, false)
+	}
+}
+
+// done removes the PV from the backlog and records how long the
+// deletion took if it was deleted by this controller.
+func (c *DeletionController) done(pvName string, deleted bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pending, ok := c.pending[pvName]
+	if !ok {
+		return
+	}
+	delete(c.pending, pvName)
+	c.updateBacklog(pending.driverName)
+	if deleted {
+		deletionDuration.WithLabelValues(pending.driverName).Observe(c.now().Sub(pending.since).Seconds())
+	}
+}
+
+// updateBacklog must be called with the mutex locked.
+func (c *DeletionController) updateBacklog(driverName string) {
+	count := 0
+	for _, pending := range c.pending {
+		if pending.driverName == driverName {
+			count++
+		}
+	}
+	deletionBacklog.WithLabelValues(driverName).Set(float64(count))
+}
+
+// driverFor returns the name under which the provisioner for the PV
+// is known if the volume of the PV needs to be deleted.
+func (c *DeletionController) driverFor(pv *v1.PersistentVolume) (string, bool) {
+	if pv.DeletionTimestamp != nil ||
+		pv.Status.Phase != v1.VolumeReleased ||
+		pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+		return "", false
+	}
+	for _, name := range []string{pv.Annotations[annDynamicallyProvisioned], pv.Annotations[annMigratedTo]} {
+		if _, ok := c.provisioners[name]; ok && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (c *DeletionController) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
+	}
+}
+
+func (c *DeletionController) processNextWorkItem(ctx context.Context) (more bool) {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	more = true
+	defer c.queue.Done(obj)
+	defer panicguard.Recover(c.queue, "deletion", obj)
+
+	pvName, ok := obj.(string)
+	if !ok {
+		c.queue.Forget(obj)
+		utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+	if err := c.syncVolume(ctx, pvName); err != nil {
+		klog.Warningf("Retrying deleting PV %q after %v failures: %v", pvName, c.queue.NumRequeues(obj), err)
+		c.queue.AddRateLimited(obj)
+	} else {
+		c.queue.Forget(obj)
+	}
+	return true
+}
+
+func (c *DeletionController) syncVolume(ctx context.Context, pvName string) error {
+	pv, err := c.pvLister.Get(pvName)
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			c.done(pvName, false)
+			return nil
+		}
+		return err
+	}
+	driverName, ok := c.driverFor(pv)
+	if !ok {
+		// For example bound again or already being deleted.
+		c.done(pvName, false)
+		return nil
+	}
+	provisioner := c.provisioners[driverName]
+	if guard, ok := provisioner.(controller.DeletionGuard); ok && !guard.ShouldDelete(ctx, pv) {
+		c.done(pvName, false)
+		return nil
+	}
+
+	klog.Infof("DeletionController: deleting volume of PV %s", pvName)
+	if err := provisioner.Delete(ctx, pv); err != nil {
+		var ignored *controller.IgnoredError
+		if errors.As(err, &ignored) {
+			// Handled by some other instance.
+			klog.Infof("DeletionController: deleting volume of PV %s ignored: %s", pvName, ignored.Reason)
+			c.done(pvName, false)
+			return nil
+		}
+		deletionFailures.WithLabelValues(driverName).Inc()
+		c.eventRecorder.Event(pv, v1.EventTypeWarning, "VolumeFailedDelete", err.Error())
+		return err
+	}
+	if err := c.client.CoreV1().PersistentVolumes().Delete(ctx, pvName, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &pv.UID},
+	}); err != nil && !apierrs.IsNotFound(err) {
+		// Deleting the volume again must succeed because
+		// DeleteVolume is idempotent.
+		deletionFailures.WithLabelValues(driverName).Inc()
+		return fmt.Errorf("delete PV: %v", err)
+	}
+	klog.Infof("DeletionController: deleted PV %s", pvName)
+	c.done(pvName, true)
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+// deleteErrorProvisioner fails all Delete calls with the error.
+type deleteErrorProvisioner struct {
+	fakeProvisioner
+	err error
+}
+
+func (p *deleteErrorProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	p.deleted = true
+	return p.err
+}
+
+func TestDeletionController(t *testing.T) {
+	pv := func(modify func(pv *v1.PersistentVolume)) *v1.PersistentVolume {
+		pv := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pv",
+				UID:         "pv-uid",
+				Annotations: map[string]string{annDynamicallyProvisioned: driverName},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			},
+			Status: v1.PersistentVolumeStatus{Phase: v1.VolumeReleased},
+		}
+		if modify != nil {
+			modify(pv)
+		}
+		return pv
+	}
+
+	testcases := map[string]struct {
+		pv           *v1.PersistentVolume
+		deleteErr    error
+		expectDelete bool
+		expectRemove bool
+		expectErr    bool
+		expectEvent  string
+	}{
+		"released": {
+			pv:           pv(nil),
+			expectDelete: true,
+			expectRemove: true,
+		},
+		"migrated": {
+			pv: pv(func(pv *v1.PersistentVolume) {
+				pv.Annotations[annDynamicallyProvisioned] = "kubernetes.io/in-tree"
+				pv.Annotations[annMigratedTo] = driverName
+			}),
+			expectDelete: true,
+			expectRemove: true,
+		},
+		"delete failed": {
+			pv:           pv(nil),
+			deleteErr:    errors.New("backend unavailable"),
+			expectDelete: true,
+			expectErr:    true,
+			expectEvent:  "Warning VolumeFailedDelete backend unavailable",
+		},
+		"delete ignored": {
+			pv:           pv(nil),
+			deleteErr:    &controller.IgnoredError{Reason: "other instance"},
+			expectDelete: true,
+		},
+		"bound": {
+			pv: pv(func(pv *v1.PersistentVolume) { pv.Status.Phase = v1.VolumeBound }),
+		},
+		"retained": {
+			pv: pv(func(pv *v1.PersistentVolume) {
+				pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+			}),
+		},
+		"other driver": {
+			pv: pv(func(pv *v1.PersistentVolume) { pv.Annotations[annDynamicallyProvisioned] = "other-driver" }),
+		},
+		"being deleted": {
+			pv: pv(func(pv *v1.PersistentVolume) {
+				now := metav1.Now()
+				pv.DeletionTimestamp = &now
+			}),
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			client := fakeclientset.NewSimpleClientset(tc.pv)
+			pvInformer := informers.NewSharedInformerFactory(client, 0).Core().V1().PersistentVolumes()
+			if err := pvInformer.Informer().GetIndexer().Add(tc.pv); err != nil {
+				t.Fatalf("add PV: %v", err)
+			}
+			provisioner := &deleteErrorProvisioner{err: tc.deleteErr}
+			c := NewDeletionController(map[string]controller.Provisioner{driverName: provisioner}, client,
+				pvInformer.Lister(), pvInformer.Informer(), workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()))
+			recorder := record.NewFakeRecorder(10)
+			c.eventRecorder = recorder
+			deletionBacklog.Reset()
+
+			c.enqueueVolume(tc.pv)
+			err := c.syncVolume(ctx, tc.pv.Name)
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if provisioner.deleted != tc.expectDelete {
+				t.Errorf("expected volume deleted %v, got %v", tc.expectDelete, provisioner.deleted)
+			}
+			_, err = client.CoreV1().PersistentVolumes().Get(ctx, tc.pv.Name, metav1.GetOptions{})
+			if removed := err != nil; removed != tc.expectRemove {
+				t.Errorf("expected PV removed %v, got error %v", tc.expectRemove, err)
+			}
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if tc.expectEvent != "" && (len(events) != 1 || events[0] != tc.expectEvent) ||
+				tc.expectEvent == "" && len(events) != 0 {
+				t.Errorf("expected event %q, got: %q", tc.expectEvent, events)
+			}
+
+			// Only a failed deletion remains in the backlog.
+			expectBacklog := 0.0
+			if tc.expectErr {
+				expectBacklog = 1
+			}
+			backlog, err := testutil.GetGaugeMetricValue(deletionBacklog.WithLabelValues(driverName))
+			if err != nil {
+				t.Fatalf("get backlog: %v", err)
+			}
+			if backlog != expectBacklog {
+				t.Errorf("expected backlog %v, got %v", expectBacklog, backlog)
+			}
+		})
+	}
+}
+
+func TestDeletionControllerDuration(t *testing.T) {
+	now := time.Now()
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pv",
+			Annotations: map[string]string{annDynamicallyProvisioned: driverName},
+		},
+		Spec:   v1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete},
+		Status: v1.PersistentVolumeStatus{Phase: v1.VolumeReleased},
+	}
+	client := fakeclientset.NewSimpleClientset(pv)
+	pvInformer := informers.NewSharedInformerFactory(client, 0).Core().V1().PersistentVolumes()
+	if err := pvInformer.Informer().GetIndexer().Add(pv); err != nil {
+		t.Fatalf("add PV: %v", err)
+	}
+	c := NewDeletionController(map[string]controller.Provisioner{driverName: &fakeProvisioner{}}, client,
+		pvInformer.Lister(), pvInformer.Informer(), workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()))
+	c.eventRecorder = record.NewFakeRecorder(10)
+	c.now = func() time.Time { return now }
+	deletionDuration.Reset()
+
+	c.enqueueVolume(pv)
+	// Noticing the PV again must not reset the start time.
+	now = now.Add(time.Minute)
+	c.enqueueVolume(pv)
+	now = now.Add(time.Minute)
+	if err := c.syncVolume(context.Background(), pv.Name); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	histogram, err := testutil.GetHistogramFromGatherer(legacyregistry.DefaultGatherer, "persistentvolume_deletion_duration_seconds")
+	if err != nil {
+		t.Fatalf("get duration: %v", err)
+	}
+	if histogram.GetSampleCount() != 1 || histogram.GetSampleSum() != 120 {
+		t.Errorf("expected one deletion which took 120s, got %d with a total of %vs", histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/connection"
+	"github.com/kubernetes-csi/csi-lib-utils/metrics"
+	"github.com/kubernetes-csi/csi-lib-utils/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// FailoverConn is a connection to one of several CSI endpoints which
+// all serve the same driver, for example different replicas of the
+// controller service. When a call fails because the current endpoint
+// is unavailable, the next endpoint which passes the same checks as
+// during startup is used for future calls. The failed call itself is
+// not repeated, that is left to the normal retry mechanisms.
+type FailoverConn struct {
+	endpoints      []string
+	metricsManager metrics.CSIMetricsManager
+	timeout        time.Duration
+	tlsConfig      *TLSConfig
+
+	// Set by the initial connect and compared against
+	// when switching to a different endpoint.
+	driverName             string
+	pluginCapabilities     rpc.PluginCapabilitySet
+	controllerCapabilities rpc.ControllerCapabilitySet
+
+	mutex     sync.Mutex
+	current   int
+	conn      *grpc.ClientConn
+	switching bool
+}
+
+var _ grpc.ClientConnInterface = &FailoverConn{}
+
+// SplitEndpoints splits the value of the --csi-address parameter.
+func SplitEndpoints(addresses string) []string {
+	var endpoints []string
+	for _, endpoint := range strings.Split(addresses, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// ConnectFailover tries the endpoints in order until one of them is
+// ready. Like Connect and Probe, it does not give up.
+func ConnectFailover(endpoints []string, metricsManager metrics.CSIMetricsManager, timeout time.Duration, tlsConfig *TLSConfig) (*FailoverConn, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no CSI endpoints")
+	}
+	f := &FailoverConn{
+		endpoints:      endpoints,
+		metricsManager: metricsManager,
+		timeout:        timeout,
+		tlsConfig:      tlsConfig,
+	}
+	for i := 0; ; i = (i + 1) % len(endpoints) {
+		conn, err := f.connect(endpoints[i], true)
+		if err != nil {
+			klog.Warningf("CSI endpoint %s not usable: %v", endpoints[i], err)
+			if i == len(endpoints)-1 {
+				time.Sleep(timeout)
+			}
+			continue
+		}
+		f.current = i
+		f.conn = conn
+		klog.Infof("Using CSI endpoint %s", endpoints[i])
+		return f, nil
+	}
+}
+
+// Conn returns the connection that is currently in use.
+func (f *FailoverConn) Conn() *grpc.ClientConn {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.conn
+}
+
+// Close closes the current connection.
+func (f *FailoverConn) Close() error {
+	return f.Conn().Close()
+}
+
+// UpdateCapabilities implements CapabilitiesUpdater. Other endpoints
+// must have the new capabilities to be used after a failover.
+func (f *FailoverConn) UpdateCapabilities(pluginCapabilities rpc.PluginCapabilitySet, controllerCapabilities rpc.ControllerCapabilitySet) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pluginCapabilities = pluginCapabilities
+	f.controllerCapabilities = controllerCapabilities
+}
+
+// Invoke implements grpc.ClientConnInterface.
+func (f *FailoverConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	conn := f.Conn()
+	err := conn.Invoke(ctx, method, args, reply, opts...)
+	if status.Code(err) == codes.Unavailable {
+		f.failover(conn)
+	}
+	return err
+}
+
+// NewStream implements grpc.ClientConnInterface.
+func (f *FailoverConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn := f.Conn()
+	stream, err := conn.NewStream(ctx, desc, method, opts...)
+	if status.Code(err) == codes.Unavailable {
+		f.failover(conn)
+	}
+	return stream, err
+}
+
+// failover switches away from the failed connection in the background,
+// unless that already happened or is in progress.
+func (f *FailoverConn) failover(failed *grpc.ClientConn) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.conn != failed || f.switching {
+		return
+	}
+	f.switching = true
+	go f.switchEndpoint(f.current)
+}
+
+func (f *FailoverConn) switchEndpoint(failed int) {
+	defer func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.switching = false
+	}()
+
+	klog.Warningf("CSI endpoint %s is unavailable, trying other endpoints", f.endpoints[failed])
+	for n := 1; n < len(f.endpoints); n++ {
+		i := (failed + n) % len(f.endpoints)
+		conn, err := f.connect(f.endpoints[i], false)
+		if err != nil {
+			klog.Warningf("CSI endpoint %s not usable: %v", f.endpoints[i], err)
+			continue
+		}
+
+		f.mutex.Lock()
+		old := f.conn
+		f.current = i
+		f.conn = conn
+		f.mutex.Unlock()
+		klog.Infof("Switched from CSI endpoint %s to %s", f.endpoints[failed], f.endpoints[i])
+		// Calls which are still using the old connection fail.
+		// They would have failed anyway.
+		old.Close()
+		return
+	}
+	// gRPC keeps trying to reconnect in the background, so the
+	// current endpoint might still recover.
+	klog.Errorf("No other CSI endpoint is usable, staying with %s", f.endpoints[failed])
+}
+
+// connect establishes a connection and checks that the endpoint is
+// ready. The driver information of the initial endpoint gets stored,
+// all other endpoints must serve the same driver with the same
+// capabilities.
+func (f *FailoverConn) connect(endpoint string, initial bool) (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	conn, err := dial(ctx, endpoint, f.metricsManager, f.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.check(conn, initial); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (f *FailoverConn) check(conn *grpc.ClientConn, initial bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	ready, err := rpc.Probe(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("probe: %v", err)
+	}
+	if !ready {
+		return fmt.Errorf("driver not ready")
+	}
+	driverName, err := GetDriverName(conn, f.timeout)
+	if err != nil {
+		return fmt.Errorf("get driver name: %v", err)
+	}
+	pluginCapabilities, controllerCapabilities, err := GetDriverCapabilities(conn, f.timeout)
+	if err != nil {
+		return fmt.Errorf("get driver capabilities: %v", err)
+	}
+
+	if initial {
+		f.driverName = driverName
+		f.pluginCapabilities = pluginCapabilities
+		f.controllerCapabilities = controllerCapabilities
+		return nil
+	}
+	if driverName != f.driverName {
+		return fmt.Errorf("driver name %q is different from %q", driverName, f.driverName)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !reflect.DeepEqual(pluginCapabilities, f.pluginCapabilities) ||
+		!reflect.DeepEqual(controllerCapabilities, f.controllerCapabilities) {
+		return fmt.Errorf("driver capabilities are different")
+	}
+	return nil
+}
+
+// dial is like Connect, except that it gives up when the context is done
+// and does not react to connection loss.
+func dial(ctx context.Context, address string, metricsManager metrics.CSIMetricsManager, tlsConfig *TLSConfig) (*grpc.ClientConn, error) {
+	target, transport, err := transportOptions(address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.DialContext(ctx, target,
+		transport,
+		grpc.WithBackoffMaxDelay(time.Second),
+		grpc.WithBlock(),
+		grpc.WithChainUnaryInterceptor(
+			connection.LogGRPC,
+			connection.ExtendedCSIMetricsManager{CSIMetricsManager: metricsManager}.RecordMetricsClientInterceptor,
+		),
+	)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+type failoverTestDriver struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedControllerServer
+	name string
+}
+
+func (d *failoverTestDriver) GetPluginInfo(context.Context, *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: d.name}, nil
+}
+
+func (d *failoverTestDriver) GetPluginCapabilities(context.Context, *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	return &csi.GetPluginCapabilitiesResponse{}, nil
+}
+
+func (d *failoverTestDriver) Probe(context.Context, *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{}, nil
+}
+
+func (d *failoverTestDriver) ControllerGetCapabilities(context.Context, *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	return &csi.ControllerGetCapabilitiesResponse{}, nil
+}
+
+func (d *failoverTestDriver) ControllerGetVolume(_ context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      req.VolumeId,
+			VolumeContext: map[string]string{"server": d.name},
+		},
+	}, nil
+}
+
+func startFailoverTestDriver(t *testing.T, dir, name, serverName string) (string, *grpc.Server) {
+	endpoint := filepath.Join(dir, serverName+".sock")
+	listener, err := net.Listen("unix", endpoint)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := grpc.NewServer()
+	driver := &failoverTestDriver{name: name}
+	csi.RegisterIdentityServer(server, driver)
+	csi.RegisterControllerServer(server, driver)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return endpoint, server
+}
+
+func TestSplitEndpoints(t *testing.T) {
+	for input, expected := range map[string][]string{
+		"/run/csi/socket":              {"/run/csi/socket"},
+		"/a.sock, /b.sock":             {"/a.sock", "/b.sock"},
+		"dns:///a:10000,,dns:///b:100": {"dns:///a:10000", "dns:///b:100"},
+		"":                             nil,
+	} {
+		if actual := SplitEndpoints(input); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%q: expected %v, got %v", input, expected, actual)
+		}
+	}
+}
+
+func TestFailoverConn(t *testing.T) {
+	dir := t.TempDir()
+	first, firstServer := startFailoverTestDriver(t, dir, driverName, "first")
+	other, _ := startFailoverTestDriver(t, dir, "other-driver", "other")
+	second, _ := startFailoverTestDriver(t, dir, driverName, "second")
+
+	metricsManager := metrics.NewCSIMetricsManager("")
+	f, err := ConnectFailover([]string{first, other, second}, metricsManager, time.Second, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	client := NewControllerClient(f)
+	ctx := context.Background()
+
+	getVolume := func() error {
+		_, err := client.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: "test-volume"})
+		return err
+	}
+	if err := getVolume(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	firstServer.Stop()
+	if err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		err := getVolume()
+		if err == nil {
+			return true, nil
+		}
+		if status.Code(err) != codes.Unavailable {
+			return false, err
+		}
+		return false, nil
+	}); err != nil {
+		t.Fatalf("failover: %v", err)
+	}
+
+	// The endpoint with a different driver must have been skipped.
+	f.mutex.Lock()
+	current := f.endpoints[f.current]
+	f.mutex.Unlock()
+	if current != second {
+		t.Errorf("expected endpoint %s, got %s", second, current)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// annImportVolumeHandle can be set on a PVC to get a PV for an
+	// existing volume of the driver instead of a new one.
+	annImportVolumeHandle = "csi.storage.k8s.io/import-volume-handle"
+
+	// prefixedAllowVolumeImportKey must be set to "true" in a storage
+	// class before PVCs of that class may import volumes. Otherwise
+	// everyone who can create PVCs could get access to any volume whose
+	// handle they know.
+	prefixedAllowVolumeImportKey = csiParameterPrefix + "allow-volume-import"
+)
+
+// checkVolumeImport returns the volume handle from annImportVolumeHandle,
+// empty if the claim doesn't have it, or a permanentError when the
+// import is not allowed.
+func checkVolumeImport(claim *v1.PersistentVolumeClaim, sc *storagev1.StorageClass) (string, error) {
+	handle := claim.Annotations[annImportVolumeHandle]
+	if handle == "" {
+		return "", nil
+	}
+	if sc.Parameters[prefixedAllowVolumeImportKey] != "true" {
+		return "", &permanentError{
+			reason: "VolumeImportNotAllowed",
+			message: fmt.Sprintf("PVC %s/%s has the %s annotation, but storage class %s does not allow importing volumes with the %s parameter",
+				claim.Namespace, claim.Name, annImportVolumeHandle, sc.Name, prefixedAllowVolumeImportKey),
+		}
+	}
+	if claim.Spec.DataSource != nil || claim.Annotations[annCloneFromPV] != "" {
+		return "", &permanentError{
+			reason: "VolumeImportConflict",
+			message: fmt.Sprintf("PVC %s/%s has the %s annotation and a data source, only one of them may be set",
+				claim.Namespace, claim.Name, annImportVolumeHandle),
+		}
+	}
+	return handle, nil
+}
+
+// checkVolumeImportInUse returns a permanentError when a PV of the
+// driver, other than one created for the claim by an earlier attempt,
+// already has the volume handle. Two PVs for the same volume would let
+// one claim destroy or modify the data of another one.
+func (p *csiProvisioner) checkVolumeImportInUse(ctx context.Context, claim *v1.PersistentVolumeClaim, handle string) error {
+	var pvs []*v1.PersistentVolume
+	if p.pvLister != nil {
+		var err error
+		pvs, err = p.pvLister.List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("list PVs: %v", err)
+		}
+	} else {
+		list, err := p.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("list PVs: %v", err)
+		}
+		for i := range list.Items {
+			pvs = append(pvs, &list.Items[i])
+		}
+	}
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != p.driverName || pv.Spec.CSI.VolumeHandle != handle {
+			continue
+		}
+		if pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.UID == claim.UID {
+			continue
+		}
+		return &permanentError{
+			reason:  "VolumeImportInUse",
+			message: fmt.Sprintf("volume %s cannot be imported because PV %s already uses it", handle, pv.Name),
+		}
+	}
+	return nil
+}
+
+// importVolume is called instead of CreateVolume for an existing volume.
+// It asks the driver about the volume with ControllerGetVolume or, if
+// the driver doesn't support that, with ListVolumes, so that the PV gets
+// the actual capacity, volume context and topology of the volume.
+// Without either capability, the volume is used as it is with the
+// requested capacity.
+func (p *csiProvisioner) importVolume(ctx context.Context, volumeID string, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	_, controllerCapabilities := p.getCapabilities()
+	var volume *csi.Volume
+	switch {
+	case controllerCapabilities[csi.ControllerServiceCapability_RPC_GET_VOLUME]:
+		resp, err := p.csiClient.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+		if status.Code(err) == codes.NotFound {
+			return nil, importNotFoundError(volumeID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ControllerGetVolume for volume %s: %v", volumeID, err)
+		}
+		volume = resp.GetVolume()
+	case controllerCapabilities[csi.ControllerServiceCapability_RPC_LIST_VOLUMES]:
+		var err error
+		volume, err = p.findVolume(ctx, volumeID)
+		if err != nil {
+			return nil, err
+		}
+		if volume == nil {
+			return nil, importNotFoundError(volumeID)
+		}
+	default:
+		klog.Warningf("CSI driver %s supports neither GET_VOLUME nor LIST_VOLUMES, importing volume %s without checking it", p.driverName, volumeID)
+		volume = &csi.Volume{VolumeId: volumeID}
+	}
+
+	requiredBytes := req.GetCapacityRange().GetRequiredBytes()
+	if volume.CapacityBytes != 0 && volume.CapacityBytes < requiredBytes {
+		return nil, &permanentError{
+			reason: "VolumeImportTooSmall",
+			message: fmt.Sprintf("volume %s has a capacity of %d bytes, which is less than the requested %d bytes",
+				volumeID, volume.CapacityBytes, requiredBytes),
+		}
+	}
+	return &csi.CreateVolumeResponse{Volume: volume}, nil
+}
+
+// findVolume pages through ListVolumes until it finds the volume. It
+// returns nil if the driver doesn't have it.
+func (p *csiProvisioner) findVolume(ctx context.Context, volumeID string) (*csi.Volume, error) {
+	token := ""
+	for {
+		resp, err := p.csiClient.ListVolumes(ctx, &csi.ListVolumesRequest{StartingToken: token})
+		if err != nil {
+			return nil, fmt.Errorf("ListVolumes: %v", err)
+		}
+		for _, entry := range resp.GetEntries() {
+			if entry.GetVolume().GetVolumeId() == volumeID {
+				return entry.GetVolume(), nil
+			}
+		}
+		token = resp.GetNextToken()
+		if token == "" {
+			return nil, nil
+		}
+	}
+}
+
+func importNotFoundError(volumeID string) error {
+	return &permanentError{
+		reason:  "VolumeImportNotFound",
+		message: fmt.Sprintf("volume %s cannot be imported because the CSI driver does not have it", volumeID),
+	}
+}
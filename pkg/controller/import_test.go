@@ -0,0 +1,291 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	csitrans "k8s.io/csi-translation-lib"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+func TestCheckVolumeImport(t *testing.T) {
+	testcases := map[string]struct {
+		handle       string
+		allowImport  string
+		dataSource   bool
+		cloneFromPV  bool
+		expectReason string
+	}{
+		"no import":          {},
+		"no import, allowed": {allowImport: "true"},
+		"import":             {handle: "vol-1", allowImport: "true"},
+		"not allowed":        {handle: "vol-1", expectReason: "VolumeImportNotAllowed"},
+		"not allowed, false": {handle: "vol-1", allowImport: "false", expectReason: "VolumeImportNotAllowed"},
+		"data source":        {handle: "vol-1", allowImport: "true", dataSource: true, expectReason: "VolumeImportConflict"},
+		"clone from PV":      {handle: "vol-1", allowImport: "true", cloneFromPV: true, expectReason: "VolumeImportConflict"},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			claim := &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", Annotations: map[string]string{}},
+			}
+			if tc.handle != "" {
+				claim.Annotations[annImportVolumeHandle] = tc.handle
+			}
+			if tc.dataSource {
+				claim.Spec.DataSource = &v1.TypedLocalObjectReference{Kind: pvcKind, Name: "source"}
+			}
+			if tc.cloneFromPV {
+				claim.Annotations[annCloneFromPV] = "released-pv"
+			}
+			sc := &storagev1.StorageClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "migrated"},
+				Parameters: map[string]string{},
+			}
+			if tc.allowImport != "" {
+				sc.Parameters[prefixedAllowVolumeImportKey] = tc.allowImport
+			}
+			handle, err := checkVolumeImport(claim, sc)
+			if tc.expectReason != "" {
+				var permanentErr *permanentError
+				if !errors.As(err, &permanentErr) || permanentErr.reason != tc.expectReason {
+					t.Errorf("expected %s, got %v", tc.expectReason, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if handle != tc.handle {
+				t.Errorf("expected handle %q, got %q", tc.handle, handle)
+			}
+		})
+	}
+}
+
+func TestProvisionImport(t *testing.T) {
+	existing := &csi.Volume{
+		CapacityBytes: 2 * requestedBytes,
+		VolumeId:      "existing-volume",
+		VolumeContext: map[string]string{"pool": "legacy"},
+	}
+	other := &csi.Volume{
+		CapacityBytes: requestedBytes / 2,
+		VolumeId:      "other-volume",
+	}
+
+	testcases := map[string]struct {
+		capability     csi.ControllerServiceCapability_RPC_Type
+		handle         string
+		getVolumeErr   error
+		expectCapacity int64
+		expectContext  map[string]string
+		pvs            []runtime.Object
+		expectEvent    string
+		expectErr      bool
+	}{
+		"get volume": {
+			capability:     csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			handle:         "existing-volume",
+			expectCapacity: 2 * requestedBytes,
+			expectContext:  map[string]string{"pool": "legacy"},
+		},
+		"get volume not found": {
+			capability:   csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			handle:       "existing-volume",
+			getVolumeErr: status.Error(codes.NotFound, "no such volume"),
+			expectEvent:  "VolumeImportNotFound",
+		},
+		"get volume failed": {
+			capability:   csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			handle:       "existing-volume",
+			getVolumeErr: status.Error(codes.Unavailable, "try again"),
+			expectErr:    true,
+		},
+		"get volume too small": {
+			capability:  csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			handle:      "other-volume",
+			expectEvent: "VolumeImportTooSmall",
+		},
+		"list volumes": {
+			capability:     csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+			handle:         "existing-volume",
+			expectCapacity: 2 * requestedBytes,
+			expectContext:  map[string]string{"pool": "legacy"},
+		},
+		"in use by other PV": {
+			capability: csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			handle:     "existing-volume",
+			pvs: []runtime.Object{&v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-pv"},
+				Spec: v1.PersistentVolumeSpec{
+					PersistentVolumeSource: v1.PersistentVolumeSource{
+						CSI: &v1.CSIPersistentVolumeSource{Driver: driverName, VolumeHandle: "existing-volume"},
+					},
+				},
+			}},
+			expectEvent: "VolumeImportInUse",
+		},
+		"same handle of other driver": {
+			capability: csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			handle:     "existing-volume",
+			pvs: []runtime.Object{&v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-pv"},
+				Spec: v1.PersistentVolumeSpec{
+					PersistentVolumeSource: v1.PersistentVolumeSource{
+						CSI: &v1.CSIPersistentVolumeSource{Driver: "other-driver", VolumeHandle: "existing-volume"},
+					},
+				},
+			}},
+			expectCapacity: 2 * requestedBytes,
+			expectContext:  map[string]string{"pool": "legacy"},
+		},
+		"list volumes not found": {
+			capability:  csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+			handle:      "missing-volume",
+			expectEvent: "VolumeImportNotFound",
+		},
+		"unchecked": {
+			handle:         "anything",
+			expectCapacity: requestedBytes,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tmpdir := tempDir(t)
+			defer os.RemoveAll(tmpdir)
+			mockController, driver, _, controllerServer, csiConn, err := createMockServer(t, tmpdir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mockController.Finish()
+			defer driver.Stop()
+
+			ctx := context.Background()
+			claim := createFakePVC(requestedBytes)
+			claim.Annotations[annImportVolumeHandle] = tc.handle
+			client := fakeclientset.NewSimpleClientset(append(tc.pvs, claim)...)
+			pluginCaps, controllerCaps := provisionCapabilities()
+			if tc.capability != csi.ControllerServiceCapability_RPC_UNKNOWN {
+				controllerCaps[tc.capability] = true
+			}
+			csiProvisioner := NewCSIProvisioner(client, 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
+				nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{})
+			recorder := record.NewFakeRecorder(10)
+			setEventRecorder(csiProvisioner, recorder)
+
+			controllerServer.EXPECT().CreateVolume(gomock.Any(), gomock.Any()).Times(0)
+			controllerServer.EXPECT().DeleteVolume(gomock.Any(), gomock.Any()).Times(0)
+			controllerServer.EXPECT().ControllerGetVolume(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+					if tc.getVolumeErr != nil {
+						return nil, tc.getVolumeErr
+					}
+					for _, volume := range []*csi.Volume{existing, other} {
+						if volume.VolumeId == req.VolumeId {
+							return &csi.ControllerGetVolumeResponse{Volume: volume}, nil
+						}
+					}
+					return nil, status.Error(codes.NotFound, "no such volume")
+				}).AnyTimes()
+			// Two pages, to check that all of them get searched.
+			controllerServer.EXPECT().ListVolumes(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+					if req.StartingToken == "" {
+						return &csi.ListVolumesResponse{
+							Entries:   []*csi.ListVolumesResponse_Entry{{Volume: other}},
+							NextToken: "page-2",
+						}, nil
+					}
+					return &csi.ListVolumesResponse{
+						Entries: []*csi.ListVolumesResponse_Entry{{Volume: existing}},
+					}, nil
+				}).AnyTimes()
+
+			deletePolicy := v1.PersistentVolumeReclaimDelete
+			pv, state, err := csiProvisioner.Provision(ctx, controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{
+					ObjectMeta:    metav1.ObjectMeta{Name: fakeSCName},
+					ReclaimPolicy: &deletePolicy,
+					Parameters:    map[string]string{prefixedAllowVolumeImportKey: "true"},
+				},
+				PVName: "test-name",
+				PVC:    claim,
+			})
+			if state != controller.ProvisioningFinished {
+				t.Errorf("expected state %s, got %s", controller.ProvisioningFinished, state)
+			}
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+
+			switch {
+			case tc.expectEvent != "":
+				if _, ok := err.(*controller.IgnoredError); !ok {
+					t.Errorf("expected IgnoredError, got: %v", err)
+				}
+				if len(events) != 1 || strings.Fields(events[0])[1] != tc.expectEvent {
+					t.Errorf("expected %s event, got: %q", tc.expectEvent, events)
+				}
+			case tc.expectErr:
+				if err == nil {
+					t.Error("expected error, got none")
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if pv.Spec.CSI.VolumeHandle != tc.handle {
+					t.Errorf("expected volume handle %q, got %q", tc.handle, pv.Spec.CSI.VolumeHandle)
+				}
+				if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+					t.Errorf("expected reclaim policy %s, got %s", v1.PersistentVolumeReclaimRetain, pv.Spec.PersistentVolumeReclaimPolicy)
+				}
+				capacity := pv.Spec.Capacity[v1.ResourceStorage]
+				if capacity.Value() != tc.expectCapacity {
+					t.Errorf("expected capacity %d, got %s", tc.expectCapacity, capacity.String())
+				}
+				for key, value := range tc.expectContext {
+					if pv.Spec.CSI.VolumeAttributes[key] != value {
+						t.Errorf("expected volume attribute %s=%s, got %v", key, value, pv.Spec.CSI.VolumeAttributes)
+					}
+				}
+			}
+		})
+	}
+}
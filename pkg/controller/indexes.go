@@ -0,0 +1,175 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	storageinformersv1 "k8s.io/client-go/informers/storage/v1"
+	storagelistersv1 "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/util"
+)
+
+const (
+	// pendingClaimsByStorageClassIndex indexes pending claims by the
+	// name of their storage class.
+	pendingClaimsByStorageClassIndex = "pendingByStorageClass"
+	// claimsBySelectedNodeIndex indexes claims by the node that the
+	// scheduler selected for them.
+	claimsBySelectedNodeIndex = "bySelectedNode"
+	// volumeAttachmentsByPVIndex indexes VolumeAttachments by the
+	// name of the PV that they attach.
+	volumeAttachmentsByPVIndex = "byPersistentVolume"
+)
+
+// AddClaimIndexers adds the indexers that are used by PendingClaimsForStorageClass
+// and ClaimsForSelectedNode. It must be called before the informer is started.
+// Looking up claims through them avoids iterating over all claims in the cache,
+// which matters in clusters with many claims.
+func AddClaimIndexers(claimInformer cache.SharedIndexInformer) error {
+	return claimInformer.AddIndexers(cache.Indexers{
+		pendingClaimsByStorageClassIndex: pendingClaimStorageClass,
+		claimsBySelectedNodeIndex:        claimSelectedNode,
+	})
+}
+
+func pendingClaimStorageClass(obj interface{}) ([]string, error) {
+	claim, ok := obj.(*v1.PersistentVolumeClaim)
+	if !ok {
+		return nil, fmt.Errorf("expected PersistentVolumeClaim, got %T", obj)
+	}
+	if claim.Status.Phase != v1.ClaimPending {
+		return nil, nil
+	}
+	return []string{util.GetPersistentVolumeClaimClass(claim)}, nil
+}
+
+func claimSelectedNode(obj interface{}) ([]string, error) {
+	claim, ok := obj.(*v1.PersistentVolumeClaim)
+	if !ok {
+		return nil, fmt.Errorf("expected PersistentVolumeClaim, got %T", obj)
+	}
+	if nodeName := claim.Annotations[annSelectedNode]; nodeName != "" {
+		return []string{nodeName}, nil
+	}
+	return nil, nil
+}
+
+// PendingClaimsForStorageClass returns all pending claims for the storage class.
+// An empty name finds pending claims without a class.
+func PendingClaimsForStorageClass(indexer cache.Indexer, storageClassName string) ([]*v1.PersistentVolumeClaim, error) {
+	return claimsByIndex(indexer, pendingClaimsByStorageClassIndex, storageClassName)
+}
+
+// ClaimsForSelectedNode returns all claims with the selected node annotation
+// for the node, regardless of whether they are already bound.
+func ClaimsForSelectedNode(indexer cache.Indexer, nodeName string) ([]*v1.PersistentVolumeClaim, error) {
+	return claimsByIndex(indexer, claimsBySelectedNodeIndex, nodeName)
+}
+
+func claimsByIndex(indexer cache.Indexer, index, value string) ([]*v1.PersistentVolumeClaim, error) {
+	objs, err := indexer.ByIndex(index, value)
+	if err != nil {
+		return nil, err
+	}
+	claims := make([]*v1.PersistentVolumeClaim, 0, len(objs))
+	for _, obj := range objs {
+		claim, ok := obj.(*v1.PersistentVolumeClaim)
+		if !ok {
+			return nil, fmt.Errorf("expected PersistentVolumeClaim in index %s, got %T", index, obj)
+		}
+		claims = append(claims, claim)
+	}
+	return claims, nil
+}
+
+// volumeAttachmentsForPVLister is implemented by VolumeAttachment listers
+// which can find the attachments of a PV without listing all of them.
+type volumeAttachmentsForPVLister interface {
+	ListForPersistentVolume(pvName string) ([]*storagev1.VolumeAttachment, error)
+}
+
+type indexedVolumeAttachmentLister struct {
+	storagelistersv1.VolumeAttachmentLister
+	indexer cache.Indexer
+}
+
+var _ volumeAttachmentsForPVLister = &indexedVolumeAttachmentLister{}
+
+// NewIndexedVolumeAttachmentLister adds an index by PV name to the informer
+// and returns a lister which uses it when checking whether a PV is still
+// attached. It must be called before the informer is started.
+func NewIndexedVolumeAttachmentLister(vaInformer storageinformersv1.VolumeAttachmentInformer) (storagelistersv1.VolumeAttachmentLister, error) {
+	informer := vaInformer.Informer()
+	if err := informer.AddIndexers(cache.Indexers{volumeAttachmentsByPVIndex: volumeAttachmentPV}); err != nil {
+		return nil, err
+	}
+	return &indexedVolumeAttachmentLister{
+		VolumeAttachmentLister: vaInformer.Lister(),
+		indexer:                informer.GetIndexer(),
+	}, nil
+}
+
+func volumeAttachmentPV(obj interface{}) ([]string, error) {
+	va, ok := obj.(*storagev1.VolumeAttachment)
+	if !ok {
+		return nil, fmt.Errorf("expected VolumeAttachment, got %T", obj)
+	}
+	if va.Spec.Source.PersistentVolumeName == nil {
+		return nil, nil
+	}
+	return []string{*va.Spec.Source.PersistentVolumeName}, nil
+}
+
+func (l *indexedVolumeAttachmentLister) ListForPersistentVolume(pvName string) ([]*storagev1.VolumeAttachment, error) {
+	objs, err := l.indexer.ByIndex(volumeAttachmentsByPVIndex, pvName)
+	if err != nil {
+		return nil, err
+	}
+	vas := make([]*storagev1.VolumeAttachment, 0, len(objs))
+	for _, obj := range objs {
+		va, ok := obj.(*storagev1.VolumeAttachment)
+		if !ok {
+			return nil, fmt.Errorf("expected VolumeAttachment in index %s, got %T", volumeAttachmentsByPVIndex, obj)
+		}
+		vas = append(vas, va)
+	}
+	return vas, nil
+}
+
+// listVolumeAttachmentsForPV falls back to listing all VolumeAttachments
+// if the lister has no index.
+func listVolumeAttachmentsForPV(lister storagelistersv1.VolumeAttachmentLister, pvName string) ([]*storagev1.VolumeAttachment, error) {
+	if indexed, ok := lister.(volumeAttachmentsForPVLister); ok {
+		return indexed.ListForPersistentVolume(pvName)
+	}
+	vaList, err := lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var vas []*storagev1.VolumeAttachment
+	for _, va := range vaList {
+		if va.Spec.Source.PersistentVolumeName != nil && *va.Spec.Source.PersistentVolumeName == pvName {
+			vas = append(vas, va)
+		}
+	}
+	return vas, nil
+}
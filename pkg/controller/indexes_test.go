@@ -0,0 +1,153 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	storagelistersv1 "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestClaimIndexers(t *testing.T) {
+	claim := func(name, class, node string, phase v1.PersistentVolumeClaimPhase) *v1.PersistentVolumeClaim {
+		claim := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{},
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &class,
+			},
+			Status: v1.PersistentVolumeClaimStatus{
+				Phase: phase,
+			},
+		}
+		if node != "" {
+			claim.Annotations[annSelectedNode] = node
+		}
+		return claim
+	}
+
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &v1.PersistentVolumeClaim{}, 0, cache.Indexers{})
+	if err := AddClaimIndexers(informer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	indexer := informer.GetIndexer()
+	for _, c := range []*v1.PersistentVolumeClaim{
+		claim("pending-a", "a", "", v1.ClaimPending),
+		claim("pending-a-node1", "a", "node1", v1.ClaimPending),
+		claim("bound-a-node1", "a", "node1", v1.ClaimBound),
+		claim("pending-b-node2", "b", "node2", v1.ClaimPending),
+	} {
+		if err := indexer.Add(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	names := func(claims []*v1.PersistentVolumeClaim, err error) []string {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var names []string
+		for _, claim := range claims {
+			names = append(names, claim.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	check := func(what string, actual []string, expected ...string) {
+		if len(actual) != len(expected) {
+			t.Errorf("%s: expected %v, got %v", what, expected, actual)
+			return
+		}
+		for i := range actual {
+			if actual[i] != expected[i] {
+				t.Errorf("%s: expected %v, got %v", what, expected, actual)
+				return
+			}
+		}
+	}
+
+	check("class a", names(PendingClaimsForStorageClass(indexer, "a")), "pending-a", "pending-a-node1")
+	check("class b", names(PendingClaimsForStorageClass(indexer, "b")), "pending-b-node2")
+	check("class c", names(PendingClaimsForStorageClass(indexer, "c")))
+	check("node1", names(ClaimsForSelectedNode(indexer, "node1")), "bound-a-node1", "pending-a-node1")
+	check("node3", names(ClaimsForSelectedNode(indexer, "node3")))
+
+	// Binding removes the claim from the class index.
+	if err := indexer.Update(claim("pending-b-node2", "b", "node2", v1.ClaimBound)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check("class b after binding", names(PendingClaimsForStorageClass(indexer, "b")))
+}
+
+func TestListVolumeAttachmentsForPV(t *testing.T) {
+	va := func(name, pvName string) *storagev1.VolumeAttachment {
+		va := &storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+		}
+		if pvName != "" {
+			va.Spec.Source.PersistentVolumeName = &pvName
+		}
+		return va
+	}
+	client := fakeclientset.NewSimpleClientset(va("va-1", "pv-1"), va("va-2", "pv-2"), va("va-3", "pv-1"), va("va-inline", ""))
+	factory := informers.NewSharedInformerFactory(client, 0)
+	indexed, err := NewIndexedVolumeAttachmentLister(factory.Storage().V1().VolumeAttachments())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+
+	for name, lister := range map[string]storagelistersv1.VolumeAttachmentLister{
+		"indexed": indexed,
+		"scan":    factory.Storage().V1().VolumeAttachments().Lister(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			vas, err := listVolumeAttachmentsForPV(lister, "pv-1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, va := range vas {
+				names = append(names, va.Name)
+			}
+			sort.Strings(names)
+			if len(names) != 2 || names[0] != "va-1" || names[1] != "va-3" {
+				t.Errorf("expected va-1 and va-3, got %v", names)
+			}
+			vas, err = listVolumeAttachmentsForPV(lister, "pv-3")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(vas) != 0 {
+				t.Errorf("expected no VolumeAttachments, got %v", vas)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+var inFlightCreateWaits = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Name:           "createvolume_in_flight_waits_total",
+		Help:           "Number of provisioning attempts which waited for a CreateVolume call for the same volume that continued in the background after an earlier attempt timed out, instead of calling CreateVolume again.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(inFlightCreateWaits)
+}
+
+// inFlightCreates keeps track of CreateVolume calls by volume name. A
+// call continues in the background when the provisioning attempt which
+// started it stops waiting for it, and the next attempts for the same
+// volume wait for that call instead of sending another one to the
+// driver. The zero value is ready to use.
+type inFlightCreates struct {
+	mutex sync.Mutex
+	calls map[string]*inFlightCreate
+	now   func() time.Time
+}
+
+type inFlightCreate struct {
+	done     chan struct{}
+	rep      *csi.CreateVolumeResponse
+	err      error
+	finished time.Time
+}
+
+// createVolume invokes create for the volume unless a call for it is
+// still running or finished without any attempt picking up its result.
+// It waits for the result until ctx is done, while create may run for
+// up to maxDuration.
+func (f *inFlightCreates) createVolume(ctx context.Context, name string, maxDuration time.Duration, create func(ctx context.Context) (*csi.CreateVolumeResponse, error)) (*csi.CreateVolumeResponse, error) {
+	f.mutex.Lock()
+	f.prune(maxDuration)
+	call, ok := f.calls[name]
+	if ok {
+		klog.V(3).Infof("Waiting for CreateVolume call for volume %s which was started by an earlier attempt", name)
+		inFlightCreateWaits.Inc()
+	} else {
+		if f.calls == nil {
+			f.calls = map[string]*inFlightCreate{}
+		}
+		call = &inFlightCreate{done: make(chan struct{})}
+		f.calls[name] = call
+		// The call keeps the values of the context, for example
+		// whether the volume was migrated, but not its deadline.
+		callCtx, cancel := context.WithTimeout(detachedContext{ctx}, maxDuration)
+		go func() {
+			defer cancel()
+			rep, err := create(callCtx)
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			call.rep, call.err, call.finished = rep, err, f.getNow()
+			close(call.done)
+		}()
+	}
+	f.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if f.calls[name] == call {
+			delete(f.calls, name)
+		}
+		return call.rep, call.err
+	case <-ctx.Done():
+		return nil, status.Errorf(codes.DeadlineExceeded, "CreateVolume for volume %s is still in progress: %v", name, ctx.Err())
+	}
+}
+
+// prune forgets calls whose result nobody picked up for maxDuration,
+// for example because the PVC was deleted. Must be called with the
+// mutex locked.
+func (f *inFlightCreates) prune(maxDuration time.Duration) {
+	now := f.getNow()
+	for name, call := range f.calls {
+		select {
+		case <-call.done:
+			if now.Sub(call.finished) > maxDuration {
+				delete(f.calls, name)
+			}
+		default:
+		}
+	}
+}
+
+func (f *inFlightCreates) getNow() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+// detachedContext has the values of its parent, but is neither
+// canceled nor has a deadline when the parent does.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
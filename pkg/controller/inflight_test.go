@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type key string
+
+func TestInFlightCreates(t *testing.T) {
+	var f inFlightCreates
+	var calls int32
+	release := make(chan struct{})
+	create := func(ctx context.Context) (*csi.CreateVolumeResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		if ctx.Value(key("migrated")) != "true" {
+			t.Error("context values were not passed on")
+		}
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) < time.Minute/2 {
+			t.Errorf("expected the deadline of the call to be a minute away, got %v", deadline)
+		}
+		<-release
+		return &csi.CreateVolumeResponse{Volume: &csi.Volume{VolumeId: "vol-1"}}, nil
+	}
+	attempt := func(timeout time.Duration) (*csi.CreateVolumeResponse, error) {
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), key("migrated"), "true"), timeout)
+		defer cancel()
+		return f.createVolume(ctx, "pvc-1", time.Minute, create)
+	}
+
+	// The first attempts time out while the call is in flight.
+	for i := 0; i < 2; i++ {
+		if _, err := attempt(10 * time.Millisecond); status.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("attempt #%d: expected DeadlineExceeded, got %v", i, err)
+		}
+	}
+
+	// The next one gets the result of the same call.
+	close(release)
+	rep, err := attempt(time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rep.GetVolume().GetVolumeId() != "vol-1" {
+		t.Errorf("expected volume vol-1, got %v", rep)
+	}
+	if calls != 1 {
+		t.Errorf("expected one CreateVolume call, got %d", calls)
+	}
+
+	// Once the result was picked up, CreateVolume gets called again.
+	if _, err := attempt(time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected two CreateVolume calls, got %d", calls)
+	}
+}
+
+func TestInFlightCreatesPrune(t *testing.T) {
+	now := time.Now()
+	f := inFlightCreates{now: func() time.Time { return now }}
+	create := func(ctx context.Context) (*csi.CreateVolumeResponse, error) {
+		return nil, status.Error(codes.Internal, "failed")
+	}
+
+	// Nobody waits for the result.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.createVolume(ctx, "pvc-1", time.Minute, create)
+	f.mutex.Lock()
+	call := f.calls["pvc-1"]
+	f.mutex.Unlock()
+	<-call.done
+
+	now = now.Add(2 * time.Minute)
+	if _, err := f.createVolume(context.Background(), "pvc-2", time.Minute, create); status.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal error, got %v", err)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.calls) != 0 {
+		t.Errorf("expected no remembered calls, got %v", f.calls)
+	}
+}
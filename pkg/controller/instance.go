@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+// annProvisionerInstanceID is set on new PVs to the
+// --provisioner-instance-id of the external-provisioner deployment
+// which provisioned them.
+const annProvisionerInstanceID = "volume.kubernetes.io/provisioner-instance-id"
+
+// instanceProvisioner allows several deployments of the
+// external-provisioner for the same driver to co-exist, for example
+// during a blue/green rollout. Each one records its instance ID on the
+// PVs that it provisions and only deletes those PVs, the ones of
+// adopted instances and PVs without an instance ID.
+type instanceProvisioner struct {
+	controller.Provisioner
+	instanceID string
+	adopted    sets.String
+}
+
+var _ controller.Provisioner = &instanceProvisioner{}
+var _ controller.BlockProvisioner = &instanceProvisioner{}
+var _ controller.Qualifier = &instanceProvisioner{}
+var _ controller.DeletionGuard = &instanceProvisioner{}
+
+// NewInstanceProvisioner wraps the provisioner such that new PVs are
+// annotated with the instance ID and PVs of other instances are left
+// alone, unless their instance ID is in the list of adopted IDs.
+func NewInstanceProvisioner(p controller.Provisioner, instanceID string, adoptedInstanceIDs []string) controller.Provisioner {
+	return &instanceProvisioner{
+		Provisioner: p,
+		instanceID:  instanceID,
+		adopted:     sets.NewString(adoptedInstanceIDs...),
+	}
+}
+
+func (p *instanceProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	pv, state, err := p.Provisioner.Provision(ctx, options)
+	if pv != nil {
+		metav1.SetMetaDataAnnotation(&pv.ObjectMeta, annProvisionerInstanceID, p.instanceID)
+	}
+	return pv, state, err
+}
+
+func (p *instanceProvisioner) SupportsBlock(ctx context.Context) bool {
+	if blockProvisioner, ok := p.Provisioner.(controller.BlockProvisioner); ok {
+		return blockProvisioner.SupportsBlock(ctx)
+	}
+	return false
+}
+
+func (p *instanceProvisioner) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	if qualifier, ok := p.Provisioner.(controller.Qualifier); ok {
+		return qualifier.ShouldProvision(ctx, claim)
+	}
+	return true
+}
+
+func (p *instanceProvisioner) ShouldDelete(ctx context.Context, volume *v1.PersistentVolume) bool {
+	if instanceID, ok := volume.Annotations[annProvisionerInstanceID]; ok && instanceID != p.instanceID && !p.adopted.Has(instanceID) {
+		klog.V(4).Infof("PV %s was provisioned by instance %q, leaving it to that instance", volume.Name, instanceID)
+		return false
+	}
+	if deletionGuard, ok := p.Provisioner.(controller.DeletionGuard); ok {
+		return deletionGuard.ShouldDelete(ctx, volume)
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+func TestInstanceProvisioner(t *testing.T) {
+	ctx := context.Background()
+	blue := NewInstanceProvisioner(&fakeProvisioner{}, "blue", nil)
+	green := NewInstanceProvisioner(&fakeProvisioner{}, "green", []string{"blue"})
+
+	pv, _, err := blue.Provision(ctx, controller.ProvisionOptions{PVC: &v1.PersistentVolumeClaim{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if instanceID := pv.Annotations[annProvisionerInstanceID]; instanceID != "blue" {
+		t.Fatalf("expected instance ID blue, got %q", instanceID)
+	}
+
+	pvOf := func(instanceID string) *v1.PersistentVolume {
+		pv := &v1.PersistentVolume{}
+		if instanceID != "" {
+			pv.Annotations = map[string]string{annProvisionerInstanceID: instanceID}
+		}
+		return pv
+	}
+	testcases := []struct {
+		instanceID  string
+		blue, green bool
+	}{
+		{instanceID: "", blue: true, green: true},
+		{instanceID: "blue", blue: true, green: true},
+		{instanceID: "green", blue: false, green: true},
+		{instanceID: "red", blue: false, green: false},
+	}
+	for _, tc := range testcases {
+		if should := blue.(controller.DeletionGuard).ShouldDelete(ctx, pvOf(tc.instanceID)); should != tc.blue {
+			t.Errorf("blue, PV of %q: expected ShouldDelete %v, got %v", tc.instanceID, tc.blue, should)
+		}
+		if should := green.(controller.DeletionGuard).ShouldDelete(ctx, pvOf(tc.instanceID)); should != tc.green {
+			t.Errorf("green, PV of %q: expected ShouldDelete %v, got %v", tc.instanceID, tc.green, should)
+		}
+	}
+}
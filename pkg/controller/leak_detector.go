@@ -0,0 +1,204 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+const (
+	// annDynamicallyProvisioned is set by the provisioner library
+	// for all PVs that it created.
+	annDynamicallyProvisioned = "pv.kubernetes.io/provisioned-by"
+
+	// maxLeakedVolumes limits how many leaked volumes are remembered
+	// for logging. The metric counts all of them.
+	maxLeakedVolumes = 100
+
+	// deletedVolumeExpiry is how long a successful DeleteVolume is
+	// remembered while waiting for the PV to be removed.
+	deletedVolumeExpiry = time.Hour
+)
+
+var leakedVolumes = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Name:           "persistentvolume_leaked_volumes_total",
+		Help:           "Number of dynamically provisioned PVs with reclaim policy Delete which were removed although DeleteVolume had not succeeded for them.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(leakedVolumes)
+}
+
+// LeakDetector remembers for which PVs DeleteVolume succeeded and
+// reports PVs which get removed without that, for example because
+// someone removed the finalizer or the PV was deleted while still
+// bound. The volumes of those PVs probably still exist in the storage
+// backend and need to be cleaned up manually.
+type LeakDetector struct {
+	driverName string
+	now        func() time.Time
+
+	mutex sync.Mutex
+	// deleted maps PV names to the time when DeleteVolume succeeded.
+	deleted map[string]time.Time
+	// leaked contains the most recently detected leaked volumes.
+	leaked []leakedVolume
+}
+
+type leakedVolume struct {
+	pvName       string
+	volumeHandle string
+	removed      time.Time
+}
+
+// NewLeakDetector creates a detector for PVs of the driver. The informer
+// must deliver PV deletions.
+func NewLeakDetector(driverName string, pvInformer cache.SharedInformer) *LeakDetector {
+	d := &LeakDetector{
+		driverName: driverName,
+		now:        time.Now,
+		deleted:    map[string]time.Time{},
+	}
+	pvInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if unknown, ok := obj.(cache.DeletedFinalStateUnknown); ok && unknown.Obj != nil {
+				obj = unknown.Obj
+			}
+			pv, ok := obj.(*v1.PersistentVolume)
+			if !ok {
+				klog.Errorf("deleted object: expected PersistentVolume, got %T -> ignoring it", obj)
+				return
+			}
+			d.onPVDelete(pv)
+		},
+	})
+	return d
+}
+
+// Run logs the known leaked volumes once per interval until the context
+// is done.
+func (d *LeakDetector) Run(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) { d.logLeaked() }, interval)
+}
+
+// Wrap returns a provisioner which informs the detector about volumes
+// that were deleted successfully.
+func (d *LeakDetector) Wrap(p controller.Provisioner) controller.Provisioner {
+	return &leakTrackingProvisioner{
+		Provisioner: p,
+		d:           d,
+	}
+}
+
+func (d *LeakDetector) volumeDeleted(pv *v1.PersistentVolume) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.deleted[pv.Name] = d.now()
+}
+
+func (d *LeakDetector) onPVDelete(pv *v1.PersistentVolume) {
+	if pv.Spec.CSI == nil ||
+		pv.Spec.CSI.Driver != d.driverName ||
+		pv.Annotations[annDynamicallyProvisioned] != d.driverName ||
+		pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+		// Not a volume that we are responsible for deleting.
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, ok := d.deleted[pv.Name]; ok {
+		delete(d.deleted, pv.Name)
+		return
+	}
+	klog.Warningf("PV %s was removed without deleting volume %s, the volume may have leaked", pv.Name, pv.Spec.CSI.VolumeHandle)
+	leakedVolumes.Inc()
+	d.leaked = append(d.leaked, leakedVolume{
+		pvName:       pv.Name,
+		volumeHandle: pv.Spec.CSI.VolumeHandle,
+		removed:      d.now(),
+	})
+	if len(d.leaked) > maxLeakedVolumes {
+		d.leaked = d.leaked[len(d.leaked)-maxLeakedVolumes:]
+	}
+}
+
+func (d *LeakDetector) logLeaked() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// Also forget about successful deletions whose PV never got
+	// removed, for example because it was re-created.
+	now := d.now()
+	for pvName, deleted := range d.deleted {
+		if now.Sub(deleted) > deletedVolumeExpiry {
+			delete(d.deleted, pvName)
+		}
+	}
+
+	if len(d.leaked) == 0 {
+		return
+	}
+	klog.Warningf("%d recently removed PVs may have leaked their volumes:", len(d.leaked))
+	for _, leaked := range d.leaked {
+		klog.Warningf("  PV %s, volume %s, removed at %s", leaked.pvName, leaked.volumeHandle, leaked.removed.Format(time.RFC3339))
+	}
+}
+
+type leakTrackingProvisioner struct {
+	controller.Provisioner
+	d *LeakDetector
+}
+
+var _ controller.Provisioner = &leakTrackingProvisioner{}
+var _ controller.BlockProvisioner = &leakTrackingProvisioner{}
+var _ controller.Qualifier = &leakTrackingProvisioner{}
+
+func (p *leakTrackingProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	err := p.Provisioner.Delete(ctx, pv)
+	if err == nil {
+		p.d.volumeDeleted(pv)
+	}
+	return err
+}
+
+func (p *leakTrackingProvisioner) SupportsBlock(ctx context.Context) bool {
+	if blockProvisioner, ok := p.Provisioner.(controller.BlockProvisioner); ok {
+		return blockProvisioner.SupportsBlock(ctx)
+	}
+	return false
+}
+
+func (p *leakTrackingProvisioner) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	if qualifier, ok := p.Provisioner.(controller.Qualifier); ok {
+		return qualifier.ShouldProvision(ctx, claim)
+	}
+	return true
+}
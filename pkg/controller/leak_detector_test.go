@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLeakDetector(t *testing.T) {
+	pv := func(name, driver, provisionedBy string, policy v1.PersistentVolumeReclaimPolicy) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{annDynamicallyProvisioned: provisionedBy},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: policy,
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{
+						Driver:       driver,
+						VolumeHandle: name + "-handle",
+					},
+				},
+			},
+		}
+	}
+
+	testcases := map[string]struct {
+		pv           *v1.PersistentVolume
+		deleteVolume bool
+		expectLeak   bool
+	}{
+		"deleted": {
+			pv:           pv("pv", driverName, driverName, v1.PersistentVolumeReclaimDelete),
+			deleteVolume: true,
+		},
+		"leaked": {
+			pv:         pv("pv", driverName, driverName, v1.PersistentVolumeReclaimDelete),
+			expectLeak: true,
+		},
+		"retained": {
+			pv: pv("pv", driverName, driverName, v1.PersistentVolumeReclaimRetain),
+		},
+		"static": {
+			pv: pv("pv", driverName, "", v1.PersistentVolumeReclaimDelete),
+		},
+		"other driver": {
+			pv: pv("pv", "other-driver", "other-driver", v1.PersistentVolumeReclaimDelete),
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			d := &LeakDetector{
+				driverName: driverName,
+				now:        time.Now,
+				deleted:    map[string]time.Time{},
+			}
+			if tc.deleteVolume {
+				if err := d.Wrap(&fakeProvisioner{}).Delete(context.Background(), tc.pv); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			d.onPVDelete(tc.pv)
+			if leaked := len(d.leaked) > 0; leaked != tc.expectLeak {
+				t.Errorf("expected leak %v, got %v", tc.expectLeak, d.leaked)
+			}
+			if len(d.deleted) > 0 {
+				t.Errorf("successful deletion not forgotten: %v", d.deleted)
+			}
+		})
+	}
+}
+
+func TestLeakDetectorExpiry(t *testing.T) {
+	now := time.Now()
+	d := &LeakDetector{
+		driverName: driverName,
+		now:        func() time.Time { return now },
+		deleted: map[string]time.Time{
+			"old":    now.Add(-2 * deletedVolumeExpiry),
+			"recent": now.Add(-time.Minute),
+		},
+	}
+	d.logLeaked()
+	if _, ok := d.deleted["old"]; ok {
+		t.Error("old deletion not forgotten")
+	}
+	if _, ok := d.deleted["recent"]; !ok {
+		t.Error("recent deletion forgotten")
+	}
+}
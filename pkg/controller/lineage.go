@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// annSourceSnapshotHandle is set on PVs which were restored from a
+	// snapshot to the CSI snapshot handle. It remains valid after the
+	// VolumeSnapshot and VolumeSnapshotContent objects are gone.
+	annSourceSnapshotHandle = "csi.storage.k8s.io/source-snapshot-handle"
+
+	// annSourcePV and annSourceVolumeHandle are set on PVs which were
+	// cloned from another volume, either through a PVC data source or
+	// annCloneFromPV. The volume handle identifies the source also
+	// after its PV was deleted.
+	annSourcePV           = "csi.storage.k8s.io/source-pv"
+	annSourceVolumeHandle = "csi.storage.k8s.io/source-volume-handle"
+)
+
+// setLineageAnnotations records on the PV where its content came from.
+// sourcePVName is the PV that was cloned, if known.
+func setLineageAnnotations(pv *v1.PersistentVolume, contentSource *csi.VolumeContentSource, sourcePVName string) {
+	if snapshot := contentSource.GetSnapshot(); snapshot != nil {
+		metav1.SetMetaDataAnnotation(&pv.ObjectMeta, annSourceSnapshotHandle, snapshot.GetSnapshotId())
+	}
+	if volume := contentSource.GetVolume(); volume != nil {
+		if sourcePVName != "" {
+			metav1.SetMetaDataAnnotation(&pv.ObjectMeta, annSourcePV, sourcePVName)
+		}
+		metav1.SetMetaDataAnnotation(&pv.ObjectMeta, annSourceVolumeHandle, volume.GetVolumeId())
+	}
+}
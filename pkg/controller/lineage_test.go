@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestSetLineageAnnotations(t *testing.T) {
+	testcases := map[string]struct {
+		contentSource     *csi.VolumeContentSource
+		sourcePVName      string
+		expectAnnotations map[string]string
+	}{
+		"empty volume": {},
+		"snapshot": {
+			contentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snapshot-handle"},
+				},
+			},
+			expectAnnotations: map[string]string{annSourceSnapshotHandle: "snapshot-handle"},
+		},
+		"clone": {
+			contentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "volume-handle"},
+				},
+			},
+			sourcePVName: "source-pv",
+			expectAnnotations: map[string]string{
+				annSourcePV:           "source-pv",
+				annSourceVolumeHandle: "volume-handle",
+			},
+		},
+		"clone without PV name": {
+			contentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "volume-handle"},
+				},
+			},
+			expectAnnotations: map[string]string{annSourceVolumeHandle: "volume-handle"},
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			pv := &v1.PersistentVolume{}
+			setLineageAnnotations(pv, tc.contentSource, tc.sourcePVName)
+			if !reflect.DeepEqual(pv.Annotations, tc.expectAnnotations) {
+				t.Errorf("expected annotations %v, got %v", tc.expectAnnotations, pv.Annotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+)
+
+// VerifyNodeInfo compares the NodeGetInfo response of a driver which
+// runs with --node-deployment against the CSINode and Node objects
+// that kubelet created when registering the driver. Those are what
+// the scheduler uses, so topology segments derived only from
+// NodeGetInfo may be wrong when the two diverge, for example after
+// the driver was reconfigured without re-registering.
+//
+// It returns the differences and, if the driver is registered, the
+// node information as seen by Kubernetes: the node ID from CSINode and
+// the Node labels for the topology keys in CSINode. registered is nil
+// when the objects do not exist, for example because kubelet has not
+// registered the driver yet.
+func VerifyNodeInfo(ctx context.Context, client kubernetes.Interface, driverName, nodeName string, nodeInfo *csi.NodeGetInfoResponse) (registered *csi.NodeGetInfoResponse, differences []string, err error) {
+	csiNode, err := client.StorageV1().CSINodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("get CSINode %s: %v", nodeName, err)
+	}
+	var topologyKeys []string
+	found := false
+	registered = &csi.NodeGetInfoResponse{
+		MaxVolumesPerNode: nodeInfo.MaxVolumesPerNode,
+	}
+	for _, driver := range csiNode.Spec.Drivers {
+		if driver.Name == driverName {
+			found = true
+			registered.NodeId = driver.NodeID
+			topologyKeys = driver.TopologyKeys
+			break
+		}
+	}
+	if !found {
+		return nil, nil, nil
+	}
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("get Node %s: %v", nodeName, err)
+	}
+
+	if registered.NodeId != nodeInfo.NodeId {
+		differences = append(differences, fmt.Sprintf("node ID %q from NodeGetInfo, %q in CSINode", nodeInfo.NodeId, registered.NodeId))
+	}
+	segments := map[string]string{}
+	if nodeInfo.AccessibleTopology != nil {
+		segments = nodeInfo.AccessibleTopology.Segments
+	}
+	driverKeys := sets.StringKeySet(segments)
+	registeredKeys := sets.NewString(topologyKeys...)
+	if !driverKeys.Equal(registeredKeys) {
+		differences = append(differences, fmt.Sprintf("topology keys %v from NodeGetInfo, %v in CSINode", driverKeys.List(), registeredKeys.List()))
+	}
+	if len(topologyKeys) > 0 {
+		registered.AccessibleTopology = &csi.Topology{Segments: map[string]string{}}
+		for _, key := range topologyKeys {
+			if value, ok := node.Labels[key]; ok {
+				registered.AccessibleTopology.Segments[key] = value
+			}
+		}
+	}
+	for _, key := range driverKeys.List() {
+		value, ok := node.Labels[key]
+		if !ok {
+			differences = append(differences, fmt.Sprintf("label %s=%q from NodeGetInfo is missing in Node", key, segments[key]))
+		} else if value != segments[key] {
+			differences = append(differences, fmt.Sprintf("label %s=%q from NodeGetInfo, %q in Node", key, segments[key], value))
+		}
+	}
+	return registered, differences, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVerifyNodeInfo(t *testing.T) {
+	const nodeName = "node-1"
+	nodeInfo := &csi.NodeGetInfoResponse{
+		NodeId:             "id-1",
+		MaxVolumesPerNode:  10,
+		AccessibleTopology: &csi.Topology{Segments: map[string]string{"zone": "a"}},
+	}
+	csiNode := func(driver, nodeID string, keys ...string) *storagev1.CSINode {
+		return &storagev1.CSINode{
+			ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+			Spec: storagev1.CSINodeSpec{
+				Drivers: []storagev1.CSINodeDriver{{Name: driver, NodeID: nodeID, TopologyKeys: keys}},
+			},
+		}
+	}
+	node := func(labels map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName, Labels: labels}}
+	}
+
+	testcases := map[string]struct {
+		objects           []runtime.Object
+		expectRegistered  *csi.NodeGetInfoResponse
+		expectDifferences []string
+	}{
+		"not registered": {
+			objects: []runtime.Object{node(nil)},
+		},
+		"other driver": {
+			objects: []runtime.Object{csiNode("other", "id-1", "zone"), node(map[string]string{"zone": "a"})},
+		},
+		"same": {
+			objects:          []runtime.Object{csiNode(driverName, "id-1", "zone"), node(map[string]string{"zone": "a"})},
+			expectRegistered: nodeInfo,
+		},
+		"different": {
+			objects: []runtime.Object{csiNode(driverName, "id-2", "zone", "rack"), node(map[string]string{"zone": "b", "rack": "r1"})},
+			expectRegistered: &csi.NodeGetInfoResponse{
+				NodeId:             "id-2",
+				MaxVolumesPerNode:  10,
+				AccessibleTopology: &csi.Topology{Segments: map[string]string{"zone": "b", "rack": "r1"}},
+			},
+			expectDifferences: []string{
+				`node ID "id-1" from NodeGetInfo, "id-2" in CSINode`,
+				`topology keys [zone] from NodeGetInfo, [rack zone] in CSINode`,
+				`label zone="a" from NodeGetInfo, "b" in Node`,
+			},
+		},
+		"missing label": {
+			objects: []runtime.Object{csiNode(driverName, "id-1", "zone"), node(nil)},
+			expectRegistered: &csi.NodeGetInfoResponse{
+				NodeId:             "id-1",
+				MaxVolumesPerNode:  10,
+				AccessibleTopology: &csi.Topology{Segments: map[string]string{}},
+			},
+			expectDifferences: []string{
+				`label zone="a" from NodeGetInfo is missing in Node`,
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			client := fakeclientset.NewSimpleClientset(tc.objects...)
+			registered, differences, err := VerifyNodeInfo(context.Background(), client, driverName, nodeName, nodeInfo)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(registered, tc.expectRegistered) {
+				t.Errorf("expected registered node info %+v, got %+v", tc.expectRegistered, registered)
+			}
+			if !reflect.DeepEqual(differences, tc.expectDifferences) {
+				t.Errorf("expected differences %q, got %q", tc.expectDifferences, differences)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/klog/v2"
+)
+
+// OrphanedVolumePolicy determines how an external-provisioner in
+// node deployment handles the deletion of volumes which are not
+// accessible by any existing node, for example because the node
+// that they were created for was removed from the cluster.
+type OrphanedVolumePolicy string
+
+const (
+	// OrphanedVolumesIgnore leaves orphaned volumes alone. This is the default.
+	OrphanedVolumesIgnore OrphanedVolumePolicy = "ignore"
+	// OrphanedVolumesDistribute spreads orphaned volumes across all nodes
+	// which have the CSI driver. Each volume is handled by exactly one
+	// of those nodes.
+	OrphanedVolumesDistribute OrphanedVolumePolicy = "distribute"
+	// OrphanedVolumesFallback handles all orphaned volumes in the
+	// instance where it is set. It should be set for exactly one node.
+	OrphanedVolumesFallback OrphanedVolumePolicy = "fallback"
+)
+
+// ParseOrphanedVolumePolicy checks the value of a command line flag.
+func ParseOrphanedVolumePolicy(value string) (OrphanedVolumePolicy, error) {
+	switch policy := OrphanedVolumePolicy(value); policy {
+	case OrphanedVolumesIgnore, OrphanedVolumesDistribute, OrphanedVolumesFallback:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid orphaned volume policy %q, must be one of %q, %q or %q",
+			value, OrphanedVolumesIgnore, OrphanedVolumesDistribute, OrphanedVolumesFallback)
+	}
+}
+
+// claimOrphanedVolume is called in node deployment for a volume that is
+// not accessible on the local node. It returns true if the volume is not
+// accessible by any node and the policy says that the local node is
+// responsible for deleting it.
+func (p *csiProvisioner) claimOrphanedVolume(volume *v1.PersistentVolume) (bool, error) {
+	nd := p.nodeDeployment
+	if nd.OrphanedVolumes == "" || nd.OrphanedVolumes == OrphanedVolumesIgnore {
+		return false, nil
+	}
+	affinity := volume.Spec.NodeAffinity
+	if affinity == nil || affinity.Required == nil {
+		// Accessible everywhere, cannot be orphaned.
+		return false, nil
+	}
+
+	nodes, err := nd.NodeLister.List(labels.Everything())
+	if err != nil {
+		return false, fmt.Errorf("list nodes: %v", err)
+	}
+	for _, node := range nodes {
+		matches, err := corev1helpers.MatchNodeSelectorTerms(node, affinity.Required)
+		if err != nil {
+			return false, fmt.Errorf("checking volume affinity failed: %v", err)
+		}
+		if matches {
+			// Some other instance is responsible.
+			return false, nil
+		}
+	}
+
+	if nd.OrphanedVolumes == OrphanedVolumesFallback {
+		klog.V(3).Infof("volume %s is not accessible by any node, deleting it as fallback instance", volume.Name)
+		return true, nil
+	}
+
+	// Pick one of the nodes with the driver based on the volume
+	// name, the same way in all instances.
+	csiNodes, err := nd.CSINodeLister.List(labels.Everything())
+	if err != nil {
+		return false, fmt.Errorf("list CSINodes: %v", err)
+	}
+	var candidates []string
+	for _, csiNode := range csiNodes {
+		for _, driver := range csiNode.Spec.Drivers {
+			if driver.Name == p.driverName {
+				candidates = append(candidates, csiNode.Name)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		// Not even the local node is registered yet, try again later.
+		return false, nil
+	}
+	sort.Strings(candidates)
+	hash := fnv.New32a()
+	hash.Write([]byte(volume.Name))
+	owner := candidates[hash.Sum32()%uint32(len(candidates))]
+	if owner != nd.NodeName {
+		klog.V(5).Infof("volume %s is not accessible by any node, node %s is responsible for deleting it", volume.Name, owner)
+		return false, nil
+	}
+	klog.V(3).Infof("volume %s is not accessible by any node, deleting it on this node", volume.Name)
+	return true, nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const orphanTopologyKey = "example.com/node"
+
+func orphanTestProvisioner(t *testing.T, nodeName string, policy OrphanedVolumePolicy, nodes ...string) *csiProvisioner {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	nodeInformer := factory.Core().V1().Nodes()
+	csiNodeInformer := factory.Storage().V1().CSINodes()
+	for _, name := range nodes {
+		if err := nodeInformer.Informer().GetStore().Add(&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{orphanTopologyKey: name},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := csiNodeInformer.Informer().GetStore().Add(&storagev1.CSINode{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: storagev1.CSINodeSpec{
+				Drivers: []storagev1.CSINodeDriver{{Name: driverName}},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &csiProvisioner{
+		driverName: driverName,
+		nodeDeployment: &internalNodeDeployment{
+			NodeDeployment: NodeDeployment{
+				NodeName:        nodeName,
+				OrphanedVolumes: policy,
+				NodeLister:      nodeInformer.Lister(),
+				CSINodeLister:   csiNodeInformer.Lister(),
+			},
+		},
+	}
+}
+
+func orphanTestVolume(name, node string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeSpec{
+			NodeAffinity: &v1.VolumeNodeAffinity{
+				Required: &v1.NodeSelector{
+					NodeSelectorTerms: []v1.NodeSelectorTerm{{
+						MatchExpressions: []v1.NodeSelectorRequirement{{
+							Key:      orphanTopologyKey,
+							Operator: v1.NodeSelectorOpIn,
+							Values:   []string{node},
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func TestClaimOrphanedVolume(t *testing.T) {
+	testcases := map[string]struct {
+		policy   OrphanedVolumePolicy
+		volume   *v1.PersistentVolume
+		expected bool
+	}{
+		"ignore": {
+			policy: OrphanedVolumesIgnore,
+			volume: orphanTestVolume("pv", "removed"),
+		},
+		"fallback, node exists": {
+			policy: OrphanedVolumesFallback,
+			volume: orphanTestVolume("pv", "node-2"),
+		},
+		"fallback, node removed": {
+			policy:   OrphanedVolumesFallback,
+			volume:   orphanTestVolume("pv", "removed"),
+			expected: true,
+		},
+		"fallback, no affinity": {
+			policy: OrphanedVolumesFallback,
+			volume: &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv"}},
+		},
+		"distribute, node exists": {
+			policy: OrphanedVolumesDistribute,
+			volume: orphanTestVolume("pv", "node-2"),
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			p := orphanTestProvisioner(t, "node-1", tc.policy, "node-1", "node-2")
+			orphaned, err := p.claimOrphanedVolume(tc.volume)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if orphaned != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, orphaned)
+			}
+		})
+	}
+}
+
+func TestClaimOrphanedVolumeDistribute(t *testing.T) {
+	nodes := []string{"node-1", "node-2", "node-3"}
+	var provisioners []*csiProvisioner
+	for _, node := range nodes {
+		provisioners = append(provisioners, orphanTestProvisioner(t, node, OrphanedVolumesDistribute, nodes...))
+	}
+	counts := map[string]int{}
+	for i := 0; i < 60; i++ {
+		volume := orphanTestVolume(fmt.Sprintf("pv-%d", i), "removed")
+		owners := 0
+		for j, p := range provisioners {
+			orphaned, err := p.claimOrphanedVolume(volume)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if orphaned {
+				owners++
+				counts[nodes[j]]++
+			}
+		}
+		if owners != 1 {
+			t.Errorf("volume %s was claimed by %d nodes", volume.Name, owners)
+		}
+	}
+	for _, node := range nodes {
+		if counts[node] == 0 {
+			t.Errorf("node %s did not get any volumes", node)
+		}
+	}
+}
+
+func TestParseOrphanedVolumePolicy(t *testing.T) {
+	for _, value := range []string{"ignore", "distribute", "fallback"} {
+		if _, err := ParseOrphanedVolumePolicy(value); err != nil {
+			t.Errorf("%s: unexpected error: %v", value, err)
+		}
+	}
+	if _, err := ParseOrphanedVolumePolicy("all"); err == nil {
+		t.Error("expected error for invalid policy")
+	}
+}
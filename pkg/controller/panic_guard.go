@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/panicguard"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+// panicGuardProvisioner turns panics during Provision and Delete into
+// errors. The workers of the provisioner library do not recover from
+// panics themselves, but they retry failed operations.
+type panicGuardProvisioner struct {
+	controller.Provisioner
+}
+
+var _ controller.Provisioner = &panicGuardProvisioner{}
+var _ controller.BlockProvisioner = &panicGuardProvisioner{}
+var _ controller.Qualifier = &panicGuardProvisioner{}
+var _ controller.DeletionGuard = &panicGuardProvisioner{}
+
+// NewPanicGuardProvisioner wraps the provisioner such that a panic
+// while provisioning or deleting one volume gets logged and retried
+// instead of crashing the process.
+func NewPanicGuardProvisioner(p controller.Provisioner) controller.Provisioner {
+	return &panicGuardProvisioner{
+		Provisioner: p,
+	}
+}
+
+func (p *panicGuardProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (pv *v1.PersistentVolume, state controller.ProvisioningState, err error) {
+	if panicErr := panicguard.Call("claims", klog.KObj(options.PVC), func() {
+		pv, state, err = p.Provisioner.Provision(ctx, options)
+	}); panicErr != nil {
+		// The volume might have been created before the panic,
+		// so provisioning must be retried even if the claim
+		// gets changed.
+		return nil, controller.ProvisioningInBackground, panicErr
+	}
+	return pv, state, err
+}
+
+func (p *panicGuardProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume) (err error) {
+	if panicErr := panicguard.Call("volumes", volume.Name, func() {
+		err = p.Provisioner.Delete(ctx, volume)
+	}); panicErr != nil {
+		return panicErr
+	}
+	return err
+}
+
+func (p *panicGuardProvisioner) SupportsBlock(ctx context.Context) bool {
+	if blockProvisioner, ok := p.Provisioner.(controller.BlockProvisioner); ok {
+		return blockProvisioner.SupportsBlock(ctx)
+	}
+	return false
+}
+
+func (p *panicGuardProvisioner) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	if qualifier, ok := p.Provisioner.(controller.Qualifier); ok {
+		return qualifier.ShouldProvision(ctx, claim)
+	}
+	return true
+}
+
+func (p *panicGuardProvisioner) ShouldDelete(ctx context.Context, volume *v1.PersistentVolume) bool {
+	if deletionGuard, ok := p.Provisioner.(controller.DeletionGuard); ok {
+		return deletionGuard.ShouldDelete(ctx, volume)
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+type panickingProvisioner struct{}
+
+func (p panickingProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	panic("malformed claim")
+}
+
+func (p panickingProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	panic("malformed volume")
+}
+
+func TestPanicGuardProvisioner(t *testing.T) {
+	ctx := context.Background()
+	claim := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "ns"}}
+
+	p := NewPanicGuardProvisioner(panickingProvisioner{})
+	pv, state, err := p.Provision(ctx, controller.ProvisionOptions{PVC: claim})
+	if err == nil || pv != nil || state != controller.ProvisioningInBackground {
+		t.Errorf("expected error with ProvisioningInBackground, got %v, %v, %v", pv, state, err)
+	}
+	if err := p.Delete(ctx, &v1.PersistentVolume{}); err == nil {
+		t.Error("expected error from Delete")
+	}
+
+	inner := &fakeProvisioner{}
+	p = NewPanicGuardProvisioner(inner)
+	pv, state, err = p.Provision(ctx, controller.ProvisionOptions{PVC: claim})
+	if err != nil || pv == nil || state != controller.ProvisioningFinished {
+		t.Errorf("expected provisioned volume, got %v, %v, %v", pv, state, err)
+	}
+	if err := p.Delete(ctx, &v1.PersistentVolume{}); err != nil || !inner.deleted {
+		t.Errorf("expected deletion, got %v", err)
+	}
+}
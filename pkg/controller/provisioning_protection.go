@@ -0,0 +1,116 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+// pvcProvisioningFinalizer is set on a PVC while CreateVolume may be in
+// progress for it. Without it, a PVC that gets deleted while the
+// driver creates the volume may be gone before the PV gets created,
+// and then nothing deletes the new volume.
+const pvcProvisioningFinalizer = "provisioner.storage.kubernetes.io/provisioning-protection"
+
+// addProvisioningFinalizer ensures that the PVC has the finalizer before
+// calling CreateVolume.
+func (p *csiProvisioner) addProvisioningFinalizer(ctx context.Context, claim *v1.PersistentVolumeClaim) error {
+	p.protectedClaims.Store(claim.UID, true)
+	if checkFinalizer(claim, pvcProvisioningFinalizer) {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := p.client.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current.UID != claim.UID {
+			return fmt.Errorf("PVC %s/%s was re-created with UID %s", claim.Namespace, claim.Name, current.UID)
+		}
+		if checkFinalizer(current, pvcProvisioningFinalizer) {
+			return nil
+		}
+		if current.DeletionTimestamp != nil {
+			// Finalizers cannot be added anymore. Nothing is
+			// in progress yet, so don't start now.
+			return fmt.Errorf("PVC %s/%s is being deleted", claim.Namespace, claim.Name)
+		}
+		current.Finalizers = append(current.Finalizers, pvcProvisioningFinalizer)
+		if _, err := p.client.CoreV1().PersistentVolumeClaims(current.Namespace).Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		klog.V(5).Infof("Added finalizer %s to PVC %s/%s", pvcProvisioningFinalizer, claim.Namespace, claim.Name)
+		return nil
+	})
+}
+
+// removeProvisioningFinalizer removes the finalizer once the outcome of
+// provisioning is known, i.e. unless CreateVolume may still be in
+// progress. A PVC that was deleted in the meantime can then go away,
+// which also releases the new PV, if there is one.
+func (p *csiProvisioner) removeProvisioningFinalizer(ctx context.Context, claim *v1.PersistentVolumeClaim, state controller.ProvisioningState) {
+	if state == controller.ProvisioningInBackground {
+		return
+	}
+	// The finalizer may also be left over from a previous instance of
+	// the external-provisioner.
+	if _, ok := p.protectedClaims.LoadAndDelete(claim.UID); !ok && !checkFinalizer(claim, pvcProvisioningFinalizer) {
+		return
+	}
+	removed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := p.client.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current.UID != claim.UID || !checkFinalizer(current, pvcProvisioningFinalizer) {
+			return nil
+		}
+		current.Finalizers = removeFinalizer(current.Finalizers, pvcProvisioningFinalizer)
+		if _, err := p.client.CoreV1().PersistentVolumeClaims(current.Namespace).Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		removed = true
+		return nil
+	})
+	if err != nil && !apierrs.IsNotFound(err) {
+		// The ClaimMetadataCleaner removes it once the PVC is bound.
+		klog.Warningf("Failed to remove finalizer %s from PVC %s/%s: %v", pvcProvisioningFinalizer, claim.Namespace, claim.Name, err)
+		return
+	}
+	if removed {
+		klog.V(5).Infof("Removed finalizer %s from PVC %s/%s", pvcProvisioningFinalizer, claim.Namespace, claim.Name)
+	}
+}
+
+func removeFinalizer(finalizers []string, finalizer string) []string {
+	var result []string
+	for _, f := range finalizers {
+		if f != finalizer {
+			result = append(result, f)
+		}
+	}
+	return result
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	csitrans "k8s.io/csi-translation-lib"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+func TestProvisioningFinalizer(t *testing.T) {
+	testcases := map[string]struct {
+		createErr         error
+		deleted           bool
+		expectState       controller.ProvisioningState
+		expectFinalizer   bool
+		expectCreateCalls int
+	}{
+		"success": {
+			expectState:       controller.ProvisioningFinished,
+			expectCreateCalls: 1,
+		},
+		"final error": {
+			createErr:         status.Error(codes.InvalidArgument, "invalid"),
+			expectState:       controller.ProvisioningFinished,
+			expectCreateCalls: 1,
+		},
+		"timeout": {
+			createErr:         status.Error(codes.DeadlineExceeded, "timeout"),
+			expectState:       controller.ProvisioningInBackground,
+			expectFinalizer:   true,
+			expectCreateCalls: 1,
+		},
+		"deleted": {
+			deleted:     true,
+			expectState: controller.ProvisioningNoChange,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tmpdir := tempDir(t)
+			defer os.RemoveAll(tmpdir)
+			mockController, driver, _, controllerServer, csiConn, err := createMockServer(t, tmpdir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mockController.Finish()
+			defer driver.Stop()
+
+			ctx := context.Background()
+			claim := createFakePVC(requestedBytes)
+			claim.Finalizers = []string{"kubernetes.io/pvc-protection"}
+			if tc.deleted {
+				now := metav1.Now()
+				claim.DeletionTimestamp = &now
+			}
+			client := fakeclientset.NewSimpleClientset(claim)
+			pluginCaps, controllerCaps := provisionCapabilities()
+			csiProvisioner := NewCSIProvisioner(client, 5*time.Second, "test-provisioner", "test", 5, csiConn.conn,
+				nil, driverName, pluginCaps, controllerCaps, "", false, true, csitrans.New(), nil, nil, nil, nil, nil, false, defaultfsType, nil, ProvisionerOptions{ProvisioningFinalizer: true})
+
+			getFinalizers := func() []string {
+				current, err := client.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return current.Finalizers
+			}
+			controllerServer.EXPECT().CreateVolume(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+					if !checkFinalizer(&metav1.ObjectMeta{Finalizers: getFinalizers()}, pvcProvisioningFinalizer) {
+						t.Errorf("finalizer missing during CreateVolume")
+					}
+					if tc.createErr != nil {
+						return nil, tc.createErr
+					}
+					return &csi.CreateVolumeResponse{
+						Volume: &csi.Volume{
+							CapacityBytes: requestedBytes,
+							VolumeId:      "test-volume-id",
+						},
+					}, nil
+				}).Times(tc.expectCreateCalls)
+
+			deletePolicy := v1.PersistentVolumeReclaimDelete
+			_, state, _ := csiProvisioner.Provision(ctx, controller.ProvisionOptions{
+				StorageClass: &storagev1.StorageClass{
+					ReclaimPolicy: &deletePolicy,
+				},
+				PVName: "test-name",
+				PVC:    claim,
+			})
+			if state != tc.expectState {
+				t.Errorf("expected state %s, got %s", tc.expectState, state)
+			}
+			finalizers := getFinalizers()
+			if checkFinalizer(&metav1.ObjectMeta{Finalizers: finalizers}, pvcProvisioningFinalizer) != tc.expectFinalizer {
+				t.Errorf("expected finalizer %v, got finalizers %v", tc.expectFinalizer, finalizers)
+			}
+			if !checkFinalizer(&metav1.ObjectMeta{Finalizers: finalizers}, "kubernetes.io/pvc-protection") {
+				t.Errorf("other finalizer was removed: %v", finalizers)
+			}
+		})
+	}
+}
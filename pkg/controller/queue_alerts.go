@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// QueueAgeAlerter emits a warning event for the pod of the
+// external-provisioner when an item in one of the queues created with
+// NewNamedRateLimitingQueue or in the claims and volumes queues of the
+// provisioner library has not been processed successfully for longer
+// than a threshold. The age is also available as metric,
+// the event is meant for those who don't have alerts for it.
+type QueueAgeAlerter struct {
+	threshold     time.Duration
+	pod           *v1.ObjectReference
+	eventRecorder record.EventRecorder
+	queues        func() []unprocessedAgeTracker
+
+	// alerted contains the item per queue that was reported last.
+	alerted map[string]interface{}
+}
+
+// NewQueueAgeAlerter creates an alerter for the pod with the given name.
+// Without a pod name, the warning only gets logged.
+func NewQueueAgeAlerter(client kubernetes.Interface, namespace, podName string, threshold time.Duration) *QueueAgeAlerter {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.Infof)
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "external-provisioner"})
+
+	a := &QueueAgeAlerter{
+		threshold:     threshold,
+		eventRecorder: eventRecorder,
+		queues:        queueAges.list,
+		alerted:       map[string]interface{}{},
+	}
+	if podName != "" {
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		a.pod = &v1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  namespace,
+			Name:       podName,
+		}
+	}
+	return a
+}
+
+// Run checks the queues periodically until the context is done.
+func (a *QueueAgeAlerter) Run(ctx context.Context) {
+	interval := a.threshold / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) { a.check() }, interval)
+}
+
+// check reports the oldest item of each queue once, if it is too old.
+func (a *QueueAgeAlerter) check() {
+	for _, q := range a.queues() {
+		name := q.queueName()
+		item, age := q.oldestUnprocessed()
+		if age < a.threshold {
+			delete(a.alerted, name)
+			continue
+		}
+		if alerted, ok := a.alerted[name]; ok && alerted == item {
+			continue
+		}
+		a.alerted[name] = item
+		message := fmt.Sprintf("Item %v in workqueue %s has not been processed successfully for %v, see workqueue_oldest_unprocessed_item_age_seconds",
+			item, name, age.Round(time.Second))
+		if a.pod == nil {
+			klog.Warning(message)
+			continue
+		}
+		a.eventRecorder.Event(a.pod, v1.EventTypeWarning, "WorkqueueItemAging", message)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestQueueAgeAlerter(t *testing.T) {
+	now := time.Now()
+	q := newAgeTrackingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Second, time.Second), "test")
+	defer q.ShutDown()
+	q.now = func() time.Time { return now }
+	limiter := newBackoffTrackingRateLimiter(workqueue.NewItemExponentialFailureRateLimiter(time.Second, time.Second), []string{claimQueueName, volumeQueueName}, libraryQueueOf)
+	limiter.now = func() time.Time { return now }
+	claims := libraryQueue{limiter: limiter, name: claimQueueName}
+	recorder := record.NewFakeRecorder(10)
+	a := &QueueAgeAlerter{
+		threshold:     time.Hour,
+		pod:           &v1.ObjectReference{Kind: "Pod", Name: "provisioner"},
+		eventRecorder: recorder,
+		queues:        func() []unprocessedAgeTracker { return []unprocessedAgeTracker{q, claims} },
+		alerted:       map[string]interface{}{},
+	}
+	expectEvents := func(expected ...string) {
+		t.Helper()
+		for _, prefix := range expected {
+			select {
+			case event := <-recorder.Events:
+				if !strings.HasPrefix(event, prefix) {
+					t.Errorf("expected event %q, got %q", prefix, event)
+				}
+			default:
+				t.Errorf("expected event %q, got none", prefix)
+			}
+		}
+		select {
+		case event := <-recorder.Events:
+			t.Errorf("unexpected event %q", event)
+		default:
+		}
+	}
+
+	q.Add("a")
+	a.check()
+	expectEvents()
+
+	now = now.Add(time.Hour)
+	a.check()
+	expectEvents("Warning WorkqueueItemAging Item a in workqueue test has not been processed successfully for 1h0m0s")
+
+	// Only reported once.
+	now = now.Add(time.Hour)
+	a.check()
+	expectEvents()
+
+	// Another item becomes the oldest one.
+	q.Add("b")
+	item, _ := q.Get()
+	q.Done(item)
+	q.Forget(item)
+	a.check()
+	expectEvents()
+	now = now.Add(time.Hour)
+	a.check()
+	expectEvents("Warning WorkqueueItemAging Item b in workqueue test")
+
+	// A claim of the provisioner library which keeps failing.
+	q.Add("c")
+	claim := "0a6a3a34-1a4f-4c1f-9b0e-8d3a1b5f2c11"
+	limiter.When(claim)
+	now = now.Add(time.Hour)
+	limiter.When(claim)
+	a.check()
+	expectEvents("Warning WorkqueueItemAging Item " + claim + " in workqueue claims has not been processed successfully for 1h0m0s")
+}
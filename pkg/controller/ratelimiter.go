@@ -24,9 +24,20 @@ import (
 	"k8s.io/client-go/util/workqueue"
 )
 
+// newRand returns a random number generator for a rate limiter. It
+// gets seeded from the global generator, so seeding that one makes the
+// jitter of all rate limiters reproducible.
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
+
+// rateLimiterWithJitter shortens each delay of the wrapped rate limiter
+// randomly by up to baseDelay plus the jitter fraction of the delay, but
+// not below zero.
 type rateLimiterWithJitter struct {
 	workqueue.RateLimiter
 	baseDelay time.Duration
+	jitter    float64
 	rd        *rand.Rand
 	mutex     sync.Mutex
 }
@@ -37,7 +48,7 @@ func (r *rateLimiterWithJitter) When(item interface{}) time.Duration {
 
 	delay := r.RateLimiter.When(item).Nanoseconds()
 	percentage := r.rd.Float64()
-	jitter := int64(float64(r.baseDelay.Nanoseconds()) * percentage)
+	jitter := int64((float64(r.baseDelay.Nanoseconds()) + float64(delay)*r.jitter) * percentage)
 	if jitter > delay {
 		return 0
 	}
@@ -48,6 +59,98 @@ func newItemExponentialFailureRateLimiterWithJitter(baseDelay time.Duration, max
 	return &rateLimiterWithJitter{
 		RateLimiter: workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
 		baseDelay:   baseDelay,
-		rd:          rand.New(rand.NewSource(time.Now().UTC().UnixNano())),
+		rd:          newRand(),
+	}
+}
+
+// tokenBucketRateLimiter limits the total number of retries across all
+// items with a token bucket. When the bucket is empty, retries get
+// delayed until enough tokens were added again. It is meant to be
+// combined with a per-item rate limiter through
+// workqueue.NewMaxOfRateLimiter.
+type tokenBucketRateLimiter struct {
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+	mutex    sync.Mutex
+}
+
+var _ workqueue.RateLimiter = &tokenBucketRateLimiter{}
+
+// newTokenBucketRateLimiter returns a full bucket which gets one
+// token added per interval, up to burst tokens.
+func newTokenBucketRateLimiter(interval time.Duration, burst int) *tokenBucketRateLimiter {
+	return &tokenBucketRateLimiter{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// When takes one token from the bucket and returns how long the caller
+// has to wait until that token is available.
+func (r *tokenBucketRateLimiter) When(item interface{}) time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	if elapsed := now.Sub(r.last); elapsed > 0 {
+		r.tokens += float64(elapsed) / float64(r.interval)
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+	}
+	r.last = now
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens * float64(r.interval))
+}
+
+func (r *tokenBucketRateLimiter) Forget(item interface{}) {}
+
+func (r *tokenBucketRateLimiter) NumRequeues(item interface{}) int {
+	return 0
+}
+
+// NewRetryBudget returns a budget of retriesPerMinute retries per
+// minute for NewRetryBudgetRateLimiter. All rate limiters which use
+// the same budget share it.
+func NewRetryBudget(retriesPerMinute int) workqueue.RateLimiter {
+	return newTokenBucketRateLimiter(time.Minute/time.Duration(retriesPerMinute), retriesPerMinute)
+}
+
+// NewRetryBudgetRateLimiter returns a rate limiter which uses the
+// given rate limiter for per-item backoff and in addition ensures
+// that retries of all items stay within the budget from
+// NewRetryBudget.
+func NewRetryBudgetRateLimiter(rateLimiter workqueue.RateLimiter, budget workqueue.RateLimiter) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(rateLimiter, budget)
+}
+
+// NewRateLimiter returns a rate limiter with exponential backoff per
+// item from initialDelay up to maxDelay, where each delay gets
+// shortened randomly by up to the jitter fraction. With a positive
+// qps, retries of all items additionally share a token bucket.
+func NewRateLimiter(initialDelay, maxDelay time.Duration, jitter float64, qps float64, burst int) workqueue.RateLimiter {
+	var rateLimiter workqueue.RateLimiter = workqueue.NewItemExponentialFailureRateLimiter(initialDelay, maxDelay)
+	if jitter > 0 {
+		rateLimiter = &rateLimiterWithJitter{
+			RateLimiter: rateLimiter,
+			jitter:      jitter,
+			rd:          newRand(),
+		}
+	}
+	if qps > 0 {
+		rateLimiter = workqueue.NewMaxOfRateLimiter(
+			rateLimiter,
+			newTokenBucketRateLimiter(time.Duration(float64(time.Second)/qps), burst),
+		)
 	}
+	return rateLimiter
 }
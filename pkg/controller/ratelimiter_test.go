@@ -17,8 +17,11 @@ limitations under the License.
 package controller
 
 import (
+	"math/rand"
 	"testing"
 	"time"
+
+	"k8s.io/client-go/util/workqueue"
 )
 
 const factorMaxDelay = 10
@@ -35,3 +38,91 @@ func TestRateLimiter(t *testing.T) {
 		rd.Forget(1)
 	}
 }
+
+func TestRateLimiterSeed(t *testing.T) {
+	delays := func() []time.Duration {
+		rand.Seed(42)
+		nodeDeployment := newItemExponentialFailureRateLimiterWithJitter(time.Second, time.Minute)
+		claims := NewRateLimiter(time.Second, time.Minute, 0.5, 0, 0)
+		var delays []time.Duration
+		for i := 0; i < 10; i++ {
+			delays = append(delays, nodeDeployment.When(i), claims.When(i))
+		}
+		return delays
+	}
+	defer rand.Seed(time.Now().UnixNano())
+
+	first, second := delays(), delays()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same delays with the same seed, got %v and %v", first, second)
+		}
+	}
+}
+
+func TestRetryBudgetRateLimiter(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucketRateLimiter(time.Second, 60)
+	bucket.now = func() time.Time { return now }
+	bucket.last = now
+	rl := workqueue.NewMaxOfRateLimiter(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond), bucket)
+
+	// The initial budget is available immediately.
+	for i := 0; i < 60; i++ {
+		if backoff := rl.When(i); backoff != time.Millisecond {
+			t.Fatalf("retry #%d: expected per-item backoff %s, got %s", i, time.Millisecond, backoff)
+		}
+	}
+
+	// Once exhausted, retries get spread out, one per second.
+	for i := 1; i <= 3; i++ {
+		if backoff := rl.When(i); backoff != time.Duration(i)*time.Second {
+			t.Fatalf("over budget retry #%d: expected %s, got %s", i, time.Duration(i)*time.Second, backoff)
+		}
+	}
+
+	// The bucket refills over time.
+	now = now.Add(time.Hour)
+	if backoff := rl.When(0); backoff != time.Millisecond {
+		t.Fatalf("after refill: expected per-item backoff %s, got %s", time.Millisecond, backoff)
+	}
+
+	// NewRetryBudgetRateLimiter combines the rate limiters the same way.
+	// Rate limiters with the same budget share it.
+	budget := NewRetryBudget(1)
+	rl = NewRetryBudgetRateLimiter(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond), budget)
+	other := NewRetryBudgetRateLimiter(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond), budget)
+	if backoff := rl.When("a"); backoff != time.Millisecond {
+		t.Errorf("within budget: expected per-item backoff %s, got %s", time.Millisecond, backoff)
+	}
+	if backoff := other.When("b"); backoff < 30*time.Second {
+		t.Errorf("over shared budget: expected a delay of about one minute, got %s", backoff)
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	rl := NewRateLimiter(time.Second, time.Minute, 0.5, 0, 0)
+	for i := 0; i < 100; i++ {
+		expected := time.Second << uint(i)
+		if expected > time.Minute || expected <= 0 {
+			expected = time.Minute
+		}
+		backoff := rl.When("item")
+		if backoff > expected || backoff < expected/2 {
+			t.Fatalf("failure #%d: expected delay between %s and %s, got %s", i+1, expected/2, expected, backoff)
+		}
+	}
+	rl.Forget("item")
+	if backoff := rl.When("item"); backoff > time.Second {
+		t.Errorf("after forget: expected at most %s, got %s", time.Second, backoff)
+	}
+
+	// The shared bucket delays retries of different items.
+	rl = NewRateLimiter(time.Millisecond, time.Millisecond, 0, 1, 1)
+	if backoff := rl.When("a"); backoff != time.Millisecond {
+		t.Errorf("first item: expected per-item delay %s, got %s", time.Millisecond, backoff)
+	}
+	if backoff := rl.When("b"); backoff < 500*time.Millisecond {
+		t.Errorf("second item: expected delay from shared bucket of about one second, got %s", backoff)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+var reservationRejections = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Name:           "reserved_workers_rejected_operations_total",
+		Help:           "Number of provisioning operations for claims outside of the reserved namespaces that were postponed because all unreserved worker threads were busy.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(reservationRejections)
+}
+
+// reservedProvisioner keeps some worker threads available for claims in
+// a set of namespaces. Claims in other namespaces may use at most the
+// remaining worker threads for provisioning.
+//
+// An operation which exceeds the limit fails right away instead of
+// waiting for a free slot. A waiting operation would block its worker
+// thread and thus use up the reserved capacity that it is meant to
+// protect. The provisioner library then retries the claim with the
+// usual exponential backoff.
+type reservedProvisioner struct {
+	controller.Provisioner
+	namespaces sets.String
+	limit      int
+
+	mutex  sync.Mutex
+	active int
+}
+
+var _ controller.Provisioner = &reservedProvisioner{}
+var _ controller.BlockProvisioner = &reservedProvisioner{}
+var _ controller.Qualifier = &reservedProvisioner{}
+var _ controller.DeletionGuard = &reservedProvisioner{}
+
+// NewReservedProvisioner wraps the provisioner such that claims in the
+// given namespaces always find one of the reserved worker threads
+// available, even while a backlog of claims in other namespaces is
+// being processed. Deletion is not affected.
+func NewReservedProvisioner(p controller.Provisioner, workers, reserved int, namespaces []string) controller.Provisioner {
+	return &reservedProvisioner{
+		Provisioner: p,
+		namespaces:  sets.NewString(namespaces...),
+		limit:       workers - reserved,
+	}
+}
+
+// acquire returns false if the claim is not in a reserved namespace and
+// all unreserved worker threads are busy. On success, the returned
+// function must be called once the operation is complete.
+func (p *reservedProvisioner) acquire(namespace string) (func(), bool) {
+	if p.namespaces.Has(namespace) {
+		return func() {}, true
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.active >= p.limit {
+		return nil, false
+	}
+	p.active++
+	return p.release, true
+}
+
+func (p *reservedProvisioner) release() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.active--
+}
+
+func (p *reservedProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	release, ok := p.acquire(options.PVC.Namespace)
+	if !ok {
+		reservationRejections.Inc()
+		return nil, controller.ProvisioningNoChange, fmt.Errorf("all %d worker threads for namespaces outside of %s are busy, will retry later", p.limit, strings.Join(p.namespaces.List(), ", "))
+	}
+	defer release()
+	return p.Provisioner.Provision(ctx, options)
+}
+
+func (p *reservedProvisioner) SupportsBlock(ctx context.Context) bool {
+	if blockProvisioner, ok := p.Provisioner.(controller.BlockProvisioner); ok {
+		return blockProvisioner.SupportsBlock(ctx)
+	}
+	return false
+}
+
+func (p *reservedProvisioner) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	if qualifier, ok := p.Provisioner.(controller.Qualifier); ok {
+		return qualifier.ShouldProvision(ctx, claim)
+	}
+	return true
+}
+
+func (p *reservedProvisioner) ShouldDelete(ctx context.Context, volume *v1.PersistentVolume) bool {
+	if deletionGuard, ok := p.Provisioner.(controller.DeletionGuard); ok {
+		return deletionGuard.ShouldDelete(ctx, volume)
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+func TestReservedProvisioner(t *testing.T) {
+	ctx := context.Background()
+	p := NewReservedProvisioner(&fakeProvisioner{}, 3, 1, []string{"kube-system", "monitoring"}).(*reservedProvisioner)
+
+	var releases []func()
+	for _, namespace := range []string{"tenant-a", "tenant-b"} {
+		release, ok := p.acquire(namespace)
+		if !ok {
+			t.Fatalf("%s: expected a free worker", namespace)
+		}
+		releases = append(releases, release)
+	}
+
+	options := func(namespace string) controller.ProvisionOptions {
+		return controller.ProvisionOptions{
+			PVC: &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "claim"}},
+		}
+	}
+	if _, state, err := p.Provision(ctx, options("tenant-c")); err == nil || state != controller.ProvisioningNoChange {
+		t.Errorf("tenant-c: expected error with ProvisioningNoChange, got %v, %v", state, err)
+	}
+	for _, namespace := range []string{"kube-system", "monitoring"} {
+		if _, _, err := p.Provision(ctx, options(namespace)); err != nil {
+			t.Errorf("%s: unexpected error: %v", namespace, err)
+		}
+	}
+
+	releases[0]()
+	if _, _, err := p.Provision(ctx, options("tenant-c")); err != nil {
+		t.Errorf("tenant-c after release: unexpected error: %v", err)
+	}
+	releases[1]()
+	if p.active != 0 {
+		t.Errorf("expected no active operations, got %d", p.active)
+	}
+}
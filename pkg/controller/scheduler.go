@@ -0,0 +1,246 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// annClaimPriority can be set on a PVC to an integer between
+	// minClaimPriority and maxClaimPriority. Operations with a higher
+	// priority get free slots of the StorageClassScheduler first. The
+	// default is zero. Without a StorageClassScheduler the annotation
+	// has no effect.
+	annClaimPriority = "provisioner.storage.k8s.io/priority"
+
+	minClaimPriority = -1000
+	maxClaimPriority = 1000
+)
+
+var storageClassWait = metrics.NewHistogramVec(
+	&metrics.HistogramOpts{
+		Name:           "storageclass_scheduling_wait_seconds",
+		Help:           "Time that a provisioning operation waited for a free slot in the per-StorageClass round-robin scheduler.",
+		Buckets:        []float64{0.01, 0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600},
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"storage_class"},
+)
+
+func init() {
+	legacyregistry.MustRegister(storageClassWait)
+}
+
+// StorageClassScheduler limits the number of concurrent provisioning
+// operations. When all slots are in use, operations wait and free slots
+// are handed out round-robin across storage classes, so a burst of
+// claims for one class cannot starve claims for other classes.
+//
+// Waiting operations with a higher priority, as set with the
+// annClaimPriority annotation of their claim, are served first. Storage
+// classes only take turns among operations of the same priority.
+//
+// Claims are dequeued by the provisioner library in FIFO order. To give
+// operations for other storage classes or with a higher priority a
+// chance to get picked up while a burst is being processed, the number
+// of slots must be smaller than the number of worker threads.
+type StorageClassScheduler struct {
+	now     func() time.Time
+	observe func(storageClassName string, seconds float64)
+
+	mutex sync.Mutex
+	free  int
+	// classes contains all storage classes with waiting operations in
+	// the order in which they get served.
+	classes []string
+	// next is the index in classes which gets served next.
+	next int
+	// waiters contains the waiting operations of each storage class,
+	// sorted by priority and then by arrival.
+	waiters map[string][]*schedulerWaiter
+}
+
+type schedulerWaiter struct {
+	granted  chan struct{}
+	priority int
+}
+
+// NewStorageClassScheduler creates a scheduler with the given number of
+// slots for concurrent operations.
+func NewStorageClassScheduler(slots int) *StorageClassScheduler {
+	return &StorageClassScheduler{
+		now: time.Now,
+		observe: func(storageClassName string, seconds float64) {
+			storageClassWait.WithLabelValues(storageClassName).Observe(seconds)
+		},
+		free:    slots,
+		waiters: map[string][]*schedulerWaiter{},
+	}
+}
+
+// acquire blocks until the operation for the storage class may proceed
+// or the context is done. On success, the returned function must be
+// called once the operation is complete.
+func (s *StorageClassScheduler) acquire(ctx context.Context, storageClassName string, priority int) (func(), error) {
+	start := s.now()
+	s.mutex.Lock()
+	if s.free > 0 && len(s.classes) == 0 {
+		s.free--
+		s.mutex.Unlock()
+		s.observe(storageClassName, 0)
+		return s.release, nil
+	}
+	granted := make(chan struct{})
+	waiters := s.waiters[storageClassName]
+	if len(waiters) == 0 {
+		s.classes = append(s.classes, storageClassName)
+	}
+	i := len(waiters)
+	for i > 0 && waiters[i-1].priority < priority {
+		i--
+	}
+	waiters = append(waiters, nil)
+	copy(waiters[i+1:], waiters[i:])
+	waiters[i] = &schedulerWaiter{granted: granted, priority: priority}
+	s.waiters[storageClassName] = waiters
+	s.mutex.Unlock()
+
+	select {
+	case <-granted:
+		s.observe(storageClassName, s.now().Sub(start).Seconds())
+		return s.release, nil
+	case <-ctx.Done():
+		s.mutex.Lock()
+		removed := s.removeWaiter(storageClassName, granted)
+		s.mutex.Unlock()
+		if !removed {
+			// The slot was handed to us concurrently, pass it on.
+			s.release()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// release hands the slot to the next waiting operation or marks it as free.
+func (s *StorageClassScheduler) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.classes) == 0 {
+		s.free++
+		return
+	}
+	highest := minClaimPriority
+	for _, storageClassName := range s.classes {
+		if priority := s.waiters[storageClassName][0].priority; priority > highest {
+			highest = priority
+		}
+	}
+	i := s.next % len(s.classes)
+	for s.waiters[s.classes[i]][0].priority < highest {
+		i = (i + 1) % len(s.classes)
+	}
+	storageClassName := s.classes[i]
+	waiters := s.waiters[storageClassName]
+	close(waiters[0].granted)
+	if len(waiters) == 1 {
+		delete(s.waiters, storageClassName)
+		s.classes = append(s.classes[:i], s.classes[i+1:]...)
+		// The class after the removed one is now at index i.
+		s.next = i
+	} else {
+		s.waiters[storageClassName] = waiters[1:]
+		s.next = i + 1
+	}
+}
+
+// removeWaiter must be called while holding the mutex. It returns false if
+// the waiter was not found because it already got a slot.
+func (s *StorageClassScheduler) removeWaiter(storageClassName string, granted chan struct{}) bool {
+	waiters := s.waiters[storageClassName]
+	for i, waiter := range waiters {
+		if waiter.granted != granted {
+			continue
+		}
+		if len(waiters) > 1 {
+			s.waiters[storageClassName] = append(waiters[:i], waiters[i+1:]...)
+			return true
+		}
+		delete(s.waiters, storageClassName)
+		for j, class := range s.classes {
+			if class == storageClassName {
+				s.classes = append(s.classes[:j], s.classes[j+1:]...)
+				if j < s.next {
+					s.next--
+				}
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// claimPriority returns the priority from the annClaimPriority
+// annotation, limited to the supported range. Invalid values are
+// ignored.
+func claimPriority(claim *v1.PersistentVolumeClaim) int {
+	value, ok := claim.Annotations[annClaimPriority]
+	if !ok {
+		return 0
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		klog.Warningf("Ignoring invalid %s annotation of PVC %s/%s: %v", annClaimPriority, claim.Namespace, claim.Name, err)
+		return 0
+	}
+	switch {
+	case priority < minClaimPriority:
+		return minClaimPriority
+	case priority > maxClaimPriority:
+		return maxClaimPriority
+	}
+	return priority
+}
+
+// ClaimsWithPriority returns the claims which have the annClaimPriority
+// annotation. It is meant for warning at startup about annotations
+// which have no effect because there is no StorageClassScheduler.
+func ClaimsWithPriority(claimLister corelisters.PersistentVolumeClaimLister) ([]*v1.PersistentVolumeClaim, error) {
+	claims, err := claimLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var result []*v1.PersistentVolumeClaim
+	for _, claim := range claims {
+		if _, ok := claim.Annotations[annClaimPriority]; ok {
+			result = append(result, claim)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func numWaiters(s *StorageClassScheduler) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	num := 0
+	for _, waiters := range s.waiters {
+		num += len(waiters)
+	}
+	return num
+}
+
+func waitForWaiters(t *testing.T, s *StorageClassScheduler, expected int) {
+	for start := time.Now(); numWaiters(s) != expected; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("timed out waiting for %d waiters, have %d", expected, numWaiters(s))
+		}
+	}
+}
+
+func TestStorageClassSchedulerRoundRobin(t *testing.T) {
+	s := NewStorageClassScheduler(1)
+	observed := map[string]int{}
+	s.observe = func(storageClassName string, seconds float64) {
+		observed[storageClassName]++
+	}
+	ctx := context.Background()
+
+	release, err := s.acquire(ctx, "a", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type operation struct {
+		name, storageClassName string
+	}
+	operations := []operation{
+		{"a1", "a"},
+		{"a2", "a"},
+		{"a3", "a"},
+		{"b1", "b"},
+		{"b2", "b"},
+		{"c1", "c"},
+	}
+	granted := make(chan string)
+	done := map[string]chan struct{}{}
+	for i, op := range operations {
+		op := op
+		done[op.name] = make(chan struct{})
+		go func() {
+			release, err := s.acquire(ctx, op.storageClassName, 0)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", op.name, err)
+				return
+			}
+			granted <- op.name
+			<-done[op.name]
+			release()
+		}()
+		waitForWaiters(t, s, i+1)
+	}
+
+	release()
+	var order []string
+	for range operations {
+		name := <-granted
+		order = append(order, name)
+		close(done[name])
+	}
+	expected := []string{"a1", "b1", "c1", "a2", "b2", "a3"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+	if !reflect.DeepEqual(observed, map[string]int{"a": 4, "b": 2, "c": 1}) {
+		t.Errorf("unexpected observations: %v", observed)
+	}
+}
+
+func TestStorageClassSchedulerPriority(t *testing.T) {
+	s := NewStorageClassScheduler(1)
+	s.observe = func(storageClassName string, seconds float64) {}
+	ctx := context.Background()
+
+	release, err := s.acquire(ctx, "a", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type operation struct {
+		name, storageClassName string
+		priority               int
+	}
+	operations := []operation{
+		{"a1", "a", 0},
+		{"a2", "a", 10},
+		{"b1", "b", 0},
+		{"b2", "b", -5},
+		{"c1", "c", 10},
+		{"a3", "a", 10},
+		{"c2", "c", 0},
+	}
+	granted := make(chan string)
+	done := map[string]chan struct{}{}
+	for i, op := range operations {
+		op := op
+		done[op.name] = make(chan struct{})
+		go func() {
+			release, err := s.acquire(ctx, op.storageClassName, op.priority)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", op.name, err)
+				return
+			}
+			granted <- op.name
+			<-done[op.name]
+			release()
+		}()
+		waitForWaiters(t, s, i+1)
+	}
+
+	release()
+	var order []string
+	for range operations {
+		name := <-granted
+		order = append(order, name)
+		close(done[name])
+	}
+	// Priority 10 round-robin, then priority 0 round-robin, then -5.
+	expected := []string{"a2", "c1", "a3", "b1", "c2", "a1", "b2"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+}
+
+func TestClaimPriority(t *testing.T) {
+	testcases := map[string]struct {
+		annotations map[string]string
+		expected    int
+	}{
+		"none":     {expected: 0},
+		"positive": {annotations: map[string]string{annClaimPriority: "100"}, expected: 100},
+		"negative": {annotations: map[string]string{annClaimPriority: "-7"}, expected: -7},
+		"too high": {annotations: map[string]string{annClaimPriority: "1000000"}, expected: maxClaimPriority},
+		"too low":  {annotations: map[string]string{annClaimPriority: "-1000000"}, expected: minClaimPriority},
+		"invalid":  {annotations: map[string]string{annClaimPriority: "urgent"}, expected: 0},
+		"float":    {annotations: map[string]string{annClaimPriority: "1.5"}, expected: 0},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			claim := &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", Annotations: tc.annotations},
+			}
+			if priority := claimPriority(claim); priority != tc.expected {
+				t.Errorf("expected priority %d, got %d", tc.expected, priority)
+			}
+		})
+	}
+}
+
+func TestClaimsWithPriority(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, claim := range []*v1.PersistentVolumeClaim{
+		{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "urgent", Namespace: "default", Annotations: map[string]string{annClaimPriority: "100"}}},
+	} {
+		if err := indexer.Add(claim); err != nil {
+			t.Fatal(err)
+		}
+	}
+	claims, err := ClaimsWithPriority(corelisters.NewPersistentVolumeClaimLister(indexer))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(claims) != 1 || claims[0].Name != "urgent" {
+		t.Errorf("expected only claim default/urgent, got %v", claims)
+	}
+}
+
+func TestStorageClassSchedulerCancel(t *testing.T) {
+	s := NewStorageClassScheduler(1)
+	s.observe = func(storageClassName string, seconds float64) {}
+
+	release, err := s.acquire(context.Background(), "a", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		_, err := s.acquire(ctx, "b", 0)
+		result <- err
+	}()
+	waitForWaiters(t, s, 1)
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if len(s.classes) != 0 || len(s.waiters) != 0 {
+		t.Errorf("cancelled waiter not removed: %v, %v", s.classes, s.waiters)
+	}
+
+	release()
+	if s.free != 1 {
+		t.Errorf("expected one free slot, got %d", s.free)
+	}
+}
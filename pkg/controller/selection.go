@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+// selectiveProvisioner disables provisioning or deleting of volumes
+// for a provisioner. The provisioner library then ignores the
+// corresponding PVCs or PVs, so another instance can handle them.
+type selectiveProvisioner struct {
+	controller.Provisioner
+	provision bool
+	delete    bool
+}
+
+var _ controller.Provisioner = &selectiveProvisioner{}
+var _ controller.BlockProvisioner = &selectiveProvisioner{}
+var _ controller.Qualifier = &selectiveProvisioner{}
+var _ controller.DeletionGuard = &selectiveProvisioner{}
+
+// NewSelectiveProvisioner wraps the provisioner such that only the
+// enabled operations are performed. It must be the outermost wrapper
+// because it is responsible for the optional interfaces.
+func NewSelectiveProvisioner(p controller.Provisioner, provision, delete bool) controller.Provisioner {
+	return &selectiveProvisioner{
+		Provisioner: p,
+		provision:   provision,
+		delete:      delete,
+	}
+}
+
+func (p *selectiveProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	if !p.provision {
+		return nil, controller.ProvisioningFinished, &controller.IgnoredError{Reason: "provisioning is disabled in this instance"}
+	}
+	return p.Provisioner.Provision(ctx, options)
+}
+
+func (p *selectiveProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	if !p.delete {
+		return &controller.IgnoredError{Reason: "deleting is disabled in this instance"}
+	}
+	return p.Provisioner.Delete(ctx, pv)
+}
+
+func (p *selectiveProvisioner) SupportsBlock(ctx context.Context) bool {
+	if blockProvisioner, ok := p.Provisioner.(controller.BlockProvisioner); ok {
+		return blockProvisioner.SupportsBlock(ctx)
+	}
+	return false
+}
+
+func (p *selectiveProvisioner) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	if !p.provision {
+		return false
+	}
+	if qualifier, ok := p.Provisioner.(controller.Qualifier); ok {
+		return qualifier.ShouldProvision(ctx, claim)
+	}
+	return true
+}
+
+func (p *selectiveProvisioner) ShouldDelete(ctx context.Context, volume *v1.PersistentVolume) bool {
+	if !p.delete {
+		return false
+	}
+	if deletionGuard, ok := p.Provisioner.(controller.DeletionGuard); ok {
+		return deletionGuard.ShouldDelete(ctx, volume)
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+type fakeProvisioner struct {
+	provisioned, deleted bool
+}
+
+func (p *fakeProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	p.provisioned = true
+	return &v1.PersistentVolume{}, controller.ProvisioningFinished, nil
+}
+
+func (p *fakeProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	p.deleted = true
+	return nil
+}
+
+func TestSelectiveProvisioner(t *testing.T) {
+	testcases := map[string]struct {
+		provision, delete bool
+	}{
+		"everything": {provision: true, delete: true},
+		"provision":  {provision: true},
+		"delete":     {delete: true},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			inner := &fakeProvisioner{}
+			p := NewSelectiveProvisioner(inner, tc.provision, tc.delete)
+
+			if should := p.(controller.Qualifier).ShouldProvision(ctx, &v1.PersistentVolumeClaim{}); should != tc.provision {
+				t.Errorf("expected ShouldProvision %v, got %v", tc.provision, should)
+			}
+			if should := p.(controller.DeletionGuard).ShouldDelete(ctx, &v1.PersistentVolume{}); should != tc.delete {
+				t.Errorf("expected ShouldDelete %v, got %v", tc.delete, should)
+			}
+
+			_, _, err := p.Provision(ctx, controller.ProvisionOptions{})
+			if _, ignored := err.(*controller.IgnoredError); ignored == tc.provision || inner.provisioned != tc.provision {
+				t.Errorf("expected provisioning %v, got error %v and provisioned %v", tc.provision, err, inner.provisioned)
+			}
+			err = p.Delete(ctx, &v1.PersistentVolume{})
+			if _, ignored := err.(*controller.IgnoredError); ignored == tc.delete || inner.deleted != tc.delete {
+				t.Errorf("expected deleting %v, got error %v and deleted %v", tc.delete, err, inner.deleted)
+			}
+		})
+	}
+}
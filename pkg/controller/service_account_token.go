@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// serviceAccountTokensKey is the key in the CreateVolume secrets
+	// under which the tokens for CSIDriver.spec.tokenRequests are
+	// passed to the driver. The value has the same format as the one
+	// that kubelet puts into the volume context of NodePublishVolume:
+	// a JSON map from audience to token and expiration timestamp.
+	serviceAccountTokensKey = "csi.storage.k8s.io/serviceAccount.tokens"
+
+	// defaultProvisionerServiceAccount is the service account in the
+	// namespace of the PVC whose tokens are requested unless the
+	// storage class sets prefixedProvisionerServiceAccountKey.
+	defaultProvisionerServiceAccount = "default"
+)
+
+// serviceAccountTokens returns the tokens requested by the CSIDriver
+// object of the driver for a service account in the namespace of the
+// PVC, encoded for serviceAccountTokensKey. It returns an empty string
+// if there is no CSIDriver object or it does not request tokens.
+func (p *csiProvisioner) serviceAccountTokens(ctx context.Context, claim *v1.PersistentVolumeClaim, sc *storagev1.StorageClass) (string, error) {
+	if p.csiDriverLister == nil {
+		return "", nil
+	}
+	csiDriver, err := p.csiDriverLister.Get(p.driverName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("error getting CSIDriver %s: %v", p.driverName, err)
+	}
+	if len(csiDriver.Spec.TokenRequests) == 0 {
+		return "", nil
+	}
+
+	serviceAccount := defaultProvisionerServiceAccount
+	if name, ok := sc.Parameters[prefixedProvisionerServiceAccountKey]; ok {
+		serviceAccount = name
+	}
+	tokens := map[string]authenticationv1.TokenRequestStatus{}
+	for _, tokenRequest := range csiDriver.Spec.TokenRequests {
+		tr, err := p.client.CoreV1().ServiceAccounts(claim.Namespace).CreateToken(ctx, serviceAccount, &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{
+				Audiences:         []string{tokenRequest.Audience},
+				ExpirationSeconds: tokenRequest.ExpirationSeconds,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get token for audience %q of service account %s/%s: %v", tokenRequest.Audience, claim.Namespace, serviceAccount, err)
+		}
+		tokens[tokenRequest.Audience] = tr.Status
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode service account tokens: %v", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestServiceAccountTokens(t *testing.T) {
+	expirationSeconds := int64(600)
+	testcases := map[string]struct {
+		tokenRequests []storagev1.TokenRequest
+		noCSIDriver   bool
+		parameters    map[string]string
+		expectAccount string
+		expectTokens  map[string]string
+	}{
+		"no CSIDriver": {
+			noCSIDriver: true,
+		},
+		"no token requests": {},
+		"default service account": {
+			tokenRequests: []storagev1.TokenRequest{
+				{Audience: "backend"},
+				{Audience: "vault", ExpirationSeconds: &expirationSeconds},
+			},
+			expectAccount: "default",
+			expectTokens: map[string]string{
+				"backend": "token-default-backend",
+				"vault":   "token-default-vault",
+			},
+		},
+		"service account from storage class": {
+			tokenRequests: []storagev1.TokenRequest{{Audience: "backend"}},
+			parameters:    map[string]string{prefixedProvisionerServiceAccountKey: "storage"},
+			expectAccount: "storage",
+			expectTokens: map[string]string{
+				"backend": "token-storage-backend",
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			client := fakeclientset.NewSimpleClientset()
+			var accounts []string
+			client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				create := action.(k8stesting.CreateAction)
+				if create.GetSubresource() != "token" || create.GetNamespace() != "ns" {
+					t.Errorf("unexpected action %+v", action)
+				}
+				name := create.(k8stesting.CreateActionImpl).Name
+				accounts = append(accounts, name)
+				tr := create.GetObject().(*authenticationv1.TokenRequest).DeepCopy()
+				tr.Status.Token = "token-" + name + "-" + tr.Spec.Audiences[0]
+				return true, tr, nil
+			})
+			csiDrivers := informers.NewSharedInformerFactory(client, 0).Storage().V1().CSIDrivers()
+			if !tc.noCSIDriver {
+				csiDrivers.Informer().GetStore().Add(&storagev1.CSIDriver{
+					ObjectMeta: metav1.ObjectMeta{Name: driverName},
+					Spec:       storagev1.CSIDriverSpec{TokenRequests: tc.tokenRequests},
+				})
+			}
+			p := &csiProvisioner{
+				client:          client,
+				driverName:      driverName,
+				csiDriverLister: csiDrivers.Lister(),
+			}
+			claim := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "ns"}}
+			sc := &storagev1.StorageClass{Parameters: tc.parameters}
+
+			data, err := p.serviceAccountTokens(context.Background(), claim, sc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expectTokens == nil {
+				if data != "" || len(accounts) != 0 {
+					t.Fatalf("expected no tokens, got %q for %v", data, accounts)
+				}
+				return
+			}
+			for _, account := range accounts {
+				if account != tc.expectAccount {
+					t.Errorf("expected tokens of service account %q, got %q", tc.expectAccount, account)
+				}
+			}
+			var statuses map[string]authenticationv1.TokenRequestStatus
+			if err := json.Unmarshal([]byte(data), &statuses); err != nil {
+				t.Fatalf("decoding %q: %v", data, err)
+			}
+			tokens := map[string]string{}
+			for audience, status := range statuses {
+				tokens[audience] = status.Token
+			}
+			if !reflect.DeepEqual(tokens, tc.expectTokens) {
+				t.Errorf("expected tokens %v, got %v", tc.expectTokens, tokens)
+			}
+		})
+	}
+}
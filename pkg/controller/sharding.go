@@ -0,0 +1,217 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+// ClaimShardKey determines how claims get assigned to shards.
+type ClaimShardKey string
+
+const (
+	// ClaimShardByNamespace assigns all claims of a namespace to the
+	// same shard, using a hash of the namespace name.
+	ClaimShardByNamespace ClaimShardKey = "namespace"
+
+	// ClaimShardByUID spreads claims evenly over the shards also when
+	// a few namespaces contain most of them, using a consistent hash
+	// of the claim UID. When the number of shards changes, only the
+	// claims which need to move to or from the added or removed
+	// shards get assigned differently.
+	ClaimShardByUID ClaimShardKey = "uid"
+)
+
+// ClaimShard identifies the subset of claims which are handled by one
+// external-provisioner instance.
+type ClaimShard struct {
+	// Index is the shard of this instance, in the range [0, Count).
+	Index int
+	// Count is the total number of shards.
+	Count int
+	// Key is ClaimShardByNamespace when empty.
+	Key ClaimShardKey
+}
+
+// Contains returns true if claims in the namespace belong to the shard.
+// Only valid for ClaimShardByNamespace.
+func (s ClaimShard) Contains(namespace string) bool {
+	if s.Count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(namespace))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+// ContainsClaim returns true if the claim with the given namespace and
+// UID belongs to the shard.
+func (s ClaimShard) ContainsClaim(namespace string, uid types.UID) bool {
+	if s.Key != ClaimShardByUID {
+		return s.Contains(namespace)
+	}
+	if s.Count <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(uid))
+	return jumpHash(h.Sum64(), s.Count) == s.Index
+}
+
+// jumpHash is the jump consistent hash from "A Fast, Minimal Memory,
+// Consistent Hash Algorithm" by Lamping and Veach.
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// ParseStatefulSetOrdinal returns the ordinal at the end of the name of
+// a pod that belongs to a StatefulSet, for example 2 for "provisioner-2".
+func ParseStatefulSetOrdinal(podName string) (int, error) {
+	i := strings.LastIndex(podName, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("pod name %q has no StatefulSet ordinal", podName)
+	}
+	ordinal, err := strconv.Atoi(podName[i+1:])
+	if err != nil || ordinal < 0 {
+		return 0, fmt.Errorf("pod name %q has no StatefulSet ordinal", podName)
+	}
+	return ordinal, nil
+}
+
+// NewShardedClaimInformer returns an informer which only stores claims
+// of the shard. The API server has no way to filter by a hash, so all
+// claims still get listed and watched, but the others get dropped right
+// away instead of being kept in the cache.
+//
+// It can be registered with a SharedInformerFactory through InformerFor
+// before any other code asks the factory for a claim informer.
+func NewShardedClaimInformer(client kubernetes.Interface, resyncPeriod time.Duration, shard ClaimShard) cache.SharedIndexInformer {
+	claims := client.CoreV1().PersistentVolumeClaims(v1.NamespaceAll)
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				list, err := claims.List(context.TODO(), options)
+				if err != nil {
+					return nil, err
+				}
+				items := list.Items[:0]
+				for _, claim := range list.Items {
+					if shard.ContainsClaim(claim.Namespace, claim.UID) {
+						items = append(items, claim)
+					}
+				}
+				list.Items = items
+				return list, nil
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				w, err := claims.Watch(context.TODO(), options)
+				if err != nil {
+					return nil, err
+				}
+				return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+					if event.Type == watch.Bookmark || event.Type == watch.Error {
+						return event, true
+					}
+					obj, err := meta.Accessor(event.Object)
+					if err != nil {
+						return event, true
+					}
+					return event, shard.ContainsClaim(obj.GetNamespace(), obj.GetUID())
+				}), nil
+			},
+		},
+		&v1.PersistentVolumeClaim{},
+		resyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+}
+
+// shardedProvisioner only provisions and deletes volumes whose claim
+// belongs to the shard. The claim informer usually already contains
+// nothing else, but the volume informer has all PVs.
+type shardedProvisioner struct {
+	controller.Provisioner
+	shard ClaimShard
+}
+
+var _ controller.Provisioner = &shardedProvisioner{}
+var _ controller.BlockProvisioner = &shardedProvisioner{}
+var _ controller.Qualifier = &shardedProvisioner{}
+var _ controller.DeletionGuard = &shardedProvisioner{}
+
+// NewShardedProvisioner wraps the provisioner such that other
+// instances delete the volumes of claims in other shards.
+func NewShardedProvisioner(p controller.Provisioner, shard ClaimShard) controller.Provisioner {
+	return &shardedProvisioner{
+		Provisioner: p,
+		shard:       shard,
+	}
+}
+
+func (p *shardedProvisioner) SupportsBlock(ctx context.Context) bool {
+	if blockProvisioner, ok := p.Provisioner.(controller.BlockProvisioner); ok {
+		return blockProvisioner.SupportsBlock(ctx)
+	}
+	return false
+}
+
+func (p *shardedProvisioner) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	if !p.shard.ContainsClaim(claim.Namespace, claim.UID) {
+		return false
+	}
+	if qualifier, ok := p.Provisioner.(controller.Qualifier); ok {
+		return qualifier.ShouldProvision(ctx, claim)
+	}
+	return true
+}
+
+func (p *shardedProvisioner) ShouldDelete(ctx context.Context, volume *v1.PersistentVolume) bool {
+	claimRef := volume.Spec.ClaimRef
+	if claimRef == nil || claimRef.Namespace == "" || p.shard.Key == ClaimShardByUID && claimRef.UID == "" {
+		// Volumes without a claim are handled by the first shard.
+		if p.shard.Index != 0 {
+			return false
+		}
+	} else if !p.shard.ContainsClaim(claimRef.Namespace, claimRef.UID) {
+		return false
+	}
+	if deletionGuard, ok := p.Provisioner.(controller.DeletionGuard); ok {
+		return deletionGuard.ShouldDelete(ctx, volume)
+	}
+	return true
+}
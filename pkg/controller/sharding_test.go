@@ -0,0 +1,212 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+func TestClaimShard(t *testing.T) {
+	const shards = 3
+	counts := make([]int, shards)
+	for i := 0; i < 300; i++ {
+		namespace := fmt.Sprintf("ns-%d", i)
+		owners := 0
+		for index := 0; index < shards; index++ {
+			if (ClaimShard{Index: index, Count: shards}).Contains(namespace) {
+				owners++
+				counts[index]++
+			}
+		}
+		if owners != 1 {
+			t.Errorf("namespace %s belongs to %d shards", namespace, owners)
+		}
+	}
+	for index, count := range counts {
+		if count < 50 {
+			t.Errorf("shard %d only got %d of 300 namespaces", index, count)
+		}
+	}
+	if !(ClaimShard{}).Contains("anything") {
+		t.Error("without sharding, all namespaces must be included")
+	}
+}
+
+func TestClaimShardByUID(t *testing.T) {
+	shardOf := func(uid types.UID, shards int) int {
+		owner := -1
+		for index := 0; index < shards; index++ {
+			if (ClaimShard{Index: index, Count: shards, Key: ClaimShardByUID}).ContainsClaim("ns", uid) {
+				if owner != -1 {
+					t.Fatalf("claim %s belongs to shards %d and %d", uid, owner, index)
+				}
+				owner = index
+			}
+		}
+		if owner == -1 {
+			t.Fatalf("claim %s belongs to no shard", uid)
+		}
+		return owner
+	}
+
+	counts := make([]int, 3)
+	moved := 0
+	for i := 0; i < 300; i++ {
+		uid := types.UID(fmt.Sprintf("uid-%d", i))
+		before := shardOf(uid, 3)
+		counts[before]++
+		// Adding a fourth shard only moves claims to that shard.
+		if after := shardOf(uid, 4); after != before {
+			if after != 3 {
+				t.Errorf("claim %s moved from shard %d to %d", uid, before, after)
+			}
+			moved++
+		}
+	}
+	for index, count := range counts {
+		if count < 50 {
+			t.Errorf("shard %d only got %d of 300 claims", index, count)
+		}
+	}
+	if moved < 40 || moved > 110 {
+		t.Errorf("expected about a quarter of the claims to move to the new shard, got %d", moved)
+	}
+}
+
+func TestParseStatefulSetOrdinal(t *testing.T) {
+	testcases := map[string]int{
+		"csi-provisioner-0":  0,
+		"csi-provisioner-12": 12,
+		"csi-provisioner":    -1,
+		"provisioner-abc":    -1,
+		"provisioner-":       -1,
+	}
+	for podName, expected := range testcases {
+		ordinal, err := ParseStatefulSetOrdinal(podName)
+		if expected < 0 {
+			if err == nil {
+				t.Errorf("%s: expected error, got ordinal %d", podName, ordinal)
+			}
+			continue
+		}
+		if err != nil || ordinal != expected {
+			t.Errorf("%s: expected ordinal %d, got %d, %v", podName, expected, ordinal, err)
+		}
+	}
+}
+
+func TestShardedClaimInformer(t *testing.T) {
+	shard := ClaimShard{Index: 1, Count: 2}
+	var objects []runtime.Object
+	expected := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		claim := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: fmt.Sprintf("ns-%d", i)},
+		}
+		objects = append(objects, claim)
+		if shard.Contains(claim.Namespace) {
+			expected[claim.Namespace+"/"+claim.Name] = true
+		}
+	}
+	client := fake.NewSimpleClientset(objects...)
+	informer := NewShardedClaimInformer(client, 0, shard)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		t.Fatal("informer not synced")
+	}
+
+	keys := informer.GetStore().ListKeys()
+	if len(keys) != len(expected) {
+		t.Errorf("expected %d claims, got %v", len(expected), keys)
+	}
+	for _, key := range keys {
+		if !expected[key] {
+			t.Errorf("unexpected claim %s in shard", key)
+		}
+	}
+}
+
+func TestShardedProvisioner(t *testing.T) {
+	ctx := context.Background()
+	shard := ClaimShard{Index: 0, Count: 2}
+	var inShard, otherShard string
+	for i := 0; inShard == "" || otherShard == ""; i++ {
+		namespace := fmt.Sprintf("ns-%d", i)
+		if shard.Contains(namespace) {
+			inShard = namespace
+		} else {
+			otherShard = namespace
+		}
+	}
+	pv := func(namespace string) *v1.PersistentVolume {
+		pv := &v1.PersistentVolume{}
+		if namespace != "" {
+			pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: namespace, Name: "claim"}
+		}
+		return pv
+	}
+
+	for index, expectUnbound := range []bool{true, false} {
+		p := NewShardedProvisioner(&fakeProvisioner{}, ClaimShard{Index: index, Count: 2})
+		if should := p.(controller.DeletionGuard).ShouldDelete(ctx, pv("")); should != expectUnbound {
+			t.Errorf("shard %d: expected ShouldDelete %v for PV without claim, got %v", index, expectUnbound, should)
+		}
+	}
+
+	p := NewShardedProvisioner(&fakeProvisioner{}, shard)
+	if !p.(controller.DeletionGuard).ShouldDelete(ctx, pv(inShard)) {
+		t.Errorf("PV of claim in namespace %s should be deleted", inShard)
+	}
+	if p.(controller.DeletionGuard).ShouldDelete(ctx, pv(otherShard)) {
+		t.Errorf("PV of claim in namespace %s should not be deleted", otherShard)
+	}
+	claim := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: otherShard}}
+	if p.(controller.Qualifier).ShouldProvision(ctx, claim) {
+		t.Errorf("claim in namespace %s should not be provisioned", otherShard)
+	}
+
+	byUID := ClaimShard{Index: 1, Count: 2, Key: ClaimShardByUID}
+	p = NewShardedProvisioner(&fakeProvisioner{}, byUID)
+	for i := 0; i < 10; i++ {
+		uid := types.UID(fmt.Sprintf("uid-%d", i))
+		expected := byUID.ContainsClaim(inShard, uid)
+		volume := pv(inShard)
+		volume.Spec.ClaimRef.UID = uid
+		if should := p.(controller.DeletionGuard).ShouldDelete(ctx, volume); should != expected {
+			t.Errorf("PV of claim %s: expected ShouldDelete %v, got %v", uid, expected, should)
+		}
+		claim := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: inShard, UID: uid}}
+		if should := p.(controller.Qualifier).ShouldProvision(ctx, claim); should != expected {
+			t.Errorf("claim %s: expected ShouldProvision %v, got %v", uid, expected, should)
+		}
+	}
+	if p.(controller.DeletionGuard).ShouldDelete(ctx, pv(inShard)) {
+		t.Error("PV of claim without UID should only be deleted by the first shard")
+	}
+}
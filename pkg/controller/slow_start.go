@@ -0,0 +1,180 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+var slowStartWorkerLimit = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Name:           "slow_start_worker_limit",
+		Help:           "Current number of concurrent operations allowed while ramping up after a restart, by operation.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"operation"},
+)
+
+func init() {
+	legacyregistry.MustRegister(slowStartWorkerLimit)
+}
+
+// slowStart limits the number of concurrent operations. The limit
+// starts at one with the first operation and grows linearly until it
+// reaches the maximum at the end of the ramp-up period.
+type slowStart struct {
+	operation string
+	max       int
+	rampUp    time.Duration
+	now       func() time.Time
+
+	mutex  sync.Mutex
+	start  time.Time
+	active int
+	// changed gets closed and replaced when an operation completes.
+	changed chan struct{}
+}
+
+func newSlowStart(operation string, max int, rampUp time.Duration) *slowStart {
+	return &slowStart{
+		operation: operation,
+		max:       max,
+		rampUp:    rampUp,
+		now:       time.Now,
+		changed:   make(chan struct{}),
+	}
+}
+
+// limit must be called while holding the mutex.
+func (s *slowStart) limit(now time.Time) int {
+	elapsed := now.Sub(s.start)
+	if elapsed >= s.rampUp {
+		return s.max
+	}
+	return 1 + int(float64(s.max-1)*float64(elapsed)/float64(s.rampUp))
+}
+
+// acquire blocks until the operation may proceed or the context is
+// done. On success, the returned function must be called once the
+// operation is complete.
+func (s *slowStart) acquire(ctx context.Context) (func(), error) {
+	// The limit grows by one at this interval.
+	step := s.rampUp / time.Duration(s.max)
+	for {
+		s.mutex.Lock()
+		now := s.now()
+		if s.start.IsZero() {
+			klog.V(2).Infof("Ramping up %s operations to %d within %s", s.operation, s.max, s.rampUp)
+			s.start = now
+		}
+		limit := s.limit(now)
+		slowStartWorkerLimit.WithLabelValues(s.operation).Set(float64(limit))
+		if s.active < limit {
+			s.active++
+			s.mutex.Unlock()
+			return s.release, nil
+		}
+		changed := s.changed
+		s.mutex.Unlock()
+
+		timer := time.NewTimer(step)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+func (s *slowStart) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.active--
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// slowStartProvisioner ramps up the number of concurrent Provision and
+// Delete calls after a restart. Both get counted separately because
+// the provisioner library has separate worker threads for them.
+type slowStartProvisioner struct {
+	controller.Provisioner
+	provision *slowStart
+	delete    *slowStart
+}
+
+var _ controller.Provisioner = &slowStartProvisioner{}
+var _ controller.BlockProvisioner = &slowStartProvisioner{}
+var _ controller.Qualifier = &slowStartProvisioner{}
+
+// NewSlowStartProvisioner wraps the provisioner such that at first only
+// one volume at a time gets provisioned and deleted. The number of
+// concurrent operations then increases until it reaches the number of
+// worker threads after the ramp-up period. This avoids overloading the
+// storage backend when a new leader starts working through a large
+// backlog.
+func NewSlowStartProvisioner(p controller.Provisioner, workers int, rampUp time.Duration) controller.Provisioner {
+	return &slowStartProvisioner{
+		Provisioner: p,
+		provision:   newSlowStart("provision", workers, rampUp),
+		delete:      newSlowStart("delete", workers, rampUp),
+	}
+}
+
+func (p *slowStartProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	release, err := p.provision.acquire(ctx)
+	if err != nil {
+		return nil, controller.ProvisioningNoChange, fmt.Errorf("waiting for ramp-up of provisioning: %v", err)
+	}
+	defer release()
+	return p.Provisioner.Provision(ctx, options)
+}
+
+func (p *slowStartProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	release, err := p.delete.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting for ramp-up of deletion: %v", err)
+	}
+	defer release()
+	return p.Provisioner.Delete(ctx, pv)
+}
+
+func (p *slowStartProvisioner) SupportsBlock(ctx context.Context) bool {
+	if blockProvisioner, ok := p.Provisioner.(controller.BlockProvisioner); ok {
+		return blockProvisioner.SupportsBlock(ctx)
+	}
+	return false
+}
+
+func (p *slowStartProvisioner) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	if qualifier, ok := p.Provisioner.(controller.Qualifier); ok {
+		return qualifier.ShouldProvision(ctx, claim)
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSlowStartLimit(t *testing.T) {
+	s := newSlowStart("provision", 11, 10*time.Second)
+	start := time.Now()
+	s.start = start
+	for elapsed, expected := range map[time.Duration]int{
+		0:                       1,
+		time.Second:             2,
+		5*time.Second + 1:       6,
+		10 * time.Second:        11,
+		time.Hour:               11,
+		9999 * time.Millisecond: 10,
+	} {
+		if limit := s.limit(start.Add(elapsed)); limit != expected {
+			t.Errorf("after %s: expected limit %d, got %d", elapsed, expected, limit)
+		}
+	}
+}
+
+func TestSlowStartAcquire(t *testing.T) {
+	now := time.Now()
+	s := newSlowStart("provision", 3, time.Minute)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	release, err := s.acquire(ctx)
+	if err != nil {
+		t.Fatalf("first operation: %v", err)
+	}
+
+	// Only one operation is allowed at the beginning.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(timeoutCtx); err == nil {
+		t.Fatal("second operation should have been blocked")
+	}
+
+	// Completing the first operation unblocks a waiting one.
+	acquired := make(chan func())
+	go func() {
+		release, err := s.acquire(ctx)
+		if err != nil {
+			t.Errorf("waiting operation: %v", err)
+		}
+		acquired <- release
+	}()
+	release()
+	release = <-acquired
+
+	// After the ramp-up, all operations may run.
+	s.mutex.Lock()
+	now = now.Add(time.Minute)
+	s.mutex.Unlock()
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := s.acquire(ctx)
+		if err != nil {
+			t.Fatalf("operation #%d after ramp-up: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+	release()
+	for _, release := range releases {
+		release()
+	}
+	if s.active != 0 {
+		t.Errorf("expected no active operations, got %d", s.active)
+	}
+}
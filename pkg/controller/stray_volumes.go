@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+var strayVolumesRemoved = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name:           "persistentvolume_stray_volumes_removed_total",
+		Help:           "Number of PVs which were never bound and whose PVC no longer exists, removed together with their volume, by result.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"result"},
+)
+
+func init() {
+	legacyregistry.MustRegister(strayVolumesRemoved)
+}
+
+// StrayVolumeCleaner removes PVs of the driver which were never bound
+// and whose PVC is gone, for example because the PVC was deleted while
+// CreateVolume was still in progress. Normally kube-controller-manager
+// releases such PVs and they get deleted like any other, but PVs may
+// also get stuck in the Pending or Available phase, for example when
+// the PV controller was not running at the time.
+//
+// Only PVs with reclaim policy Delete are considered, and only after
+// they exist for a certain time. The volume gets deleted first through
+// the provisioner, then the PV.
+type StrayVolumeCleaner struct {
+	client      kubernetes.Interface
+	driverName  string
+	provisioner controller.Provisioner
+	pvLister    corelisters.PersistentVolumeLister
+	minAge      time.Duration
+	now         func() time.Time
+}
+
+// NewStrayVolumeCleaner creates a cleaner for PVs of the driver which
+// are older than minAge. The provisioner must be the same one that the
+// provision controller uses, including all wrappers.
+func NewStrayVolumeCleaner(
+	client kubernetes.Interface,
+	driverName string,
+	provisioner controller.Provisioner,
+	pvLister corelisters.PersistentVolumeLister,
+	minAge time.Duration,
+) *StrayVolumeCleaner {
+	return &StrayVolumeCleaner{
+		client:      client,
+		driverName:  driverName,
+		provisioner: provisioner,
+		pvLister:    pvLister,
+		minAge:      minAge,
+		now:         time.Now,
+	}
+}
+
+// Run checks for stray PVs once per interval until the context is done.
+func (c *StrayVolumeCleaner) Run(ctx context.Context, interval time.Duration) {
+	klog.Infof("Starting StrayVolumeCleaner for PVs older than %s", c.minAge)
+	wait.UntilWithContext(ctx, c.cleanup, interval)
+	klog.Info("Shutting down StrayVolumeCleaner")
+}
+
+func (c *StrayVolumeCleaner) cleanup(ctx context.Context) {
+	pvs, err := c.pvLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("StrayVolumeCleaner: list PVs: %v", err)
+		return
+	}
+	for _, pv := range pvs {
+		if !c.isCandidate(pv) {
+			continue
+		}
+		if err := c.remove(ctx, pv); err != nil {
+			klog.Errorf("StrayVolumeCleaner: PV %s: %v", pv.Name, err)
+			strayVolumesRemoved.WithLabelValues("error").Inc()
+		}
+	}
+}
+
+// isCandidate checks the cached PV. The PV and its claim get checked
+// again with the API server before removing anything.
+func (c *StrayVolumeCleaner) isCandidate(pv *v1.PersistentVolume) bool {
+	if pv.Spec.CSI == nil ||
+		pv.Spec.CSI.Driver != c.driverName ||
+		pv.Annotations[annDynamicallyProvisioned] != c.driverName ||
+		pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete ||
+		pv.DeletionTimestamp != nil {
+		return false
+	}
+	if pv.Status.Phase != v1.VolumePending && pv.Status.Phase != v1.VolumeAvailable {
+		// Bound, or released and handled by the normal deletion.
+		return false
+	}
+	return c.now().Sub(pv.CreationTimestamp.Time) >= c.minAge
+}
+
+func (c *StrayVolumeCleaner) remove(ctx context.Context, cached *v1.PersistentVolume) error {
+	pv, err := c.client.CoreV1().PersistentVolumes().Get(ctx, cached.Name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !c.isCandidate(pv) {
+		return nil
+	}
+	stray, err := c.claimIsGone(ctx, pv)
+	if err != nil || !stray {
+		return err
+	}
+	if guard, ok := c.provisioner.(controller.DeletionGuard); ok && !guard.ShouldDelete(ctx, pv) {
+		return nil
+	}
+
+	klog.Infof("StrayVolumeCleaner: PV %s was never bound and its claim %s/%s is gone, deleting volume %s",
+		pv.Name, pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name, pv.Spec.CSI.VolumeHandle)
+	if err := c.provisioner.Delete(ctx, pv); err != nil {
+		var ignored *controller.IgnoredError
+		if errors.As(err, &ignored) {
+			// Handled by some other instance.
+			klog.V(3).Infof("StrayVolumeCleaner: PV %s: %s", pv.Name, ignored.Reason)
+			return nil
+		}
+		return fmt.Errorf("delete volume: %v", err)
+	}
+	// The preconditions ensure that the PV was not modified
+	// in the meantime.
+	err = c.client.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{
+			UID:             &pv.UID,
+			ResourceVersion: &pv.ResourceVersion,
+		},
+	})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return fmt.Errorf("delete PV: %v", err)
+	}
+	strayVolumesRemoved.WithLabelValues("success").Inc()
+	return nil
+}
+
+// claimIsGone checks with the API server whether the PVC that the PV
+// was provisioned for no longer exists.
+func (c *StrayVolumeCleaner) claimIsGone(ctx context.Context, pv *v1.PersistentVolume) (bool, error) {
+	claimRef := pv.Spec.ClaimRef
+	if claimRef == nil || claimRef.UID == "" {
+		// Not provisioned for a specific PVC, leave it alone.
+		return false, nil
+	}
+	claim, err := c.client.CoreV1().PersistentVolumeClaims(claimRef.Namespace).Get(ctx, claimRef.Name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// A new PVC with the same name is a different claim.
+	return claim.UID != claimRef.UID, nil
+}
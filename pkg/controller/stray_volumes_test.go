@@ -0,0 +1,151 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestStrayVolumeCleaner(t *testing.T) {
+	now := time.Now()
+	minAge := time.Hour
+	claimUID := types.UID("claim-uid")
+
+	pv := func(modify func(pv *v1.PersistentVolume)) *v1.PersistentVolume {
+		pv := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "pv",
+				UID:               "pv-uid",
+				CreationTimestamp: metav1.NewTime(now.Add(-2 * minAge)),
+				Annotations:       map[string]string{annDynamicallyProvisioned: driverName},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{
+						Driver:       driverName,
+						VolumeHandle: "pv-handle",
+					},
+				},
+				ClaimRef: &v1.ObjectReference{
+					Namespace: "default",
+					Name:      "claim",
+					UID:       claimUID,
+				},
+			},
+			Status: v1.PersistentVolumeStatus{Phase: v1.VolumeAvailable},
+		}
+		if modify != nil {
+			modify(pv)
+		}
+		return pv
+	}
+	claim := func(uid types.UID) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "claim",
+				UID:       uid,
+			},
+		}
+	}
+
+	testcases := map[string]struct {
+		pv           *v1.PersistentVolume
+		claim        *v1.PersistentVolumeClaim
+		expectRemove bool
+	}{
+		"claim gone": {
+			pv:           pv(nil),
+			expectRemove: true,
+		},
+		"pending": {
+			pv:           pv(func(pv *v1.PersistentVolume) { pv.Status.Phase = v1.VolumePending }),
+			expectRemove: true,
+		},
+		"claim re-created": {
+			pv:           pv(nil),
+			claim:        claim("other-uid"),
+			expectRemove: true,
+		},
+		"claim exists": {
+			pv:    pv(nil),
+			claim: claim(claimUID),
+		},
+		"bound": {
+			pv: pv(func(pv *v1.PersistentVolume) { pv.Status.Phase = v1.VolumeBound }),
+		},
+		"released": {
+			pv: pv(func(pv *v1.PersistentVolume) { pv.Status.Phase = v1.VolumeReleased }),
+		},
+		"too young": {
+			pv: pv(func(pv *v1.PersistentVolume) { pv.CreationTimestamp = metav1.NewTime(now.Add(-minAge / 2)) }),
+		},
+		"retained": {
+			pv: pv(func(pv *v1.PersistentVolume) {
+				pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+			}),
+		},
+		"other driver": {
+			pv: pv(func(pv *v1.PersistentVolume) { pv.Spec.CSI.Driver = "other-driver" }),
+		},
+		"not provisioned": {
+			pv: pv(func(pv *v1.PersistentVolume) { pv.Annotations = nil }),
+		},
+		"no claim": {
+			pv: pv(func(pv *v1.PersistentVolume) { pv.Spec.ClaimRef = nil }),
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			client := fakeclientset.NewSimpleClientset(tc.pv)
+			if tc.claim != nil {
+				if _, err := client.CoreV1().PersistentVolumeClaims(tc.claim.Namespace).Create(ctx, tc.claim, metav1.CreateOptions{}); err != nil {
+					t.Fatalf("create claim: %v", err)
+				}
+			}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if err := indexer.Add(tc.pv); err != nil {
+				t.Fatalf("add PV: %v", err)
+			}
+			provisioner := &fakeProvisioner{}
+			cleaner := NewStrayVolumeCleaner(client, driverName, provisioner, corelisters.NewPersistentVolumeLister(indexer), minAge)
+			cleaner.now = func() time.Time { return now }
+
+			cleaner.cleanup(ctx)
+
+			if provisioner.deleted != tc.expectRemove {
+				t.Errorf("expected volume deleted %v, got %v", tc.expectRemove, provisioner.deleted)
+			}
+			_, err := client.CoreV1().PersistentVolumes().Get(ctx, tc.pv.Name, metav1.GetOptions{})
+			if removed := err != nil; removed != tc.expectRemove {
+				t.Errorf("expected PV removed %v, got error %v", tc.expectRemove, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// throughputWindow is the period over which the provisioning throughput
+// gets averaged. It is long enough to smooth out bursts and short enough
+// to reflect a recovering backend within a few minutes.
+const throughputWindow = 5 * time.Minute
+
+var (
+	throughputDesc = metrics.NewDesc(
+		"persistentvolumeclaim_provisioning_throughput_per_minute",
+		"Average number of PVCs per minute that got bound during the last five minutes.",
+		[]string{"driver_name"}, nil,
+		metrics.ALPHA,
+		"",
+	)
+	backlogDesc = metrics.NewDesc(
+		"persistentvolumeclaim_provisioning_backlog",
+		"Number of PVCs which are waiting to be bound.",
+		[]string{"driver_name"}, nil,
+		metrics.ALPHA,
+		"",
+	)
+	burnDownDesc = metrics.NewDesc(
+		"persistentvolumeclaim_provisioning_backlog_burn_down_seconds",
+		"Estimated time until all pending PVCs are bound at the current throughput. Not reported while the throughput is zero.",
+		[]string{"driver_name"}, nil,
+		metrics.ALPHA,
+		"",
+	)
+
+	throughputs = &throughputCollector{}
+)
+
+func init() {
+	legacyregistry.CustomMustRegister(throughputs)
+}
+
+// throughputCollector reports throughput and backlog for all trackers
+// created with NewBoundLatencyTracker.
+type throughputCollector struct {
+	metrics.BaseStableCollector
+
+	mutex    sync.Mutex
+	trackers []*BoundLatencyTracker
+}
+
+var _ metrics.StableCollector = &throughputCollector{}
+
+func (c *throughputCollector) add(t *BoundLatencyTracker) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.trackers = append(c.trackers, t)
+}
+
+// DescribeWithStability implements the metrics.StableCollector interface.
+func (c *throughputCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- throughputDesc
+	ch <- backlogDesc
+	ch <- burnDownDesc
+}
+
+// CollectWithStability implements the metrics.StableCollector interface.
+func (c *throughputCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, t := range c.trackers {
+		backlog, perMinute := t.throughput()
+		ch <- metrics.NewLazyConstMetric(throughputDesc, metrics.GaugeValue, perMinute, t.driverName)
+		ch <- metrics.NewLazyConstMetric(backlogDesc, metrics.GaugeValue, float64(backlog), t.driverName)
+		if burnDown, ok := burnDownSeconds(backlog, perMinute); ok {
+			ch <- metrics.NewLazyConstMetric(burnDownDesc, metrics.GaugeValue, burnDown, t.driverName)
+		}
+	}
+}
+
+// throughput returns the number of pending PVCs and how many PVCs per
+// minute got bound on average during the last throughputWindow.
+func (t *BoundLatencyTracker) throughput() (backlog int, perMinute float64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.bound = t.pruneBound(t.now())
+	return len(t.pending), float64(len(t.bound)) / throughputWindow.Minutes()
+}
+
+// pruneBound removes times which are outside of the throughputWindow.
+// Must be called with the mutex locked.
+func (t *BoundLatencyTracker) pruneBound(now time.Time) []time.Time {
+	cutoff := now.Add(-throughputWindow)
+	i := 0
+	for i < len(t.bound) && !t.bound[i].After(cutoff) {
+		i++
+	}
+	return t.bound[i:]
+}
+
+// burnDownSeconds estimates how long it takes to clear the backlog. It
+// returns false when that cannot be estimated because nothing got
+// bound recently.
+func burnDownSeconds(backlog int, perMinute float64) (float64, bool) {
+	if backlog == 0 {
+		return 0, true
+	}
+	if perMinute <= 0 {
+		return 0, false
+	}
+	return float64(backlog) / perMinute * 60, true
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func throughputTestClaim(i int, phase v1.PersistentVolumeClaimPhase) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("pvc-%d", i),
+			UID:         types.UID(fmt.Sprintf("uid-%d", i)),
+			Annotations: map[string]string{annStorageProvisioner: driverName},
+		},
+		Status: v1.PersistentVolumeClaimStatus{
+			Phase: phase,
+		},
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	now := time.Now()
+	tracker := newBoundLatencyTracker(driverName, "")
+	tracker.now = func() time.Time { return now }
+	tracker.observe = func(string, float64) {}
+
+	for i := 0; i < 30; i++ {
+		tracker.OnAdd(throughputTestClaim(i, v1.ClaimPending))
+	}
+	// Bind ten PVCs, one every minute.
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Minute)
+		tracker.OnUpdate(throughputTestClaim(i, v1.ClaimPending), throughputTestClaim(i, v1.ClaimBound))
+	}
+
+	// Only the last five are inside the window.
+	backlog, perMinute := tracker.throughput()
+	if backlog != 20 || perMinute != 1 {
+		t.Fatalf("expected backlog 20 and throughput 1/min, got %d and %v", backlog, perMinute)
+	}
+
+	registry := metrics.NewKubeRegistry()
+	collector := &throughputCollector{}
+	collector.add(tracker)
+	registry.CustomMustRegister(collector)
+	if err := testutil.GatherAndCompare(registry, bytes.NewBufferString(`# HELP persistentvolumeclaim_provisioning_backlog [ALPHA] Number of PVCs which are waiting to be bound.
+# TYPE persistentvolumeclaim_provisioning_backlog gauge
+persistentvolumeclaim_provisioning_backlog{driver_name="test-driver"} 20
+# HELP persistentvolumeclaim_provisioning_backlog_burn_down_seconds [ALPHA] Estimated time until all pending PVCs are bound at the current throughput. Not reported while the throughput is zero.
+# TYPE persistentvolumeclaim_provisioning_backlog_burn_down_seconds gauge
+persistentvolumeclaim_provisioning_backlog_burn_down_seconds{driver_name="test-driver"} 1200
+# HELP persistentvolumeclaim_provisioning_throughput_per_minute [ALPHA] Average number of PVCs per minute that got bound during the last five minutes.
+# TYPE persistentvolumeclaim_provisioning_throughput_per_minute gauge
+persistentvolumeclaim_provisioning_throughput_per_minute{driver_name="test-driver"} 1
+`)); err != nil {
+		t.Error(err)
+	}
+
+	// Nothing got bound for a while.
+	now = now.Add(throughputWindow)
+	backlog, perMinute = tracker.throughput()
+	if backlog != 20 || perMinute != 0 {
+		t.Fatalf("expected backlog 20 and throughput 0/min, got %d and %v", backlog, perMinute)
+	}
+	if len(tracker.bound) != 0 {
+		t.Errorf("expected old entries to be pruned, got %v", tracker.bound)
+	}
+}
+
+func TestBurnDownSeconds(t *testing.T) {
+	testcases := []struct {
+		backlog   int
+		perMinute float64
+		expected  float64
+		ok        bool
+	}{
+		{backlog: 0, perMinute: 0, expected: 0, ok: true},
+		{backlog: 10, perMinute: 0, ok: false},
+		{backlog: 10, perMinute: 2, expected: 300, ok: true},
+	}
+	for _, tc := range testcases {
+		seconds, ok := burnDownSeconds(tc.backlog, tc.perMinute)
+		if ok != tc.ok || seconds != tc.expected {
+			t.Errorf("backlog %d, %v/min: expected %v %v, got %v %v", tc.backlog, tc.perMinute, tc.expected, tc.ok, seconds, ok)
+		}
+	}
+}
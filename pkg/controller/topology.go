@@ -266,6 +266,123 @@ func GenerateAccessibilityRequirements(
 	return requirement, nil
 }
 
+// TopologyLimitStrategy determines what LimitAccessibilityRequirements
+// does when there are too many requisite topology entries.
+type TopologyLimitStrategy string
+
+const (
+	// TopologyLimitTruncate keeps only the first preferred entries.
+	TopologyLimitTruncate TopologyLimitStrategy = "truncate"
+	// TopologyLimitError fails provisioning.
+	TopologyLimitError TopologyLimitStrategy = "error"
+	// TopologyLimitSample keeps the first preferred entry and a sample
+	// of the other requisite entries which is spread evenly across the
+	// sorted list.
+	TopologyLimitSample TopologyLimitStrategy = "sample"
+)
+
+// LimitAccessibilityRequirements ensures that the requirement has at most
+// maxEntries requisite topology entries. Zero or less disables the
+// limit. When truncating, the first preferred entries are kept because
+// the preferred list starts with the topology of the selected node (if
+// any) and the requisite list must be a superset of the preferred one.
+// When sampling, the PVC name determines which entries are kept, so
+// retries for the same PVC get the same result while different PVCs
+// use different parts of the cluster.
+func LimitAccessibilityRequirements(requirement *csi.TopologyRequirement, maxEntries int, strategy TopologyLimitStrategy, pvcName string) (*csi.TopologyRequirement, error) {
+	if requirement == nil || maxEntries <= 0 || len(requirement.Requisite) <= maxEntries {
+		return requirement, nil
+	}
+	if strategy == TopologyLimitError {
+		return nil, fmt.Errorf("%d requisite topology entries exceed the limit of %d", len(requirement.Requisite), maxEntries)
+	}
+	if strategy == TopologyLimitSample {
+		return sampleAccessibilityRequirements(requirement, maxEntries, pvcName), nil
+	}
+
+	klog.Warningf("Truncating %d requisite topology entries to %d", len(requirement.Requisite), maxEntries)
+	preferred := requirement.Preferred
+	if len(preferred) == 0 {
+		preferred = requirement.Requisite
+	}
+	if len(preferred) > maxEntries {
+		preferred = preferred[:maxEntries]
+	}
+	requisite := make([]*csi.Topology, len(preferred))
+	copy(requisite, preferred)
+	sort.Slice(requisite, func(i, j int) bool {
+		return topologyTerm(requisite[i].Segments).less(requisite[j].Segments)
+	})
+	logDroppedTopologies(requirement.Requisite, requisite)
+	return &csi.TopologyRequirement{
+		Requisite: requisite,
+		Preferred: preferred,
+	}, nil
+}
+
+// sampleAccessibilityRequirements implements TopologyLimitSample. The
+// requisite list is sorted, so the result only depends on the set of
+// topology entries and the PVC name.
+func sampleAccessibilityRequirements(requirement *csi.TopologyRequirement, maxEntries int, pvcName string) *csi.TopologyRequirement {
+	keep := map[string]bool{}
+	var primary string
+	if len(requirement.Preferred) > 0 {
+		primary = topologyTerm(requirement.Preferred[0].Segments).hash()
+		keep[primary] = true
+	}
+	var candidates []*csi.Topology
+	for _, topology := range requirement.Requisite {
+		if topologyTerm(topology.Segments).hash() != primary {
+			candidates = append(candidates, topology)
+		}
+	}
+	h := fnv.New32()
+	h.Write([]byte(pvcName))
+	offset := int(h.Sum32() % uint32(len(candidates)))
+	samples := maxEntries - len(keep)
+	for i := 0; i < samples; i++ {
+		// Distinct indices because there are more candidates than samples.
+		index := (offset + i*len(candidates)/samples) % len(candidates)
+		keep[topologyTerm(candidates[index].Segments).hash()] = true
+	}
+
+	filter := func(topologies []*csi.Topology) []*csi.Topology {
+		var result []*csi.Topology
+		for _, topology := range topologies {
+			if keep[topologyTerm(topology.Segments).hash()] {
+				result = append(result, topology)
+			}
+		}
+		return result
+	}
+	requisite := filter(requirement.Requisite)
+	klog.Warningf("Sampling %d of %d requisite topology entries", len(requisite), len(requirement.Requisite))
+	logDroppedTopologies(requirement.Requisite, requisite)
+	return &csi.TopologyRequirement{
+		Requisite: requisite,
+		Preferred: filter(requirement.Preferred),
+	}
+}
+
+// logDroppedTopologies logs the entries of all which are not in kept.
+// The list can be long, therefore only at a higher log level.
+func logDroppedTopologies(all, kept []*csi.Topology) {
+	if !klog.V(4).Enabled() {
+		return
+	}
+	found := map[string]bool{}
+	for _, topology := range kept {
+		found[topologyTerm(topology.Segments).hash()] = true
+	}
+	var dropped []string
+	for _, topology := range all {
+		if hash := topologyTerm(topology.Segments).hash(); !found[hash] {
+			dropped = append(dropped, hash)
+		}
+	}
+	klog.V(4).Infof("Dropped topology entries: %s", strings.Join(dropped, " "))
+}
+
 // getSelectedCSINode returns the CSINode object for the given selectedNode.
 func getSelectedCSINode(
 	csiNodeLister storagelistersv1.CSINodeLister,
@@ -303,17 +420,11 @@ func aggregateTopologies(
 			// error with the API server.
 			return nil, fmt.Errorf("error listing CSINodes: %v", err)
 		}
-		rand.Shuffle(len(csiNodes), func(i, j int) {
-			csiNodes[i], csiNodes[j] = csiNodes[j], csiNodes[i]
-		})
-
-		// Pick the first node with topology keys
-		for _, csiNode := range csiNodes {
-			topologyKeys = getTopologyKeys(csiNode, driverName)
-			if topologyKeys != nil {
-				break
-			}
-		}
+		// The CSINode objects are not cached between calls, so
+		// changes of the topology keys (for example, because an
+		// upgraded driver reports an additional key) take effect
+		// immediately.
+		topologyKeys = selectTopologyKeys(csiNodes, driverName)
 
 		if len(topologyKeys) == 0 {
 			// The driver supports topology but no nodes have registered any topology keys.
@@ -370,6 +481,38 @@ func aggregateTopologies(
 	return terms, nil
 }
 
+// selectTopologyKeys picks the set of topology keys that is reported by
+// most CSINode objects for the driver. Different key sets occur while a
+// driver update which changes the keys is rolled out. Ties are broken
+// in favor of the set with more keys, then alphabetically, so that the
+// result is deterministic.
+func selectTopologyKeys(csiNodes []*storagev1.CSINode, driverName string) []string {
+	counts := map[string]int{}
+	keySets := map[string][]string{}
+	for _, csiNode := range csiNodes {
+		keys := getTopologyKeys(csiNode, driverName)
+		if len(keys) == 0 {
+			continue
+		}
+		sorted := append([]string(nil), keys...)
+		sort.Strings(sorted)
+		id := strings.Join(sorted, ",")
+		counts[id]++
+		keySets[id] = sorted
+	}
+
+	var best string
+	for id, count := range counts {
+		if best == "" ||
+			count > counts[best] ||
+			count == counts[best] && len(keySets[id]) > len(keySets[best]) ||
+			count == counts[best] && len(keySets[id]) == len(keySets[best]) && id < best {
+			best = id
+		}
+	}
+	return keySets[best]
+}
+
 // AllowedTopologies is an OR of TopologySelectorTerms.
 // A TopologySelectorTerm contains an AND of TopologySelectorLabelRequirements.
 // A TopologySelectorLabelRequirement contains a single key and an OR of topology values.
@@ -443,6 +586,10 @@ func deduplicate(terms []topologyTerm) []topologyTerm {
 	for _, term := range termMap {
 		dedupedTerms = append(dedupedTerms, term)
 	}
+	// Map iteration is random, sort to get a deterministic order.
+	sort.Slice(dedupedTerms, func(i, j int) bool {
+		return dedupedTerms[i].less(dedupedTerms[j])
+	})
 	return dedupedTerms
 }
 
@@ -0,0 +1,87 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// prefixedTopologyModeKey is a storage class parameter which overrides
+// the topology mode of the provisioner for that class.
+const prefixedTopologyModeKey = csiParameterPrefix + "topology-mode"
+
+// TopologyMode determines which parts of the accessibility requirements
+// are passed to CreateVolume.
+type TopologyMode string
+
+const (
+	// TopologyModeRequisite passes requisite and preferred topology.
+	// This is the default.
+	TopologyModeRequisite TopologyMode = "requisite"
+	// TopologyModePreferredOnly only passes preferred topology. This
+	// is meant for drivers which ignore the requisite topology and
+	// reject the long lists that large clusters produce.
+	TopologyModePreferredOnly TopologyMode = "preferred-only"
+)
+
+// ParseTopologyMode checks that the string is a known mode.
+func ParseTopologyMode(mode string) (TopologyMode, error) {
+	switch TopologyMode(mode) {
+	case TopologyModeRequisite, TopologyModePreferredOnly:
+		return TopologyMode(mode), nil
+	default:
+		return "", fmt.Errorf("invalid topology mode %q, must be %q or %q", mode, TopologyModeRequisite, TopologyModePreferredOnly)
+	}
+}
+
+// topologyModeForClass returns the mode from the storage class
+// parameters, if set, otherwise the default of the provisioner.
+func (p *csiProvisioner) topologyModeForClass(sc *storagev1.StorageClass) (TopologyMode, error) {
+	mode, ok := sc.Parameters[prefixedTopologyModeKey]
+	if !ok {
+		if p.topologyMode == "" {
+			return TopologyModeRequisite, nil
+		}
+		return p.topologyMode, nil
+	}
+	parsed, err := ParseTopologyMode(mode)
+	if err != nil {
+		return "", fmt.Errorf("storage class parameter %s: %v", prefixedTopologyModeKey, err)
+	}
+	return parsed, nil
+}
+
+// PreferredOnlyAccessibilityRequirements removes the requisite topology.
+// The preferred list starts with the topology of the selected node (if
+// any) or the one chosen for the PVC otherwise, so when maxEntries is
+// positive, the list gets shortened to that many entries without
+// losing the most relevant ones.
+func PreferredOnlyAccessibilityRequirements(requirement *csi.TopologyRequirement, maxEntries int) *csi.TopologyRequirement {
+	if requirement == nil {
+		return nil
+	}
+	preferred := requirement.Preferred
+	if maxEntries > 0 && len(preferred) > maxEntries {
+		preferred = preferred[:maxEntries]
+	}
+	return &csi.TopologyRequirement{
+		Preferred: preferred,
+	}
+}
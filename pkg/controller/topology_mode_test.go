@@ -0,0 +1,116 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+func TestTopologyModeForClass(t *testing.T) {
+	testcases := map[string]struct {
+		defaultMode TopologyMode
+		parameters  map[string]string
+		expectMode  TopologyMode
+		expectErr   bool
+	}{
+		"unset": {
+			expectMode: TopologyModeRequisite,
+		},
+		"provisioner default": {
+			defaultMode: TopologyModePreferredOnly,
+			expectMode:  TopologyModePreferredOnly,
+		},
+		"class override": {
+			defaultMode: TopologyModePreferredOnly,
+			parameters:  map[string]string{prefixedTopologyModeKey: string(TopologyModeRequisite)},
+			expectMode:  TopologyModeRequisite,
+		},
+		"invalid": {
+			parameters: map[string]string{prefixedTopologyModeKey: "preferred"},
+			expectErr:  true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			p := &csiProvisioner{topologyMode: tc.defaultMode}
+			mode, err := p.topologyModeForClass(&storagev1.StorageClass{Parameters: tc.parameters})
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mode != tc.expectMode {
+				t.Errorf("expected mode %q, got %q", tc.expectMode, mode)
+			}
+		})
+	}
+}
+
+func TestPreferredOnlyAccessibilityRequirements(t *testing.T) {
+	topology := func(zones ...string) []*csi.Topology {
+		var result []*csi.Topology
+		for _, zone := range zones {
+			result = append(result, &csi.Topology{Segments: map[string]string{"zone": zone}})
+		}
+		return result
+	}
+
+	testcases := map[string]struct {
+		requirement *csi.TopologyRequirement
+		maxEntries  int
+		expected    *csi.TopologyRequirement
+	}{
+		"nil": {},
+		"no limit": {
+			requirement: &csi.TopologyRequirement{
+				Requisite: topology("a", "b", "c"),
+				Preferred: topology("b", "c", "a"),
+			},
+			expected: &csi.TopologyRequirement{
+				Preferred: topology("b", "c", "a"),
+			},
+		},
+		"truncated": {
+			requirement: &csi.TopologyRequirement{
+				Requisite: topology("a", "b", "c"),
+				Preferred: topology("b", "c", "a"),
+			},
+			maxEntries: 1,
+			expected: &csi.TopologyRequirement{
+				Preferred: topology("b"),
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			actual := PreferredOnlyAccessibilityRequirements(tc.requirement, tc.maxEntries)
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
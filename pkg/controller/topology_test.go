@@ -18,6 +18,7 @@ package controller
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -916,24 +917,50 @@ func TestTopologyAggregation(t *testing.T) {
 				{Segments: map[string]string{"com.example.csi/zone": "zone1"}},
 			},
 		},
-		//"different keys across cluster": {
-		//	nodeLabels: []map[string]string{
-		//		{ "com.example.csi/zone": "zone1" },
-		//		{ "com.example.csi/zone": "zone1", "com.example.csi/rack": "rackA" },
-		//		{ "com.example.csi/zone": "zone1", "com.example.csi/rack": "rackA" },
-		//	},
-		//	topologyKeys: []map[string][]string{
-		//		{ testDriverName: []string{ "com.example.csi/zone" } },
-		//		{ testDriverName: []string{ "com.example.csi/zone", "com.example.csi/rack" } },
-		//		{ testDriverName: []string{ "com.example.csi/zone", "com.example.csi/rack" } },
-		//	},
-		//	expectedRequisite: &csi.TopologyRequirement{
-		//		Requisite: []*csi.Topology{
-		//			{ Segments: map[string]string{ "com.example.csi/zone": "zone1", "com.example.csi/rack": "rackA" } },
-		//		},
-		//	},
-		//	// TODO (verult) mock Rand
-		//},
+		"different keys across cluster": {
+			nodeLabels: []map[string]string{
+				{"com.example.csi/zone": "zone1"},
+				{"com.example.csi/zone": "zone1", "com.example.csi/rack": "rackA"},
+				{"com.example.csi/zone": "zone1", "com.example.csi/rack": "rackA"},
+			},
+			topologyKeys: []map[string][]string{
+				{testDriverName: []string{"com.example.csi/zone"}},
+				{testDriverName: []string{"com.example.csi/zone", "com.example.csi/rack"}},
+				{testDriverName: []string{"com.example.csi/zone", "com.example.csi/rack"}},
+			},
+			expectedRequisite: []*csi.Topology{
+				{Segments: map[string]string{"com.example.csi/zone": "zone1", "com.example.csi/rack": "rackA"}},
+			},
+		},
+		"different keys across cluster, same count": {
+			nodeLabels: []map[string]string{
+				{"com.example.csi/zone": "zone1"},
+				{"com.example.csi/zone": "zone1", "com.example.csi/rack": "rackA"},
+			},
+			topologyKeys: []map[string][]string{
+				{testDriverName: []string{"com.example.csi/zone"}},
+				{testDriverName: []string{"com.example.csi/rack", "com.example.csi/zone"}},
+			},
+			expectedRequisite: []*csi.Topology{
+				{Segments: map[string]string{"com.example.csi/zone": "zone1", "com.example.csi/rack": "rackA"}},
+			},
+		},
+		"different keys across cluster, majority has old keys": {
+			nodeLabels: []map[string]string{
+				{"com.example.csi/zone": "zone1"},
+				{"com.example.csi/zone": "zone2"},
+				{"com.example.csi/zone": "zone1", "com.example.csi/rack": "rackA"},
+			},
+			topologyKeys: []map[string][]string{
+				{testDriverName: []string{"com.example.csi/zone"}},
+				{testDriverName: []string{"com.example.csi/zone"}},
+				{testDriverName: []string{"com.example.csi/zone", "com.example.csi/rack"}},
+			},
+			expectedRequisite: []*csi.Topology{
+				{Segments: map[string]string{"com.example.csi/zone": "zone1"}},
+				{Segments: map[string]string{"com.example.csi/zone": "zone2"}},
+			},
+		},
 		"selected node: different keys across cluster": {
 			hasSelectedNode: true,
 			nodeLabels: []map[string]string{
@@ -1594,6 +1621,138 @@ func volumeNodeAffinitiesEqual(n1, n2 *v1.VolumeNodeAffinity) bool {
 	return match(ns1.NodeSelectorTerms, ns2.NodeSelectorTerms) && match(ns2.NodeSelectorTerms, ns1.NodeSelectorTerms)
 }
 
+func TestDeduplicateIsSorted(t *testing.T) {
+	terms := []topologyTerm{
+		{"zone": "zone3"},
+		{"zone": "zone1"},
+		{"zone": "zone2"},
+		{"zone": "zone1"},
+	}
+	expected := []topologyTerm{
+		{"zone": "zone1"},
+		{"zone": "zone2"},
+		{"zone": "zone3"},
+	}
+	for i := 0; i < 10; i++ {
+		if actual := deduplicate(terms); !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("expected %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestLimitAccessibilityRequirements(t *testing.T) {
+	zones := func(names ...string) []*csi.Topology {
+		var topologies []*csi.Topology
+		for _, name := range names {
+			topologies = append(topologies, &csi.Topology{Segments: map[string]string{"zone": name}})
+		}
+		return topologies
+	}
+
+	testcases := map[string]struct {
+		requirement *csi.TopologyRequirement
+		maxEntries  int
+		strategy    TopologyLimitStrategy
+		pvcName     string
+		expected    *csi.TopologyRequirement
+		expectErr   bool
+	}{
+		"nil": {
+			maxEntries: 1,
+			strategy:   TopologyLimitError,
+		},
+		"no limit": {
+			requirement: &csi.TopologyRequirement{
+				Requisite: zones("a", "b", "c"),
+				Preferred: zones("b", "c", "a"),
+			},
+			strategy: TopologyLimitError,
+			expected: &csi.TopologyRequirement{
+				Requisite: zones("a", "b", "c"),
+				Preferred: zones("b", "c", "a"),
+			},
+		},
+		"within limit": {
+			requirement: &csi.TopologyRequirement{
+				Requisite: zones("a", "b", "c"),
+				Preferred: zones("b", "c", "a"),
+			},
+			maxEntries: 3,
+			strategy:   TopologyLimitError,
+			expected: &csi.TopologyRequirement{
+				Requisite: zones("a", "b", "c"),
+				Preferred: zones("b", "c", "a"),
+			},
+		},
+		"error": {
+			requirement: &csi.TopologyRequirement{
+				Requisite: zones("a", "b", "c"),
+				Preferred: zones("b", "c", "a"),
+			},
+			maxEntries: 2,
+			strategy:   TopologyLimitError,
+			expectErr:  true,
+		},
+		"truncate": {
+			requirement: &csi.TopologyRequirement{
+				Requisite: zones("a", "b", "c"),
+				Preferred: zones("c", "a", "b"),
+			},
+			maxEntries: 2,
+			strategy:   TopologyLimitTruncate,
+			expected: &csi.TopologyRequirement{
+				Requisite: zones("a", "c"),
+				Preferred: zones("c", "a"),
+			},
+		},
+		"sample": {
+			requirement: &csi.TopologyRequirement{
+				Requisite: zones("a", "b", "c", "d", "e", "f", "g"),
+				Preferred: zones("c", "d", "e", "f", "g", "a", "b"),
+			},
+			maxEntries: 3,
+			strategy:   TopologyLimitSample,
+			pvcName:    "pvc-1",
+			// The hash of pvc-1 modulo 6 is 4, so f and b get picked
+			// from the candidates a, b, d, e, f and g.
+			expected: &csi.TopologyRequirement{
+				Requisite: zones("b", "c", "f"),
+				Preferred: zones("c", "f", "b"),
+			},
+		},
+		"sample without preferred": {
+			requirement: &csi.TopologyRequirement{
+				Requisite: zones("a", "b", "c", "d"),
+			},
+			maxEntries: 2,
+			strategy:   TopologyLimitSample,
+			pvcName:    "pvc-1",
+			// The hash of pvc-1 modulo 4 is 0.
+			expected: &csi.TopologyRequirement{
+				Requisite: zones("a", "c"),
+			},
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := LimitAccessibilityRequirements(tc.requirement, tc.maxEntries, tc.strategy, tc.pvcName)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
 func requisiteEqual(t1, t2 []*csi.Topology) bool {
 	// Requisite may contain duplicate topologies
 	unchecked := make(sets.Int)
@@ -1630,7 +1789,10 @@ func listers(kubeClient *fakeclientset.Clientset) (
 	csiNodeLister := factory.Storage().V1().CSINodes().Lister()
 	nodeLister := factory.Core().V1().Nodes().Lister()
 	claimLister := factory.Core().V1().PersistentVolumeClaims().Lister()
-	vaLister := factory.Storage().V1().VolumeAttachments().Lister()
+	vaLister, err := NewIndexedVolumeAttachmentLister(factory.Storage().V1().VolumeAttachments())
+	if err != nil {
+		panic(err)
+	}
 	factory.Start(stopChan)
 	factory.WaitForCacheSync(stopChan)
 	return scLister, csiNodeLister, nodeLister, claimLister, vaLister, stopChan
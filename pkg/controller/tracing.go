@@ -0,0 +1,214 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/otlp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+const (
+	// pvSaveTimeout is how long a saved PV is waited for before its
+	// span gets ended as failed.
+	pvSaveTimeout = 10 * time.Minute
+	// attemptTimeout is how long the end of a failed provisioning
+	// attempt is remembered. Claims may get deleted while
+	// provisioning is retried.
+	attemptTimeout = time.Hour
+	// pruneInterval limits how often the maps get checked for
+	// expired entries.
+	pruneInterval = time.Minute
+)
+
+// tracingProvisioner records a span for each Provision and Delete call.
+// CSI calls and Kubernetes API requests made during those calls become
+// children of that span when the CSI connection and the Kubernetes
+// client are wrapped with otlp.WrapConn and otlp.WrapTransport.
+//
+// The provisioner library saves new PVs asynchronously without a
+// context. Saving is therefore traced from the end of Provision until
+// the PV shows up in the informer.
+type tracingProvisioner struct {
+	controller.Provisioner
+	tracer *otlp.Tracer
+	now    func() time.Time
+
+	mutex       sync.Mutex
+	lastAttempt map[types.UID]time.Time
+	saving      map[string]pendingSave
+	lastPrune   time.Time
+}
+
+type pendingSave struct {
+	span  *otlp.Span
+	since time.Time
+}
+
+var _ controller.Provisioner = &tracingProvisioner{}
+var _ controller.BlockProvisioner = &tracingProvisioner{}
+var _ controller.Qualifier = &tracingProvisioner{}
+var _ controller.DeletionGuard = &tracingProvisioner{}
+
+// NewTracingProvisioner wraps the provisioner such that provisioning and
+// deleting gets traced. The PV informer is used to detect when new PVs
+// were saved.
+func NewTracingProvisioner(p controller.Provisioner, tracer *otlp.Tracer, pvInformer cache.SharedInformer) controller.Provisioner {
+	t := &tracingProvisioner{
+		Provisioner: p,
+		tracer:      tracer,
+		now:         time.Now,
+		lastAttempt: map[types.UID]time.Time{},
+		saving:      map[string]pendingSave{},
+	}
+	pvInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: t.volumeAdded,
+	})
+	return t
+}
+
+func (p *tracingProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	claim := options.PVC
+	ctx, span := p.tracer.Start(ctx, "Provision")
+	defer span.End()
+	span.SetAttribute("k8s.namespace.name", claim.Namespace)
+	span.SetAttribute("k8s.pvc.name", claim.Name)
+	if options.StorageClass != nil {
+		span.SetAttribute("k8s.storageclass.name", options.StorageClass.Name)
+	}
+	if options.SelectedNode != nil {
+		span.SetAttribute("k8s.node.name", options.SelectedNode.Name)
+	}
+
+	// The time since the claim was created or, for retries, since
+	// the previous attempt. This covers waiting in the work queue
+	// and the backoff after failures.
+	p.mutex.Lock()
+	waitStart, ok := p.lastAttempt[claim.UID]
+	p.mutex.Unlock()
+	if !ok {
+		waitStart = claim.CreationTimestamp.Time
+	}
+	_, wait := otlp.StartAt(ctx, "wait", waitStart)
+	wait.End()
+
+	pv, state, err := p.Provisioner.Provision(ctx, options)
+	span.SetAttribute("provisioning.state", string(state))
+	recordError(span, err)
+
+	now := p.now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err == nil || state == controller.ProvisioningFinished {
+		delete(p.lastAttempt, claim.UID)
+	} else {
+		p.lastAttempt[claim.UID] = now
+	}
+	if err == nil && pv != nil {
+		span.SetAttribute("k8s.pv.name", pv.Name)
+		_, save := otlp.Start(ctx, "save PersistentVolume")
+		p.saving[pv.Name] = pendingSave{span: save, since: now}
+	}
+	p.prune(now)
+	return pv, state, err
+}
+
+func (p *tracingProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume) error {
+	ctx, span := p.tracer.Start(ctx, "Delete")
+	defer span.End()
+	span.SetAttribute("k8s.pv.name", volume.Name)
+	if volume.Spec.CSI != nil {
+		span.SetAttribute("csi.volume.handle", volume.Spec.CSI.VolumeHandle)
+	}
+	err := p.Provisioner.Delete(ctx, volume)
+	recordError(span, err)
+	return err
+}
+
+// recordError does not treat IgnoredError as failure because it only
+// means that some other instance is responsible.
+func recordError(span *otlp.Span, err error) {
+	var ignored *controller.IgnoredError
+	if errors.As(err, &ignored) {
+		span.SetAttribute("provisioning.ignored", ignored.Reason)
+		return
+	}
+	span.SetError(err)
+}
+
+func (p *tracingProvisioner) volumeAdded(obj interface{}) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		return
+	}
+	p.mutex.Lock()
+	save, ok := p.saving[pv.Name]
+	delete(p.saving, pv.Name)
+	p.mutex.Unlock()
+	if ok {
+		save.span.End()
+	}
+}
+
+// prune must be called while holding the mutex.
+func (p *tracingProvisioner) prune(now time.Time) {
+	if now.Sub(p.lastPrune) < pruneInterval {
+		return
+	}
+	p.lastPrune = now
+	for uid, last := range p.lastAttempt {
+		if now.Sub(last) > attemptTimeout {
+			delete(p.lastAttempt, uid)
+		}
+	}
+	for name, save := range p.saving {
+		if now.Sub(save.since) > pvSaveTimeout {
+			save.span.SetError(fmt.Errorf("PersistentVolume %s not observed after %s", name, pvSaveTimeout))
+			save.span.End()
+			delete(p.saving, name)
+		}
+	}
+}
+
+func (p *tracingProvisioner) SupportsBlock(ctx context.Context) bool {
+	if blockProvisioner, ok := p.Provisioner.(controller.BlockProvisioner); ok {
+		return blockProvisioner.SupportsBlock(ctx)
+	}
+	return false
+}
+
+func (p *tracingProvisioner) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	if qualifier, ok := p.Provisioner.(controller.Qualifier); ok {
+		return qualifier.ShouldProvision(ctx, claim)
+	}
+	return true
+}
+
+func (p *tracingProvisioner) ShouldDelete(ctx context.Context, volume *v1.PersistentVolume) bool {
+	if deletionGuard, ok := p.Provisioner.(controller.DeletionGuard); ok {
+		return deletionGuard.ShouldDelete(ctx, volume)
+	}
+	return true
+}
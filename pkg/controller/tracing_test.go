@@ -0,0 +1,187 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/otlp"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+// testSpan is the part of the OTLP JSON encoding that gets checked.
+type testSpan struct {
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+func (s testSpan) attribute(key string) string {
+	for _, attribute := range s.Attributes {
+		if attribute.Key == key {
+			return attribute.Value.StringValue
+		}
+	}
+	return ""
+}
+
+type tracedProvisioner struct {
+	pv  *v1.PersistentVolume
+	err error
+}
+
+func (p *tracedProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	_, span := otlp.Start(ctx, "CreateVolume")
+	span.End()
+	if p.err != nil {
+		return nil, controller.ProvisioningInBackground, p.err
+	}
+	return p.pv, controller.ProvisioningFinished, nil
+}
+
+func (p *tracedProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	return p.err
+}
+
+func TestTracingProvisioner(t *testing.T) {
+	var mutex sync.Mutex
+	spans := map[string][]testSpan{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []testSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				for _, span := range scopeSpans.Spans {
+					spans[span.Name] = append(spans[span.Name], span)
+				}
+			}
+		}
+	}))
+	defer server.Close()
+	tracer := otlp.NewTracer(server.URL, time.Minute, 1, nil)
+	export := func(name string) []testSpan {
+		if err := tracer.Export(context.Background()); err != nil {
+			t.Fatalf("export: %v", err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		return spans[name]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fakeclientset.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	inner := &tracedProvisioner{err: errors.New("timeout")}
+	p := NewTracingProvisioner(inner, tracer, factory.Core().V1().PersistentVolumes().Informer())
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "pvc-1",
+			UID:               "claim-uid",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Minute)),
+		},
+	}
+	options := controller.ProvisionOptions{PVC: claim, StorageClass: &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast"}}}
+
+	// A failed attempt.
+	if _, _, err := p.Provision(ctx, options); err == nil {
+		t.Fatal("expected error, got none")
+	}
+	provision := export("Provision")
+	if len(provision) != 1 || provision[0].Status.Code == 0 || provision[0].attribute("k8s.pvc.name") != "pvc-1" {
+		t.Fatalf("unexpected Provision spans: %+v", provision)
+	}
+	if create := export("CreateVolume"); len(create) != 1 || create[0].ParentSpanID != provision[0].SpanID {
+		t.Fatalf("unexpected CreateVolume spans: %+v", create)
+	}
+	if waits := export("wait"); len(waits) != 1 || waits[0].ParentSpanID != provision[0].SpanID {
+		t.Fatalf("unexpected wait spans: %+v", waits)
+	}
+
+	// The retry succeeds.
+	inner.err = nil
+	inner.pv = &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}}
+	if _, _, err := p.Provision(ctx, options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	provision = export("Provision")
+	if len(provision) != 2 || provision[1].Status.Code != 0 || provision[1].attribute("k8s.pv.name") != "pv-1" {
+		t.Fatalf("unexpected Provision spans: %+v", provision)
+	}
+	if save := export("save PersistentVolume"); len(save) != 0 {
+		t.Fatalf("PV not saved yet, got spans %+v", save)
+	}
+	if _, err := client.CoreV1().PersistentVolumes().Create(ctx, inner.pv, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create PV: %v", err)
+	}
+	var save []testSpan
+	if err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		save = export("save PersistentVolume")
+		return len(save) > 0, nil
+	}); err != nil {
+		t.Fatalf("no span for saving the PV: %v", err)
+	}
+	if save[0].ParentSpanID != provision[1].SpanID {
+		t.Errorf("unexpected parent of save span: %+v", save[0])
+	}
+
+	// Ignored errors are not failures.
+	inner.err = &controller.IgnoredError{Reason: "not responsible"}
+	if err := p.Delete(ctx, inner.pv); err == nil {
+		t.Fatal("expected error, got none")
+	}
+	deleteSpans := export("Delete")
+	if len(deleteSpans) != 1 || deleteSpans[0].Status.Code != 0 || deleteSpans[0].attribute("provisioning.ignored") != "not responsible" {
+		t.Errorf("unexpected Delete spans: %+v", deleteSpans)
+	}
+}
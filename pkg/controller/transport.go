@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// tcpPrefix marks CSI endpoints which are reached over the network
+// instead of a Unix domain socket.
+const tcpPrefix = "tcp://"
+
+// TLSConfig secures connections to tcp:// CSI endpoints. Without any
+// files, such connections are not encrypted. It has no effect on Unix
+// domain sockets.
+type TLSConfig struct {
+	// CAFile contains the certificates which are used to verify
+	// the driver. The system certificates are used when empty.
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key which
+	// are presented to the driver for mutual TLS.
+	CertFile, KeyFile string
+	// ServerName overrides the name that is expected in the
+	// certificate of the driver, which is the host in the
+	// endpoint by default.
+	ServerName string
+}
+
+// Enabled is true when at least one setting was provided.
+func (c *TLSConfig) Enabled() bool {
+	return c != nil && (c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.ServerName != "")
+}
+
+// IsNetworkEndpoint is true for CSI endpoints which are reached over TCP.
+func IsNetworkEndpoint(address string) bool {
+	return strings.HasPrefix(address, tcpPrefix)
+}
+
+// transportCredentials loads the files. It returns nil when TLS is
+// not enabled.
+func (c *TLSConfig) transportCredentials() (credentials.TransportCredentials, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be provided together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// transportOptions returns the target for grpc.Dial and the dial
+// option for the transport of the endpoint.
+func transportOptions(address string, tlsConfig *TLSConfig) (string, grpc.DialOption, error) {
+	if strings.HasPrefix(address, "/") {
+		// It looks like filesystem path.
+		return "unix://" + address, grpc.WithInsecure(), nil
+	}
+	if !IsNetworkEndpoint(address) {
+		return address, grpc.WithInsecure(), nil
+	}
+	target := strings.TrimPrefix(address, tcpPrefix)
+	creds, err := tlsConfig.transportCredentials()
+	if err != nil {
+		return "", nil, fmt.Errorf("TLS for %s: %v", address, err)
+	}
+	if creds == nil {
+		return target, grpc.WithInsecure(), nil
+	}
+	return target, grpc.WithTransportCredentials(creds), nil
+}
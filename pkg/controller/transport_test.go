@@ -0,0 +1,245 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCertificate creates a certificate for 127.0.0.1 which is
+// signed by the parent or, without parent, is a self-signed CA.
+func newTestCertificate(t *testing.T, dir, name string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{name},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signerCert, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCertificate{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	if err := ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestTransportOptions(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, dir, "ca", nil)
+
+	testcases := map[string]struct {
+		address      string
+		tlsConfig    *TLSConfig
+		expectTarget string
+		expectErr    bool
+	}{
+		"path": {
+			address:      "/run/csi/socket",
+			tlsConfig:    &TLSConfig{CAFile: ca.certFile},
+			expectTarget: "unix:///run/csi/socket",
+		},
+		"unix": {
+			address:      "unix:///run/csi/socket",
+			expectTarget: "unix:///run/csi/socket",
+		},
+		"tcp": {
+			address:      "tcp://csi-driver:10000",
+			expectTarget: "csi-driver:10000",
+		},
+		"tcp with TLS": {
+			address:      "tcp://csi-driver:10000",
+			tlsConfig:    &TLSConfig{CAFile: ca.certFile},
+			expectTarget: "csi-driver:10000",
+		},
+		"missing CA file": {
+			address:   "tcp://csi-driver:10000",
+			tlsConfig: &TLSConfig{CAFile: filepath.Join(dir, "no-such-file")},
+			expectErr: true,
+		},
+		"invalid CA file": {
+			address:   "tcp://csi-driver:10000",
+			tlsConfig: &TLSConfig{CAFile: ca.keyFile},
+			expectErr: true,
+		},
+		"certificate without key": {
+			address:   "tcp://csi-driver:10000",
+			tlsConfig: &TLSConfig{CertFile: ca.certFile},
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			target, _, err := transportOptions(tc.address, tc.tlsConfig)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target != tc.expectTarget {
+				t.Errorf("expected target %q, got %q", tc.expectTarget, target)
+			}
+		})
+	}
+}
+
+func TestConnectTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, dir, "ca", nil)
+	server := newTestCertificate(t, dir, "csi-driver", ca)
+	client := newTestCertificate(t, dir, "csi-provisioner", ca)
+	otherCA := newTestCertificate(t, dir, "other-ca", nil)
+	otherClient := newTestCertificate(t, dir, "other-client", otherCA)
+
+	serverCert, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	driver := &failoverTestDriver{name: driverName}
+	csi.RegisterIdentityServer(grpcServer, driver)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	endpoint := "tcp://" + listener.Addr().String()
+
+	testcases := map[string]struct {
+		tlsConfig *TLSConfig
+		expectErr bool
+	}{
+		"mutual TLS": {
+			tlsConfig: &TLSConfig{CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile},
+		},
+		"server name": {
+			tlsConfig: &TLSConfig{CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile, ServerName: "csi-driver"},
+		},
+		"wrong server name": {
+			tlsConfig: &TLSConfig{CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile, ServerName: "some-other-driver"},
+			expectErr: true,
+		},
+		"unknown server": {
+			tlsConfig: &TLSConfig{CAFile: otherCA.certFile, CertFile: client.certFile, KeyFile: client.keyFile},
+			expectErr: true,
+		},
+		"unknown client": {
+			tlsConfig: &TLSConfig{CAFile: ca.certFile, CertFile: otherClient.certFile, KeyFile: otherClient.keyFile},
+			expectErr: true,
+		},
+		"no client certificate": {
+			tlsConfig: &TLSConfig{CAFile: ca.certFile},
+			expectErr: true,
+		},
+		"insecure": {
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			// The handshake may fail during dialing or, with TLS 1.3,
+			// only when the server rejects the client certificate.
+			conn, err := dial(ctx, endpoint, metrics.NewCSIMetricsManager(""), tc.tlsConfig)
+			if err == nil {
+				defer conn.Close()
+				_, err = GetDriverName(conn, time.Second)
+			}
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
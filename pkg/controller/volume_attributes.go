@@ -0,0 +1,211 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/external-provisioner/pkg/panicguard"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// CSIVolumeClient is the relevant subset of csi.ControllerClient.
+type CSIVolumeClient interface {
+	ControllerGetVolume(ctx context.Context, in *csi.ControllerGetVolumeRequest, opts ...grpc.CallOption) (*csi.ControllerGetVolumeResponse, error)
+}
+
+// VolumeAttributesController periodically compares the volume attributes
+// of PVs with the volume context that the driver reports for the
+// volume and updates the PV when they differ, for example after the
+// storage backend migrated the volume.
+type VolumeAttributesController struct {
+	csiClient     CSIVolumeClient
+	driverName    string
+	client        kubernetes.Interface
+	pvLister      corelisters.PersistentVolumeLister
+	queue         workqueue.RateLimitingInterface
+	period        time.Duration
+	timeout       time.Duration
+	eventRecorder record.EventRecorder
+}
+
+// NewVolumeAttributesController creates a controller which checks all
+// PVs of the driver once per period. The driver must support
+// GET_VOLUME.
+func NewVolumeAttributesController(
+	csiClient CSIVolumeClient,
+	driverName string,
+	client kubernetes.Interface,
+	pvLister corelisters.PersistentVolumeLister,
+	queue workqueue.RateLimitingInterface,
+	period time.Duration,
+	timeout time.Duration,
+) *VolumeAttributesController {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.Infof)
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "external-provisioner"})
+
+	return &VolumeAttributesController{
+		csiClient:     csiClient,
+		driverName:    driverName,
+		client:        client,
+		pvLister:      pvLister,
+		queue:         queue,
+		period:        period,
+		timeout:       timeout,
+		eventRecorder: eventRecorder,
+	}
+}
+
+// Run is the main VolumeAttributesController handler.
+func (c *VolumeAttributesController) Run(ctx context.Context) {
+	klog.Info("Starting VolumeAttributes controller")
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	go wait.UntilWithContext(ctx, c.enqueueAll, c.period)
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+
+	klog.Info("Started VolumeAttributes controller")
+	<-ctx.Done()
+	klog.Info("Shutting down VolumeAttributes controller")
+}
+
+func (c *VolumeAttributesController) enqueueAll(ctx context.Context) {
+	pvs, err := c.pvLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, pv := range pvs {
+		if c.isOurs(pv) {
+			c.queue.Add(pv.Name)
+		}
+	}
+}
+
+func (c *VolumeAttributesController) isOurs(pv *v1.PersistentVolume) bool {
+	return pv.Spec.CSI != nil && pv.Spec.CSI.Driver == c.driverName && pv.DeletionTimestamp == nil
+}
+
+func (c *VolumeAttributesController) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
+	}
+}
+
+func (c *VolumeAttributesController) processNextWorkItem(ctx context.Context) (more bool) {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	more = true
+	defer c.queue.Done(obj)
+	defer panicguard.Recover(c.queue, "volumeattributes", obj)
+
+	name, ok := obj.(string)
+	if !ok {
+		c.queue.Forget(obj)
+		utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+	if err := c.syncVolume(ctx, name); err != nil {
+		klog.Warningf("Retrying refreshing volume attributes of PV %q after %v failures: %v", name, c.queue.NumRequeues(obj), err)
+		c.queue.AddRateLimited(obj)
+	} else {
+		c.queue.Forget(obj)
+	}
+	return true
+}
+
+func (c *VolumeAttributesController) syncVolume(ctx context.Context, name string) error {
+	pv, err := c.pvLister.Get(name)
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !c.isOurs(pv) {
+		return nil
+	}
+
+	rpcCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := c.csiClient.ControllerGetVolume(rpcCtx, &csi.ControllerGetVolumeRequest{VolumeId: pv.Spec.CSI.VolumeHandle})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			// Either the volume is gone or it is not
+			// accessible through this driver instance.
+			klog.V(5).Infof("Volume %s of PV %q not found by driver, not refreshing its attributes", pv.Spec.CSI.VolumeHandle, name)
+			return nil
+		}
+		return fmt.Errorf("ControllerGetVolume: %v", err)
+	}
+	reported := resp.GetVolume().GetVolumeContext()
+	if len(reported) == 0 {
+		// The volume context is optional in the response. Not
+		// reporting it doesn't mean that it is empty.
+		return nil
+	}
+
+	attributes := refreshedVolumeAttributes(pv.Spec.CSI.VolumeAttributes, reported)
+	if reflect.DeepEqual(attributes, pv.Spec.CSI.VolumeAttributes) {
+		return nil
+	}
+	klog.V(3).Infof("Volume attributes of PV %q changed from %v to %v", name, pv.Spec.CSI.VolumeAttributes, attributes)
+	pv = pv.DeepCopy()
+	pv.Spec.CSI.VolumeAttributes = attributes
+	if _, err := c.client.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("update PV: %v", err)
+	}
+	c.eventRecorder.Event(pv, v1.EventTypeNormal, "VolumeAttributesUpdated", "Volume attributes updated with the volume context reported by the CSI driver")
+	return nil
+}
+
+// refreshedVolumeAttributes returns the volume context reported by the
+// driver plus the attributes that were added by the external-provisioner
+// itself.
+func refreshedVolumeAttributes(current, reported map[string]string) map[string]string {
+	attributes := make(map[string]string, len(reported)+1)
+	for key, value := range reported {
+		attributes[key] = value
+	}
+	if identity, ok := current[provisionerIDKey]; ok {
+		attributes[provisionerIDKey] = identity
+	}
+	return attributes
+}
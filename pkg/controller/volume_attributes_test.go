@@ -0,0 +1,146 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+type fakeVolumeClient struct {
+	volumeContext map[string]string
+	err           error
+}
+
+func (f *fakeVolumeClient) ControllerGetVolume(ctx context.Context, in *csi.ControllerGetVolumeRequest, opts ...grpc.CallOption) (*csi.ControllerGetVolumeResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      in.VolumeId,
+			VolumeContext: f.volumeContext,
+		},
+	}, nil
+}
+
+func TestVolumeAttributesController(t *testing.T) {
+	const pvName = "test-pv"
+	current := map[string]string{
+		provisionerIDKey: "test-identity",
+		"server":         "old",
+	}
+
+	testcases := map[string]struct {
+		driver             string
+		volumeContext      map[string]string
+		err                error
+		expectErr          bool
+		expectedAttributes map[string]string
+	}{
+		"unchanged": {
+			volumeContext:      map[string]string{"server": "old"},
+			expectedAttributes: current,
+		},
+		"changed": {
+			volumeContext: map[string]string{"server": "new", "pool": "a"},
+			expectedAttributes: map[string]string{
+				provisionerIDKey: "test-identity",
+				"server":         "new",
+				"pool":           "a",
+			},
+		},
+		"no context": {
+			expectedAttributes: current,
+		},
+		"other driver": {
+			driver:             "other-driver",
+			volumeContext:      map[string]string{"server": "new"},
+			expectedAttributes: current,
+		},
+		"not found": {
+			err:                status.Error(codes.NotFound, "no such volume"),
+			expectedAttributes: current,
+		},
+		"failure": {
+			err:                status.Error(codes.Unavailable, "try again"),
+			expectErr:          true,
+			expectedAttributes: current,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			driver := tc.driver
+			if driver == "" {
+				driver = driverName
+			}
+			pv := &v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: pvName},
+				Spec: v1.PersistentVolumeSpec{
+					PersistentVolumeSource: v1.PersistentVolumeSource{
+						CSI: &v1.CSIPersistentVolumeSource{
+							Driver:           driver,
+							VolumeHandle:     "test-volume",
+							VolumeAttributes: current,
+						},
+					},
+				},
+			}
+			client := fakeclientset.NewSimpleClientset(pv)
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			indexer.Add(pv)
+			c := &VolumeAttributesController{
+				csiClient:     &fakeVolumeClient{volumeContext: tc.volumeContext, err: tc.err},
+				driverName:    driverName,
+				client:        client,
+				pvLister:      corelisters.NewPersistentVolumeLister(indexer),
+				timeout:       time.Second,
+				eventRecorder: record.NewFakeRecorder(10),
+			}
+
+			ctx := context.Background()
+			err := c.syncVolume(ctx, pvName)
+			if tc.expectErr && err == nil {
+				t.Error("expected error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			pv, err = client.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(pv.Spec.CSI.VolumeAttributes, tc.expectedAttributes) {
+				t.Errorf("expected attributes %v, got %v", tc.expectedAttributes, pv.Spec.CSI.VolumeAttributes)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	snapapi "github.com/kubernetes-csi/external-snapshotter/client/v3/apis/volumesnapshot/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// annAllowVolumeModeChange on a VolumeSnapshotContent permits restoring
+// the snapshot with a different volume mode than the one of the volume
+// it was taken from, for drivers which support that conversion. Newer
+// releases of the snapshot controller use the same annotation.
+const annAllowVolumeModeChange = "snapshot.storage.kubernetes.io/allow-volume-mode-change"
+
+// volumeModeOrDefault returns Filesystem for unset modes.
+func volumeModeOrDefault(mode *v1.PersistentVolumeMode) v1.PersistentVolumeMode {
+	if mode == nil {
+		return v1.PersistentVolumeFilesystem
+	}
+	return *mode
+}
+
+// checkVolumeMode returns a permanentError when the claim requests
+// a different volume mode than the one of its source. CSI drivers
+// cannot turn a filesystem into a block device or the other way around,
+// so CreateVolume would fail with some driver-specific error or produce
+// a volume that cannot be used as intended.
+func checkVolumeMode(claim *v1.PersistentVolumeClaim, sourceMode *v1.PersistentVolumeMode, source string) error {
+	claimMode := volumeModeOrDefault(claim.Spec.VolumeMode)
+	srcMode := volumeModeOrDefault(sourceMode)
+	if claimMode == srcMode {
+		return nil
+	}
+	return &permanentError{
+		reason: "VolumeModeMismatch",
+		message: fmt.Sprintf("%s has volume mode %s, but PVC %s/%s requests volume mode %s, the volume mode cannot be converted",
+			source, srcMode, claim.Namespace, claim.Name, claimMode),
+	}
+}
+
+// snapshotSourceVolumeMode determines the volume mode of the volume from
+// which a snapshot was taken. The PVC named in the snapshot must still
+// be bound to a PV with the volume handle recorded in the content,
+// otherwise the mode is unknown and nil is returned.
+func (p *csiProvisioner) snapshotSourceVolumeMode(ctx context.Context, snapshot *snapapi.VolumeSnapshot, content *snapapi.VolumeSnapshotContent) *v1.PersistentVolumeMode {
+	if p.claimLister == nil ||
+		snapshot.Spec.Source.PersistentVolumeClaimName == nil ||
+		content.Spec.Source.VolumeHandle == nil {
+		return nil
+	}
+	claim, err := p.claimLister.PersistentVolumeClaims(snapshot.Namespace).Get(*snapshot.Spec.Source.PersistentVolumeClaimName)
+	if err != nil || claim.Spec.VolumeName == "" {
+		return nil
+	}
+	pv, err := p.client.CoreV1().PersistentVolumes().Get(ctx, claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		klog.V(4).Infof("cannot determine volume mode of the source of snapshot %s/%s: %v", snapshot.Namespace, snapshot.Name, err)
+		return nil
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.VolumeHandle != *content.Spec.Source.VolumeHandle {
+		// The PVC was re-created after taking the snapshot.
+		return nil
+	}
+	mode := volumeModeOrDefault(pv.Spec.VolumeMode)
+	return &mode
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	snapapi "github.com/kubernetes-csi/external-snapshotter/client/v3/apis/volumesnapshot/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCheckVolumeMode(t *testing.T) {
+	block := v1.PersistentVolumeBlock
+	filesystem := v1.PersistentVolumeFilesystem
+	testcases := map[string]struct {
+		claimMode, sourceMode *v1.PersistentVolumeMode
+		expectError           bool
+	}{
+		"both unset":                {},
+		"unset and filesystem":      {sourceMode: &filesystem},
+		"block":                     {claimMode: &block, sourceMode: &block},
+		"block from filesystem":     {claimMode: &block, sourceMode: &filesystem, expectError: true},
+		"block from unset":          {claimMode: &block, expectError: true},
+		"filesystem from block":     {claimMode: &filesystem, sourceMode: &block, expectError: true},
+		"unset from block":          {sourceMode: &block, expectError: true},
+		"filesystem and unset":      {claimMode: &filesystem},
+		"filesystem and filesystem": {claimMode: &filesystem, sourceMode: &filesystem},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			claim := &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default"},
+				Spec:       v1.PersistentVolumeClaimSpec{VolumeMode: tc.claimMode},
+			}
+			err := checkVolumeMode(claim, tc.sourceMode, "source PVC default/source")
+			if !tc.expectError {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var permanentErr *permanentError
+			if !errors.As(err, &permanentErr) {
+				t.Fatalf("expected permanentError, got %v", err)
+			}
+			if permanentErr.reason != "VolumeModeMismatch" {
+				t.Errorf("expected reason VolumeModeMismatch, got %s", permanentErr.reason)
+			}
+		})
+	}
+}
+
+func TestSnapshotSourceVolumeMode(t *testing.T) {
+	block := v1.PersistentVolumeBlock
+	claimName := "source"
+	handle := "volume-handle"
+	otherHandle := "other-handle"
+	sourceClaim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: claimName, Namespace: "default"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "source-pv"},
+	}
+	sourcePV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "source-pv"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{VolumeHandle: handle},
+			},
+			VolumeMode: &block,
+		},
+	}
+	snapshot := &snapapi.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "snapshot", Namespace: "default"},
+		Spec: snapapi.VolumeSnapshotSpec{
+			Source: snapapi.VolumeSnapshotSource{PersistentVolumeClaimName: &claimName},
+		},
+	}
+	content := func(handle *string) *snapapi.VolumeSnapshotContent {
+		return &snapapi.VolumeSnapshotContent{
+			Spec: snapapi.VolumeSnapshotContentSpec{
+				Source: snapapi.VolumeSnapshotContentSource{VolumeHandle: handle},
+			},
+		}
+	}
+
+	testcases := map[string]struct {
+		claims     []*v1.PersistentVolumeClaim
+		pvs        []runtime.Object
+		content    *snapapi.VolumeSnapshotContent
+		expectMode *v1.PersistentVolumeMode
+	}{
+		"known": {
+			claims:     []*v1.PersistentVolumeClaim{sourceClaim},
+			pvs:        []runtime.Object{sourcePV},
+			content:    content(&handle),
+			expectMode: &block,
+		},
+		"pre-provisioned snapshot": {
+			claims:  []*v1.PersistentVolumeClaim{sourceClaim},
+			pvs:     []runtime.Object{sourcePV},
+			content: content(nil),
+		},
+		"PVC gone": {
+			pvs:     []runtime.Object{sourcePV},
+			content: content(&handle),
+		},
+		"PV gone": {
+			claims:  []*v1.PersistentVolumeClaim{sourceClaim},
+			content: content(&handle),
+		},
+		"PVC re-created": {
+			claims:  []*v1.PersistentVolumeClaim{sourceClaim},
+			pvs:     []runtime.Object{sourcePV},
+			content: content(&otherHandle),
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, claim := range tc.claims {
+				if err := indexer.Add(claim); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p := &csiProvisioner{
+				client:      fake.NewSimpleClientset(tc.pvs...),
+				claimLister: corelisters.NewPersistentVolumeClaimLister(indexer),
+			}
+			mode := p.snapshotSourceVolumeMode(context.Background(), snapshot, tc.content)
+			switch {
+			case tc.expectMode == nil && mode != nil:
+				t.Errorf("expected unknown mode, got %s", *mode)
+			case tc.expectMode != nil && mode == nil:
+				t.Errorf("expected mode %s, got unknown mode", *tc.expectMode)
+			case tc.expectMode != nil && *mode != *tc.expectMode:
+				t.Errorf("expected mode %s, got %s", *tc.expectMode, *mode)
+			}
+		})
+	}
+}
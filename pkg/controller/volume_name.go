@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"text/template"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+var volumeNameCollisions = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Name:           "persistentvolume_name_collisions_total",
+		Help:           "Number of times that the generated name for a new PV was already used by a PV of a different claim.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(volumeNameCollisions)
+}
+
+// VolumeNameParameters are the values that a volume name template can
+// use, for example "{{.PVCNamespace}}-{{.PVCName}}".
+type VolumeNameParameters struct {
+	PVCName          string
+	PVCNamespace     string
+	PVCUID           string
+	StorageClassName string
+}
+
+// ParseVolumeNameTemplate parses a template for the names of new PVs,
+// which are also used as names in CreateVolume. Unknown fields and
+// names that cannot be valid, for example because of upper case
+// characters, are reported as error.
+func ParseVolumeNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("volume name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	example := VolumeNameParameters{
+		PVCName:          "pvc",
+		PVCNamespace:     "default",
+		PVCUID:           "00000000-0000-0000-0000-000000000000",
+		StorageClassName: "standard",
+	}
+	if _, err := executeVolumeNameTemplate(tmpl, example); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func executeVolumeNameTemplate(tmpl *template.Template, parameters VolumeNameParameters) (string, error) {
+	var name bytes.Buffer
+	if err := tmpl.Execute(&name, parameters); err != nil {
+		return "", err
+	}
+	if errs := validation.IsDNS1123Subdomain(name.String()); len(errs) > 0 {
+		return "", fmt.Errorf("generated volume name %q is invalid: %s", name.String(), strings.Join(errs, ", "))
+	}
+	return name.String(), nil
+}
+
+// volumeName generates the name for a new PV, either with the template
+// or from the prefix and the claim UID.
+func (p *csiProvisioner) volumeName(claim *v1.PersistentVolumeClaim, sc *storagev1.StorageClass) (string, error) {
+	if p.volumeNameTemplate == nil {
+		return makeVolumeName(p.volumeNamePrefix, string(claim.UID), p.volumeNameUUIDLength)
+	}
+	if len(claim.UID) == 0 {
+		return "", fmt.Errorf("corrupted PVC object, it is missing UID")
+	}
+	return executeVolumeNameTemplate(p.volumeNameTemplate, VolumeNameParameters{
+		PVCName:          claim.Name,
+		PVCNamespace:     claim.Namespace,
+		PVCUID:           string(claim.UID),
+		StorageClassName: sc.Name,
+	})
+}
+
+// avoidVolumeNameCollision returns the generated name unless it is
+// used by a PV of some other claim. The provisioner library would
+// otherwise treat the existing PV as successfully created and the claim
+// would never get bound. In that case, the alternative name from
+// alternativeVolumeName is returned, so retries call CreateVolume with
+// the same name.
+//
+// Collisions can only happen when the claim UID gets truncated or a
+// template is used, so the check is skipped otherwise.
+func (p *csiProvisioner) avoidVolumeNameCollision(ctx context.Context, claim *v1.PersistentVolumeClaim, pvName string) (string, error) {
+	if p.volumeNameUUIDLength == -1 && p.volumeNameTemplate == nil {
+		return pvName, nil
+	}
+	available, err := p.volumeNameAvailable(ctx, claim, pvName)
+	if err != nil || available {
+		return pvName, err
+	}
+	volumeNameCollisions.Inc()
+	alternative := alternativeVolumeName(pvName, claim.UID)
+	if errs := validation.IsDNS1123Subdomain(alternative); len(errs) > 0 {
+		return "", fmt.Errorf("PV name %s is in use by another claim and alternative name %q is invalid: %s", pvName, alternative, strings.Join(errs, ", "))
+	}
+	klog.Warningf("PV name %s for claim %s/%s is already in use, using %s instead", pvName, claim.Namespace, claim.Name, alternative)
+	available, err = p.volumeNameAvailable(ctx, claim, alternative)
+	if err != nil {
+		return "", err
+	}
+	if !available {
+		return "", fmt.Errorf("PV names %s and %s are both in use by other claims", pvName, alternative)
+	}
+	return alternative, nil
+}
+
+// alternativeVolumeName appends a hash of the full claim UID to the
+// generated name. It is always the same for a claim.
+func alternativeVolumeName(pvName string, uid types.UID) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(uid))
+	return fmt.Sprintf("%s-%08x", pvName, hash.Sum32())
+}
+
+// volumeNameAvailable returns true if there is no PV with the name or
+// the PV was created for the claim by an earlier attempt. PVs are
+// looked up in the PV lister if there is one, otherwise with the API
+// server.
+func (p *csiProvisioner) volumeNameAvailable(ctx context.Context, claim *v1.PersistentVolumeClaim, name string) (bool, error) {
+	var pv *v1.PersistentVolume
+	var err error
+	if p.pvLister != nil {
+		pv, err = p.pvLister.Get(name)
+	} else {
+		pv, err = p.client.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+	}
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("check whether PV %s exists: %v", name, err)
+	}
+	return pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.UID == claim.UID, nil
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"text/template"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestAvoidVolumeNameCollision(t *testing.T) {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "claim",
+			Namespace: "default",
+			UID:       "uid-1",
+		},
+	}
+	pvForClaim := func(name string, uid types.UID) *v1.PersistentVolume {
+		pv := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+		}
+		if uid != "" {
+			pv.Spec.ClaimRef = &v1.ObjectReference{UID: uid}
+		}
+		return pv
+	}
+	alternative := alternativeVolumeName("pvc-1234", "uid-1")
+
+	testcases := map[string]struct {
+		uuidLength  int
+		template    string
+		pvs         []runtime.Object
+		expectName  string
+		expectError bool
+	}{
+		"no PV": {
+			uuidLength: 4,
+			expectName: "pvc-1234",
+		},
+		"PV of same claim": {
+			uuidLength: 4,
+			pvs:        []runtime.Object{pvForClaim("pvc-1234", "uid-1")},
+			expectName: "pvc-1234",
+		},
+		"PV of other claim": {
+			uuidLength: 4,
+			pvs:        []runtime.Object{pvForClaim("pvc-1234", "other")},
+			expectName: alternative,
+		},
+		"unbound PV": {
+			uuidLength: 4,
+			pvs:        []runtime.Object{pvForClaim("pvc-1234", "")},
+			expectName: alternative,
+		},
+		"alternative name of same claim": {
+			uuidLength: 4,
+			pvs: []runtime.Object{
+				pvForClaim("pvc-1234", "other"),
+				pvForClaim(alternative, "uid-1"),
+			},
+			expectName: alternative,
+		},
+		"all names taken": {
+			uuidLength: 4,
+			pvs: []runtime.Object{
+				pvForClaim("pvc-1234", "other"),
+				pvForClaim(alternative, "other"),
+			},
+			expectError: true,
+		},
+		"not truncated": {
+			uuidLength: -1,
+			pvs:        []runtime.Object{pvForClaim("pvc-1234", "other")},
+			expectName: "pvc-1234",
+		},
+		"template": {
+			uuidLength: -1,
+			template:   "pvc-{{.PVCName}}",
+			pvs:        []runtime.Object{pvForClaim("pvc-1234", "other")},
+			expectName: alternative,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, pv := range tc.pvs {
+				if err := indexer.Add(pv); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, withLister := range []bool{false, true} {
+				p := &csiProvisioner{
+					client:               fake.NewSimpleClientset(tc.pvs...),
+					volumeNameUUIDLength: tc.uuidLength,
+				}
+				if withLister {
+					p.pvLister = corelisters.NewPersistentVolumeLister(indexer)
+				}
+				if tc.template != "" {
+					p.volumeNameTemplate = template.Must(ParseVolumeNameTemplate(tc.template))
+				}
+				pvName, err := p.avoidVolumeNameCollision(context.Background(), claim, "pvc-1234")
+				if tc.expectError {
+					if err == nil {
+						t.Errorf("lister %v: expected error, got name %s", withLister, pvName)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("lister %v: unexpected error: %v", withLister, err)
+				}
+				if pvName != tc.expectName {
+					t.Errorf("lister %v: expected name %s, got %s", withLister, tc.expectName, pvName)
+				}
+			}
+		})
+	}
+}
+
+func TestVolumeNameTemplate(t *testing.T) {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "data-db-0",
+			Namespace: "billing",
+			UID:       "4f1c7e2a-9b3d-4e5f-8a6b-7c8d9e0f1a2b",
+		},
+	}
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast"}}
+
+	testcases := map[string]struct {
+		template    string
+		claimName   string
+		expectName  string
+		expectParse bool
+		expectError bool
+	}{
+		"no template": {
+			expectName: "pvc-4f1c7e2a-9b3d-4e5f-8a6b-7c8d9e0f1a2b",
+		},
+		"all fields": {
+			template:   "{{.StorageClassName}}.{{.PVCNamespace}}.{{.PVCName}}.{{.PVCUID}}",
+			expectName: "fast.billing.data-db-0.4f1c7e2a-9b3d-4e5f-8a6b-7c8d9e0f1a2b",
+		},
+		"unknown field": {
+			template:    "{{.Namespace}}-{{.PVCName}}",
+			expectParse: true,
+		},
+		"syntax error": {
+			template:    "{{.PVCName",
+			expectParse: true,
+		},
+		"invalid characters": {
+			template:    "Volume_{{.PVCName}}",
+			expectParse: true,
+		},
+		"too long for claim": {
+			template:    "{{.PVCNamespace}}-{{.PVCName}}",
+			claimName:   strings.Repeat("a", 250),
+			expectError: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			p := &csiProvisioner{
+				volumeNamePrefix:     "pvc",
+				volumeNameUUIDLength: -1,
+			}
+			if tc.template != "" {
+				tmpl, err := ParseVolumeNameTemplate(tc.template)
+				if tc.expectParse {
+					if err == nil {
+						t.Fatal("expected parse error, got none")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected parse error: %v", err)
+				}
+				p.volumeNameTemplate = tmpl
+			}
+			claim := claim.DeepCopy()
+			if tc.claimName != "" {
+				claim.Name = tc.claimName
+			}
+			pvName, err := p.volumeName(claim, sc)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error, got name %s", pvName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pvName != tc.expectName {
+				t.Errorf("expected name %s, got %s", tc.expectName, pvName)
+			}
+		})
+	}
+}
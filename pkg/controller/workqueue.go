@@ -0,0 +1,238 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	oldestItemAgeDesc = metrics.NewDesc(
+		"workqueue_oldest_item_age_seconds",
+		"How long the oldest item has been waiting in a workqueue to be processed.",
+		[]string{"name"}, nil,
+		metrics.ALPHA,
+		"",
+	)
+	oldestUnprocessedItemAgeDesc = metrics.NewDesc(
+		"workqueue_oldest_unprocessed_item_age_seconds",
+		"How long the oldest item has been in a workqueue without being processed successfully, including the time spent waiting for retries.",
+		[]string{"name"}, nil,
+		metrics.ALPHA,
+		"",
+	)
+
+	queueAges = &queueAgeCollector{}
+)
+
+func init() {
+	legacyregistry.CustomMustRegister(queueAges)
+}
+
+// unprocessedAgeTracker is implemented by the queues for which
+// workqueue_oldest_unprocessed_item_age_seconds gets reported.
+type unprocessedAgeTracker interface {
+	queueName() string
+	oldestUnprocessed() (interface{}, time.Duration)
+}
+
+// queueAgeCollector reports the age of the oldest item for all queues
+// created with NewNamedRateLimitingQueue and the age of the oldest
+// failing item for the queues of the provisioner library.
+type queueAgeCollector struct {
+	metrics.BaseStableCollector
+
+	mutex  sync.Mutex
+	queues []unprocessedAgeTracker
+}
+
+var _ metrics.StableCollector = &queueAgeCollector{}
+
+func (c *queueAgeCollector) add(q unprocessedAgeTracker) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.queues = append(c.queues, q)
+}
+
+// DescribeWithStability implements the metrics.StableCollector interface.
+func (c *queueAgeCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- oldestItemAgeDesc
+	ch <- oldestUnprocessedItemAgeDesc
+}
+
+// CollectWithStability implements the metrics.StableCollector interface.
+func (c *queueAgeCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, q := range c.queues {
+		if q, ok := q.(*ageTrackingQueue); ok {
+			ch <- metrics.NewLazyConstMetric(oldestItemAgeDesc,
+				metrics.GaugeValue,
+				q.oldestAge().Seconds(),
+				q.name,
+			)
+		}
+		_, age := q.oldestUnprocessed()
+		ch <- metrics.NewLazyConstMetric(oldestUnprocessedItemAgeDesc,
+			metrics.GaugeValue,
+			age.Seconds(),
+			q.queueName(),
+		)
+	}
+}
+
+// list returns all queues.
+func (c *queueAgeCollector) list() []unprocessedAgeTracker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]unprocessedAgeTracker(nil), c.queues...)
+}
+
+// ageTrackingQueue remembers since when each pending item has been
+// ready for processing. Items added with a delay only count as
+// waiting once that delay is over.
+//
+// It also remembers since when each item has been waiting for
+// successful processing, which is signaled by Forget. An item that
+// keeps failing therefore gets older even though it gets processed
+// and requeued regularly.
+type ageTrackingQueue struct {
+	workqueue.RateLimitingInterface
+	rateLimiter workqueue.RateLimiter
+	name        string
+	now         func() time.Time
+
+	mutex       sync.Mutex
+	pending     map[interface{}]time.Time
+	unprocessed map[interface{}]time.Time
+}
+
+// NewNamedRateLimitingQueue creates a rate limiting queue which emits the
+// usual workqueue metrics under the given name and in addition the age
+// of its oldest item.
+func NewNamedRateLimitingQueue(rateLimiter workqueue.RateLimiter, name string) workqueue.RateLimitingInterface {
+	q := newAgeTrackingQueue(rateLimiter, name)
+	queueAges.add(q)
+	return q
+}
+
+func newAgeTrackingQueue(rateLimiter workqueue.RateLimiter, name string) *ageTrackingQueue {
+	return &ageTrackingQueue{
+		RateLimitingInterface: workqueue.NewNamedRateLimitingQueue(rateLimiter, name),
+		rateLimiter:           rateLimiter,
+		name:                  name,
+		now:                   time.Now,
+		pending:               map[interface{}]time.Time{},
+		unprocessed:           map[interface{}]time.Time{},
+	}
+}
+
+func (q *ageTrackingQueue) track(item interface{}, ready time.Time) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if existing, ok := q.pending[item]; !ok || ready.Before(existing) {
+		q.pending[item] = ready
+	}
+	if _, ok := q.unprocessed[item]; !ok {
+		q.unprocessed[item] = ready
+	}
+}
+
+func (q *ageTrackingQueue) Add(item interface{}) {
+	q.track(item, q.now())
+	q.RateLimitingInterface.Add(item)
+}
+
+func (q *ageTrackingQueue) AddAfter(item interface{}, duration time.Duration) {
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	q.track(item, q.now().Add(duration))
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+func (q *ageTrackingQueue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+func (q *ageTrackingQueue) Get() (interface{}, bool) {
+	item, shutdown := q.RateLimitingInterface.Get()
+	if !shutdown {
+		q.mutex.Lock()
+		delete(q.pending, item)
+		q.mutex.Unlock()
+	}
+	return item, shutdown
+}
+
+func (q *ageTrackingQueue) Forget(item interface{}) {
+	q.mutex.Lock()
+	if ready, ok := q.pending[item]; ok {
+		// Added again while being processed.
+		q.unprocessed[item] = ready
+	} else {
+		delete(q.unprocessed, item)
+	}
+	q.mutex.Unlock()
+	q.RateLimitingInterface.Forget(item)
+}
+
+func (q *ageTrackingQueue) queueName() string {
+	return q.name
+}
+
+// oldestAge returns how long the oldest pending item has been ready for
+// processing, zero if there is none.
+func (q *ageTrackingQueue) oldestAge() time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := q.now()
+	var oldest time.Duration
+	for _, ready := range q.pending {
+		if age := now.Sub(ready); age > oldest {
+			oldest = age
+		}
+	}
+	return oldest
+}
+
+// oldestUnprocessed returns the item which has been waiting longest for
+// successful processing and how long that was, nil and zero if there
+// is none.
+func (q *ageTrackingQueue) oldestUnprocessed() (interface{}, time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := q.now()
+	var oldestItem interface{}
+	var oldest time.Duration
+	for item, since := range q.unprocessed {
+		if age := now.Sub(since); age > oldest {
+			oldestItem = item
+			oldest = age
+		}
+	}
+	return oldestItem, oldest
+}
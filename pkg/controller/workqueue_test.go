@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestAgeTrackingQueue(t *testing.T) {
+	now := time.Now()
+	q := newAgeTrackingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Hour, time.Hour), "test")
+	defer q.ShutDown()
+	q.now = func() time.Time { return now }
+
+	expectAge := func(expected time.Duration) {
+		t.Helper()
+		if age := q.oldestAge(); age != expected {
+			t.Errorf("expected oldest age %s, got %s", expected, age)
+		}
+	}
+
+	expectAge(0)
+
+	q.Add("a")
+	now = now.Add(time.Second)
+	q.Add("b")
+	now = now.Add(time.Second)
+	expectAge(2 * time.Second)
+
+	// Adding again does not reset the age.
+	q.Add("a")
+	expectAge(2 * time.Second)
+
+	// Rate limited items only count once they are ready.
+	q.AddRateLimited("c")
+	expectAge(2 * time.Second)
+
+	item, _ := q.Get()
+	if item != "a" {
+		t.Fatalf("expected item a, got %v", item)
+	}
+	q.Done(item)
+	expectAge(time.Second)
+
+	item, _ = q.Get()
+	q.Done(item)
+	expectAge(0)
+
+	now = now.Add(2 * time.Hour)
+	expectAge(time.Hour)
+}
+
+func TestAgeTrackingQueueUnprocessed(t *testing.T) {
+	now := time.Now()
+	q := newAgeTrackingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Second, time.Second), "test")
+	defer q.ShutDown()
+	q.now = func() time.Time { return now }
+
+	expectOldest := func(expectedItem interface{}, expected time.Duration) {
+		t.Helper()
+		if item, age := q.oldestUnprocessed(); item != expectedItem || age != expected {
+			t.Errorf("expected oldest unprocessed %v with age %s, got %v with %s", expectedItem, expected, item, age)
+		}
+	}
+
+	expectOldest(nil, 0)
+	q.Add("a")
+	now = now.Add(time.Minute)
+	q.Add("b")
+
+	// Failing keeps the original time.
+	item, _ := q.Get()
+	q.Done(item)
+	q.AddRateLimited(item)
+	now = now.Add(time.Minute)
+	expectOldest("a", 2*time.Minute)
+	if age := q.oldestAge(); age != time.Minute {
+		t.Errorf("expected oldest age %s, got %s", time.Minute, age)
+	}
+
+	// Success resets it.
+	item, _ = q.Get()
+	if item != "b" {
+		t.Fatalf("expected item b, got %v", item)
+	}
+	q.Done(item)
+	q.Forget(item)
+	expectOldest("a", 2*time.Minute)
+	item, _ = q.Get()
+	q.Done(item)
+	q.Forget(item)
+	expectOldest(nil, 0)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package csimetrics implements the metrics.CSIMetricsManager interface
+// of csi-lib-utils with configurable histogram buckets. The metric has
+// the same name, help text and labels as the one from csi-lib-utils,
+// which hard-codes buckets of up to ten minutes.
+package csimetrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8smetrics "k8s.io/component-base/metrics"
+)
+
+const (
+	labelCSIDriverName    = "driver_name"
+	labelCSIOperationName = "method_name"
+	labelGrpcStatusCode   = "grpc_status_code"
+	unknownCSIDriverName  = "unknown-driver"
+)
+
+// ParseBuckets parses a comma-separated list of upper bounds in
+// seconds. They must be positive and in increasing order.
+func ParseBuckets(value string) ([]float64, error) {
+	var buckets []float64
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		bucket, err := strconv.ParseFloat(entry, 64)
+		if err != nil || bucket <= 0 {
+			return nil, fmt.Errorf("bucket must be a positive number of seconds, got %q", entry)
+		}
+		buckets = append(buckets, bucket)
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		return nil, fmt.Errorf("buckets must be in increasing order, got %v", buckets)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] == buckets[i-1] {
+			return nil, fmt.Errorf("duplicate bucket %v", buckets[i])
+		}
+	}
+	return buckets, nil
+}
+
+// NewManager returns a metrics manager for a sidecar which records
+// CSI operations in a histogram with the given buckets. With migration
+// enabled, the histogram has the additional "migrated" label. The
+// process start time is not registered.
+func NewManager(driverName string, buckets []float64, migration bool) metrics.CSIMetricsManager {
+	labels := []string{labelCSIDriverName, labelCSIOperationName, labelGrpcStatusCode}
+	if migration {
+		labels = append(labels, metrics.LabelMigrated)
+	}
+	m := &manager{
+		registry:  k8smetrics.NewKubeRegistry(),
+		migration: migration,
+		latency: k8smetrics.NewHistogramVec(
+			&k8smetrics.HistogramOpts{
+				Subsystem:      metrics.SubsystemSidecar,
+				Name:           "operations_seconds",
+				Help:           "Container Storage Interface operation duration with gRPC error code status total",
+				Buckets:        buckets,
+				StabilityLevel: k8smetrics.ALPHA,
+			},
+			labels,
+		),
+	}
+	m.SetDriverName(driverName)
+	m.registry.MustRegister(m.latency)
+	return m
+}
+
+type manager struct {
+	registry   k8smetrics.KubeRegistry
+	latency    *k8smetrics.HistogramVec
+	migration  bool
+	driverName string
+}
+
+var _ metrics.CSIMetricsManager = &manager{}
+
+func (m *manager) GetRegistry() k8smetrics.KubeRegistry {
+	return m.registry
+}
+
+func (m *manager) RecordMetrics(operationName string, operationErr error, operationDuration time.Duration) {
+	m.record(operationName, operationErr, operationDuration, nil)
+}
+
+func (m *manager) record(operationName string, operationErr error, operationDuration time.Duration, labelValues map[string]string) {
+	values := []string{m.driverName, operationName, errorCode(operationErr)}
+	if m.migration {
+		values = append(values, labelValues[metrics.LabelMigrated])
+	}
+	m.latency.WithLabelValues(values...).Observe(operationDuration.Seconds())
+}
+
+func (m *manager) WithLabelValues(labels map[string]string) (metrics.CSIMetricsManager, error) {
+	return (&managerWithValues{manager: m}).WithLabelValues(labels)
+}
+
+func (m *manager) HaveAdditionalLabel(name string) bool {
+	return m.migration && name == metrics.LabelMigrated
+}
+
+func (m *manager) SetDriverName(driverName string) {
+	if driverName == "" {
+		driverName = unknownCSIDriverName
+	}
+	m.driverName = driverName
+}
+
+func (m *manager) RegisterToServer(s metrics.Server, metricsPath string) {
+	s.Handle(metricsPath, k8smetrics.HandlerFor(
+		m.GetRegistry(),
+		k8smetrics.HandlerOpts{
+			ErrorHandling: k8smetrics.ContinueOnError}))
+}
+
+// managerWithValues holds the values for additional labels.
+type managerWithValues struct {
+	*manager
+	values map[string]string
+}
+
+func (m *managerWithValues) WithLabelValues(labels map[string]string) (metrics.CSIMetricsManager, error) {
+	extended := &managerWithValues{
+		manager: m.manager,
+		values:  map[string]string{},
+	}
+	for name, value := range m.values {
+		extended.values[name] = value
+	}
+	for name, value := range labels {
+		if !m.HaveAdditionalLabel(name) {
+			return nil, fmt.Errorf("label %q was not defined", name)
+		}
+		if v, ok := extended.values[name]; ok {
+			return nil, fmt.Errorf("label %q already has value %q", name, v)
+		}
+		extended.values[name] = value
+	}
+	return extended, nil
+}
+
+func (m *managerWithValues) RecordMetrics(operationName string, operationErr error, operationDuration time.Duration) {
+	m.record(operationName, operationErr, operationDuration, m.values)
+}
+
+func errorCode(err error) string {
+	if err == nil {
+		return codes.OK.String()
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		// Same as in csi-lib-utils: the operation failed before
+		// the gRPC method was called.
+		return "unknown-non-grpc"
+	}
+	return st.Code().String()
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csimetrics
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestParseBuckets(t *testing.T) {
+	testcases := map[string]struct {
+		value       string
+		expected    []float64
+		expectError bool
+	}{
+		"valid": {
+			value:    "1, 10,60,600,3600",
+			expected: []float64{1, 10, 60, 600, 3600},
+		},
+		"not a number": {
+			value:       "1,x",
+			expectError: true,
+		},
+		"zero": {
+			value:       "0,1",
+			expectError: true,
+		},
+		"unsorted": {
+			value:       "10,1",
+			expectError: true,
+		},
+		"duplicate": {
+			value:       "1,1",
+			expectError: true,
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			buckets, err := ParseBuckets(tc.value)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %v", buckets)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(buckets, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, buckets)
+			}
+		})
+	}
+}
+
+func TestManager(t *testing.T) {
+	m := NewManager("", []float64{60, 600}, false)
+	m.SetDriverName("test-driver")
+	m.RecordMetrics("/csi.v1.Controller/CreateVolume", nil, 2*time.Minute)
+	m.RecordMetrics("/csi.v1.Controller/CreateVolume", status.Error(codes.Internal, "fake"), time.Second)
+	if m.HaveAdditionalLabel(metrics.LabelMigrated) {
+		t.Error("migrated label must only be defined with migration")
+	}
+
+	if err := testutil.GatherAndCompare(m.GetRegistry(), bytes.NewBufferString(`# HELP csi_sidecar_operations_seconds [ALPHA] Container Storage Interface operation duration with gRPC error code status total
+# TYPE csi_sidecar_operations_seconds histogram
+csi_sidecar_operations_seconds_bucket{driver_name="test-driver",grpc_status_code="Internal",method_name="/csi.v1.Controller/CreateVolume",le="60"} 1
+csi_sidecar_operations_seconds_bucket{driver_name="test-driver",grpc_status_code="Internal",method_name="/csi.v1.Controller/CreateVolume",le="600"} 1
+csi_sidecar_operations_seconds_bucket{driver_name="test-driver",grpc_status_code="Internal",method_name="/csi.v1.Controller/CreateVolume",le="+Inf"} 1
+csi_sidecar_operations_seconds_sum{driver_name="test-driver",grpc_status_code="Internal",method_name="/csi.v1.Controller/CreateVolume"} 1
+csi_sidecar_operations_seconds_count{driver_name="test-driver",grpc_status_code="Internal",method_name="/csi.v1.Controller/CreateVolume"} 1
+csi_sidecar_operations_seconds_bucket{driver_name="test-driver",grpc_status_code="OK",method_name="/csi.v1.Controller/CreateVolume",le="60"} 0
+csi_sidecar_operations_seconds_bucket{driver_name="test-driver",grpc_status_code="OK",method_name="/csi.v1.Controller/CreateVolume",le="600"} 1
+csi_sidecar_operations_seconds_bucket{driver_name="test-driver",grpc_status_code="OK",method_name="/csi.v1.Controller/CreateVolume",le="+Inf"} 1
+csi_sidecar_operations_seconds_sum{driver_name="test-driver",grpc_status_code="OK",method_name="/csi.v1.Controller/CreateVolume"} 120
+csi_sidecar_operations_seconds_count{driver_name="test-driver",grpc_status_code="OK",method_name="/csi.v1.Controller/CreateVolume"} 1
+`)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestManagerMigration(t *testing.T) {
+	m := NewManager("test-driver", []float64{60}, true)
+	if !m.HaveAdditionalLabel(metrics.LabelMigrated) {
+		t.Fatal("migrated label must be defined")
+	}
+	if _, err := m.WithLabelValues(map[string]string{"other": "x"}); err == nil {
+		t.Error("expected error for unknown label")
+	}
+	mv, err := m.WithLabelValues(map[string]string{metrics.LabelMigrated: "true"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := mv.WithLabelValues(map[string]string{metrics.LabelMigrated: "false"}); err == nil {
+		t.Error("expected error when overwriting a label value")
+	}
+	mv.RecordMetrics("/csi.v1.Controller/DeleteVolume", nil, time.Second)
+
+	if err := testutil.GatherAndCompare(m.GetRegistry(), bytes.NewBufferString(`# HELP csi_sidecar_operations_seconds [ALPHA] Container Storage Interface operation duration with gRPC error code status total
+# TYPE csi_sidecar_operations_seconds histogram
+csi_sidecar_operations_seconds_bucket{driver_name="test-driver",grpc_status_code="OK",method_name="/csi.v1.Controller/DeleteVolume",migrated="true",le="60"} 1
+csi_sidecar_operations_seconds_bucket{driver_name="test-driver",grpc_status_code="OK",method_name="/csi.v1.Controller/DeleteVolume",migrated="true",le="+Inf"} 1
+csi_sidecar_operations_seconds_sum{driver_name="test-driver",grpc_status_code="OK",method_name="/csi.v1.Controller/DeleteVolume",migrated="true"} 1
+csi_sidecar_operations_seconds_count{driver_name="test-driver",grpc_status_code="OK",method_name="/csi.v1.Controller/DeleteVolume",migrated="true"} 1
+`)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debugstate
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// authorize checks the bearer token of the request with a TokenReview
+// and whether the user may access the path with a SubjectAccessReview.
+// On failure, it returns the HTTP status code for the response.
+func authorize(r *http.Request, client kubernetes.Interface) (int, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return http.StatusUnauthorized, errors.New("bearer token required")
+	}
+
+	review, err := client.AuthenticationV1().TokenReviews().Create(r.Context(),
+		&authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token},
+		},
+		metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("token review: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, errors.New("invalid bearer token")
+	}
+
+	user := review.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	access, err := client.AuthorizationV1().SubjectAccessReviews().Create(r.Context(),
+		&authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   user.Username,
+				Groups: user.Groups,
+				UID:    user.UID,
+				Extra:  extra,
+				NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+					Path: r.URL.Path,
+					Verb: "get",
+				},
+			},
+		},
+		metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("subject access review: %v", err)
+	}
+	if !access.Status.Allowed {
+		return http.StatusForbidden, fmt.Errorf("user %q may not get %s", user.Username, r.URL.Path)
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package debugstate serves a JSON dump of the internal state of the
+// external-provisioner: claims which are waiting to be provisioned,
+// operations in progress, known topology segments and work items of
+// the capacity controller.
+package debugstate
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kubernetes-csi/external-provisioner/pkg/capacity"
+	"github.com/kubernetes-csi/external-provisioner/pkg/capacity/topology"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+const (
+	annStorageProvisioner     = "volume.kubernetes.io/storage-provisioner"
+	annBetaStorageProvisioner = "volume.beta.kubernetes.io/storage-provisioner"
+)
+
+// State is the content of the dump.
+type State struct {
+	Time              time.Time                `json:"time"`
+	Leader            bool                     `json:"leader"`
+	PendingClaims     []PendingClaim           `json:"pendingClaims"`
+	InFlight          []Operation              `json:"inFlight"`
+	TopologySegments  []string                 `json:"topologySegments,omitempty"`
+	CapacityWorkItems []capacity.WorkItemState `json:"capacityWorkItems,omitempty"`
+}
+
+// PendingClaim is a claim for one of the drivers which is not bound yet.
+type PendingClaim struct {
+	Namespace        string    `json:"namespace"`
+	Name             string    `json:"name"`
+	UID              string    `json:"uid"`
+	StorageClassName string    `json:"storageClassName,omitempty"`
+	SelectedNode     string    `json:"selectedNode,omitempty"`
+	Created          time.Time `json:"created"`
+	// Retries is the number of failed provisioning attempts.
+	Retries int `json:"retries"`
+	// Backoff is the delay before the next attempt after the
+	// last failure.
+	Backoff string `json:"backoff,omitempty"`
+}
+
+// Dumper collects the state. All fields besides DriverNames are
+// optional and get skipped when not set.
+type Dumper struct {
+	// DriverNames is used to identify the relevant claims.
+	DriverNames []string
+	// Leader returns true while the controllers are running in
+	// this instance.
+	Leader func() bool
+	// Claims is the store of the claim informer.
+	Claims cache.Store
+	// ClaimRateLimiter is the rate limiter of the claim work
+	// queue, which uses claim UIDs as keys.
+	ClaimRateLimiter workqueue.RateLimiter
+	// Backoff calculates the delay after the given number of
+	// failures.
+	Backoff    func(retries int) time.Duration
+	Operations *OperationTracker
+	Topology   topology.Informer
+	Capacity   *capacity.Controller
+}
+
+// State collects the current state.
+func (d *Dumper) State() State {
+	state := State{
+		Time:          time.Now(),
+		PendingClaims: []PendingClaim{},
+		InFlight:      []Operation{},
+	}
+	if d.Leader != nil {
+		state.Leader = d.Leader()
+	}
+	if d.Claims != nil {
+		state.PendingClaims = d.pendingClaims()
+	}
+	if d.Operations != nil {
+		state.InFlight = d.Operations.List(state.Time)
+	}
+	if d.Topology != nil {
+		for _, segment := range d.Topology.List() {
+			state.TopologySegments = append(state.TopologySegments, segment.SimpleString())
+		}
+		sort.Strings(state.TopologySegments)
+	}
+	if d.Capacity != nil {
+		state.CapacityWorkItems = d.Capacity.WorkItems()
+	}
+	return state
+}
+
+func (d *Dumper) pendingClaims() []PendingClaim {
+	drivers := map[string]bool{}
+	for _, name := range d.DriverNames {
+		drivers[name] = true
+	}
+	claims := []PendingClaim{}
+	for _, obj := range d.Claims.List() {
+		claim, ok := obj.(*v1.PersistentVolumeClaim)
+		if !ok || claim.Status.Phase != v1.ClaimPending {
+			continue
+		}
+		provisioner := claim.Annotations[annStorageProvisioner]
+		if provisioner == "" {
+			provisioner = claim.Annotations[annBetaStorageProvisioner]
+		}
+		if !drivers[provisioner] {
+			continue
+		}
+		pending := PendingClaim{
+			Namespace:    claim.Namespace,
+			Name:         claim.Name,
+			UID:          string(claim.UID),
+			SelectedNode: claim.Annotations["volume.kubernetes.io/selected-node"],
+			Created:      claim.CreationTimestamp.Time,
+		}
+		if claim.Spec.StorageClassName != nil {
+			pending.StorageClassName = *claim.Spec.StorageClassName
+		}
+		if d.ClaimRateLimiter != nil {
+			pending.Retries = d.ClaimRateLimiter.NumRequeues(string(claim.UID))
+			if pending.Retries > 0 && d.Backoff != nil {
+				pending.Backoff = d.Backoff(pending.Retries).String()
+			}
+		}
+		claims = append(claims, pending)
+	}
+	sort.Slice(claims, func(i, j int) bool {
+		if claims[i].Namespace != claims[j].Namespace {
+			return claims[i].Namespace < claims[j].Namespace
+		}
+		return claims[i].Name < claims[j].Name
+	})
+	return claims
+}
+
+// NewHandler returns an HTTP handler which serves the state as JSON
+// for GET requests. Each request must be authenticated with a bearer
+// token of a user who is allowed to get the path of the request as a
+// non-resource URL, which gets checked with the client.
+func NewHandler(d *Dumper, client kubernetes.Interface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		if status, err := authorize(r, client); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(d.State()); err != nil {
+			klog.Errorf("Writing debug state: %v", err)
+		}
+	})
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debugstate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+const driverName = "test-driver"
+
+type blockingProvisioner struct {
+	started, release chan struct{}
+}
+
+func (p *blockingProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	close(p.started)
+	<-p.release
+	return &v1.PersistentVolume{}, controller.ProvisioningFinished, nil
+}
+
+func (p *blockingProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	return nil
+}
+
+func makeClaim(name string, phase v1.PersistentVolumeClaimPhase, driver string) *v1.PersistentVolumeClaim {
+	storageClassName := "sc"
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			UID:         "uid-" + types.UID(name),
+			Annotations: map[string]string{annStorageProvisioner: driver},
+		},
+		Spec:   v1.PersistentVolumeClaimSpec{StorageClassName: &storageClassName},
+		Status: v1.PersistentVolumeClaimStatus{Phase: phase},
+	}
+}
+
+func TestState(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, claim := range []*v1.PersistentVolumeClaim{
+		makeClaim("pending", v1.ClaimPending, driverName),
+		makeClaim("bound", v1.ClaimBound, driverName),
+		makeClaim("other-driver", v1.ClaimPending, "other-driver"),
+		makeClaim("failed", v1.ClaimPending, driverName),
+	} {
+		if err := store.Add(claim); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, time.Minute)
+	rateLimiter.When("uid-failed")
+	rateLimiter.When("uid-failed")
+
+	tracker := NewOperationTracker()
+	inner := &blockingProvisioner{started: make(chan struct{}), release: make(chan struct{})}
+	p := tracker.Wrap(inner)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Provision(context.Background(), controller.ProvisionOptions{PVC: makeClaim("pending", v1.ClaimPending, driverName)})
+	}()
+	<-inner.started
+
+	d := &Dumper{
+		DriverNames:      []string{driverName},
+		Leader:           func() bool { return true },
+		Claims:           store,
+		ClaimRateLimiter: rateLimiter,
+		Backoff: func(retries int) time.Duration {
+			return time.Duration(retries) * time.Second
+		},
+		Operations: tracker,
+	}
+	state := d.State()
+	close(inner.release)
+	<-done
+
+	if !state.Leader {
+		t.Error("expected leader")
+	}
+	if len(state.PendingClaims) != 2 {
+		t.Fatalf("expected two pending claims, got %+v", state.PendingClaims)
+	}
+	failed, pending := state.PendingClaims[0], state.PendingClaims[1]
+	if failed.Name != "failed" || failed.Retries != 2 || failed.Backoff != "2s" {
+		t.Errorf("unexpected failed claim %+v", failed)
+	}
+	if pending.Name != "pending" || pending.Retries != 0 || pending.Backoff != "" || pending.StorageClassName != "sc" {
+		t.Errorf("unexpected pending claim %+v", pending)
+	}
+	if len(state.InFlight) != 1 || state.InFlight[0].Operation != "provision" || state.InFlight[0].Object != "default/pending" {
+		t.Errorf("unexpected in-flight operations %+v", state.InFlight)
+	}
+	if operations := tracker.List(time.Now()); len(operations) != 0 {
+		t.Errorf("expected no operations after completion, got %+v", operations)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	testcases := map[string]struct {
+		method        string
+		authorization string
+		authenticated bool
+		allowed       bool
+		expectStatus  int
+	}{
+		"wrong method": {
+			method:       http.MethodPost,
+			expectStatus: http.StatusMethodNotAllowed,
+		},
+		"no token": {
+			expectStatus: http.StatusUnauthorized,
+		},
+		"basic auth": {
+			authorization: "Basic Zm9vOmJhcg==",
+			expectStatus:  http.StatusUnauthorized,
+		},
+		"invalid token": {
+			authorization: "Bearer invalid",
+			expectStatus:  http.StatusUnauthorized,
+		},
+		"forbidden": {
+			authorization: "Bearer valid",
+			authenticated: true,
+			expectStatus:  http.StatusForbidden,
+		},
+		"allowed": {
+			authorization: "Bearer valid",
+			authenticated: true,
+			allowed:       true,
+			expectStatus:  http.StatusOK,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+				review.Status.Authenticated = tc.authenticated && review.Spec.Token == "valid"
+				review.Status.User.Username = "admin"
+				return true, review, nil
+			})
+			client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+				if review.Spec.User != "admin" || review.Spec.NonResourceAttributes == nil ||
+					review.Spec.NonResourceAttributes.Path != "/debug/state" || review.Spec.NonResourceAttributes.Verb != "get" {
+					t.Errorf("unexpected subject access review %+v", review.Spec)
+				}
+				review.Status.Allowed = tc.allowed
+				return true, review, nil
+			})
+			handler := NewHandler(&Dumper{DriverNames: []string{driverName}}, client)
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/debug/state", nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var state State
+			if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if state.Leader {
+				t.Error("expected no leader")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debugstate
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v6/controller"
+)
+
+// Operation is a Provision or Delete call which has not returned yet.
+type Operation struct {
+	// Operation is either "provision" or "delete".
+	Operation string `json:"operation"`
+	// Object is <namespace>/<name> of the claim or the name of the PV.
+	Object   string    `json:"object"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+}
+
+// OperationTracker keeps track of the operations in progress.
+type OperationTracker struct {
+	mutex      sync.Mutex
+	nextID     int
+	operations map[int]Operation
+}
+
+// NewOperationTracker creates an empty tracker.
+func NewOperationTracker() *OperationTracker {
+	return &OperationTracker{
+		operations: map[int]Operation{},
+	}
+}
+
+func (t *OperationTracker) start(operation, object string) func() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	id := t.nextID
+	t.nextID++
+	t.operations[id] = Operation{
+		Operation: operation,
+		Object:    object,
+		Started:   time.Now(),
+	}
+	return func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		delete(t.operations, id)
+	}
+}
+
+// List returns the operations in progress, oldest first.
+func (t *OperationTracker) List(now time.Time) []Operation {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	operations := make([]Operation, 0, len(t.operations))
+	for _, operation := range t.operations {
+		operation.Duration = now.Sub(operation.Started).String()
+		operations = append(operations, operation)
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i].Started.Before(operations[j].Started)
+	})
+	return operations
+}
+
+// Wrap returns a provisioner which records its Provision and Delete
+// calls in the tracker.
+func (t *OperationTracker) Wrap(p controller.Provisioner) controller.Provisioner {
+	return &trackingProvisioner{
+		Provisioner: p,
+		t:           t,
+	}
+}
+
+type trackingProvisioner struct {
+	controller.Provisioner
+	t *OperationTracker
+}
+
+var _ controller.Provisioner = &trackingProvisioner{}
+var _ controller.BlockProvisioner = &trackingProvisioner{}
+var _ controller.Qualifier = &trackingProvisioner{}
+
+func (p *trackingProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	done := p.t.start("provision", options.PVC.Namespace+"/"+options.PVC.Name)
+	defer done()
+	return p.Provisioner.Provision(ctx, options)
+}
+
+func (p *trackingProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	done := p.t.start("delete", pv.Name)
+	defer done()
+	return p.Provisioner.Delete(ctx, pv)
+}
+
+func (p *trackingProvisioner) SupportsBlock(ctx context.Context) bool {
+	if blockProvisioner, ok := p.Provisioner.(controller.BlockProvisioner); ok {
+		return blockProvisioner.SupportsBlock(ctx)
+	}
+	return false
+}
+
+func (p *trackingProvisioner) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	if qualifier, ok := p.Provisioner.(controller.Qualifier); ok {
+		return qualifier.ShouldProvision(ctx, claim)
+	}
+	return true
+}
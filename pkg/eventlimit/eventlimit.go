@@ -0,0 +1,239 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package eventlimit caps the number of events that get written for each
+// PVC. The event recorders of client-go already filter spam, but only per
+// recorder, and the external-provisioner and the provisioner library use
+// several of them. A single claim that fails over and over again
+// therefore can still produce a lot of events.
+//
+// The limit gets applied to the event client, which is shared by all
+// recorders.
+package eventlimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+var suppressedEvents = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name:           "claim_events_suppressed_total",
+		Help:           "Number of PVC events that were not written because the per-claim limit was reached, by reason.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	legacyregistry.MustRegister(suppressedEvents)
+}
+
+// Limiter allows a certain number of event writes per claim in a time
+// window. Creating an event and updating the count of an existing one
+// both count as a write.
+type Limiter struct {
+	maxEvents int
+	window    time.Duration
+	now       func() time.Time
+
+	mutex     sync.Mutex
+	claims    map[string]*claimEvents
+	lastPrune time.Time
+}
+
+type claimEvents struct {
+	windowStart time.Time
+	written     int
+	// suppressed counts the writes that were dropped, by reason and
+	// message.
+	suppressed map[suppressedKey]int
+	// unwritten contains the names of events which were never
+	// created.
+	unwritten sets.String
+}
+
+type suppressedKey struct {
+	reason, message string
+}
+
+// NewLimiter allows maxEvents writes per claim in each window.
+func NewLimiter(maxEvents int, window time.Duration) *Limiter {
+	return &Limiter{
+		maxEvents: maxEvents,
+		window:    window,
+		now:       time.Now,
+		claims:    map[string]*claimEvents{},
+	}
+}
+
+// admit decides whether the event may be written, isNew is true when it
+// is about to be created. For events which get written, it returns how
+// often an event with the same reason and message was dropped before and
+// whether the event must be created because it was dropped when it was
+// new.
+func (l *Limiter) admit(event *v1.Event, isNew bool) (allowed bool, repeated int, mustCreate bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.prune(now)
+	obj := event.InvolvedObject
+	claimKey := obj.Namespace + "/" + obj.Name + "/" + string(obj.UID)
+	claim := l.claims[claimKey]
+	if claim == nil {
+		claim = &claimEvents{
+			windowStart: now,
+			suppressed:  map[suppressedKey]int{},
+			unwritten:   sets.NewString(),
+		}
+		l.claims[claimKey] = claim
+	}
+	if now.Sub(claim.windowStart) >= l.window {
+		claim.windowStart = now
+		claim.written = 0
+	}
+
+	key := suppressedKey{reason: event.Reason, message: event.Message}
+	if claim.written >= l.maxEvents {
+		if claim.suppressed[key] == 0 {
+			klog.V(4).Infof("Too many events for claim %s/%s, suppressing %s event: %s", obj.Namespace, obj.Name, event.Reason, event.Message)
+		}
+		claim.suppressed[key]++
+		if isNew {
+			claim.unwritten.Insert(event.Name)
+		}
+		suppressedEvents.WithLabelValues(event.Reason).Inc()
+		return false, 0, false
+	}
+	claim.written++
+	repeated = claim.suppressed[key]
+	delete(claim.suppressed, key)
+	mustCreate = claim.unwritten.Has(event.Name)
+	claim.unwritten.Delete(event.Name)
+	return true, repeated, mustCreate
+}
+
+// prune forgets claims once per window. Claims are kept for one more
+// window when events were suppressed, in case they repeat.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	l.lastPrune = now
+	for key, claim := range l.claims {
+		age := now.Sub(claim.windowStart)
+		if age >= 2*l.window ||
+			age >= l.window && len(claim.suppressed) == 0 && claim.unwritten.Len() == 0 {
+			delete(l.claims, key)
+		}
+	}
+}
+
+// WrapClient returns a client which applies the limit to all events for
+// PVCs written through it. Dropped events are reported as written, so
+// that event recorders do not retry them.
+func WrapClient(client kubernetes.Interface, limiter *Limiter) kubernetes.Interface {
+	return &limitedClient{Interface: client, limiter: limiter}
+}
+
+type limitedClient struct {
+	kubernetes.Interface
+	limiter *Limiter
+}
+
+func (c *limitedClient) CoreV1() corev1.CoreV1Interface {
+	return &limitedCoreV1{CoreV1Interface: c.Interface.CoreV1(), limiter: c.limiter}
+}
+
+type limitedCoreV1 struct {
+	corev1.CoreV1Interface
+	limiter *Limiter
+}
+
+func (c *limitedCoreV1) Events(namespace string) corev1.EventInterface {
+	return &limitedEvents{EventInterface: c.CoreV1Interface.Events(namespace), limiter: c.limiter}
+}
+
+type limitedEvents struct {
+	corev1.EventInterface
+	limiter *Limiter
+}
+
+func isClaimEvent(event *v1.Event) bool {
+	return event.InvolvedObject.Kind == "PersistentVolumeClaim"
+}
+
+func (e *limitedEvents) CreateWithEventNamespace(event *v1.Event) (*v1.Event, error) {
+	if !isClaimEvent(event) {
+		return e.EventInterface.CreateWithEventNamespace(event)
+	}
+	allowed, repeated, _ := e.limiter.admit(event, true)
+	if !allowed {
+		return event, nil
+	}
+	return e.create(event, repeated)
+}
+
+func (e *limitedEvents) UpdateWithEventNamespace(event *v1.Event) (*v1.Event, error) {
+	if !isClaimEvent(event) {
+		return e.EventInterface.UpdateWithEventNamespace(event)
+	}
+	allowed, repeated, mustCreate := e.limiter.admit(event, false)
+	if !allowed {
+		return event, nil
+	}
+	if mustCreate {
+		return e.create(event, repeated)
+	}
+	return e.EventInterface.UpdateWithEventNamespace(event)
+}
+
+func (e *limitedEvents) PatchWithEventNamespace(event *v1.Event, data []byte) (*v1.Event, error) {
+	if !isClaimEvent(event) {
+		return e.EventInterface.PatchWithEventNamespace(event, data)
+	}
+	allowed, repeated, mustCreate := e.limiter.admit(event, false)
+	if !allowed {
+		return event, nil
+	}
+	if mustCreate {
+		// The patch was computed for an event that does not exist.
+		// The event contains the updated count.
+		return e.create(event, repeated)
+	}
+	return e.EventInterface.PatchWithEventNamespace(event, data)
+}
+
+func (e *limitedEvents) create(event *v1.Event, repeated int) (*v1.Event, error) {
+	if repeated > 0 || event.ResourceVersion != "" {
+		event = event.DeepCopy()
+		event.ResourceVersion = ""
+		if repeated > 0 {
+			event.Message = fmt.Sprintf("%s (repeated %d times)", event.Message, repeated)
+		}
+	}
+	return e.EventInterface.CreateWithEventNamespace(event)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newEvent(name, kind, objName, reason, message string) *v1.Event {
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:      kind,
+			Namespace: "default",
+			Name:      objName,
+			UID:       types.UID("uid-" + objName),
+		},
+		Reason:  reason,
+		Message: message,
+		Count:   1,
+	}
+}
+
+func TestLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewLimiter(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+	fakeClient := fake.NewSimpleClientset()
+	events := WrapClient(fakeClient, limiter).CoreV1().Events("default")
+
+	create := func(event *v1.Event) {
+		t.Helper()
+		if _, err := events.CreateWithEventNamespace(event); err != nil {
+			t.Fatalf("create %s: %v", event.Name, err)
+		}
+	}
+	patch := func(event *v1.Event) {
+		t.Helper()
+		if _, err := events.PatchWithEventNamespace(event, []byte(`{"count":2}`)); err != nil {
+			t.Fatalf("patch %s: %v", event.Name, err)
+		}
+	}
+	expectEvent := func(name, message string) {
+		t.Helper()
+		event, err := fakeClient.CoreV1().Events("default").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get %s: %v", name, err)
+		}
+		if event.Message != message {
+			t.Errorf("event %s: expected message %q, got %q", name, message, event.Message)
+		}
+	}
+	expectNoEvent := func(name string) {
+		t.Helper()
+		if _, err := fakeClient.CoreV1().Events("default").Get(context.Background(), name, metav1.GetOptions{}); err == nil {
+			t.Errorf("event %s: expected it to be dropped", name)
+		}
+	}
+
+	create(newEvent("a", "PersistentVolumeClaim", "claim-1", "ProvisioningFailed", "failure 1"))
+	create(newEvent("b", "PersistentVolumeClaim", "claim-1", "ProvisioningFailed", "failure 2"))
+	// Over the limit.
+	create(newEvent("c", "PersistentVolumeClaim", "claim-1", "ProvisioningFailed", "failure 3"))
+	patch(newEvent("a", "PersistentVolumeClaim", "claim-1", "ProvisioningFailed", "failure 1"))
+	create(newEvent("d", "PersistentVolumeClaim", "claim-1", "ProvisioningFailed", "failure 3"))
+	expectNoEvent("c")
+	expectNoEvent("d")
+	// Other claims and objects are not affected.
+	create(newEvent("e", "PersistentVolumeClaim", "claim-2", "ProvisioningFailed", "failure 1"))
+	create(newEvent("f", "PersistentVolume", "pv-1", "VolumeFailedDelete", "failure"))
+	create(newEvent("g", "PersistentVolume", "pv-1", "VolumeFailedDelete", "failure"))
+	create(newEvent("h", "PersistentVolume", "pv-1", "VolumeFailedDelete", "failure"))
+	expectEvent("e", "failure 1")
+	expectEvent("h", "failure")
+
+	// The next window allows events again. "c" was never created, so the
+	// patch creates it.
+	now = now.Add(time.Minute)
+	patch(newEvent("c", "PersistentVolumeClaim", "claim-1", "ProvisioningFailed", "failure 3"))
+	expectEvent("c", "failure 3 (repeated 2 times)")
+	create(newEvent("i", "PersistentVolumeClaim", "claim-1", "ProvisioningFailed", "failure 1"))
+	expectEvent("i", "failure 1 (repeated 1 times)")
+	create(newEvent("j", "PersistentVolumeClaim", "claim-1", "ProvisioningFailed", "failure 4"))
+	expectNoEvent("j")
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewLimiter(1, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	limiter.admit(newEvent("a", "PersistentVolumeClaim", "claim-1", "ProvisioningFailed", "failure"), true)
+	limiter.admit(newEvent("b", "PersistentVolumeClaim", "claim-2", "ProvisioningFailed", "failure"), true)
+	limiter.admit(newEvent("c", "PersistentVolumeClaim", "claim-2", "ProvisioningFailed", "failure"), true)
+	if len(limiter.claims) != 2 {
+		t.Fatalf("expected 2 claims, got %d", len(limiter.claims))
+	}
+
+	// claim-2 has a suppressed event and is kept longer.
+	now = now.Add(time.Minute)
+	limiter.admit(newEvent("d", "PersistentVolumeClaim", "claim-3", "ProvisioningFailed", "failure"), true)
+	if len(limiter.claims) != 2 || limiter.claims["default/claim-2/uid-claim-2"] == nil {
+		t.Fatalf("expected claim-2 and claim-3, got %v", limiter.claims)
+	}
+	now = now.Add(time.Minute)
+	limiter.admit(newEvent("e", "PersistentVolumeClaim", "claim-3", "ProvisioningFailed", "failure"), true)
+	if len(limiter.claims) != 1 || limiter.claims["default/claim-3/uid-claim-3"] == nil {
+		t.Fatalf("expected claim-3, got %v", limiter.claims)
+	}
+}
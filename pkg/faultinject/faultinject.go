@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package faultinject adds artificial latency and errors to CSI calls
+// and Kubernetes API writes. It is meant for rehearsing how the
+// external-provisioner and the alerting around it behave when the
+// storage backend or the API server degrade, without having to break
+// the real driver.
+package faultinject
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	targetCSI = "csi"
+	targetAPI = "api"
+)
+
+var injectedFaults = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name:           "fault_injections_total",
+		Help:           "Number of artificial delays and errors added to CSI calls and Kubernetes API writes, by target and type.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"target", "type"},
+)
+
+func init() {
+	legacyregistry.MustRegister(injectedFaults)
+}
+
+// Faults describes what gets injected into each call.
+type Faults struct {
+	// Latency is added before each call.
+	Latency time.Duration
+	// ErrorRate is the fraction of calls, between 0 and 1, which fail
+	// without being passed on.
+	ErrorRate float64
+}
+
+// Enabled returns true if any fault is configured.
+func (f Faults) Enabled() bool {
+	return f.Latency > 0 || f.ErrorRate > 0
+}
+
+// Validate checks the configuration.
+func (f Faults) Validate() error {
+	if f.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return fmt.Errorf("error rate must be between 0 and 1")
+	}
+	return nil
+}
+
+func (f Faults) String() string {
+	return fmt.Sprintf("latency %s, error rate %.2f", f.Latency, f.ErrorRate)
+}
+
+// inject waits and decides whether the call fails. It returns an error
+// if the context is done while waiting.
+func (f Faults) inject(ctx context.Context, target string) (fail bool, err error) {
+	if f.Latency > 0 {
+		injectedFaults.WithLabelValues(target, "latency").Inc()
+		timer := time.NewTimer(f.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+		injectedFaults.WithLabelValues(target, "error").Inc()
+		return true, nil
+	}
+	return false, nil
+}
+
+// WrapConn returns a connection which injects the faults into all CSI
+// calls. Injected errors have the Unavailable code.
+func WrapConn(conn grpc.ClientConnInterface, faults Faults) grpc.ClientConnInterface {
+	if !faults.Enabled() {
+		return conn
+	}
+	return &faultyConn{ClientConnInterface: conn, faults: faults}
+}
+
+type faultyConn struct {
+	grpc.ClientConnInterface
+	faults Faults
+}
+
+func (c *faultyConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	fail, err := c.faults.inject(ctx, targetCSI)
+	if err != nil {
+		return status.FromContextError(err).Err()
+	}
+	if fail {
+		return status.Errorf(codes.Unavailable, "injected fault for %s", method)
+	}
+	return c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+}
+
+// WrapTransport returns a function for rest.Config.WrapTransport which
+// injects the faults into all requests that modify objects. Reading is
+// not affected, so informers continue to work. Injected errors look
+// like a connection failure.
+func WrapTransport(faults Faults) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		if !faults.Enabled() {
+			return rt
+		}
+		return &faultyTransport{rt: rt, faults: faults}
+	}
+}
+
+type faultyTransport struct {
+	rt     http.RoundTripper
+	faults Faults
+}
+
+func (t *faultyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.rt.RoundTrip(req)
+	}
+	fail, err := t.faults.inject(req.Context(), targetAPI)
+	if err != nil {
+		return nil, err
+	}
+	if fail {
+		return nil, fmt.Errorf("injected fault for %s %s", req.Method, req.URL.Path)
+	}
+	return t.rt.RoundTrip(req)
+}
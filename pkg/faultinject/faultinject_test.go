@@ -0,0 +1,126 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package faultinject
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeConn struct {
+	grpc.ClientConnInterface
+	calls int
+}
+
+func (c *fakeConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	c.calls++
+	return nil
+}
+
+func TestWrapConn(t *testing.T) {
+	testcases := map[string]struct {
+		faults      Faults
+		timeout     time.Duration
+		expectCode  codes.Code
+		expectCalls int
+	}{
+		"disabled": {
+			expectCode:  codes.OK,
+			expectCalls: 1,
+		},
+		"latency": {
+			faults:      Faults{Latency: 10 * time.Millisecond},
+			expectCode:  codes.OK,
+			expectCalls: 1,
+		},
+		"latency exceeds timeout": {
+			faults:     Faults{Latency: time.Hour},
+			timeout:    10 * time.Millisecond,
+			expectCode: codes.DeadlineExceeded,
+		},
+		"errors": {
+			faults:     Faults{ErrorRate: 1},
+			expectCode: codes.Unavailable,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeConn{}
+			conn := WrapConn(fake, tc.faults)
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			start := time.Now()
+			err := conn.Invoke(ctx, "/csi.v1.Controller/CreateVolume", nil, nil)
+			if code := status.Code(err); code != tc.expectCode {
+				t.Errorf("expected code %s, got %s: %v", tc.expectCode, code, err)
+			}
+			if fake.calls != tc.expectCalls {
+				t.Errorf("expected %d calls, got %d", tc.expectCalls, fake.calls)
+			}
+			if err == nil && time.Since(start) < tc.faults.Latency {
+				t.Errorf("expected latency of at least %s, got %s", tc.faults.Latency, time.Since(start))
+			}
+		})
+	}
+}
+
+func TestWrapTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: WrapTransport(Faults{ErrorRate: 1})(http.DefaultTransport)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Errorf("GET: unexpected error: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	resp, err = client.Post(server.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Error("POST: expected error, got none")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, faults := range []Faults{
+		{Latency: -time.Second},
+		{ErrorRate: -0.1},
+		{ErrorRate: 1.1},
+	} {
+		if err := faults.Validate(); err == nil {
+			t.Errorf("%s: expected error, got none", faults)
+		}
+	}
+	if err := (Faults{Latency: time.Second, ErrorRate: 0.5}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -26,6 +26,18 @@ const (
 	// alpha: v0.4
 	// beta: v1.2
 	Topology featuregate.Feature = "Topology"
+
+	// alpha: v2.3
+	//
+	// Enables PVCs with a pre-provisioned VolumeSnapshotContent as data source.
+	VolumeSnapshotContentDataSource featuregate.Feature = "VolumeSnapshotContentDataSource"
+
+	// beta: v2.3
+	//
+	// Postpones deleting a volume while a VolumeAttachment exists for
+	// its PV. Can be disabled for drivers which safely delete attached
+	// volumes, to avoid the informer and the additional latency.
+	DeleteWaitForDetach featuregate.Feature = "DeleteWaitForDetach"
 )
 
 func init() {
@@ -35,5 +47,7 @@ func init() {
 // defaultKubernetesFeatureGates consists of all known feature keys specific to external-provisioner.
 // To add a new feature, define a key for it above and add it here.
 var defaultKubernetesFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
-	Topology: {Default: false, PreRelease: featuregate.GA},
+	Topology:                        {Default: false, PreRelease: featuregate.GA},
+	VolumeSnapshotContentDataSource: {Default: false, PreRelease: featuregate.Alpha},
+	DeleteWaitForDetach:             {Default: true, PreRelease: featuregate.Beta},
 }
@@ -0,0 +1,154 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jsonlog writes klog output as one JSON object per line. The
+// fields are the same as in the "json" logging format of Kubernetes
+// components: "ts" (milliseconds since the epoch), "v" (verbosity),
+// "msg", "err" for errors and then the key/value pairs of structured
+// log calls.
+//
+// klog does not pass on the severity of unstructured warnings and the
+// caller, so those are not included. Messages which span several
+// lines, for example gRPC errors, stay in a single line because the
+// line breaks get escaped.
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// missingValue is used by klog for the same purpose.
+const missingValue = "(MISSING)"
+
+type output struct {
+	mutex sync.Mutex
+	w     io.Writer
+	now   func() time.Time
+}
+
+type logger struct {
+	out    *output
+	v      int
+	name   string
+	values []interface{}
+}
+
+var _ logr.Logger = &logger{}
+
+// NewLogger returns a logger for klog.SetLogger which writes to w.
+func NewLogger(w io.Writer) logr.Logger {
+	return &logger{out: &output{w: w, now: time.Now}}
+}
+
+// Enabled always returns true because klog checks the verbosity before
+// calling the logger.
+func (l *logger) Enabled() bool {
+	return true
+}
+
+func (l *logger) Info(msg string, keysAndValues ...interface{}) {
+	l.write(false, nil, msg, keysAndValues)
+}
+
+// Error always adds "err", with null as value for unstructured klog
+// errors, which have no error value.
+func (l *logger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.write(true, err, msg, keysAndValues)
+}
+
+func (l *logger) V(level int) logr.Logger {
+	c := *l
+	c.v += level
+	return &c
+}
+
+func (l *logger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	c := *l
+	c.values = append(append([]interface{}{}, l.values...), keysAndValues...)
+	return &c
+}
+
+func (l *logger) WithName(name string) logr.Logger {
+	c := *l
+	if c.name != "" {
+		c.name += "/"
+	}
+	c.name += name
+	return &c
+}
+
+func (l *logger) write(isError bool, err error, msg string, keysAndValues []interface{}) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `{"ts":%.3f`, float64(l.out.now().UnixNano())/float64(time.Millisecond))
+	if l.name != "" {
+		b.WriteString(`,"logger":`)
+		writeValue(&b, l.name)
+	}
+	// Unstructured klog messages end with a newline.
+	b.WriteString(`,"msg":`)
+	writeValue(&b, strings.TrimSuffix(msg, "\n"))
+	fmt.Fprintf(&b, `,"v":%d`, l.v)
+	if isError {
+		b.WriteString(`,"err":`)
+		if err != nil {
+			writeValue(&b, err)
+		} else {
+			b.WriteString("null")
+		}
+	}
+	writeKeysAndValues(&b, l.values)
+	writeKeysAndValues(&b, keysAndValues)
+	b.WriteString("}\n")
+
+	l.out.mutex.Lock()
+	defer l.out.mutex.Unlock()
+	l.out.w.Write(b.Bytes())
+}
+
+func writeKeysAndValues(b *bytes.Buffer, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		b.WriteByte(',')
+		writeValue(b, fmt.Sprintf("%v", keysAndValues[i]))
+		b.WriteByte(':')
+		if i+1 < len(keysAndValues) {
+			writeValue(b, keysAndValues[i+1])
+		} else {
+			writeValue(b, missingValue)
+		}
+	}
+}
+
+func writeValue(b *bytes.Buffer, value interface{}) {
+	switch value.(type) {
+	case error, fmt.Stringer:
+		// For example klog.KObj, which then is rendered like
+		// in text output. fmt also copes with nil pointers.
+		value = fmt.Sprint(value)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	b.Write(data)
+}
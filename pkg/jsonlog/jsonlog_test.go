@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+func newTestLogger() (*logger, *bytes.Buffer) {
+	var buffer bytes.Buffer
+	l := NewLogger(&buffer).(*logger)
+	l.out.now = func() time.Time { return time.Unix(1600000000, 123456789) }
+	return l, &buffer
+}
+
+func TestLogger(t *testing.T) {
+	testcases := map[string]struct {
+		log    func(l *logger)
+		expect string
+	}{
+		"info": {
+			log:    func(l *logger) { l.Info("hello\n") },
+			expect: `{"ts":1600000000123.457,"msg":"hello","v":0}`,
+		},
+		"verbosity": {
+			log:    func(l *logger) { l.V(2).V(3).Info("hello") },
+			expect: `{"ts":1600000000123.457,"msg":"hello","v":5}`,
+		},
+		"multi-line": {
+			log:    func(l *logger) { l.Info("rpc error: code = Internal\ndesc = \"failed\"\n") },
+			expect: `{"ts":1600000000123.457,"msg":"rpc error: code = Internal\ndesc = \"failed\"","v":0}`,
+		},
+		"error": {
+			log:    func(l *logger) { l.Error(errors.New("timeout"), "CreateVolume failed", "pvc", "default/pvc-1") },
+			expect: `{"ts":1600000000123.457,"msg":"CreateVolume failed","v":0,"err":"timeout","pvc":"default/pvc-1"}`,
+		},
+		"error without value": {
+			log:    func(l *logger) { l.Error(nil, "failed") },
+			expect: `{"ts":1600000000123.457,"msg":"failed","v":0,"err":null}`,
+		},
+		"values": {
+			log: func(l *logger) {
+				l.WithName("capacity").WithValues("a", 1).WithName("worker").Info("done", "duration", 2*time.Second, "ok", true, "odd")
+			},
+			expect: `{"ts":1600000000123.457,"logger":"capacity/worker","msg":"done","v":0,"a":1,"duration":"2s","ok":true,"odd":"(MISSING)"}`,
+		},
+		"object reference": {
+			log: func(l *logger) {
+				l.Info("provisioning", "pvc", klog.KRef("default", "pvc-1"), "channel", make(chan int))
+			},
+			expect: `{"ts":1600000000123.457,"msg":"provisioning","v":0,"pvc":"default/pvc-1","channel":"`,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			l, buffer := newTestLogger()
+			tc.log(l)
+			output := buffer.String()
+			if !strings.HasPrefix(output, tc.expect) || !strings.HasSuffix(output, "}\n") || strings.Count(output, "\n") != 1 {
+				t.Errorf("expected %s, got %s", tc.expect, output)
+			}
+			var decoded map[string]interface{}
+			if err := json.Unmarshal(buffer.Bytes(), &decoded); err != nil {
+				t.Errorf("invalid JSON %q: %v", output, err)
+			}
+		})
+	}
+}
+
+func TestKlog(t *testing.T) {
+	l, buffer := newTestLogger()
+	klog.SetLogger(l)
+	defer klog.SetLogger(nil)
+
+	klog.Infof("provisioning %s", "pvc-1")
+	klog.Errorf("provisioning failed:\n%s", "quota exceeded")
+	klog.InfoS("provisioned", "pv", "pv-1")
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected three lines, got %q", buffer.String())
+	}
+	expected := []map[string]interface{}{
+		{"msg": "provisioning pvc-1"},
+		{"msg": "provisioning failed:\nquota exceeded", "err": nil},
+		{"msg": "provisioned", "pv": "pv-1"},
+	}
+	for i, line := range lines {
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("invalid JSON %q: %v", line, err)
+		}
+		for key, value := range expected[i] {
+			if actual, ok := decoded[key]; !ok || actual != value {
+				t.Errorf("line %d: expected %s=%v, got %s", i, key, value, line)
+			}
+		}
+	}
+}
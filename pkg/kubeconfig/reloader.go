@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kubeconfig reloads the credentials of a kubeconfig file while
+// the external-provisioner is running. Client certificate and token
+// files referenced by the kubeconfig are already reloaded by client-go,
+// but credentials embedded in the file itself, for example after
+// rotating client-certificate-data or changing the exec plugin, are not.
+package kubeconfig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+// Reloader is the transport of all clients created from the config
+// returned by NewConfig. It forwards requests to a transport with the
+// current credentials.
+type Reloader struct {
+	master string
+	path   string
+
+	// reloading is 1 while a check runs, to avoid piling up checks
+	// triggered by failed requests.
+	reloading int32
+
+	mutex     sync.RWMutex
+	content   []byte
+	host      string
+	transport http.RoundTripper
+}
+
+var _ http.RoundTripper = &Reloader{}
+
+// NewConfig loads the kubeconfig file like clientcmd.BuildConfigFromFlags.
+// Clients created from the returned config use the credentials from the
+// most recent version of the file once Run has detected a change.
+// The server cannot change without a restart.
+func NewConfig(master, path string) (*rest.Config, *Reloader, error) {
+	r := &Reloader{
+		master: master,
+		path:   path,
+	}
+	config, err := r.load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Authentication happens in the transport of the reloader,
+	// so the config itself must not have any credentials.
+	anonymous := rest.AnonymousClientConfig(config)
+	anonymous.WrapTransport = func(http.RoundTripper) http.RoundTripper { return r }
+	return anonymous, r, nil
+}
+
+// Run checks the file for changes once per interval until the context
+// is done.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	klog.Infof("Checking %s for changes every %s", r.path, interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) { r.check() }, interval)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Reloader) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mutex.RLock()
+	transport := r.transport
+	r.mutex.RUnlock()
+
+	resp, err := transport.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// The credentials might have been rotated on the server
+		// side before the periodic check noticed the new file.
+		go r.check()
+	}
+	return resp, err
+}
+
+func (r *Reloader) load() (*rest.Config, error) {
+	content, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := clientcmd.BuildConfigFromFlags(r.master, r.path)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("create transport: %v", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.host != "" && r.host != config.Host {
+		klog.Warningf("Server in %s changed from %s to %s, restart to use it", r.path, r.host, config.Host)
+	} else {
+		r.host = config.Host
+	}
+	r.content = content
+	r.transport = transport
+	return config, nil
+}
+
+func (r *Reloader) check() {
+	if !atomic.CompareAndSwapInt32(&r.reloading, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&r.reloading, 0)
+
+	content, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		klog.Warningf("Checking %s for changes failed: %v", r.path, err)
+		return
+	}
+	r.mutex.RLock()
+	unchanged := bytes.Equal(content, r.content)
+	r.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	klog.Infof("%s changed, reloading credentials", r.path)
+	if _, err := r.load(); err != nil {
+		// Keep using the old credentials, maybe the file
+		// was only partially written.
+		klog.Warningf("Reloading %s failed: %v", r.path, err)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+const kubeconfigTemplate = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+  name: test
+contexts:
+- context:
+    cluster: test
+    user: test
+  name: test
+current-context: test
+users:
+- name: test
+  user:
+    token: %s
+`
+
+func TestReloader(t *testing.T) {
+	var mutex sync.Mutex
+	var authorization string
+	// client-go only uses credentials for HTTPS servers.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	writeConfig := func(token string) {
+		content := fmt.Sprintf(kubeconfigTemplate, server.URL, token)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("write kubeconfig: %v", err)
+		}
+	}
+	writeConfig("old-token")
+
+	config, reloader, err := NewConfig("", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.BearerToken != "" {
+		t.Errorf("expected config without credentials, got token %q", config.BearerToken)
+	}
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &http.Client{Transport: transport}
+
+	expectAuthorization := func(expected string) {
+		t.Helper()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		mutex.Lock()
+		defer mutex.Unlock()
+		if authorization != expected {
+			t.Errorf("expected Authorization %q, got %q", expected, authorization)
+		}
+	}
+
+	expectAuthorization("Bearer old-token")
+
+	// Not reloaded yet.
+	writeConfig("new-token")
+	expectAuthorization("Bearer old-token")
+
+	reloader.check()
+	expectAuthorization("Bearer new-token")
+
+	// Invalid content is ignored.
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatalf("write kubeconfig: %v", err)
+	}
+	reloader.check()
+	expectAuthorization("Bearer new-token")
+}
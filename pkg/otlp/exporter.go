@@ -0,0 +1,349 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package otlp periodically pushes the metrics and traces of the
+// external-provisioner to an OpenTelemetry collector. It uses the JSON
+// encoding of OTLP over HTTP, which avoids depending on the
+// OpenTelemetry SDK.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/klog/v2"
+)
+
+// aggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+// Prometheus counters and histograms are always cumulative.
+const aggregationTemporalityCumulative = 2
+
+// Exporter sends all metrics of a gatherer to an OTLP/HTTP metrics
+// endpoint, for example http://otel-collector:4318/v1/metrics.
+type Exporter struct {
+	endpoint string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+	resource map[string]string
+	client   *http.Client
+	start    time.Time
+	now      func() time.Time
+}
+
+// NewExporter creates an exporter which pushes the metrics of the
+// gatherer once per interval. The resource attributes identify the
+// instance, for example with "service.name".
+func NewExporter(endpoint string, interval time.Duration, gatherer prometheus.Gatherer, resource map[string]string) *Exporter {
+	return &Exporter{
+		endpoint: endpoint,
+		interval: interval,
+		gatherer: gatherer,
+		resource: resource,
+		client:   &http.Client{Timeout: interval},
+		start:    time.Now(),
+		now:      time.Now,
+	}
+}
+
+// Run pushes metrics until the context is done. Failures are logged
+// and the next attempt happens after the normal interval.
+func (e *Exporter) Run(ctx context.Context) {
+	klog.Infof("Exporting metrics to %s every %s", e.endpoint, e.interval)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Export(ctx); err != nil {
+				klog.Warningf("Exporting metrics to %s failed: %v", e.endpoint, err)
+			}
+		}
+	}
+}
+
+// Export pushes the current metrics once.
+func (e *Exporter) Export(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could gather together with the error.
+		klog.Warningf("Gathering some metrics failed: %v", err)
+	}
+	body, err := json.Marshal(e.request(families))
+	if err != nil {
+		return fmt.Errorf("encode metrics: %v", err)
+	}
+	return post(ctx, e.client, e.endpoint, body)
+}
+
+// post sends one JSON encoded export request.
+func post(ctx context.Context, client *http.Client, endpoint string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, message)
+	}
+	return nil
+}
+
+// The following types are the subset of the OTLP JSON encoding that is
+// needed for Prometheus metrics. 64 bit integers are strings in that
+// encoding.
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type metric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Gauge       *gauge     `json:"gauge,omitempty"`
+	Sum         *sum       `json:"sum,omitempty"`
+	Histogram   *histogram `json:"histogram,omitempty"`
+	Summary     *summary   `json:"summary,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsDouble          float64    `json:"asDouble"`
+}
+
+type histogram struct {
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               float64    `json:"sum"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+}
+
+type summary struct {
+	DataPoints []summaryDataPoint `json:"dataPoints"`
+}
+
+type summaryDataPoint struct {
+	Attributes        []keyValue      `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	QuantileValues    []quantileValue `json:"quantileValues"`
+}
+
+type quantileValue struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+func (e *Exporter) request(families []*dto.MetricFamily) *exportRequest {
+	now := unixNano(e.now())
+	start := unixNano(e.start)
+	var metrics []metric
+	for _, family := range families {
+		if m := convert(family, start, now); m != nil {
+			metrics = append(metrics, *m)
+		}
+	}
+
+	return &exportRequest{
+		ResourceMetrics: []resourceMetrics{{
+			Resource: resource{Attributes: sortedAttributes(e.resource)},
+			ScopeMetrics: []scopeMetrics{{
+				Scope:   scope{Name: "external-provisioner"},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// convert returns nil for metric types which cannot be represented.
+func convert(family *dto.MetricFamily, start, now string) *metric {
+	m := &metric{
+		Name:        family.GetName(),
+		Description: family.GetHelp(),
+	}
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		m.Sum = &sum{
+			AggregationTemporality: aggregationTemporalityCumulative,
+			IsMonotonic:            true,
+		}
+		for _, sample := range family.GetMetric() {
+			m.Sum.DataPoints = append(m.Sum.DataPoints, numberDataPoint{
+				Attributes:        labels(sample),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				AsDouble:          sample.GetCounter().GetValue(),
+			})
+		}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		m.Gauge = &gauge{}
+		for _, sample := range family.GetMetric() {
+			value := sample.GetGauge().GetValue()
+			if family.GetType() == dto.MetricType_UNTYPED {
+				value = sample.GetUntyped().GetValue()
+			}
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, numberDataPoint{
+				Attributes:   labels(sample),
+				TimeUnixNano: now,
+				AsDouble:     value,
+			})
+		}
+	case dto.MetricType_HISTOGRAM:
+		m.Histogram = &histogram{
+			AggregationTemporality: aggregationTemporalityCumulative,
+		}
+		for _, sample := range family.GetMetric() {
+			h := sample.GetHistogram()
+			point := histogramDataPoint{
+				Attributes:        labels(sample),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+				Sum:               h.GetSampleSum(),
+				BucketCounts:      []string{},
+				ExplicitBounds:    []float64{},
+			}
+			// Prometheus buckets are cumulative, OTLP buckets are not.
+			var previous uint64
+			for _, bucket := range h.GetBucket() {
+				if math.IsInf(bucket.GetUpperBound(), +1) {
+					continue
+				}
+				point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+				point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(bucket.GetCumulativeCount()-previous, 10))
+				previous = bucket.GetCumulativeCount()
+			}
+			point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+			m.Histogram.DataPoints = append(m.Histogram.DataPoints, point)
+		}
+	case dto.MetricType_SUMMARY:
+		m.Summary = &summary{}
+		for _, sample := range family.GetMetric() {
+			s := sample.GetSummary()
+			point := summaryDataPoint{
+				Attributes:        labels(sample),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				Count:             strconv.FormatUint(s.GetSampleCount(), 10),
+				Sum:               s.GetSampleSum(),
+				QuantileValues:    []quantileValue{},
+			}
+			for _, quantile := range s.GetQuantile() {
+				point.QuantileValues = append(point.QuantileValues, quantileValue{
+					Quantile: quantile.GetQuantile(),
+					Value:    quantile.GetValue(),
+				})
+			}
+			m.Summary.DataPoints = append(m.Summary.DataPoints, point)
+		}
+	default:
+		return nil
+	}
+	return m
+}
+
+// sortedAttributes converts a map, sorted by key.
+func sortedAttributes(m map[string]string) []keyValue {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var attributes []keyValue
+	for _, key := range keys {
+		attributes = append(attributes, keyValue{Key: key, Value: anyValue{StringValue: m[key]}})
+	}
+	return attributes
+}
+
+func labels(sample *dto.Metric) []keyValue {
+	var attributes []keyValue
+	for _, label := range sample.GetLabel() {
+		attributes = append(attributes, keyValue{Key: label.GetName(), Value: anyValue{StringValue: label.GetValue()}})
+	}
+	return attributes
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
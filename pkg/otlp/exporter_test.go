@@ -0,0 +1,137 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestExport(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_total",
+		Help: "A counter.",
+	}, []string{"class"})
+	testHistogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "test_seconds",
+		Help:    "A histogram.",
+		Buckets: []float64{1, 10},
+	})
+	testGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "test_gauge",
+		Help: "A gauge.",
+	})
+	registry.MustRegister(counter, testHistogram, testGauge)
+	counter.WithLabelValues("fast").Add(3)
+	testHistogram.Observe(0.5)
+	testHistogram.Observe(5)
+	testHistogram.Observe(7)
+	testHistogram.Observe(100)
+	testGauge.Set(42)
+
+	var received exportRequest
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	exporter := NewExporter(server.URL, time.Minute, registry, map[string]string{"service.name": "csi-provisioner"})
+	exporter.start = time.Unix(100, 0)
+	exporter.now = func() time.Time { return time.Unix(200, 0) }
+	if err := exporter.Export(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if contentType != "application/json" {
+		t.Errorf("expected JSON content, got %q", contentType)
+	}
+	expected := exportRequest{
+		ResourceMetrics: []resourceMetrics{{
+			Resource: resource{Attributes: []keyValue{{Key: "service.name", Value: anyValue{StringValue: "csi-provisioner"}}}},
+			ScopeMetrics: []scopeMetrics{{
+				Scope: scope{Name: "external-provisioner"},
+				Metrics: []metric{
+					{
+						Name:        "test_gauge",
+						Description: "A gauge.",
+						Gauge: &gauge{
+							DataPoints: []numberDataPoint{{TimeUnixNano: "200000000000", AsDouble: 42}},
+						},
+					},
+					{
+						Name:        "test_seconds",
+						Description: "A histogram.",
+						Histogram: &histogram{
+							AggregationTemporality: aggregationTemporalityCumulative,
+							DataPoints: []histogramDataPoint{{
+								StartTimeUnixNano: "100000000000",
+								TimeUnixNano:      "200000000000",
+								Count:             "4",
+								Sum:               112.5,
+								BucketCounts:      []string{"1", "2", "1"},
+								ExplicitBounds:    []float64{1, 10},
+							}},
+						},
+					},
+					{
+						Name:        "test_total",
+						Description: "A counter.",
+						Sum: &sum{
+							AggregationTemporality: aggregationTemporalityCumulative,
+							IsMonotonic:            true,
+							DataPoints: []numberDataPoint{{
+								Attributes:        []keyValue{{Key: "class", Value: anyValue{StringValue: "fast"}}},
+								StartTimeUnixNano: "100000000000",
+								TimeUnixNano:      "200000000000",
+								AsDouble:          3,
+							}},
+						},
+					},
+				},
+			}},
+		}},
+	}
+	if !reflect.DeepEqual(received, expected) {
+		expectedJSON, _ := json.MarshalIndent(expected, "", "  ")
+		receivedJSON, _ := json.MarshalIndent(received, "", "  ")
+		t.Errorf("expected:\n%s\ngot:\n%s", expectedJSON, receivedJSON)
+	}
+}
+
+func TestExportFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such endpoint", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	exporter := NewExporter(server.URL, time.Minute, prometheus.NewRegistry(), nil)
+	if err := exporter.Export(context.Background()); err == nil {
+		t.Error("expected error, got none")
+	}
+}
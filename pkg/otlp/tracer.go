@@ -0,0 +1,390 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maxQueuedSpans limits the memory used for spans when the
+	// collector is not reachable. Further spans get dropped.
+	maxQueuedSpans = 10000
+
+	// traceparentHeader is the W3C trace context header, used both
+	// for HTTP and gRPC metadata.
+	traceparentHeader = "traceparent"
+
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	statusCodeError = 2
+)
+
+// Tracer collects finished spans and sends them once per interval to
+// an OTLP/HTTP traces endpoint, for example
+// http://otel-collector:4318/v1/traces.
+type Tracer struct {
+	endpoint      string
+	interval      time.Duration
+	samplingRatio float64
+	resource      map[string]string
+	client        *http.Client
+	now           func() time.Time
+	random        func() float64
+
+	mutex   sync.Mutex
+	queue   []spanData
+	dropped int
+}
+
+// NewTracer creates a tracer which records the given fraction of the
+// traces, between 0 and 1. The resource attributes identify the
+// instance, for example with "service.name".
+func NewTracer(endpoint string, interval time.Duration, samplingRatio float64, resource map[string]string) *Tracer {
+	return &Tracer{
+		endpoint:      endpoint,
+		interval:      interval,
+		samplingRatio: samplingRatio,
+		resource:      resource,
+		client:        &http.Client{Timeout: interval},
+		now:           time.Now,
+		random:        mathrand.Float64,
+	}
+}
+
+// Span is one operation in a trace. All methods can be called for a
+// nil span and then do nothing, so code does not need to check whether
+// tracing is enabled.
+type Span struct {
+	tracer   *Tracer
+	sampled  bool
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mutex      sync.Mutex
+	attributes []keyValue
+	err        string
+	ended      bool
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the current span, nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Start begins a new trace, unless the context already has a span.
+// Then the new span becomes a child of that one.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		return Start(ctx, name)
+	}
+	span := &Span{
+		tracer:  t,
+		sampled: t.random() < t.samplingRatio,
+		name:    name,
+		kind:    spanKindInternal,
+		start:   t.now(),
+	}
+	randomID(span.traceID[:])
+	randomID(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Start creates a child of the span in the context. Without such a
+// span, the result is nil.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return startChild(ctx, name, spanKindInternal, time.Time{})
+}
+
+// StartAt is like Start for an operation that already began earlier,
+// for example while an object was waiting to be processed.
+func StartAt(ctx context.Context, name string, start time.Time) (context.Context, *Span) {
+	return startChild(ctx, name, spanKindInternal, start)
+}
+
+func startChild(ctx context.Context, name string, kind int, start time.Time) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	if start.IsZero() {
+		start = parent.tracer.now()
+	}
+	span := &Span{
+		tracer:   parent.tracer,
+		sampled:  parent.sampled,
+		traceID:  parent.traceID,
+		parentID: parent.spanID,
+		name:     name,
+		kind:     kind,
+		start:    start,
+	}
+	randomID(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SetAttribute adds a string attribute.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ended {
+		return
+	}
+	s.attributes = append(s.attributes, keyValue{Key: key, Value: anyValue{StringValue: value}})
+}
+
+// SetError marks the span as failed. A nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil || !s.sampled {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err.Error()
+}
+
+// End finishes the span. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	end := s.tracer.now()
+	data := spanData{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(end),
+		Attributes:        s.attributes,
+	}
+	if s.parentID != ([8]byte{}) {
+		data.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != "" {
+		data.Status = spanStatus{Code: statusCodeError, Message: s.err}
+	}
+	s.mutex.Unlock()
+	s.tracer.add(data)
+}
+
+// Traceparent returns the W3C trace context for requests made as part
+// of the span, the empty string for a nil span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]), flags)
+}
+
+func randomID(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		// Not expected to happen, fall back to the
+		// non-cryptographic generator.
+		mathrand.Read(id)
+	}
+}
+
+func (t *Tracer) add(data spanData) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.queue) >= maxQueuedSpans {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, data)
+}
+
+// Run sends spans until the context is done. Failures are logged and
+// the spans which could not be sent get dropped.
+func (t *Tracer) Run(ctx context.Context) {
+	klog.Infof("Exporting traces to %s every %s", t.endpoint, t.interval)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Export(ctx); err != nil {
+				klog.Warningf("Exporting traces to %s failed: %v", t.endpoint, err)
+			}
+		}
+	}
+}
+
+// Export sends the spans which were finished since the last call.
+func (t *Tracer) Export(ctx context.Context) error {
+	t.mutex.Lock()
+	spans, dropped := t.queue, t.dropped
+	t.queue, t.dropped = nil, 0
+	t.mutex.Unlock()
+
+	if dropped > 0 {
+		klog.Warningf("Dropped %d spans because more than %d were waiting to be exported", dropped, maxQueuedSpans)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(&traceRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{Attributes: sortedAttributes(t.resource)},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: "external-provisioner"},
+				Spans: spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("encode spans: %v", err)
+	}
+	return post(ctx, t.client, t.endpoint, body)
+}
+
+// WrapConn returns a connection which records a span for each CSI call
+// that is made as part of another span and passes the trace context to
+// the driver in the gRPC metadata.
+func WrapConn(conn grpc.ClientConnInterface) grpc.ClientConnInterface {
+	return &tracingConn{ClientConnInterface: conn}
+}
+
+type tracingConn struct {
+	grpc.ClientConnInterface
+}
+
+func (c *tracingConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	ctx, span := startChild(ctx, method, spanKindClient, time.Time{})
+	if span == nil {
+		return c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+	}
+	defer span.End()
+	span.SetAttribute("rpc.system", "grpc")
+	ctx = metadata.AppendToOutgoingContext(ctx, traceparentHeader, span.Traceparent())
+	err := c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+	span.SetAttribute("rpc.grpc.status_code", strconv.Itoa(int(status.Code(err))))
+	span.SetError(err)
+	return err
+}
+
+// WrapTransport returns a function for rest.Config.WrapTransport which
+// records a span for each Kubernetes API request that is made as part
+// of another span and passes the trace context to the API server in
+// the traceparent header.
+func WrapTransport() func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &tracingTransport{rt: rt}
+	}
+}
+
+type tracingTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := startChild(req.Context(), req.Method+" "+req.URL.Path, spanKindClient, time.Time{})
+	if span == nil {
+		return t.rt.RoundTrip(req)
+	}
+	defer span.End()
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.target", req.URL.RequestURI())
+	// A RoundTripper must not modify the original request.
+	req = req.Clone(ctx)
+	req.Header.Set(traceparentHeader, span.Traceparent())
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetError(fmt.Errorf("%s", resp.Status))
+	}
+	return resp, nil
+}
+
+// The following types are the subset of the OTLP JSON encoding that is
+// needed for spans. Trace and span IDs are hex encoded.
+
+type traceRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanData `json:"spans"`
+}
+
+type spanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            spanStatus `json:"status"`
+}
+
+type spanStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var traceparentRE = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-(0[01])$`)
+
+func newTestTracer(t *testing.T, samplingRatio float64) (*Tracer, func() []spanData) {
+	var received []traceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request traceRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		received = append(received, request)
+	}))
+	t.Cleanup(server.Close)
+
+	tracer := NewTracer(server.URL, time.Minute, samplingRatio, map[string]string{"service.name": "csi-provisioner"})
+	seconds := int64(100)
+	tracer.now = func() time.Time {
+		seconds++
+		return time.Unix(seconds, 0)
+	}
+	tracer.random = func() float64 { return 0.5 }
+
+	export := func() []spanData {
+		received = nil
+		if err := tracer.Export(context.Background()); err != nil {
+			t.Fatalf("export: %v", err)
+		}
+		var spans []spanData
+		for _, request := range received {
+			for _, resourceSpans := range request.ResourceSpans {
+				if len(resourceSpans.Resource.Attributes) != 1 || resourceSpans.Resource.Attributes[0].Key != "service.name" {
+					t.Errorf("unexpected resource: %+v", resourceSpans.Resource)
+				}
+				for _, scopeSpans := range resourceSpans.ScopeSpans {
+					spans = append(spans, scopeSpans.Spans...)
+				}
+			}
+		}
+		return spans
+	}
+	return tracer, export
+}
+
+func TestTracer(t *testing.T) {
+	tracer, export := newTestTracer(t, 1)
+	ctx, root := tracer.Start(context.Background(), "Provision")
+	root.SetAttribute("k8s.pvc.name", "pvc-1")
+	_, child := Start(ctx, "topology")
+	child.SetError(errors.New("no nodes"))
+	child.End()
+	_, earlier := StartAt(ctx, "wait", time.Unix(50, 0))
+	earlier.End()
+	root.End()
+	root.End()
+	root.SetAttribute("ignored", "after end")
+
+	spans := export()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %+v", spans)
+	}
+	topology, wait, provision := spans[0], spans[1], spans[2]
+	if provision.Name != "Provision" || provision.ParentSpanID != "" || provision.Kind != spanKindInternal {
+		t.Errorf("unexpected root span: %+v", provision)
+	}
+	if len(provision.Attributes) != 1 || provision.Attributes[0].Value.StringValue != "pvc-1" {
+		t.Errorf("unexpected root attributes: %+v", provision.Attributes)
+	}
+	if topology.Name != "topology" || topology.TraceID != provision.TraceID || topology.ParentSpanID != provision.SpanID {
+		t.Errorf("unexpected child span: %+v", topology)
+	}
+	if topology.Status.Code != statusCodeError || topology.Status.Message != "no nodes" {
+		t.Errorf("unexpected child status: %+v", topology.Status)
+	}
+	if wait.StartTimeUnixNano != "50000000000" {
+		t.Errorf("unexpected start of wait span: %s", wait.StartTimeUnixNano)
+	}
+	if m := traceparentRE.FindStringSubmatch(root.Traceparent()); m == nil || m[1] != provision.TraceID || m[2] != provision.SpanID || m[3] != "01" {
+		t.Errorf("unexpected traceparent %q", root.Traceparent())
+	}
+
+	if spans := export(); len(spans) != 0 {
+		t.Errorf("expected no spans in second export, got %+v", spans)
+	}
+}
+
+func TestTracerNotSampled(t *testing.T) {
+	tracer, export := newTestTracer(t, 0.1)
+	ctx, root := tracer.Start(context.Background(), "Provision")
+	_, child := Start(ctx, "topology")
+	child.End()
+	root.End()
+
+	if spans := export(); len(spans) != 0 {
+		t.Errorf("expected no spans, got %+v", spans)
+	}
+	if m := traceparentRE.FindStringSubmatch(child.Traceparent()); m == nil || m[3] != "00" {
+		t.Errorf("expected traceparent without sampled flag, got %q", child.Traceparent())
+	}
+}
+
+func TestNoSpan(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "Provision")
+	if span != nil {
+		t.Fatalf("expected nil span, got %+v", span)
+	}
+	_, child := Start(ctx, "topology")
+	child.SetAttribute("key", "value")
+	child.SetError(errors.New("failed"))
+	child.End()
+	if child != nil || child.Traceparent() != "" {
+		t.Errorf("expected nil child span, got %+v", child)
+	}
+}
+
+type fakeConn struct {
+	grpc.ClientConnInterface
+	md metadata.MD
+}
+
+func (c *fakeConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	c.md, _ = metadata.FromOutgoingContext(ctx)
+	return nil
+}
+
+func TestWrapConn(t *testing.T) {
+	tracer, export := newTestTracer(t, 1)
+	inner := &fakeConn{}
+	conn := WrapConn(inner)
+
+	if err := conn.Invoke(context.Background(), "/csi.v1.Controller/GetCapacity", nil, nil); err != nil {
+		t.Fatalf("invoke without span: %v", err)
+	}
+	if len(inner.md.Get(traceparentHeader)) != 0 {
+		t.Errorf("unexpected trace context without span: %v", inner.md)
+	}
+
+	ctx, root := tracer.Start(context.Background(), "Provision")
+	if err := conn.Invoke(ctx, "/csi.v1.Controller/CreateVolume", nil, nil); err != nil {
+		t.Fatalf("invoke: %v", err)
+	}
+	root.End()
+	spans := export()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+	call := spans[0]
+	if call.Name != "/csi.v1.Controller/CreateVolume" || call.Kind != spanKindClient || call.ParentSpanID != spans[1].SpanID {
+		t.Errorf("unexpected span for call: %+v", call)
+	}
+	traceparent := inner.md.Get(traceparentHeader)
+	if len(traceparent) != 1 {
+		t.Fatalf("expected one traceparent, got %v", inner.md)
+	}
+	if m := traceparentRE.FindStringSubmatch(traceparent[0]); m == nil || m[1] != call.TraceID || m[2] != call.SpanID {
+		t.Errorf("traceparent %q does not match span %+v", traceparent[0], call)
+	}
+}
+
+func TestWrapTransport(t *testing.T) {
+	tracer, export := newTestTracer(t, 1)
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(traceparentHeader)
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: WrapTransport()(http.DefaultTransport)}
+
+	ctx, root := tracer.Start(context.Background(), "Provision")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/api/v1/persistentvolumes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if req.Header.Get(traceparentHeader) != "" {
+		t.Error("original request was modified")
+	}
+	root.End()
+
+	spans := export()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+	request := spans[0]
+	if request.Name != "POST /api/v1/persistentvolumes" || request.Status.Code != statusCodeError {
+		t.Errorf("unexpected span for request: %+v", request)
+	}
+	if m := traceparentRE.FindStringSubmatch(traceparent); m == nil || m[2] != request.SpanID {
+		t.Errorf("traceparent %q does not match span %+v", traceparent, request)
+	}
+}
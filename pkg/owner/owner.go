@@ -23,6 +23,7 @@ package owner
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -97,3 +98,83 @@ func lookupRecursive(c client.Client, namespace, name, group, version, kind stri
 	return nil, fmt.Errorf("%s/%s %q in namespace %q has no controlling owner, cannot unwind the ownership further",
 		apiVersion, kind, name, namespace)
 }
+
+// maxKindLevels limits how far LookupKind walks up the ownership chain.
+const maxKindLevels = 10
+
+// LookupKind walks up the ownership chain until it finds an object of the
+// given group and kind and returns an OwnerReference for it. This works
+// for arbitrary kinds, for example a custom resource of an operator which
+// owns the Deployment of the external-provisioner, without knowing how
+// many levels are in between. The object identified by name, namespace
+// and type is the starting point and is returned when it already has the
+// desired kind.
+func LookupKind(config *rest.Config, namespace, name string, gkv schema.GroupVersionKind, target schema.GroupKind) (*metav1.OwnerReference, error) {
+	c, err := client.New(config, client.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("build client: %v", err)
+	}
+
+	return lookupKindRecursive(c, namespace, name, gkv.Group, gkv.Version, gkv.Kind, target, maxKindLevels)
+}
+
+func lookupKindRecursive(c client.Client, namespace, name, group, version, kind string, target schema.GroupKind, levels int) (*metav1.OwnerReference, error) {
+	if group == target.Group && kind == target.Kind {
+		return lookupRecursive(c, namespace, name, group, version, kind, 0)
+	}
+	if levels == 0 {
+		return nil, fmt.Errorf("no %s found within %d levels of the ownership chain", target, maxKindLevels)
+	}
+
+	u := &unstructured.Unstructured{}
+	apiVersion := metav1.GroupVersion{Group: group, Version: version}.String()
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	if err := c.Get(context.Background(), client.ObjectKey{
+		Namespace: namespace,
+		Name:      name,
+	}, u); err != nil {
+		return nil, fmt.Errorf("get object: %v", err)
+	}
+
+	for _, owner := range u.GetOwnerReferences() {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("parse OwnerReference.APIVersion: %v", err)
+		}
+		if gv.Group == target.Group && owner.Kind == target.Kind {
+			// As in lookupRecursive, the owner itself doesn't
+			// need to be retrieved.
+			isTrue := true
+			return &metav1.OwnerReference{
+				APIVersion: owner.APIVersion,
+				Kind:       owner.Kind,
+				Name:       owner.Name,
+				UID:        owner.UID,
+				Controller: &isTrue,
+			}, nil
+		}
+		return lookupKindRecursive(c, namespace, owner.Name,
+			gv.Group, gv.Version, owner.Kind,
+			target, levels-1)
+	}
+	return nil, fmt.Errorf("%s/%s %q in namespace %q has no controlling owner, no %s found in the ownership chain",
+		apiVersion, kind, name, namespace, target)
+}
+
+// ParseGroupVersionKind parses <group>/<version>/<kind>, or <version>/<kind>
+// for the core group, for example "example.com/v1/StorageCluster" or "v1/Pod".
+func ParseGroupVersionKind(value string) (schema.GroupVersionKind, error) {
+	i := strings.LastIndex(value, "/")
+	if i <= 0 || i == len(value)-1 {
+		return schema.GroupVersionKind{}, fmt.Errorf("%q is not in <group>/<version>/<kind> format", value)
+	}
+	gv, err := schema.ParseGroupVersion(value[:i])
+	if err != nil {
+		return schema.GroupVersionKind{}, fmt.Errorf("%q: %v", value, err)
+	}
+	return gv.WithKind(value[i+1:]), nil
+}
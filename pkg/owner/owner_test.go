@@ -51,6 +51,11 @@ var (
 		Version: "v1",
 		Kind:    "Pod",
 	}
+	customGkv = schema.GroupVersionKind{
+		Group:   "example.com",
+		Version: "v1",
+		Kind:    "StorageCluster",
+	}
 
 	pod                  = makeObject(testNamespace, "foo", podGkv, nil)
 	statefulset          = makeObject(testNamespace, "foo", statefulsetGkv, nil)
@@ -60,6 +65,10 @@ var (
 	otherReplicaset      = makeObject(testNamespace, "bar", replicasetGkv, &deployment)
 	yetAnotherReplicaset = makeObject(otherNamespace, "foo", replicasetGkv, &deployment)
 	deploymentsetPod     = makeObject(testNamespace, "foo", podGkv, &replicaset)
+	custom               = makeObject(testNamespace, "cluster", customGkv, nil)
+	customDeployment     = makeObject(testNamespace, "foo", deploymentGkv, &custom)
+	customReplicaset     = makeObject(testNamespace, "foo", replicasetGkv, &customDeployment)
+	customPod            = makeObject(testNamespace, "foo", podGkv, &customReplicaset)
 )
 
 // TestNodeTopology checks that node labels are correctly transformed
@@ -169,6 +178,97 @@ func TestNodeTopology(t *testing.T) {
 	}
 }
 
+func TestLookupKind(t *testing.T) {
+	testcases := map[string]struct {
+		objects     []runtime.Object
+		start       unstructured.Unstructured
+		target      schema.GroupKind
+		expectError bool
+		expectOwner unstructured.Unstructured
+	}{
+		"start-object": {
+			objects:     []runtime.Object{&pod},
+			start:       pod,
+			target:      podGkv.GroupKind(),
+			expectOwner: pod,
+		},
+		"custom-owner": {
+			objects: []runtime.Object{&customPod, &customReplicaset, &customDeployment},
+			start:   customPod,
+			target:  customGkv.GroupKind(),
+			// The object doesn't have to exist.
+			expectOwner: custom,
+		},
+		"deployment": {
+			objects:     []runtime.Object{&customPod, &customReplicaset},
+			start:       customPod,
+			target:      deploymentGkv.GroupKind(),
+			expectOwner: customDeployment,
+		},
+		"not-found": {
+			objects:     []runtime.Object{&deploymentsetPod, &replicaset, &deployment},
+			start:       deploymentsetPod,
+			target:      customGkv.GroupKind(),
+			expectError: true,
+		},
+		"missing-parent": {
+			objects:     []runtime.Object{&customPod},
+			start:       customPod,
+			target:      customGkv.GroupKind(),
+			expectError: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			c := fake.NewFakeClient(tc.objects...)
+			gkv := tc.start.GroupVersionKind()
+			ownerRef, err := lookupKindRecursive(c,
+				tc.start.GetNamespace(),
+				tc.start.GetName(),
+				gkv.Group,
+				gkv.Version,
+				gkv.Kind,
+				tc.target,
+				maxKindLevels)
+			if err != nil && !tc.expectError {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && tc.expectError {
+				t.Fatal("unexpected success")
+			}
+			if err == nil {
+				if ownerRef.Kind != tc.expectOwner.GetKind() || ownerRef.Name != tc.expectOwner.GetName() ||
+					ownerRef.UID != tc.expectOwner.GetUID() || ownerRef.APIVersion != tc.expectOwner.GetAPIVersion() {
+					t.Errorf("expected %s %s/%s with UID %s, got %+v",
+						tc.expectOwner.GetAPIVersion(), tc.expectOwner.GetKind(), tc.expectOwner.GetName(), tc.expectOwner.GetUID(), ownerRef)
+				}
+			}
+		})
+	}
+}
+
+func TestParseGroupVersionKind(t *testing.T) {
+	for value, expected := range map[string]schema.GroupVersionKind{
+		"example.com/v1/StorageCluster": customGkv,
+		"apps/v1/Deployment":            deploymentGkv,
+		"v1/Pod":                        podGkv,
+	} {
+		gvk, err := ParseGroupVersionKind(value)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", value, err)
+		} else if gvk != expected {
+			t.Errorf("%s: expected %v, got %v", value, expected, gvk)
+		}
+	}
+	for _, value := range []string{"Pod", "v1/", "/Pod", "a/b/c/Pod"} {
+		if gvk, err := ParseGroupVersionKind(value); err == nil {
+			t.Errorf("%s: expected error, got %v", value, gvk)
+		}
+	}
+}
+
 var uidCounter int
 
 func makeObject(namespace, name string, gkv schema.GroupVersionKind, owner *unstructured.Unstructured) unstructured.Unstructured {
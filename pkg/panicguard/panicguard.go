@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package panicguard keeps a panic while processing one work queue item
+// from crashing the whole external-provisioner. A single malformed
+// object that triggers a bug would otherwise put the process into a
+// crash loop, because the object is still there after the restart, and
+// block all other work.
+//
+// The panic gets logged with a stack trace and counted, and the item
+// gets retried with the usual backoff of its work queue.
+package panicguard
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+var panics = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name:           "workqueue_worker_panics_total",
+		Help:           "Number of panics that were recovered while processing a work queue item, by work queue.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"name"},
+)
+
+func init() {
+	legacyregistry.MustRegister(panics)
+}
+
+// Recover must be deferred by a worker directly after taking the item
+// from the queue, after deferring Done. After a panic it requeues the
+// item with rate limiting. The worker function should use a named
+// result to tell its caller to continue with the next item, because
+// a recovered function returns the current values of its results.
+func Recover(queue workqueue.RateLimitingInterface, name string, item interface{}) {
+	if r := recover(); r != nil {
+		handle(name, item, r)
+		queue.AddRateLimited(item)
+	}
+}
+
+// Call invokes f and returns a panic in it as error. It is meant for
+// code whose caller retries after errors, like the provisioning
+// and deletion of volumes in the provisioner library.
+func Call(name string, item interface{}, f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			handle(name, item, r)
+			err = fmt.Errorf("recovered from panic: %v", r)
+		}
+	}()
+	f()
+	return nil
+}
+
+func handle(name string, item interface{}, r interface{}) {
+	panics.WithLabelValues(name).Inc()
+	klog.Errorf("Recovered from panic while processing %v from work queue %s: %v\n%s", item, name, r, debug.Stack())
+}
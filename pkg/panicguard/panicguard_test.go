@@ -0,0 +1,72 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package panicguard
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestRecover(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond))
+	defer queue.ShutDown()
+	queue.Add("item")
+
+	process := func(fail bool) (more bool) {
+		item, shutdown := queue.Get()
+		if shutdown {
+			return false
+		}
+		more = true
+		defer queue.Done(item)
+		defer Recover(queue, "test", item)
+		if fail {
+			panic("malformed object")
+		}
+		queue.Forget(item)
+		return
+	}
+
+	if !process(true) {
+		t.Fatal("expected worker to continue after panic")
+	}
+	if requeues := queue.NumRequeues("item"); requeues != 1 {
+		t.Fatalf("expected one requeue, got %d", requeues)
+	}
+	// Blocks until the item is back after the delay.
+	if !process(false) {
+		t.Fatal("expected worker to continue")
+	}
+	if length := queue.Len(); length != 0 {
+		t.Fatalf("expected empty queue, got %d items", length)
+	}
+}
+
+func TestCall(t *testing.T) {
+	if err := Call("test", "item", func() {}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := Call("test", "item", func() {
+		var m map[string]string
+		m["key"] = "value"
+	})
+	if err == nil {
+		t.Fatal("expected error after panic")
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resourceqps rate limits Kubernetes API requests separately
+// for each resource. That way, for example, relisting all nodes cannot
+// use up the budget that is needed for creating PVs.
+package resourceqps
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// leaseResource is exempt from the default limit because renewing the
+// leader election lease must not get delayed by other requests.
+const leaseResource = "leases"
+
+// Limit is the QPS and burst for one resource.
+type Limit struct {
+	QPS   float32
+	Burst int
+}
+
+// ParseLimits parses <resource>=<qps>[:<burst>] entries. Without a
+// burst, it is twice the QPS.
+func ParseLimits(values map[string]string) (map[string]Limit, error) {
+	limits := map[string]Limit{}
+	for resource, value := range values {
+		qpsValue, burstValue := value, ""
+		if i := strings.Index(value, ":"); i >= 0 {
+			qpsValue, burstValue = value[:i], value[i+1:]
+		}
+		qps, err := strconv.ParseFloat(qpsValue, 32)
+		if err != nil || qps <= 0 {
+			return nil, fmt.Errorf("%s: QPS must be a positive number, got %q", resource, qpsValue)
+		}
+		limit := Limit{QPS: float32(qps), Burst: int(2*qps + 0.5)}
+		if burstValue != "" {
+			limit.Burst, err = strconv.Atoi(burstValue)
+			if err != nil || limit.Burst <= 0 {
+				return nil, fmt.Errorf("%s: burst must be a positive integer, got %q", resource, burstValue)
+			}
+		}
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+		limits[resource] = limit
+	}
+	return limits, nil
+}
+
+// WrapTransport returns a function for rest.Config.Wrap which waits
+// for the rate limiter of the resource of each request. Resources
+// without their own limit share the default limiter. The rate limiter
+// of the rest.Config must be disabled, otherwise all requests are also
+// subject to that limit.
+func WrapTransport(limits map[string]Limit, defaultLimiter flowcontrol.RateLimiter) func(http.RoundTripper) http.RoundTripper {
+	limiters := map[string]flowcontrol.RateLimiter{}
+	for resource, limit := range limits {
+		limiters[resource] = flowcontrol.NewTokenBucketRateLimiter(limit.QPS, limit.Burst)
+	}
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &limitingTransport{
+			rt:             rt,
+			limiters:       limiters,
+			defaultLimiter: defaultLimiter,
+		}
+	}
+}
+
+type limitingTransport struct {
+	rt             http.RoundTripper
+	limiters       map[string]flowcontrol.RateLimiter
+	defaultLimiter flowcontrol.RateLimiter
+}
+
+func (t *limitingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resource := Resource(req.URL.Path)
+	limiter, ok := t.limiters[resource]
+	if !ok && resource != leaseResource {
+		limiter = t.defaultLimiter
+	}
+	if limiter != nil {
+		if err := limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// Resource returns the resource of a Kubernetes API path, for example
+// "persistentvolumeclaims" for
+// /api/v1/namespaces/default/persistentvolumeclaims/my-pvc. Subresources
+// are ignored. For paths which don't refer to a resource, it returns an
+// empty string.
+func Resource(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return ""
+	}
+	if parts[0] == "namespaces" && len(parts) >= 3 {
+		// Namespaced resource, not the namespace itself.
+		return parts[2]
+	}
+	return parts[0]
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourceqps
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResource(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/api/v1/nodes":              "nodes",
+		"/api/v1/nodes/foo":          "nodes",
+		"/api/v1/namespaces":         "namespaces",
+		"/api/v1/namespaces/default": "namespaces",
+		"/api/v1/namespaces/default/persistentvolumeclaims":               "persistentvolumeclaims",
+		"/api/v1/namespaces/default/persistentvolumeclaims/pvc/status":    "persistentvolumeclaims",
+		"/apis/storage.k8s.io/v1/storageclasses":                          "storageclasses",
+		"/apis/coordination.k8s.io/v1/namespaces/kube-system/leases/lock": "leases",
+		"/apis/storage.k8s.io/v1":                                         "",
+		"/api/v1":                                                         "",
+		"/version":                                                        "",
+	} {
+		if resource := Resource(path); resource != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, resource)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	testcases := map[string]struct {
+		values      map[string]string
+		expected    map[string]Limit
+		expectError bool
+	}{
+		"default burst": {
+			values:   map[string]string{"nodes": "1", "persistentvolumes": "0.2"},
+			expected: map[string]Limit{"nodes": {QPS: 1, Burst: 2}, "persistentvolumes": {QPS: 0.2, Burst: 1}},
+		},
+		"burst": {
+			values:   map[string]string{"nodes": "1.5:3"},
+			expected: map[string]Limit{"nodes": {QPS: 1.5, Burst: 3}},
+		},
+		"invalid QPS": {
+			values:      map[string]string{"nodes": "x"},
+			expectError: true,
+		},
+		"zero QPS": {
+			values:      map[string]string{"nodes": "0:1"},
+			expectError: true,
+		},
+		"invalid burst": {
+			values:      map[string]string{"nodes": "1:0"},
+			expectError: true,
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			limits, err := ParseLimits(tc.values)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %v", limits)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(limits, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, limits)
+			}
+		})
+	}
+}
+
+// countingLimiter rejects all requests and counts them.
+type countingLimiter struct {
+	waits int
+}
+
+func (l *countingLimiter) TryAccept() bool { return false }
+func (l *countingLimiter) Accept()         {}
+func (l *countingLimiter) Stop()           {}
+func (l *countingLimiter) QPS() float32    { return 0 }
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	l.waits++
+	return context.Canceled
+}
+
+type okTransport struct{}
+
+func (okTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK}, nil
+}
+
+func TestWrapTransport(t *testing.T) {
+	defaultLimiter := &countingLimiter{}
+	rt := WrapTransport(map[string]Limit{"nodes": {QPS: 1000, Burst: 1000}}, defaultLimiter)(okTransport{})
+
+	for path, expectDefault := range map[string]bool{
+		"/api/v1/nodes": false,
+		"/apis/coordination.k8s.io/v1/namespaces/kube-system/leases/lock": false,
+		"/api/v1/persistentvolumes":                                       true,
+	} {
+		defaultLimiter.waits = 0
+		_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, path, nil))
+		if expectDefault {
+			if err == nil || defaultLimiter.waits != 1 {
+				t.Errorf("%s: expected request to be limited by default limiter", path)
+			}
+		} else if err != nil || defaultLimiter.waits != 0 {
+			t.Errorf("%s: expected request to bypass default limiter, got error %v", path, err)
+		}
+	}
+}
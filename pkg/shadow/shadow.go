@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package shadow keeps an external-provisioner instance from changing
+// anything. Such an instance processes the same PVCs and PVs as the
+// active one and logs which CSI calls and Kubernetes API writes it
+// would have made. This can be used to compare a new version of the
+// sidecar against the running one before rolling it out.
+package shadow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	targetCSI = "csi"
+	targetAPI = "api"
+)
+
+var suppressedCalls = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name:           "shadow_mode_suppressed_calls_total",
+		Help:           "Number of CSI calls and Kubernetes API writes that were logged instead of being made because of --shadow-mode, by target and method.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"target", "method"},
+)
+
+func init() {
+	legacyregistry.MustRegister(suppressedCalls)
+}
+
+// readOnlyCSIMethods are the CSI calls which get passed on to the
+// driver because they don't change anything.
+var readOnlyCSIMethods = sets.NewString(
+	"GetPluginInfo",
+	"GetPluginCapabilities",
+	"Probe",
+	"ControllerGetCapabilities",
+	"ValidateVolumeCapabilities",
+	"ListVolumes",
+	"GetCapacity",
+	"ListSnapshots",
+	"ControllerGetVolume",
+	"NodeGetInfo",
+	"NodeGetCapabilities",
+)
+
+// WrapConn returns a connection which only passes on CSI calls that
+// don't modify volumes or snapshots. All other calls get logged and
+// fail with the Unavailable code, so provisioning and deleting get
+// retried with the usual backoff.
+func WrapConn(conn grpc.ClientConnInterface) grpc.ClientConnInterface {
+	return &shadowConn{ClientConnInterface: conn}
+}
+
+type shadowConn struct {
+	grpc.ClientConnInterface
+}
+
+func (c *shadowConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	name := path.Base(method)
+	if readOnlyCSIMethods.Has(name) {
+		return c.ClientConnInterface.Invoke(ctx, method, args, reply, opts...)
+	}
+	suppressedCalls.WithLabelValues(targetCSI, name).Inc()
+	klog.Infof("Shadow mode: would call %s with %s", method, protosanitizer.StripSecrets(args))
+	return status.Errorf(codes.Unavailable, "shadow mode: %s was not sent to the driver", method)
+}
+
+// WrapTransport returns a function for rest.Config.WrapTransport which
+// only passes on requests that read objects. All other requests get
+// logged and fail like a connection failure.
+func WrapTransport() func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &shadowTransport{rt: rt}
+	}
+}
+
+type shadowTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *shadowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.rt.RoundTrip(req)
+	}
+	suppressedCalls.WithLabelValues(targetAPI, req.Method).Inc()
+	klog.Infof("Shadow mode: would send %s %s", req.Method, req.URL.Path)
+	if req.Body != nil {
+		// A RoundTripper must close the body, even when not sending it.
+		defer req.Body.Close()
+		if klogV := klog.V(5); klogV.Enabled() {
+			if body, err := io.ReadAll(req.Body); err == nil {
+				klogV.Infof("Shadow mode: body of %s %s: %s", req.Method, req.URL.Path, body)
+			}
+		}
+	}
+	return nil, fmt.Errorf("shadow mode: %s %s was not sent to the API server", req.Method, req.URL.Path)
+}
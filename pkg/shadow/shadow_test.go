@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shadow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeConn struct {
+	grpc.ClientConnInterface
+	calls []string
+}
+
+func (c *fakeConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	c.calls = append(c.calls, method)
+	return nil
+}
+
+func TestWrapConn(t *testing.T) {
+	testcases := map[string]struct {
+		method     string
+		args       interface{}
+		expectCode codes.Code
+	}{
+		"capacity": {
+			method:     "/csi.v1.Controller/GetCapacity",
+			args:       &csi.GetCapacityRequest{},
+			expectCode: codes.OK,
+		},
+		"create": {
+			method: "/csi.v1.Controller/CreateVolume",
+			args: &csi.CreateVolumeRequest{
+				Name:    "pvc-1",
+				Secrets: map[string]string{"password": "secret"},
+			},
+			expectCode: codes.Unavailable,
+		},
+		"delete": {
+			method:     "/csi.v1.Controller/DeleteVolume",
+			args:       &csi.DeleteVolumeRequest{VolumeId: "vol-1"},
+			expectCode: codes.Unavailable,
+		},
+	}
+
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeConn{}
+			err := WrapConn(fake).Invoke(context.Background(), tc.method, tc.args, nil)
+			if code := status.Code(err); code != tc.expectCode {
+				t.Errorf("expected code %s, got %s: %v", tc.expectCode, code, err)
+			}
+			expectCalls := 0
+			if tc.expectCode == codes.OK {
+				expectCalls = 1
+			}
+			if len(fake.calls) != expectCalls {
+				t.Errorf("expected %d calls, got %v", expectCalls, fake.calls)
+			}
+		})
+	}
+}
+
+func TestWrapTransport(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: WrapTransport()(http.DefaultTransport)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Errorf("GET: unexpected error: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	resp, err = client.Post(server.URL, "application/json", strings.NewReader(`{"kind":"PersistentVolume"}`))
+	if err == nil {
+		resp.Body.Close()
+		t.Error("POST: expected error, got none")
+	}
+	if len(requests) != 1 || requests[0] != http.MethodGet {
+		t.Errorf("expected only the GET request to reach the server, got %v", requests)
+	}
+}